@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"vx-module/pkg/foldermonitor"
+)
+
+// runBenchCommand implements "monitor bench", copying synthetic files with
+// each combination of the given settings and printing the throughput.
+func runBenchCommand(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	files := fs.Int("files", 8, "Number of files copied per run")
+	size := fs.Int64("size", 64, "Size of each file in MiB")
+	buffers := fs.String("buffers", "32K,256K,1M,4M", "Comma-separated copy_buffer sizes to compare")
+	concurrency := fs.String("concurrency", "1,2,4", "Comma-separated numbers of copies to run at once")
+	verify := fs.Bool("verify", false, "Also compare runs with verify_writes on")
+	slow := fs.Float64("slow", 0, "Simulate a slow destination writing each copy at this many MiB/s")
+	dir := fs.String("dir", "", "Where to create the temporary source (default: system temp folder)")
+	dest := fs.String("dest", "", "Folder to copy into, e.g. on the real destination disk (default: next to the source)")
+	fs.Parse(args)
+
+	opts := foldermonitor.BenchOptions{
+		Files:    *files,
+		Size:     *size << 20,
+		SlowRate: int64(*slow * (1 << 20)),
+		Dir:      *dir,
+		DestDir:  *dest,
+		Progress: func(msg string) { fmt.Println(msg) },
+	}
+	for _, s := range strings.Split(*buffers, ",") {
+		n, err := parseSize(s)
+		if err != nil {
+			return fmt.Errorf("-buffers: %v", err)
+		}
+		opts.Buffers = append(opts.Buffers, n)
+	}
+	for _, s := range strings.Split(*concurrency, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 1 {
+			return fmt.Errorf("-concurrency: %q is not a positive number", s)
+		}
+		opts.Concurrency = append(opts.Concurrency, n)
+	}
+	opts.Verify = []bool{false}
+	if *verify {
+		opts.Verify = append(opts.Verify, true)
+	}
+
+	// Per-file log lines would drown the results.
+	foldermonitor.SetLogger(foldermonitor.NewConsoleLogger(os.Stderr, foldermonitor.LevelWarning))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	results, err := foldermonitor.Bench(ctx, opts)
+	if len(results) > 0 {
+		printBench(results)
+	}
+	return err
+}
+
+// printBench prints one line per run, marking the fastest.
+func printBench(results []foldermonitor.BenchResult) {
+	best := 0
+	for i, r := range results {
+		if r.Failures == 0 && r.Rate() > results[best].Rate() {
+			best = i
+		}
+	}
+	fmt.Printf("\n%-10s %-7s %-7s %12s %10s\n", "BUFFER", "AT ONCE", "VERIFY", "THROUGHPUT", "TIME")
+	for i, r := range results {
+		note := ""
+		switch {
+		case r.Failures > 0:
+			note = fmt.Sprintf("%d failed: %s", r.Failures, r.Err)
+		case i == best:
+			note = "fastest"
+		}
+		fmt.Printf("%-10s %-7d %-7v %10s/s %10s  %s\n", foldermonitor.FormatBytes(r.Buffer), r.Concurrency, r.Verify,
+			foldermonitor.FormatBytes(int64(r.Rate())), r.Duration.Round(time.Millisecond), note)
+	}
+	if b := results[best]; b.Failures == 0 {
+		fmt.Printf("\nFastest: \"copy_buffer\": %d with %d copies at once\n", b.Buffer, b.Concurrency)
+	}
+}
+
+// parseSize parses a byte count such as "256K" or "4M", with binary
+// units.
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		mult, s = 1<<10, strings.TrimSuffix(s, "K")
+	case strings.HasSuffix(s, "M"):
+		mult, s = 1<<20, strings.TrimSuffix(s, "M")
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%q is not a size such as 256K or 4M", s)
+	}
+	return n * mult, nil
+}
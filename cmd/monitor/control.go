@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"vx-module/pkg/foldermonitor"
+)
+
+// runStatusCommand implements "monitor status", printing what the running
+// service is doing.
+func runStatusCommand(cfg *foldermonitor.Config, args []string) error {
+	client, base, err := foldermonitor.ServiceClient(cfg, configFile, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	s, err := fetchStatus(client, base+"/api/status")
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 && args[0] == "-json" {
+		out, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+	state := "copying"
+	switch {
+	case s.Degraded != "":
+		state = "degraded: " + s.Degraded
+	case s.PausedSince != nil:
+		state = "paused since " + s.PausedSince.Local().Format("2006-01-02 15:04")
+	}
+	last := "never"
+	if !s.LastCopy.IsZero() {
+		last = s.LastCopy.Local().Format("2006-01-02 15:04:05")
+	}
+	fmt.Printf("State:     %s\n", state)
+	fmt.Printf("Up since:  %s\n", s.Started.Local().Format("2006-01-02 15:04"))
+	fmt.Printf("Copied:    %d (%s), %d failed, last %s\n", s.FilesCopied, foldermonitor.FormatBytes(s.BytesCopied), s.FilesFailed, last)
+	fmt.Printf("Pending:   %d copying, %d settling, %d to retry\n", len(s.InFlight), s.Settling, s.Retrying)
+	for _, dir := range s.Watching {
+		fmt.Printf("Watching:  %s\n", dir)
+	}
+	for _, dir := range s.SourcesDown {
+		fmt.Printf("Down:      %s\n", dir)
+	}
+	return nil
+}
+
+// runRescanCommand implements "monitor rescan", asking the running service
+// to copy files in the watched folders that it has not copied.
+func runRescanCommand(cfg *foldermonitor.Config) error {
+	client, base, err := foldermonitor.ServiceClient(cfg, configFile, 30*time.Second)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(base+"/api/rescan", "application/json", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusOK {
+		if body.Error == "" {
+			body.Error = resp.Status
+		}
+		return fmt.Errorf("rescan: %s", body.Error)
+	}
+	fmt.Println("Rescanning the watched folders in the running monitor")
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build windows || cgo
+
+package main
+
+import "github.com/sqweek/dialog"
+
+// nativeDialogsAvailable reports whether this build includes native dialogs.
+const nativeDialogsAvailable = true
+
+// browseDirectory shows a native folder picker with the given title.
+func browseDirectory(title string) (string, error) {
+	return dialog.Directory().Title(title).Browse()
+}
@@ -0,0 +1,14 @@
+//go:build !windows && !cgo
+
+package main
+
+import "errors"
+
+// nativeDialogsAvailable reports whether this build includes native dialogs.
+const nativeDialogsAvailable = false
+
+// browseDirectory is unavailable without cgo; use the web UI or the
+// "config" command instead.
+func browseDirectory(title string) (string, error) {
+	return "", errors.New("native dialogs are not available in this build; use -config without -native or the config command")
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"vx-module/pkg/foldermonitor"
+)
+
+// runExportCommand implements "monitor export <archive.zip>", bundling the
+// config file and its store for another machine.
+func runExportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		host, _ := os.Hostname()
+		return fmt.Errorf("usage: export <archive.zip>, e.g. export %s-%s.zip", host, time.Now().Format("20060102"))
+	}
+	m, err := foldermonitor.ExportState(configFile, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Exported %s and %d state files to %s\n", m.Config, len(m.Files), fs.Arg(0))
+	return nil
+}
+
+// runImportCommand implements "monitor import [-force] <archive.zip>",
+// restoring an export in place of this machine's config and store.
+func runImportCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	force := fs.Bool("force", false, "Replace an existing config and state")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return errors.New("usage: import [-force] <archive.zip>")
+	}
+	m, err := foldermonitor.ImportState(configFile, fs.Arg(0), *force)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported the state of %s from %s (release %s) into %s\n", m.Host, m.Created.Format(time.DateTime), m.Version, configFile)
+	fmt.Println("Copy any certificates and key files the config refers to, then start the service to resume")
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"vx-module/pkg/foldermonitor"
+)
+
+// runFleetCommand implements "monitor fleet", listing the monitors that
+// report to this one when it is the fleet collector.
+func runFleetCommand(cfg *foldermonitor.Config) error {
+	if cfg.UIAddr == "" || cfg.Fleet == nil || !cfg.Fleet.Collect {
+		return fmt.Errorf("this monitor is not a fleet collector; set fleet.collect and ui_addr")
+	}
+	client, base, err := foldermonitor.ServiceClient(cfg, configFile, 30*time.Second)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Get(base + "/api/fleet")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fleet: %s", resp.Status)
+	}
+	var members []foldermonitor.FleetMember
+	if err := json.NewDecoder(resp.Body).Decode(&members); err != nil {
+		return err
+	}
+	if len(members) == 0 {
+		fmt.Println("No monitors have reported yet")
+		return nil
+	}
+	for _, m := range members {
+		state := "ok"
+		switch {
+		case m.Problem != "":
+			state = m.Problem
+		case m.Stale:
+			state = "not reporting"
+		case m.Status.Paused:
+			state = "paused"
+		}
+		fmt.Printf("%-16s %-10s %s  queue %d  copied %d  failed %d  %s\n", m.Name, m.Status.Version,
+			m.Received.Format(time.DateTime), m.Health.QueueDepth, m.Status.FilesCopied, m.Status.FilesFailed, state)
+	}
+	return nil
+}
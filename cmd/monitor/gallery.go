@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"vx-module/pkg/foldermonitor"
+)
+
+// runGallery implements "monitor gallery [-addr host:port]", serving the
+// read-only gallery of the archive without running the service, such as
+// on a machine that only has the destination share mounted.
+func runGallery(cfg *foldermonitor.Config, args []string) error {
+	fs := flag.NewFlagSet("gallery", flag.ExitOnError)
+	addr := fs.String("addr", "", "Listen on this host:port instead of gallery.addr")
+	fs.Parse(args)
+	if cfg.Gallery == nil {
+		cfg.Gallery = &foldermonitor.GalleryConfig{}
+	}
+	if *addr != "" {
+		cfg.Gallery.Addr = *addr
+	}
+	if cfg.Gallery.Addr == "" {
+		return fmt.Errorf("no gallery.addr is configured; pass -addr")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	fmt.Printf("Serving the gallery at %s, press Ctrl+C to stop\n", cfg.Gallery.Addr)
+	return foldermonitor.ServeGallery(ctx, cfg, foldermonitor.NewStore(filepath.Dir(configFile)))
+}
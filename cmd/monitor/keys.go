@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"vx-module/pkg/foldermonitor"
+)
+
+// runEncryptionCommand implements "monitor keygen [keychain-account]" and
+// "monitor decrypt <file> [output]".
+func runEncryptionCommand(cfg *foldermonitor.Config, args []string) error {
+	switch args[0] {
+	case "keygen":
+		key, err := foldermonitor.GenerateKey()
+		if err != nil {
+			return err
+		}
+		if len(args) > 1 {
+			if err := foldermonitor.StoreKeychainKey(args[1], key); err != nil {
+				return err
+			}
+			fmt.Printf("Stored a new key in the OS keychain as %q; set encryption.keychain to %q\n", args[1], args[1])
+			return nil
+		}
+		fmt.Println(key)
+		return nil
+	case "decrypt":
+		if len(args) < 2 {
+			return errors.New("usage: decrypt <file.enc> [output]")
+		}
+		out := strings.TrimSuffix(args[1], foldermonitor.EncSuffix)
+		if len(args) > 2 {
+			out = args[2]
+		}
+		if out == args[1] {
+			return errors.New("output would overwrite the input; give an output path")
+		}
+		if err := foldermonitor.DecryptFile(cfg.Encryption, args[1], out); err != nil {
+			return err
+		}
+		fmt.Println("Decrypted to", out)
+		return nil
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
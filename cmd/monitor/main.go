@@ -0,0 +1,414 @@
+// Command monitor runs the folder monitor as a system service or in the
+// console, and provides commands to configure and inspect it.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	neturl "net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"syscall"
+
+	"github.com/kardianos/service"
+
+	"vx-module/pkg/foldermonitor"
+)
+
+// configFile is the active configuration path, resolved in main.
+var configFile = "config.json"
+
+// profile is the named profile selected with -profile, or empty for the
+// default one. It also names the service, so that the monitors of several
+// profiles can be installed side by side.
+var profile string
+
+// program adapts a Monitor to the service manager's interface.
+type program struct {
+	*foldermonitor.Monitor
+}
+
+// Start is called when the service starts.
+func (p *program) Start(s service.Service) error {
+	return p.Monitor.Start()
+}
+
+// Stop is called when the service is stopped.
+func (p *program) Stop(s service.Service) error {
+	return p.Monitor.Stop()
+}
+
+// commandHelp describes the subcommands accepted after the flags.
+var commandHelp = [][2]string{
+	{"config", "Interactive setup; \"config set key=value\" or \"config show\""},
+	{"validate", "Check the configuration and exit"},
+	{"rule", "List rules or take one offline: \"rule list\", \"rule enable|disable <name>\""},
+	{"quarantine", "List files that failed to copy: \"quarantine [list]\", \"quarantine release <file>|all\""},
+	{"queue", "List copies waiting to be retried: \"queue [list]\", \"queue retry <id>\", \"queue drop <id>\""},
+	{"status", "Print what the running service is doing: \"status [-json]\""},
+	{"rescan", "Ask the running service to copy files it missed in the watched folders"},
+	{"pause", "Stop copying until \"resume\", including across restarts"},
+	{"resume", "Resume copying, catching up on files that arrived while paused"},
+	{"install", "Install the service: \"install [-user account] [-password pw] [-start type] [-on-failure action]\""},
+	{"uninstall", "Remove the installed service; \"start\", \"stop\" and \"restart\" control it"},
+	{"update", "Install the newest signed release and restart: \"update [-check]\", \"update keygen|sign\""},
+	{"gallery", "Serve the archive read-only to browsers: \"gallery [-addr host:port]\""},
+	{"search", "Find archived clips: \"search [-limit n] [-json] bay 2 last tuesday afternoon\""},
+	{"stats", "Print copies per day or month: \"stats [-from date] [-to date] [-rule name] [-by month] [-format csv|json]\""},
+	{"version", "Print the release of this build"},
+	{"profiles", "List the named profiles; select one with \"-profile name\""},
+	{"top", "Show a live dashboard of the running service"},
+	{"fleet", "List the monitors reporting to this fleet collector"},
+	{"verify", "Compare sources with the archive: \"verify [-rule name] [-hash] [-fix] [-days n]\""},
+	{"sync", "Copy everything missing, then exit with -once: \"sync [-once] [-rule name] [-hash] [-days n]\""},
+	{"export", "Bundle the config, history, queue and other state for another machine: \"export <archive.zip>\""},
+	{"import", "Restore an export with the service stopped: \"import [-force] <archive.zip>\""},
+	{"bench", "Compare copy throughput by setting: \"bench [-files n] [-size MiB] [-buffers 32K,1M] [-concurrency 1,4] [-verify] [-slow MiB/s] [-dest path]\""},
+	{"selftest", "Copy simulated camera files through temp folders and check them: \"selftest [-files n] [-size MiB] [-keep]\""},
+	{"keygen", "Print a new encryption key, or store it: \"keygen <keychain-account>\""},
+	{"decrypt", "Decrypt an archived file: \"decrypt <file.enc> [output]\""},
+}
+
+// usage prints the flags and subcommands.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [command]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	for _, c := range commandHelp {
+		fmt.Fprintf(out, "  %-10s %s\n", c[0], c[1])
+	}
+	fmt.Fprintln(out, "\nFlags:")
+	visible := flag.NewFlagSet("", flag.ContinueOnError)
+	visible.SetOutput(out)
+	flag.VisitAll(func(f *flag.Flag) {
+		if !hiddenFlags[f.Name] {
+			visible.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	visible.PrintDefaults()
+}
+
+// hiddenFlags are left out of the usage text.
+var hiddenFlags = map[string]bool{"chaos": true}
+
+// runConsole runs the monitor in the foreground until Ctrl+C or SIGTERM.
+func runConsole(m *foldermonitor.Monitor, level foldermonitor.LogLevel) error {
+	foldermonitor.SetLogger(foldermonitor.NewConsoleLogger(os.Stdout, level))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return m.Run(ctx)
+}
+
+// runNativeConfig asks for the folders with native dialogs and saves them.
+func runNativeConfig() {
+	src, err := browseDirectory("Select Source Folder")
+	if err != nil {
+		log.Fatalf("Error selecting source folder: %v", err)
+	}
+	dest, err := browseDirectory("Select Destination Folder")
+	if err != nil {
+		log.Fatalf("Error selecting destination folder: %v", err)
+	}
+	cfg, err := foldermonitor.ReadConfig(configFile)
+	if err != nil {
+		cfg = &foldermonitor.Config{}
+	}
+	cfg.SetDefaultFolders(src, dest)
+	err = foldermonitor.WriteConfig(configFile, cfg)
+	if err != nil {
+		log.Fatalf("Error writing config file: %v", err)
+	}
+	fmt.Println("Configuration saved successfully to", configFile)
+}
+
+// runWebConfig serves the configuration web UI on localhost and opens it in
+// the browser until interrupted.
+func runWebConfig() {
+	addr := "127.0.0.1:0"
+	var auth *foldermonitor.UIAuthConfig
+	if cfg, err := foldermonitor.ReadConfig(configFile); err == nil {
+		if cfg.UIAddr != "" {
+			addr = cfg.UIAddr
+		}
+		auth = cfg.UIAuth
+	}
+	srv, base, err := foldermonitor.StartUI(addr, auth, foldermonitor.UIHandler(nil, configFile))
+	if err != nil {
+		log.Fatalf("Error starting configuration UI: %v", err)
+	}
+	url := base + "/"
+	fmt.Println("Configuration UI running at", url, "- press Ctrl+C to exit")
+	if auth != nil && auth.Token != "" {
+		url += "?token=" + neturl.QueryEscape(auth.Token)
+	}
+	if err := openBrowser(url); err != nil {
+		fmt.Println("Could not open a browser:", err)
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	srv.Close()
+}
+
+// openBrowser opens url in the user's default browser.
+func openBrowser(url string) error {
+	switch runtime.GOOS {
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+	case "darwin":
+		return exec.Command("open", url).Start()
+	default:
+		return exec.Command("xdg-open", url).Start()
+	}
+}
+
+func main() {
+	// Define a flag for running the configuration UI.
+	configFlag := flag.Bool("config", false, "Run configuration UI to select folders")
+	nativeFlag := flag.Bool("native", false, "With -config, use native folder pickers instead of the web UI")
+	consoleFlag := flag.Bool("console", false, "Run in the foreground without the service manager, logging to stdout")
+	verbosity := flag.String("verbosity", "info", "Console log level: error, warning, info or debug")
+	configPath := flag.String("config-path", "", "Path to the configuration file (default: platform config directory)")
+	profileFlag := flag.String("profile", "", "Named profile with its own configuration, queue, history and service, e.g. \"bay3\"")
+	chaosFlag := flag.Float64("chaos", 0, "Inject copy failures, slow destinations and watcher errors with this probability, for drills")
+	foldermonitor.RegisterConfigFlags(flag.CommandLine)
+	flag.Usage = usage
+	flag.Parse()
+
+	// Resolve the configuration file location.
+	if *profileFlag != "" {
+		if err := foldermonitor.ValidateProfileName(*profileFlag); err != nil {
+			log.Fatal(err)
+		}
+		profile = *profileFlag
+	}
+	if *configPath != "" {
+		configFile = *configPath
+	} else if profile != "" {
+		configFile = foldermonitor.ProfileConfigPath(profile)
+	} else {
+		configFile = foldermonitor.DefaultConfigPath()
+		if from, err := foldermonitor.MigrateLocalConfig(configFile); err != nil {
+			log.Printf("Warning: could not migrate local config: %v", err)
+		} else if from != "" {
+			log.Printf("Migrated configuration from %s to %s", from, configFile)
+		}
+	}
+	if backup, err := foldermonitor.UpgradeConfigFile(configFile); err != nil {
+		log.Printf("Warning: could not upgrade config: %v", err)
+	} else if backup != "" {
+		log.Printf("Upgraded %s to config version %d; the previous file is kept as %s", configFile, foldermonitor.CurrentConfigVersion, backup)
+	}
+
+	foldermonitor.CleanupUpdate()
+	switch flag.Arg(0) {
+	case "version":
+		fmt.Println(foldermonitor.Version)
+		return
+	case "profiles":
+		if err := runProfilesCommand(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "config":
+		if err := runConfigCommand(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "rule":
+		if err := runRuleCommand(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "quarantine":
+		if err := runQuarantineCommand(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "stats":
+		if err := runStatsCommand(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "search":
+		if err := runSearchCommand(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "bench":
+		if err := runBenchCommand(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "export":
+		if err := runExportCommand(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "import":
+		if err := runImportCommand(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// If -config is provided, show the configuration UI.
+	if *configFlag {
+		if *nativeFlag && nativeDialogsAvailable {
+			runNativeConfig()
+		} else {
+			runWebConfig()
+		}
+		return
+	}
+
+	// Read configuration from file. A missing file is allowed when every
+	// setting comes from environment variables or flags.
+	cfg, err := foldermonitor.ReadConfig(configFile)
+	if os.IsNotExist(err) {
+		cfg, err = &foldermonitor.Config{}, nil
+	}
+	if err != nil {
+		log.Fatalf("Error reading config: %v", err)
+	}
+	if err := foldermonitor.ApplyOverrides(cfg, flag.CommandLine); err != nil {
+		log.Fatalf("Error applying config overrides: %v", err)
+	}
+	if *chaosFlag > 0 && cfg.Chaos == nil {
+		cfg.Chaos = &foldermonitor.ChaosConfig{CopyFailure: *chaosFlag, SlowDestination: *chaosFlag, WatcherError: *chaosFlag}
+	}
+
+	switch flag.Arg(0) {
+	case "top":
+		if err := runTop(cfg); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "fleet":
+		if err := runFleetCommand(cfg); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "status":
+		if err := runStatusCommand(cfg, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "rescan":
+		if err := runRescanCommand(cfg); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "pause", "resume":
+		if err := runPauseCommand(cfg, flag.Arg(0)); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "queue":
+		if err := runQueueCommand(cfg, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "selftest":
+		if err := runSelfTest(cfg, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "install", "uninstall", "start", "stop", "restart":
+		if err := runServiceCommand(cfg, flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "update":
+		if err := runUpdateCommand(cfg, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "keygen", "decrypt":
+		if err := runEncryptionCommand(cfg, flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Validate the file and the effective configuration.
+	var problems foldermonitor.ConfigErrors
+	if _, err := os.Stat(configFile); err == nil {
+		problems = append(problems, foldermonitor.ValidateConfigFile(configFile)...)
+	}
+	problems = append(problems, foldermonitor.ValidateConfig(cfg)...)
+	if flag.Arg(0) == "validate" {
+		if len(problems) > 0 {
+			fmt.Println(problems)
+			os.Exit(1)
+		}
+		fmt.Println("Configuration is valid:", configFile)
+		return
+	}
+	if len(problems) > 0 {
+		log.Fatal(problems)
+	}
+	switch flag.Arg(0) {
+	case "verify":
+		if err := runVerify(cfg, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "sync":
+		if err := runSync(cfg, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "gallery":
+		if err := runGallery(cfg, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	m := foldermonitor.New(cfg, foldermonitor.Options{ConfigPath: configFile})
+	if u := cfg.Update; u != nil && u.Auto {
+		go func() {
+			if _, err := foldermonitor.AutoUpdate(context.Background(), u); err == nil {
+				if err := restartSelf(m); err != nil {
+					log.Printf("Auto-update: %v", err)
+				}
+			}
+		}()
+	}
+
+	// In console mode bypass the service framework entirely.
+	if *consoleFlag {
+		level, err := foldermonitor.ParseLogLevel(*verbosity)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := runConsole(m, level); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Create the service.
+	s, err := service.New(&program{m}, serviceConfig(cfg))
+	if err != nil {
+		fmt.Println("Error creating service:", err)
+		return
+	}
+
+	logger, err := s.Logger(nil)
+	if err != nil {
+		fmt.Println("Error setting up logger:", err)
+	}
+	foldermonitor.SetLogger(logger)
+
+	// Run the service.
+	err = runService(s, m, cfg)
+	if err != nil && logger != nil {
+		logger.Error(err)
+	}
+}
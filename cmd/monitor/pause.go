@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"vx-module/pkg/foldermonitor"
+)
+
+// runPauseCommand implements "monitor pause" and "monitor resume". The
+// running service is told through its control channel or web UI when
+// reachable; otherwise the marker file is updated and the service honours
+// it when it next starts.
+func runPauseCommand(cfg *foldermonitor.Config, command string) error {
+	paused := command == "pause"
+	if client, base, err := foldermonitor.ServiceClient(cfg, configFile, 30*time.Second); err == nil {
+		resp, err := client.Post(base+"/api/"+command, "application/json", nil)
+		if err == nil {
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("%s: %s", command, resp.Status)
+			}
+			var body struct {
+				Running bool `json:"running"`
+			}
+			json.NewDecoder(resp.Body).Decode(&body)
+			if body.Running {
+				fmt.Printf("Copying %sd in the running monitor\n", command)
+				return nil
+			}
+		}
+	}
+	if err := foldermonitor.NewStore(filepath.Dir(configFile)).SetPaused(paused); err != nil {
+		return err
+	}
+	fmt.Printf("Copying %sd; the change applies when the monitor next starts\n", command)
+	return nil
+}
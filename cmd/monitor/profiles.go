@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+
+	"vx-module/pkg/foldermonitor"
+)
+
+// runProfilesCommand implements "monitor profiles", listing the named
+// profiles with the service each installs as and its web UI address. A
+// profile is created by configuring it, e.g. "monitor -profile bay3 config".
+func runProfilesCommand() error {
+	names, err := foldermonitor.Profiles()
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		fmt.Println(`No profiles; create one with "monitor -profile <name> config"`)
+		return nil
+	}
+	for _, name := range names {
+		path := foldermonitor.ProfileConfigPath(name)
+		ui := "-"
+		if cfg, err := foldermonitor.ReadConfig(path); err != nil {
+			ui = "unreadable"
+		} else if cfg.UIAddr != "" {
+			ui = cfg.UIAddr
+		}
+		fmt.Printf("%-16s %-36s %-21s %s\n", name, serviceName(name), ui, path)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"vx-module/pkg/foldermonitor"
+)
+
+// runQuarantineCommand implements "monitor quarantine [list]" and
+// "monitor quarantine release <file>... | all". The quarantine list is
+// shared with the running service, so changes apply immediately.
+func runQuarantineCommand(args []string) error {
+	store := foldermonitor.NewStore(filepath.Dir(configFile))
+	if len(args) == 0 || args[0] == "list" {
+		list, err := store.Quarantined()
+		if err != nil {
+			return err
+		}
+		if len(list) == 0 {
+			fmt.Println("No files are quarantined")
+			return nil
+		}
+		for _, q := range list {
+			fmt.Printf("%s  %-16s %-14s %s\n    %s (%d attempts)\n", q.Since.Format(time.DateTime), q.Rule, q.Reason, q.Source, q.Error, q.Attempts)
+		}
+		return nil
+	}
+	if args[0] != "release" || len(args) < 2 {
+		return fmt.Errorf("usage: quarantine list | quarantine release <file>... | quarantine release all")
+	}
+	sources := args[1:]
+	if len(sources) == 1 && sources[0] == "all" {
+		sources = nil
+	}
+	for i, src := range sources {
+		if abs, err := filepath.Abs(src); err == nil {
+			sources[i] = abs
+		}
+	}
+	n, err := store.Release(sources...)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Released %d file(s); they are copied when next detected or synchronised\n", n)
+	return nil
+}
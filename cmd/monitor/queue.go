@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"vx-module/pkg/foldermonitor"
+)
+
+// runQueueCommand implements "monitor queue [list]", "monitor queue retry
+// <id>" and "monitor queue drop <id>" for copies waiting to be retried.
+// Changes go to the running service through its web UI when reachable;
+// otherwise the queue file is updated and a retry happens when the monitor
+// next starts.
+func runQueueCommand(cfg *foldermonitor.Config, args []string) error {
+	store := foldermonitor.NewStore(filepath.Dir(configFile))
+	if len(args) == 0 || args[0] == "list" {
+		list, err := store.Queued()
+		if err != nil {
+			return err
+		}
+		if len(list) == 0 {
+			fmt.Println("No copies are waiting to be retried")
+			return nil
+		}
+		for _, q := range list {
+			fmt.Printf("%s  %-16s attempt %d  due %s  %s\n    %s\n", q.ID, q.Rule, q.Attempt+1, q.Due.Format(time.DateTime), q.Source, q.Error)
+		}
+		return nil
+	}
+	if (args[0] != "retry" && args[0] != "drop") || len(args) != 2 {
+		return fmt.Errorf("usage: queue list | queue retry <id> | queue drop <id>")
+	}
+	action, id := args[0], args[1]
+	if client, base, err := foldermonitor.ServiceClient(cfg, configFile, 30*time.Second); err == nil {
+		resp, err := client.Post(base+"/api/queue/"+id+"/"+action, "application/json", nil)
+		if err == nil {
+			defer resp.Body.Close()
+			if resp.StatusCode == http.StatusNotFound {
+				return fmt.Errorf("no queued copy with id %s", id)
+			}
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("queue %s: %s", action, resp.Status)
+			}
+			var body struct {
+				Running bool `json:"running"`
+			}
+			json.NewDecoder(resp.Body).Decode(&body)
+			if body.Running {
+				if action == "retry" {
+					fmt.Printf("Retrying %s in the running monitor\n", id)
+				} else {
+					fmt.Printf("Dropped %s\n", id)
+				}
+				return nil
+			}
+		}
+	}
+	var ok bool
+	var err error
+	if action == "retry" {
+		ok, err = store.RetryQueued(id)
+	} else {
+		ok, err = store.DropQueued(id)
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("no queued copy with id %s", id)
+	}
+	if action == "retry" {
+		fmt.Printf("%s is due now; it is retried when the monitor next starts\n", id)
+	} else {
+		fmt.Printf("Dropped %s\n", id)
+	}
+	return nil
+}
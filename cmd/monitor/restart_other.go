@@ -0,0 +1,21 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+
+	"vx-module/pkg/foldermonitor"
+)
+
+// restartSelf stops m and replaces the process with the updated binary,
+// keeping its process ID so the service manager does not notice.
+func restartSelf(m *foldermonitor.Monitor) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	m.Stop()
+	return syscall.Exec(exe, os.Args, os.Environ())
+}
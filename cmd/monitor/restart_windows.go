@@ -0,0 +1,25 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+
+	"github.com/kardianos/service"
+
+	"vx-module/pkg/foldermonitor"
+)
+
+// restartSelf has the service restarted on the updated binary. A process
+// cannot replace itself on Windows, so a helper asks the service manager
+// to restart the service, which outlives this process being stopped.
+func restartSelf(m *foldermonitor.Monitor) error {
+	if service.Interactive() {
+		return errors.New("update installed; restart the monitor to use it")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return exec.Command(exe, "-config-path", configFile, "restart").Start()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"vx-module/pkg/foldermonitor"
+)
+
+// runRuleCommand implements "monitor rule list" and
+// "monitor rule enable|disable <name>". When the service is reachable the
+// change is made through it so it takes effect immediately; otherwise only
+// the config file is updated.
+func runRuleCommand(args []string) error {
+	cfg, err := foldermonitor.ReadConfig(configFile)
+	if os.IsNotExist(err) {
+		cfg, err = &foldermonitor.Config{}, nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 || args[0] == "list" {
+		for _, r := range cfg.ListRules() {
+			state := "enabled"
+			if !r.Enabled {
+				state = "disabled"
+			}
+			fmt.Printf("%-16s %-7s %-9s %s -> %s\n", r.Name, r.Kind, state, r.Source, r.DestDir)
+		}
+		return nil
+	}
+	if (args[0] != "enable" && args[0] != "disable") || len(args) != 2 {
+		return fmt.Errorf("usage: rule list | rule enable <name> | rule disable <name>")
+	}
+	action, name := args[0], args[1]
+	applied, err := postRuleAction(cfg, name, action)
+	if err != nil {
+		return err
+	}
+	if applied {
+		fmt.Printf("Rule %s %sd in the running monitor and saved to %s\n", name, action, configFile)
+		return nil
+	}
+	if err := foldermonitor.ToggleRule(configFile, name, action == "enable"); err != nil {
+		return err
+	}
+	fmt.Printf("Rule %s %sd in %s; the change applies when the monitor next starts\n", name, action, configFile)
+	return nil
+}
+
+// postRuleAction asks the running monitor to enable or disable a rule.
+// applied is false, with no error, when the monitor is not reachable or
+// runs without a monitor (the standalone -config UI).
+func postRuleAction(cfg *foldermonitor.Config, name, action string) (applied bool, err error) {
+	client, base, err := foldermonitor.ServiceClient(cfg, configFile, 30*time.Second)
+	if err != nil {
+		return false, nil
+	}
+	resp, err := client.Post(base+"/api/rules/"+url.PathEscape(name)+"/"+action, "application/json", nil)
+	if err != nil {
+		return false, nil
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Running bool   `json:"running"`
+		Error   string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusOK {
+		if body.Error == "" {
+			body.Error = resp.Status
+		}
+		return false, fmt.Errorf("rule %s: %s", name, body.Error)
+	}
+	return body.Running, nil
+}
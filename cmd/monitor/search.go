@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"vx-module/pkg/foldermonitor"
+)
+
+// runSearchCommand implements "monitor search [-limit n] [-json] <query>",
+// printing the archived clips matching a query such as "bay 2 last
+// tuesday afternoon" from the search index in the store.
+func runSearchCommand(args []string) error {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	limit := fs.Int("limit", 50, "Most clips to print")
+	asJSON := fs.Bool("json", false, "Print the clips as JSON")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: search [-limit n] [-json] <query>")
+	}
+	found, err := foldermonitor.NewStore(filepath.Dir(configFile)).Search(strings.Join(fs.Args(), " "), time.Now(), *limit)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(found)
+	}
+	if len(found) == 0 {
+		fmt.Println("No archived clips match")
+		return nil
+	}
+	for _, c := range found {
+		at := c.Recorded
+		if at.IsZero() {
+			at = c.Copied
+		}
+		detail := []string{c.Bay}
+		if c.Student != "" {
+			detail = append(detail, c.Student)
+		}
+		if c.Length > 0 {
+			detail = append(detail, c.Length.Round(time.Second).String())
+		}
+		fmt.Printf("%s  %-28s %s\n    %s\n", at.Local().Format("Mon 2006-01-02 15:04"), strings.Join(detail, ", "), c.Name, c.Dest)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"vx-module/pkg/foldermonitor"
+)
+
+// runSelfTest implements "monitor selftest [-files n] [-size MiB] [-dir
+// path] [-keep]". It runs the pipeline against temporary folders with the
+// configured timing and exits non-zero when a copy was not intact.
+func runSelfTest(cfg *foldermonitor.Config, args []string) error {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	files := fs.Int("files", 5, "Number of files the simulated camera writes")
+	size := fs.Int64("size", 8, "Size of each file in MiB")
+	dir := fs.String("dir", "", "Where to create the temporary folders (default: system temp folder)")
+	keep := fs.Bool("keep", false, "Keep the temporary folders for inspection")
+	timeout := fs.Duration("timeout", 2*time.Minute, "Give up after this long")
+	fs.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	report, err := foldermonitor.SelfTest(ctx, foldermonitor.SelfTestOptions{
+		Files:        *files,
+		Size:         *size << 20,
+		QuietPeriod:  cfg.QuietPeriod,
+		StallTimeout: cfg.StallTimeout,
+		LockRetries:  cfg.LockRetries,
+		Dir:          *dir,
+		Keep:         *keep,
+		Timeout:      *timeout,
+		Progress:     func(msg string) { fmt.Println(msg) },
+	})
+	if err != nil {
+		return err
+	}
+	for _, f := range report.Failures {
+		fmt.Println("  FAILED  ", f)
+	}
+	fmt.Printf("Self-test %s: %d of %d files copied intact in %s\n",
+		map[bool]string{true: "passed", false: "FAILED"}[report.Passed()], report.Verified, report.Files, report.Duration.Round(time.Millisecond))
+	if *keep {
+		fmt.Println("Test folders kept in", report.Dir)
+	}
+	if !report.Passed() {
+		os.Exit(1)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kardianos/service"
+
+	"vx-module/pkg/foldermonitor"
+)
+
+const (
+	defaultRestartDelay       = 5 * time.Second
+	defaultFailureResetPeriod = time.Hour
+)
+
+// serviceName returns the name the service of the profile is installed as.
+func serviceName(profile string) string {
+	if profile == "" {
+		return "FolderMonitorService"
+	}
+	return "FolderMonitorService-" + profile
+}
+
+// serviceConfig describes the service to the service manager, applying the
+// account and recovery options from cfg.Service. The installed service is
+// started with the same profile and config file as this invocation.
+func serviceConfig(cfg *foldermonitor.Config) *service.Config {
+	svcConfig := &service.Config{
+		Name:        serviceName(profile),
+		DisplayName: "Folder Monitor Service",
+		Description: "Monitors a folder and copies new files to a destination folder.",
+		Option:      service.KeyValue{},
+	}
+	if profile != "" {
+		svcConfig.DisplayName += " (" + profile + ")"
+		svcConfig.Arguments = []string{"-profile", profile}
+	}
+	if abs, err := filepath.Abs(configFile); err == nil {
+		svcConfig.Arguments = append(svcConfig.Arguments, "-config-path", abs)
+	}
+	sc := cfg.Service
+	if sc == nil {
+		sc = &foldermonitor.ServiceConfig{}
+	}
+	svcConfig.UserName = sc.UserName
+	svcConfig.Dependencies = sc.Dependencies
+	if sc.Password != "" {
+		svcConfig.Option["Password"] = sc.Password
+	}
+	switch sc.StartType {
+	case "delayed":
+		svcConfig.Option["StartType"] = "automatic"
+		svcConfig.Option["DelayedAutoStart"] = true
+	case "":
+		svcConfig.Option["StartType"] = "automatic"
+	default:
+		svcConfig.Option["StartType"] = sc.StartType
+	}
+
+	delay := time.Duration(sc.RestartDelay)
+	if delay <= 0 {
+		delay = defaultRestartDelay
+	}
+	reset := time.Duration(sc.FailureResetPeriod)
+	if reset <= 0 {
+		reset = defaultFailureResetPeriod
+	}
+	switch sc.OnFailure {
+	case "", "restart":
+		svcConfig.Option["OnFailure"] = "restart"
+		svcConfig.Option["Restart"] = "on-failure"
+	case "reboot":
+		svcConfig.Option["OnFailure"] = "reboot"
+	case "none":
+		svcConfig.Option["OnFailure"] = "noaction"
+		svcConfig.Option["Restart"] = "no"
+	}
+	svcConfig.Option["OnFailureDelayDuration"] = delay.String()
+	svcConfig.Option["OnFailureResetPeriod"] = int(reset / time.Second)
+	return svcConfig
+}
+
+// runServiceCommand implements "monitor install [flags]", "uninstall",
+// "start", "stop" and "restart". Install flags override the service section
+// of the config for this installation only.
+func runServiceCommand(cfg *foldermonitor.Config, args []string) error {
+	action := args[0]
+	if action == "install" {
+		sc := foldermonitor.ServiceConfig{}
+		if cfg.Service != nil {
+			sc = *cfg.Service
+		}
+		fs := flag.NewFlagSet("install", flag.ContinueOnError)
+		fs.StringVar(&sc.UserName, "user", sc.UserName, `Run the service as this account, e.g. "CLUB\svc-video"`)
+		fs.StringVar(&sc.Password, "password", sc.Password, "Password of -user (default: $FM_SERVICE_PASSWORD)")
+		fs.StringVar(&sc.StartType, "start", sc.StartType, "Start type: automatic, delayed, manual or disabled")
+		fs.StringVar(&sc.OnFailure, "on-failure", sc.OnFailure, "After a crash: restart, reboot or none")
+		depends := fs.String("depends", strings.Join(sc.Dependencies, ","), "Comma-separated services to start first")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if env := os.Getenv("FM_SERVICE_PASSWORD"); sc.Password == "" && env != "" {
+			sc.Password = env
+		}
+		sc.Dependencies = nil
+		for _, d := range strings.Split(*depends, ",") {
+			if d = strings.TrimSpace(d); d != "" {
+				sc.Dependencies = append(sc.Dependencies, d)
+			}
+		}
+		installed := *cfg
+		installed.Service = &sc
+		if problems := foldermonitor.ValidateConfig(&installed); len(problems) > 0 {
+			return problems
+		}
+		cfg = &installed
+	} else if len(args) > 1 {
+		return fmt.Errorf("usage: %s takes no arguments", action)
+	}
+
+	svcConfig := serviceConfig(cfg)
+	s, err := service.New(&program{}, svcConfig)
+	if err != nil {
+		return err
+	}
+	if err := service.Control(s, action); err != nil {
+		return err
+	}
+	if action == "install" {
+		account := svcConfig.UserName
+		if account == "" {
+			account = "the system account"
+		}
+		fmt.Printf("Installed %s to run as %s using %s\n", svcConfig.Name, account, configFile)
+		return nil
+	}
+	fmt.Printf("%s: %s succeeded\n", svcConfig.Name, action)
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import (
+	"github.com/kardianos/service"
+
+	"vx-module/pkg/foldermonitor"
+)
+
+// runService runs the monitor under the service manager. Console session
+// changes are only reported on Windows.
+func runService(s service.Service, m *foldermonitor.Monitor, cfg *foldermonitor.Config) error {
+	return s.Run()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"unsafe"
+
+	"github.com/kardianos/service"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+
+	"vx-module/pkg/foldermonitor"
+)
+
+// runService runs the monitor under the service manager. With session
+// settings it uses its own handler, since the service framework does not
+// accept session-change notifications.
+func runService(s service.Service, m *foldermonitor.Monitor, cfg *foldermonitor.Config) error {
+	if cfg.Session == nil || service.Interactive() {
+		return s.Run()
+	}
+	return svc.Run(serviceConfig(cfg).Name, &sessionHandler{m: m})
+}
+
+// sessionHandler runs the monitor as a Windows service, passing console
+// session changes on to it.
+type sessionHandler struct {
+	m *foldermonitor.Monitor
+}
+
+func (h *sessionHandler) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	const accepted = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptSessionChange
+	changes <- svc.Status{State: svc.StartPending}
+	if err := h.m.Start(); err != nil {
+		return true, 1
+	}
+	changes <- svc.Status{State: svc.Running, Accepts: accepted}
+	for c := range r {
+		switch c.Cmd {
+		case svc.Interrogate:
+			changes <- c.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			changes <- svc.Status{State: svc.StopPending}
+			h.m.Stop()
+			return false, 0
+		case svc.SessionChange:
+			if e, ok := consoleSessionEvent(c); ok {
+				h.m.SessionChanged(e)
+			}
+		}
+	}
+	return false, 0
+}
+
+// consoleSessionEvent translates a session-change notification about the
+// console session; changes of remote sessions are ignored.
+func consoleSessionEvent(c svc.ChangeRequest) (foldermonitor.SessionEvent, bool) {
+	if c.EventData == 0 {
+		return 0, false
+	}
+	n := *(**windows.WTSSESSION_NOTIFICATION)(unsafe.Pointer(&c.EventData))
+	if n.SessionID != windows.WTSGetActiveConsoleSessionId() {
+		return 0, false
+	}
+	switch c.EventType {
+	case windows.WTS_SESSION_LOCK:
+		return foldermonitor.SessionLock, true
+	case windows.WTS_SESSION_UNLOCK:
+		return foldermonitor.SessionUnlock, true
+	case windows.WTS_SESSION_LOGON:
+		return foldermonitor.SessionLogon, true
+	case windows.WTS_SESSION_LOGOFF:
+		return foldermonitor.SessionLogoff, true
+	}
+	return 0, false
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"vx-module/pkg/foldermonitor"
+)
+
+// runStatsCommand implements "monitor stats [-from date] [-to date] [-rule
+// name] [-by day|month] [-format table|csv|json]", printing the copy
+// statistics kept in the store, summed over each period and rule. The
+// range defaults to the current month.
+func runStatsCommand(args []string) error {
+	now := time.Now()
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	from := fs.String("from", now.Format("2006-01")+"-01", "First day, as YYYY-MM-DD")
+	to := fs.String("to", now.Format(time.DateOnly), "Last day, as YYYY-MM-DD")
+	ruleName := fs.String("rule", "", "Only report the named rule")
+	by := fs.String("by", "day", "Sum by day or month")
+	format := fs.String("format", "table", "Output format: table, csv or json")
+	fs.Parse(args)
+
+	first, err := time.ParseInLocation(time.DateOnly, *from, time.Local)
+	if err != nil {
+		return fmt.Errorf("-from: %v", err)
+	}
+	last, err := time.ParseInLocation(time.DateOnly, *to, time.Local)
+	if err != nil {
+		return fmt.Errorf("-to: %v", err)
+	}
+	if *by != "day" && *by != "month" {
+		return fmt.Errorf("-by %q: want day or month", *by)
+	}
+	days, err := foldermonitor.NewStore(filepath.Dir(configFile)).Stats(first, last)
+	if err != nil {
+		return err
+	}
+
+	// Sum the days of each period and rule.
+	var rows []foldermonitor.DayStats
+	index := make(map[[2]string]int)
+	for _, d := range days {
+		if *ruleName != "" && d.Rule != *ruleName {
+			continue
+		}
+		if *by == "month" {
+			d.Day = d.Day[:len("2006-01")]
+		}
+		key := [2]string{d.Day, d.Rule}
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(rows)
+			rows = append(rows, d)
+			continue
+		}
+		r := &rows[i]
+		r.Files += d.Files
+		r.Bytes += d.Bytes
+		r.Failures += d.Failures
+		r.MaxLatency = max(r.MaxLatency, d.MaxLatency)
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].Day != rows[j].Day {
+			return rows[i].Day < rows[j].Day
+		}
+		return rows[i].Rule < rows[j].Rule
+	})
+
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if rows == nil {
+			rows = []foldermonitor.DayStats{}
+		}
+		return enc.Encode(rows)
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{*by, "rule", "files", "bytes", "failures", "max_latency_seconds"})
+		for _, r := range rows {
+			w.Write([]string{r.Day, r.Rule, strconv.Itoa(r.Files), strconv.FormatInt(r.Bytes, 10),
+				strconv.Itoa(r.Failures), strconv.FormatFloat(r.MaxLatency, 'f', 1, 64)})
+		}
+		w.Flush()
+		return w.Error()
+	case "table":
+	default:
+		return fmt.Errorf("-format %q: want table, csv or json", *format)
+	}
+	if len(rows) == 0 {
+		fmt.Printf("No copies recorded from %s to %s\n", *from, *to)
+		return nil
+	}
+	var total foldermonitor.DayStats
+	fmt.Printf("%-10s  %-16s %8s %10s %8s %11s\n", *by, "rule", "files", "size", "failed", "max latency")
+	for _, r := range rows {
+		fmt.Printf("%-10s  %-16s %8d %10s %8d %11s\n", r.Day, r.Rule, r.Files, foldermonitor.FormatBytes(r.Bytes), r.Failures, formatLatency(r.MaxLatency))
+		total.Files += r.Files
+		total.Bytes += r.Bytes
+		total.Failures += r.Failures
+		total.MaxLatency = max(total.MaxLatency, r.MaxLatency)
+	}
+	fmt.Printf("%-10s  %-16s %8d %10s %8d %11s\n", "total", "", total.Files, foldermonitor.FormatBytes(total.Bytes), total.Failures, formatLatency(total.MaxLatency))
+	return nil
+}
+
+// formatLatency formats a latency in seconds for the stats table.
+func formatLatency(seconds float64) string {
+	if seconds == 0 {
+		return "-"
+	}
+	d := time.Duration(seconds * float64(time.Second))
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
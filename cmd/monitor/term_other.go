@@ -0,0 +1,6 @@
+//go:build !windows
+
+package main
+
+// enableVirtualTerminal is a no-op; Unix terminals interpret ANSI escapes.
+func enableVirtualTerminal() {}
@@ -0,0 +1,12 @@
+package main
+
+import "golang.org/x/sys/windows"
+
+// enableVirtualTerminal turns on ANSI escape processing for the console.
+func enableVirtualTerminal() {
+	h := windows.Handle(windows.Stdout)
+	var mode uint32
+	if windows.GetConsoleMode(h, &mode) == nil {
+		windows.SetConsoleMode(h, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING)
+	}
+}
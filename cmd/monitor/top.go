@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"vx-module/pkg/foldermonitor"
+)
+
+// topRefresh is how often the dashboard polls the running service.
+const topRefresh = time.Second
+
+// runTop shows a live terminal dashboard of the running service, reached
+// through its control channel or web UI, until interrupted.
+func runTop(cfg *foldermonitor.Config) error {
+	client, base, err := foldermonitor.ServiceClient(cfg, configFile, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	url := base + "/api/status"
+	enableVirtualTerminal()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	ticker := time.NewTicker(topRefresh)
+	defer ticker.Stop()
+
+	// Hide the cursor while drawing and restore it on exit.
+	fmt.Print("\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\n")
+
+	var prev *foldermonitor.StatusSnapshot
+	var prevAt time.Time
+	for {
+		snap, err := fetchStatus(client, url)
+		now := time.Now()
+		var rate float64
+		if err == nil && prev != nil {
+			rate = float64(transferredBytes(snap)-transferredBytes(prev)) / now.Sub(prevAt).Seconds()
+			if rate < 0 {
+				rate = 0
+			}
+		}
+		drawTop(url, snap, rate, err)
+		if err == nil {
+			prev, prevAt = snap, now
+		}
+		select {
+		case <-sig:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// fetchStatus retrieves a status snapshot from url.
+func fetchStatus(client *http.Client, url string) (*foldermonitor.StatusSnapshot, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	var snap foldermonitor.StatusSnapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snap); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// transferredBytes counts completed bytes plus progress of in-flight copies.
+func transferredBytes(s *foldermonitor.StatusSnapshot) int64 {
+	n := s.BytesCopied
+	for _, t := range s.InFlight {
+		n += t.Copied
+	}
+	return n
+}
+
+// drawTop redraws the whole screen.
+func drawTop(url string, s *foldermonitor.StatusSnapshot, rate float64, fetchErr error) {
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&b, "Folder Monitor - %s - %s\n\n", url, time.Now().Format("15:04:05"))
+	if fetchErr != nil {
+		fmt.Fprintf(&b, "\x1b[31mCannot reach service: %v\x1b[0m\n", fetchErr)
+		fmt.Print(b.String())
+		return
+	}
+	last := "never"
+	if !s.LastCopy.IsZero() {
+		last = s.LastCopy.Local().Format("2006-01-02 15:04:05")
+	}
+	fmt.Fprintf(&b, "Up since %s   Copied %d (%s)   Failed %d   Last copy %s\n",
+		s.Started.Local().Format("2006-01-02 15:04"), s.FilesCopied, foldermonitor.FormatBytes(s.BytesCopied), s.FilesFailed, last)
+	fmt.Fprintf(&b, "Throughput %s/s   Queue depth %d\n\n", foldermonitor.FormatBytes(int64(rate)), len(s.InFlight))
+	if s.PausedSince != nil {
+		fmt.Fprintf(&b, "\x1b[33mPaused since %s\x1b[0m\n\n", s.PausedSince.Local().Format("2006-01-02 15:04"))
+	}
+	if s.Degraded != "" {
+		fmt.Fprintf(&b, "\x1b[31mDegraded, restart %d: %s\x1b[0m\n\n", s.Restarts, s.Degraded)
+	}
+
+	b.WriteString("\x1b[1mWatching\x1b[0m\n")
+	if len(s.Watching) == 0 {
+		b.WriteString("  (nothing)\n")
+	}
+	for _, dir := range s.Watching {
+		fmt.Fprintf(&b, "  %s\n", dir)
+	}
+
+	b.WriteString("\n\x1b[1mIn flight\x1b[0m\n")
+	for _, t := range s.InFlight {
+		fmt.Fprintf(&b, "  %-40s %s %s\n", truncate(filepath.Base(t.Source), 40), progressBar(t.Copied, t.Size, 30), foldermonitor.FormatBytes(t.Size))
+	}
+
+	b.WriteString("\n\x1b[1mRecent errors\x1b[0m\n")
+	for i, e := range s.Errors {
+		if i == 8 {
+			break
+		}
+		fmt.Fprintf(&b, "  \x1b[31m%s %s\x1b[0m\n", e.Time.Local().Format("15:04:05"), truncate(e.Message, 100))
+	}
+	fmt.Print(b.String())
+}
+
+// progressBar renders done/total as a fixed-width bar with a percentage.
+func progressBar(done, total int64, width int) string {
+	frac := 1.0
+	if total > 0 {
+		frac = float64(done) / float64(total)
+	}
+	if frac > 1 {
+		frac = 1
+	}
+	filled := int(frac * float64(width))
+	return fmt.Sprintf("[%s%s] %3.0f%%", strings.Repeat("#", filled), strings.Repeat(".", width-filled), frac*100)
+}
+
+// truncate shortens s to at most n runes.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kardianos/service"
+
+	"vx-module/pkg/foldermonitor"
+)
+
+// runUpdateCommand implements "monitor update [-check]", which installs the
+// newest release and restarts the installed service, and the release tools
+// "update keygen" and "update sign <private-key-file> <binary> <version> <os/arch>".
+func runUpdateCommand(cfg *foldermonitor.Config, args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "keygen":
+			public, private, err := foldermonitor.GenerateUpdateKey()
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Public key (update.public_key): %s\nPrivate key (keep secret):      %s\n", public, private)
+			return nil
+		case "sign":
+			if len(args) != 5 {
+				return errors.New("usage: update sign <private-key-file> <binary> <version> <os/arch>")
+			}
+			key, err := os.ReadFile(args[1])
+			if err != nil {
+				return err
+			}
+			sig, sum, err := foldermonitor.SignRelease(string(key), args[2], args[3], args[4])
+			if err != nil {
+				return err
+			}
+			fmt.Printf("\"sha256\": %q,\n\"signature\": %q\n", sum, sig)
+			return nil
+		}
+	}
+	fs := flag.NewFlagSet("update", flag.ContinueOnError)
+	check := fs.Bool("check", false, "Only report whether a newer release is available")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if cfg.Update == nil {
+		return errors.New("no update source configured; set update.url and update.public_key")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	rel, err := foldermonitor.CheckUpdate(ctx, cfg.Update)
+	if err != nil {
+		return err
+	}
+	if rel == nil {
+		fmt.Println("Already up to date:", foldermonitor.Version)
+		return nil
+	}
+	if *check {
+		fmt.Printf("Release %s is available (running %s)\n", rel.Version, foldermonitor.Version)
+		return nil
+	}
+	if err := foldermonitor.ApplyUpdate(ctx, cfg.Update, rel); err != nil {
+		return err
+	}
+	fmt.Printf("Updated from %s to %s\n", foldermonitor.Version, rel.Version)
+
+	s, err := service.New(&program{}, serviceConfig(cfg))
+	if err != nil {
+		return err
+	}
+	if status, err := s.Status(); err != nil || status != service.StatusRunning {
+		return nil
+	}
+	if err := s.Restart(); err != nil {
+		return fmt.Errorf("restarting the service: %v", err)
+	}
+	fmt.Println("Restarted the service")
+	return nil
+}
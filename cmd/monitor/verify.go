@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"vx-module/pkg/foldermonitor"
+)
+
+// runVerify implements "monitor verify [-rule name] [-hash] [-fix] [-days n]".
+func runVerify(cfg *foldermonitor.Config, args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	ruleName := fs.String("rule", "", "Only verify the named rule")
+	hash := fs.Bool("hash", false, "Compare SHA-256 digests as well as sizes")
+	fix := fs.Bool("fix", false, "Copy missing or mismatched files")
+	days := fs.Int("days", 0, "Only verify files modified within this many days")
+	fs.Parse(args)
+
+	store := foldermonitor.NewStore(filepath.Dir(configFile))
+	c, err := foldermonitor.NewCopier(cfg, store)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	problems := 0
+	for _, rule := range cfg.ActiveRules() {
+		if !rule.IsEnabled() || (*ruleName != "" && rule.Name != *ruleName) {
+			continue
+		}
+		report, err := c.Reconcile(ctx, rule, foldermonitor.ReconcileOptions{Hash: *hash, Fix: *fix, MaxAge: maxAge(*days)})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			fmt.Printf("Rule %s: %v\n", rule.Name, err)
+			problems++
+			continue
+		}
+		for _, path := range report.Missing {
+			fmt.Printf("  MISSING   %s\n", path)
+		}
+		for _, m := range report.Mismatched {
+			fmt.Printf("  MISMATCH  %s\n", m)
+		}
+		fmt.Printf("Rule %s: %d checked, %d ok, %d missing, %d mismatched", rule.Name, report.Checked, report.OK, len(report.Missing), len(report.Mismatched))
+		if *fix {
+			fmt.Printf(", %d fixed, %d failed", report.Fixed, report.Failed)
+			problems += report.Failed
+		} else {
+			problems += len(report.Missing) + len(report.Mismatched)
+		}
+		if report.Skipped > 0 {
+			fmt.Printf(", %d not copied by the rule", report.Skipped)
+		}
+		if report.Old > 0 {
+			fmt.Printf(", %d older than %d days left out", report.Old, *days)
+		}
+		fmt.Println()
+	}
+	if problems > 0 {
+		return fmt.Errorf("verification found %d problem(s)", problems)
+	}
+	return nil
+}
+
+// defaultSyncInterval separates passes of "sync" without -once.
+const defaultSyncInterval = 15 * time.Minute
+
+// maxAge converts a -days flag to the age limit of a reconciliation; zero
+// means no limit.
+func maxAge(days int) time.Duration {
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// runSync implements "monitor sync [-once] [-rule name] [-hash] [-days n]": a full
+// source to destination synchronisation of every rule, for hosts where a
+// resident service is not allowed. Without -once it repeats until
+// interrupted.
+func runSync(cfg *foldermonitor.Config, args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	once := fs.Bool("once", false, "Synchronise once and exit (for Task Scheduler or cron)")
+	ruleName := fs.String("rule", "", "Only synchronise the named rule")
+	hash := fs.Bool("hash", false, "Also re-copy files whose content differs, not just size")
+	interval := fs.Duration("interval", defaultSyncInterval, "Time between passes without -once")
+	days := fs.Int("days", 0, "Only copy files modified within this many days, e.g. on a first sync of old footage")
+	fs.Parse(args)
+
+	store := foldermonitor.NewStore(filepath.Dir(configFile))
+	c, err := foldermonitor.NewCopier(cfg, store)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	for {
+		failed := 0
+		rules := cfg.ActiveRules()
+		if paused, since := store.Paused(); paused {
+			fmt.Printf("Copying is paused since %s; skipping synchronisation\n", since.Format(time.RFC1123))
+			rules = nil
+		}
+		for _, rule := range rules {
+			if !rule.IsEnabled() || (*ruleName != "" && rule.Name != *ruleName) {
+				continue
+			}
+			report, err := c.Reconcile(ctx, rule, foldermonitor.ReconcileOptions{Hash: *hash, Fix: true, MaxAge: maxAge(*days)})
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err != nil {
+				fmt.Printf("Rule %s: %v\n", rule.Name, err)
+				failed++
+				continue
+			}
+			if rule.Archive != "" {
+				foldermonitor.SealSessions(rule, time.Now())
+			}
+			fmt.Printf("Rule %s: %d files, %d up to date, %d copied, %d failed", rule.Name, report.Checked, report.OK, report.Fixed, report.Failed)
+			if report.Old > 0 {
+				fmt.Printf(", %d older than %d days left out", report.Old, *days)
+			}
+			fmt.Println()
+			failed += report.Failed
+		}
+		if *once {
+			if failed > 0 {
+				return fmt.Errorf("%d file(s) could not be synchronised", failed)
+			}
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(*interval):
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/term"
+	"vx-module/pkg/foldermonitor"
+)
+
+// configAliases maps shorthand keys accepted by "config set" to fields.
+var configAliases = map[string]string{
+	"source": "source_dir",
+	"dest":   "dest_dir",
+}
+
+// runConfigCommand implements "monitor config" (interactive setup),
+// "monitor config set key=value ..." and "monitor config show".
+func runConfigCommand(args []string) error {
+	cfg, err := foldermonitor.ReadConfig(configFile)
+	if os.IsNotExist(err) {
+		cfg, err = &foldermonitor.Config{}, nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return runWizard(cfg)
+	}
+	switch args[0] {
+	case "set":
+		if len(args) == 1 {
+			return fmt.Errorf("usage: config set key=value [key=value ...]")
+		}
+		for _, arg := range args[1:] {
+			key, value, ok := strings.Cut(arg, "=")
+			if !ok {
+				return fmt.Errorf("%q is not key=value", arg)
+			}
+			if alias, ok := configAliases[key]; ok {
+				key = alias
+			}
+			if err := foldermonitor.SetConfigField(cfg, key, value); err != nil {
+				return err
+			}
+		}
+		if problems := foldermonitor.ValidateConfig(cfg); len(problems) > 0 {
+			return problems
+		}
+		if err := foldermonitor.WriteConfig(configFile, cfg); err != nil {
+			return err
+		}
+		fmt.Println("Configuration saved successfully to", configFile)
+		return nil
+	case "show":
+		data, err := foldermonitor.EncodeConfig(cfg, foldermonitor.ConfigFormat(configFile))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("# %s\n%s\n", configFile, data)
+		return nil
+	}
+	return fmt.Errorf("unknown config command %q (want set or show)", args[0])
+}
+
+// runWizard prompts for each setting on the terminal, validating answers
+// before saving.
+func runWizard(cfg *foldermonitor.Config) error {
+	in := newLineReader()
+	fmt.Println("Folder Monitor setup. Press Tab to complete paths, Ctrl+C to abort.")
+	source, target := cfg.DefaultFolders()
+	for {
+		src, err := in.prompt("Source folder", source)
+		if err != nil {
+			return err
+		}
+		if info, err := os.Stat(src); err != nil || !info.IsDir() {
+			fmt.Printf("  %q is not an existing folder.\n", src)
+			continue
+		}
+		source = src
+		break
+	}
+	for {
+		dest, err := in.prompt("Destination folder", target)
+		if err != nil {
+			return err
+		}
+		if dest == "" {
+			continue
+		}
+		if _, err := os.Stat(dest); os.IsNotExist(err) {
+			answer, err := in.prompt(fmt.Sprintf("  %s does not exist. Create it? (y/n)", dest), "y")
+			if err != nil {
+				return err
+			}
+			if !strings.HasPrefix(strings.ToLower(answer), "y") {
+				continue
+			}
+			if err := os.MkdirAll(dest, os.ModePerm); err != nil {
+				fmt.Printf("  Could not create folder: %v\n", err)
+				continue
+			}
+		}
+		cfg.SetDefaultFolders(source, dest)
+		if problems := foldermonitor.ValidateConfig(cfg); len(problems) > 0 {
+			fmt.Println(" ", problems)
+			continue
+		}
+		break
+	}
+	addr, err := in.prompt("Web UI address (host:port, empty to disable)", cfg.UIAddr)
+	if err != nil {
+		return err
+	}
+	cfg.UIAddr = addr
+	if problems := foldermonitor.ValidateConfig(cfg); len(problems) > 0 {
+		return problems
+	}
+	if err := foldermonitor.WriteConfig(configFile, cfg); err != nil {
+		return err
+	}
+	fmt.Println("Configuration saved successfully to", configFile)
+	return nil
+}
+
+// lineReader reads answers from stdin, with path completion when stdin is
+// a terminal.
+type lineReader struct {
+	fd    int
+	isTTY bool
+	buf   *bufio.Reader
+}
+
+func newLineReader() *lineReader {
+	fd := int(os.Stdin.Fd())
+	return &lineReader{fd: fd, isTTY: term.IsTerminal(fd), buf: bufio.NewReader(os.Stdin)}
+}
+
+// prompt asks a question showing def, which is returned for an empty answer.
+func (r *lineReader) prompt(question, def string) (string, error) {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", question, def)
+	} else {
+		fmt.Printf("%s: ", question)
+	}
+	var line string
+	var err error
+	if r.isTTY {
+		line, err = r.readRaw()
+	} else {
+		line, err = r.buf.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return def, nil
+	}
+	return line, nil
+}
+
+// readRaw reads one line in raw mode, handling editing keys and Tab.
+func (r *lineReader) readRaw() (string, error) {
+	state, err := term.MakeRaw(r.fd)
+	if err != nil {
+		return r.buf.ReadString('\n')
+	}
+	defer term.Restore(r.fd, state)
+
+	var line []rune
+	for {
+		c, _, err := r.buf.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch c {
+		case '\r', '\n':
+			fmt.Print("\r\n")
+			return string(line), nil
+		case 3: // Ctrl+C
+			fmt.Print("^C\r\n")
+			return "", fmt.Errorf("setup aborted")
+		case 4: // Ctrl+D
+			if len(line) == 0 {
+				fmt.Print("\r\n")
+				return "", io.EOF
+			}
+		case 127, 8: // Backspace
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				fmt.Print("\b \b")
+			}
+		case '\t':
+			completed, candidates := completePath(string(line))
+			if len(candidates) > 1 {
+				fmt.Print("\r\n" + strings.Join(candidates, "  ") + "\r\n> ")
+				fmt.Print(completed)
+			} else {
+				fmt.Print(completed[len(string(line)):])
+			}
+			line = []rune(completed)
+		default:
+			if c >= 32 {
+				line = append(line, c)
+				fmt.Print(string(c))
+			}
+		}
+	}
+}
+
+// completePath extends prefix to the longest unambiguous folder path and
+// returns the matching candidates' base names.
+func completePath(prefix string) (string, []string) {
+	dir, base := filepath.Split(prefix)
+	readDir := dir
+	if readDir == "" {
+		readDir = "."
+	}
+	entries, err := os.ReadDir(readDir)
+	if err != nil {
+		return prefix, nil
+	}
+	var matches []string
+	for _, e := range entries {
+		if e.IsDir() && strings.HasPrefix(strings.ToLower(e.Name()), strings.ToLower(base)) {
+			matches = append(matches, e.Name())
+		}
+	}
+	sort.Strings(matches)
+	switch len(matches) {
+	case 0:
+		return prefix, nil
+	case 1:
+		return dir + matches[0] + string(filepath.Separator), matches
+	}
+	common := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(strings.ToLower(m), strings.ToLower(common)) {
+			common = common[:len(common)-1]
+		}
+	}
+	if len(common) < len(base) {
+		common = base
+	}
+	return dir + common, matches
+}
@@ -3,12 +3,26 @@ module vx-module
 go 1.24.1
 
 require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/Microsoft/go-winio v0.6.2
+	github.com/eclipse/paho.mqtt.golang v1.5.0
+	github.com/expr-lang/expr v1.17.8
 	github.com/fsnotify/fsnotify v1.8.0
 	github.com/kardianos/service v1.2.2
+	github.com/klauspost/compress v1.18.0
 	github.com/sqweek/dialog v0.0.0-20240226140203-065105509627
+	github.com/yuin/gopher-lua v1.1.2
+	github.com/zalando/go-keyring v0.2.8
+	golang.org/x/sys v0.27.0
+	golang.org/x/term v0.22.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/TheTitanrain/w32 v0.0.0-20180517000239-4f5cfb03fabf // indirect
-	golang.org/x/sys v0.13.0 // indirect
+	github.com/danieljoos/wincred v1.2.3 // indirect
+	github.com/godbus/dbus/v5 v5.2.2 // indirect
+	github.com/gorilla/websocket v1.5.3 // indirect
+	golang.org/x/net v0.27.0 // indirect
+	golang.org/x/sync v0.7.0 // indirect
 )
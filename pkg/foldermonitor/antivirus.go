@@ -0,0 +1,147 @@
+package foldermonitor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// errInfected marks files the virus scanner reported; errScan marks scans
+// that could not be completed.
+var (
+	errInfected = errors.New("the virus scanner found a threat")
+	errScan     = errors.New("virus scan")
+)
+
+// Antivirus gate defaults.
+const (
+	defaultScanRelease = 30 * time.Second
+	defaultScanTimeout = 2 * time.Minute
+	scanReleasePoll    = 500 * time.Millisecond
+	// scanInfectedCode is the exit code of a scanner Command that found a
+	// threat.
+	scanInfectedCode = 1
+)
+
+// AntivirusOptions hold each new file back until the virus scanner is done
+// with it, instead of copies failing with sharing violations while it
+// scans. A file is first given up to Release to be let go by whoever holds
+// it open, then, with Defender or Command, scanned on demand. Files with a
+// threat are quarantined; a scan that fails is retried like a failed copy.
+type AntivirusOptions struct {
+	// Release is the longest to wait for the scanner to close a new file
+	// (default 30s). Only Windows reports files held open by another
+	// program; elsewhere the wait is skipped.
+	Release Duration `json:"release,omitempty"`
+	// Defender scans each file with Windows Defender's MpCmdRun.
+	Defender bool `json:"defender,omitempty"`
+	// Command is another scanner, run with the file, whose exit code is 0
+	// for a clean file and 1 for a threat, e.g. ["clamdscan", "--no-summary",
+	// "{source}"]; {source} is replaced by the file's path, which is
+	// added at the end when the command does not name it.
+	Command []string `json:"command,omitempty"`
+	// Timeout bounds each scan (default 2m).
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+// clear waits for the scanner to release the file at path and scans it,
+// returning an error wrapping errInfected or errScan unless it is clean.
+func (a *AntivirusOptions) clear(ctx context.Context, rule Rule, path string) error {
+	if err := a.awaitRelease(ctx, rule, path); err != nil {
+		return err
+	}
+	args := a.Command
+	infected := scanInfectedCode
+	if a.Defender {
+		args, infected = defenderScan(path)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, a.Timeout.or(defaultScanTimeout))
+	defer cancel()
+	argv := make([]string, len(args))
+	for i, arg := range args {
+		argv[i] = strings.ReplaceAll(arg, "{source}", path)
+	}
+	if !a.Defender && !strings.Contains(strings.Join(args, " "), "{source}") {
+		argv = append(argv, path)
+	}
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	cmd.WaitDelay = time.Second
+	started := time.Now()
+	err := cmd.Run()
+	detail := strings.TrimSpace(string(firstLine(bytes.TrimSpace(out.Bytes()))))
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+		logDebugf("Rule %s: %s is clean, scanned in %s", rule.Name, path, time.Since(started).Round(time.Millisecond))
+		return nil
+	case ctx.Err() != nil && ctx.Err() != context.DeadlineExceeded:
+		return context.Cause(ctx)
+	case ctx.Err() != nil:
+		err = fmt.Errorf("%w of %s took longer than %s", errScan, path, a.Timeout.or(defaultScanTimeout))
+	case errors.As(err, &exit) && exit.ExitCode() == infected:
+		logEvent(CategoryQuarantine, LevelError, "Rule %s: the virus scanner found a threat in %s: %s", rule.Name, path, detail)
+		return fmt.Errorf("%w in %s: %s", errInfected, path, detail)
+	default:
+		err = fmt.Errorf("%w of %s with %s: %v: %s", errScan, path, argv[0], err, detail)
+	}
+	logEvent(CategoryCopyFailure, LevelWarning, "Rule %s: %v", rule.Name, err)
+	return err
+}
+
+// awaitRelease waits until no other program holds the file at path open,
+// for at most Release, and then lets the copy go ahead either way.
+func (a *AntivirusOptions) awaitRelease(ctx context.Context, rule Rule, path string) error {
+	deadline := time.Now().Add(a.Release.or(defaultScanRelease))
+	for waited := false; ; waited = true {
+		err := openExclusive(path)
+		if err == nil || ErrorReason(err) != "locked" {
+			if waited {
+				logDebugf("Rule %s: %s was released", rule.Name, path)
+			}
+			return nil
+		}
+		if time.Now().After(deadline) {
+			logDebugf("Rule %s: %s is still held open after %s, copying it anyway", rule.Name, path, a.Release.or(defaultScanRelease))
+			return nil
+		}
+		select {
+		case <-time.After(scanReleasePoll):
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+}
+
+// validateAntivirus checks a rule's antivirus settings.
+func validateAntivirus(key func(string) string, a *AntivirusOptions) ConfigErrors {
+	var problems ConfigErrors
+	if a.Defender && len(a.Command) > 0 {
+		problems = append(problems, key("antivirus")+".defender cannot be combined with command")
+	}
+	if a.Defender {
+		if args, _ := defenderScan(""); args == nil {
+			problems = append(problems, key("antivirus")+".defender needs Windows Defender, which is only available on Windows")
+		} else if _, err := os.Stat(args[0]); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.defender: %v", key("antivirus"), err))
+		}
+	}
+	if len(a.Command) > 0 {
+		if _, err := exec.LookPath(a.Command[0]); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.command %q was not found: %v", key("antivirus"), a.Command[0], err))
+		}
+	}
+	if a.Release < 0 || a.Timeout < 0 {
+		problems = append(problems, key("antivirus")+".release and timeout must be positive durations such as \"30s\"")
+	}
+	return problems
+}
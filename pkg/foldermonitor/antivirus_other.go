@@ -0,0 +1,14 @@
+//go:build !windows
+
+package foldermonitor
+
+// defenderScan returns nil: Windows Defender is only available on Windows.
+func defenderScan(string) ([]string, int) {
+	return nil, 0
+}
+
+// openExclusive returns nil: other systems do not lock files open in
+// another program.
+func openExclusive(string) error {
+	return nil
+}
@@ -0,0 +1,36 @@
+package foldermonitor
+
+import (
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/windows"
+)
+
+// defenderExitThreat is MpCmdRun's exit code when a scan found a threat.
+const defenderExitThreat = 2
+
+// defenderScan returns the MpCmdRun command line scanning the file at path
+// without removing threats, and its exit code for a threat.
+func defenderScan(path string) ([]string, int) {
+	dir := os.Getenv("ProgramFiles")
+	if dir == "" {
+		dir = `C:\Program Files`
+	}
+	exe := filepath.Join(dir, "Windows Defender", "MpCmdRun.exe")
+	return []string{exe, "-Scan", "-ScanType", "3", "-File", path, "-DisableRemediation"}, defenderExitThreat
+}
+
+// openExclusive opens the file at path without sharing it, which fails
+// with ERROR_SHARING_VIOLATION while another program holds it open.
+func openExclusive(path string) error {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	h, err := windows.CreateFile(p, windows.GENERIC_READ, 0, nil, windows.OPEN_EXISTING, windows.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return windows.CloseHandle(h)
+}
@@ -0,0 +1,264 @@
+package foldermonitor
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// stagingDirName holds loose files of sessions that have not been archived
+// yet, below the rule's destination.
+const stagingDirName = ".staging"
+
+// sessionLayout names sessions; one session is one calendar day.
+const sessionLayout = "2006-01-02"
+
+// archiveSuffix returns the file extension for an archive format.
+func archiveSuffix(format string) string {
+	switch format {
+	case "zip":
+		return ".zip"
+	case "tar.zst":
+		return ".tar.zst"
+	}
+	return ""
+}
+
+// validArchive reports whether format is supported.
+func validArchive(format string) bool {
+	return format == "" || archiveSuffix(format) != ""
+}
+
+// sessionStagingDir returns the folder collecting files for the session
+// containing t.
+func sessionStagingDir(rule Rule, t time.Time) string {
+	return filepath.Join(rule.DestDir, stagingDirName, t.Format(sessionLayout))
+}
+
+// manifestEntry describes one file in a session archive's index.json.
+type manifestEntry struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	SHA256   string    `json:"sha256"`
+	Modified time.Time `json:"modified"`
+}
+
+// sessionManifest is the index stored in each archive and beside it.
+type sessionManifest struct {
+	Rule    string          `json:"rule"`
+	Session string          `json:"session"`
+	Created time.Time       `json:"created"`
+	Files   []manifestEntry `json:"files"`
+}
+
+// SealSessions archives every staged session of rule older than today and
+// removes its staging folder.
+func SealSessions(rule Rule, now time.Time) {
+	root := filepath.Join(rule.DestDir, stagingDirName)
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return
+	}
+	today := now.Format(sessionLayout)
+	for _, e := range entries {
+		if !e.IsDir() || e.Name() >= today {
+			continue
+		}
+		if _, err := time.Parse(sessionLayout, e.Name()); err != nil {
+			continue
+		}
+		dir := filepath.Join(root, e.Name())
+		archive, err := sealSession(rule, e.Name(), dir)
+		if err != nil {
+			if svcLogger != nil {
+				svcLogger.Errorf("Rule %s: error archiving session %s: %v", rule.Name, e.Name(), err)
+			}
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil && svcLogger != nil {
+			svcLogger.Errorf("Rule %s: error removing staged session %s: %v", rule.Name, dir, err)
+		}
+		if svcLogger != nil {
+			svcLogger.Infof("Rule %s: archived session %s to %s", rule.Name, e.Name(), archive)
+		}
+	}
+}
+
+// sealSession writes the files in dir into one archive plus an index
+// manifest and returns the archive path.
+func sealSession(rule Rule, session, dir string) (string, error) {
+	manifest, err := buildManifest(rule, session, dir)
+	if err != nil {
+		return "", err
+	}
+	index, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	base := filepath.Join(rule.DestDir, session)
+	path := base + archiveSuffix(rule.Archive)
+	for n := 2; ; n++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			break
+		}
+		base = filepath.Join(rule.DestDir, fmt.Sprintf("%s-%d", session, n))
+		path = base + archiveSuffix(rule.Archive)
+	}
+
+	// Write to a temporary name so a crash never leaves a partial archive
+	// under the final name.
+	tmp := path + ".partial"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+	if rule.Archive == "zip" {
+		err = writeZip(f, dir, manifest, index)
+	} else {
+		err = writeTarZst(f, dir, manifest, index)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(base+".index.json", index, 0644); err != nil {
+		return "", err
+	}
+	if o := rule.Ownership; o != nil {
+		for _, p := range []string{path, base + ".index.json"} {
+			if err := o.apply(p, false); err != nil {
+				return "", err
+			}
+		}
+	}
+	return path, nil
+}
+
+// buildManifest hashes every file in dir.
+func buildManifest(rule Rule, session, dir string) (*sessionManifest, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	m := &sessionManifest{Rule: rule.Name, Session: session, Created: time.Now()}
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		sum, err := hashFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m.Files = append(m.Files, manifestEntry{Name: e.Name(), Size: info.Size(), SHA256: sum, Modified: info.ModTime()})
+	}
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Name < m.Files[j].Name })
+	return m, nil
+}
+
+// hashFile returns the hex SHA-256 of the file at path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return hashReader(f)
+}
+
+// hashReader returns the hex SHA-256 of everything read from r.
+func hashReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeZip stores the session files in a zip archive. Text payloads are
+// deflated; everything else, notably video, is stored as is.
+func writeZip(w io.Writer, dir string, m *sessionManifest, index []byte) error {
+	zw := zip.NewWriter(w)
+	for _, entry := range m.Files {
+		method := zip.Store
+		if matchesExtension(entry.Name, defaultCompressExtensions) {
+			method = zip.Deflate
+		}
+		hdr := &zip.FileHeader{Name: entry.Name, Method: method, Modified: entry.Modified}
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if err := appendFile(fw, filepath.Join(dir, entry.Name)); err != nil {
+			return err
+		}
+	}
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: "index.json", Method: zip.Deflate, Modified: m.Created})
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(index); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// writeTarZst stores the session files in a zstd-compressed tarball.
+func writeTarZst(w io.Writer, dir string, m *sessionManifest, index []byte) error {
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(zw)
+	for _, entry := range m.Files {
+		hdr := &tar.Header{Name: entry.Name, Mode: 0644, Size: entry.Size, ModTime: entry.Modified, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if err := appendFile(tw, filepath.Join(dir, entry.Name)); err != nil {
+			return err
+		}
+	}
+	hdr := &tar.Header{Name: "index.json", Mode: 0644, Size: int64(len(index)), ModTime: m.Created, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(index); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// appendFile copies the contents of path into w.
+func appendFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
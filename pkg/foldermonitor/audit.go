@@ -0,0 +1,112 @@
+package foldermonitor
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// auditHeader is the first row of every audit file.
+var auditHeader = []string{"timestamp", "rule", "src", "dst", "bytes", "sha256", "duration", "result"}
+
+// auditRecord is one copy event written to the audit log.
+type auditRecord struct {
+	Time     time.Time
+	Rule     string
+	Source   string
+	Dest     string
+	Bytes    int64
+	SHA256   string
+	Duration time.Duration
+	Err      error
+}
+
+// auditLog appends copy events to audit-YYYY-MM-DD.csv files in dir,
+// starting a new file at midnight.
+type auditLog struct {
+	mu   sync.Mutex
+	dir  string
+	day  string
+	file *os.File
+	w    *csv.Writer
+}
+
+// newAuditLog creates an audit log writing into dir. Files are opened
+// lazily on the first record.
+func newAuditLog(dir string) *auditLog {
+	return &auditLog{dir: dir}
+}
+
+// record appends rec to the file for its day. Failures are logged rather
+// than returned so that auditing never blocks copying.
+func (a *auditLog) record(rec auditRecord) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.rotate(rec.Time); err != nil {
+		if svcLogger != nil {
+			svcLogger.Errorf("Error opening audit log: %v", err)
+		}
+		return
+	}
+	result := "ok"
+	if rec.Err != nil {
+		result = "error: " + rec.Err.Error()
+	}
+	a.w.Write([]string{
+		rec.Time.Format(time.RFC3339),
+		rec.Rule,
+		rec.Source,
+		rec.Dest,
+		strconv.FormatInt(rec.Bytes, 10),
+		rec.SHA256,
+		fmt.Sprintf("%.3f", rec.Duration.Seconds()),
+		result,
+	})
+	a.w.Flush()
+	if err := a.w.Error(); err != nil && svcLogger != nil {
+		svcLogger.Errorf("Error writing audit log: %v", err)
+	}
+}
+
+// rotate makes sure the file for t's day is open. a.mu must be held.
+func (a *auditLog) rotate(t time.Time) error {
+	day := t.Format("2006-01-02")
+	if a.file != nil && a.day == day {
+		return nil
+	}
+	a.closeFile()
+	if err := os.MkdirAll(a.dir, os.ModePerm); err != nil {
+		return err
+	}
+	path := filepath.Join(a.dir, "audit-"+day+".csv")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	a.file, a.day, a.w = f, day, csv.NewWriter(f)
+	if info, err := f.Stat(); err == nil && info.Size() == 0 {
+		a.w.Write(auditHeader)
+	}
+	return nil
+}
+
+// closeFile closes the current file. a.mu must be held.
+func (a *auditLog) closeFile() {
+	if a.file != nil {
+		a.w.Flush()
+		a.file.Close()
+		a.file = nil
+	}
+}
+
+// Close flushes and closes the audit log.
+func (a *auditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.closeFile()
+	return nil
+}
@@ -0,0 +1,218 @@
+package foldermonitor
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Benchmark defaults.
+const (
+	defaultBenchFiles = 8
+	defaultBenchSize  = 64 << 20
+)
+
+// defaultBenchBuffers and defaultBenchConcurrency are the settings
+// compared unless others are given.
+var (
+	defaultBenchBuffers     = []int64{32 << 10, 256 << 10, 1 << 20, 4 << 20}
+	defaultBenchConcurrency = []int{1, 2, 4}
+)
+
+// BenchOptions tune Bench. Zero values select the defaults.
+type BenchOptions struct {
+	// Files is how many synthetic videos are copied per run (default 8),
+	// each Size bytes (default 64 MiB).
+	Files int
+	Size  int64
+	// Buffers are the copy_buffer sizes compared (default 32 KiB, 256
+	// KiB, 1 MiB and 4 MiB), Concurrency the numbers of copies run at
+	// once (default 1, 2 and 4), as volumes, uploads and retries do.
+	Buffers     []int64
+	Concurrency []int
+	// Verify are the verify_writes settings compared (default off only).
+	Verify []bool
+	// SlowRate simulates a slow destination by writing each copy at most
+	// this many bytes per second, like a share limiting every stream; zero
+	// writes at full speed.
+	SlowRate int64
+	// Dir is where the temporary source and store are made (default the
+	// system temp folder); DestDir is where the copies go (default Dir),
+	// e.g. a folder on the real destination disk or share.
+	Dir     string
+	DestDir string
+	// Progress, when set, is called with a line for each run.
+	Progress func(msg string)
+}
+
+// BenchResult is the outcome of copying the files with one combination
+// of settings.
+type BenchResult struct {
+	Buffer      int64
+	Concurrency int
+	Verify      bool
+	Files       int
+	Bytes       int64
+	Duration    time.Duration
+	// Failures counts files that were not copied; Err is the first error.
+	Failures int
+	Err      string
+}
+
+// Rate returns the throughput of the run in bytes per second.
+func (r BenchResult) Rate() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / r.Duration.Seconds()
+}
+
+// Bench copies synthetic files through the copy pipeline of this build,
+// the same rule handling, writes and verification that watched files go
+// through, once for every combination of buffer size, concurrency and
+// verification, so settings can be tuned to the site's hardware. The
+// source files are written once and the copies removed after each run.
+func Bench(ctx context.Context, opts BenchOptions) ([]BenchResult, error) {
+	if opts.Files <= 0 {
+		opts.Files = defaultBenchFiles
+	}
+	if opts.Size <= 0 {
+		opts.Size = defaultBenchSize
+	}
+	if len(opts.Buffers) == 0 {
+		opts.Buffers = defaultBenchBuffers
+	}
+	if len(opts.Concurrency) == 0 {
+		opts.Concurrency = defaultBenchConcurrency
+	}
+	if len(opts.Verify) == 0 {
+		opts.Verify = []bool{false}
+	}
+	progress := opts.Progress
+	if progress == nil {
+		progress = func(string) {}
+	}
+
+	dir, err := os.MkdirTemp(opts.Dir, "foldermonitor-bench-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	destRoot := opts.DestDir
+	if destRoot == "" {
+		destRoot = dir
+	}
+	dst, err := os.MkdirTemp(destRoot, "foldermonitor-bench-dest-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dst)
+	src := filepath.Join(dir, "source")
+	if err := os.Mkdir(src, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	progress(fmt.Sprintf("Writing %d files of %s", opts.Files, FormatBytes(opts.Size)))
+	files := make([]string, opts.Files)
+	for i := range files {
+		files[i] = filepath.Join(src, fmt.Sprintf("swing_%03d.mp4", i+1))
+		if err := writeRandomFile(files[i], opts.Size); err != nil {
+			return nil, err
+		}
+	}
+
+	var results []BenchResult
+	for _, verify := range opts.Verify {
+		for _, conc := range opts.Concurrency {
+			for _, buffer := range opts.Buffers {
+				r, err := benchRun(ctx, dir, src, dst, files, opts, buffer, conc, verify)
+				if err != nil {
+					return results, err
+				}
+				progress(fmt.Sprintf("buffer %s, %d at once, verify %v: %s/s", FormatBytes(buffer), conc, verify, FormatBytes(int64(r.Rate()))))
+				results = append(results, r)
+			}
+		}
+	}
+	return results, nil
+}
+
+// benchRun copies files from src to an emptied dst with one combination
+// of settings.
+func benchRun(ctx context.Context, dir, src, dst string, files []string, opts BenchOptions, buffer int64, conc int, verify bool) (BenchResult, error) {
+	r := BenchResult{Buffer: buffer, Concurrency: conc, Verify: verify, Files: len(files)}
+	if err := os.RemoveAll(dst); err != nil {
+		return r, err
+	}
+	if err := os.Mkdir(dst, os.ModePerm); err != nil {
+		return r, err
+	}
+	cfg := &Config{SourceDir: src, DestDir: dst, CopyBuffer: buffer}
+	if opts.SlowRate > 0 {
+		cfg.Chaos = &ChaosConfig{SlowDestination: 1, SlowRate: opts.SlowRate}
+	}
+	if problems := ValidateConfig(cfg); len(problems) > 0 {
+		return r, problems
+	}
+	// Runs must not compete for the same store files, such as stats.
+	store := NewStore(filepath.Join(dir, fmt.Sprintf("store-%d-%d-%v", buffer, conc, verify)))
+	c := newCopier(cfg, store, newStatusTracker())
+	defer c.Close()
+	rule := cfg.ActiveRules()[0]
+	rule.VerifyWrites = verify
+
+	var mu sync.Mutex
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for range conc {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range jobs {
+				info, err := os.Stat(path)
+				if err == nil {
+					err = c.archiveFile(ctx, rule, path, info, "")
+				}
+				mu.Lock()
+				if err != nil {
+					r.Failures++
+					if r.Err == "" {
+						r.Err = err.Error()
+					}
+				} else {
+					r.Bytes += info.Size()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, path := range files {
+		select {
+		case jobs <- path:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	r.Duration = time.Since(start)
+	return r, ctx.Err()
+}
+
+// writeRandomFile writes size random bytes to path.
+func writeRandomFile(path string, size int64) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(f, rand.Reader, size); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
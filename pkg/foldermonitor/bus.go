@@ -0,0 +1,145 @@
+package foldermonitor
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// EventKind identifies a stage transition in the copy pipeline.
+type EventKind string
+
+const (
+	// EventDetected: a new file or directory settled in a watched folder.
+	EventDetected EventKind = "detected"
+	// EventAccepted: a detected file passed the rule's filters.
+	EventAccepted EventKind = "accepted"
+	// EventCopied: a file reached its destination.
+	EventCopied EventKind = "copied"
+	// EventFailed: copying a file failed.
+	EventFailed EventKind = "failed"
+	// EventLate: a file was copied later than the latency SLO allows.
+	EventLate EventKind = "late"
+)
+
+// Event is published on the bus as files move through the pipeline. Dest
+// and the fields after it are set for copy outcomes only.
+type Event struct {
+	Kind   EventKind
+	Time   time.Time
+	Rule   Rule
+	Source string
+	// Seen is when a detected file first appeared, before its quiet
+	// period; Latency is the time from then to the copy, set on EventLate.
+	Seen    time.Time
+	Latency time.Duration
+	// Attempt counts earlier tries of a file that was requeued after a
+	// failed copy.
+	Attempt  int
+	Dest     string
+	Bytes    int64
+	SHA256   string
+	Duration time.Duration
+	Err      error
+	Class    ErrorClass // how Err should be handled
+	// RenamedFrom is set when the copy was made by renaming the
+	// destination of an earlier copy of the same content.
+	RenamedFrom string
+	transfer    *Transfer         // status entry of the copy, if any
+	modTime     time.Time         // of the source when it was copied
+	visual      visualPrint       // sampled look of a video, if taken
+	lesson      map[string]string // of the student the clip was filed under
+	ctx         context.Context   // cancels work started for the event
+}
+
+// Context returns the context of the run that published the event, which
+// stages pass on to the work they start. It is never nil.
+func (e Event) Context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
+}
+
+// WithContext returns a copy of e carrying ctx.
+func (e Event) WithContext(ctx context.Context) Event {
+	e.ctx = ctx
+	return e
+}
+
+// Consumer handles pipeline events.
+type Consumer func(Event)
+
+// Bus connects the stages of one monitoring run: detection publishes
+// detected files, filtering turns them into accepted ones, copying reports
+// outcomes, and any number of sinks (status, audit, log) consume those.
+// Delivery is synchronous and in registration order, so a consumer that
+// does slow I/O should hand events off to its own goroutine.
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[EventKind][]Consumer
+}
+
+// NewBus creates a bus with no consumers.
+func NewBus() *Bus {
+	return &Bus{subs: make(map[EventKind][]Consumer)}
+}
+
+// Subscribe registers fn for events of the given kinds.
+func (b *Bus) Subscribe(fn Consumer, kinds ...EventKind) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, k := range kinds {
+		b.subs[k] = append(b.subs[k], fn)
+	}
+}
+
+// Publish delivers e to every consumer of its kind, stamping the time if
+// it is not set.
+func (b *Bus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.RLock()
+	subs := b.subs[e.Kind]
+	b.mu.RUnlock()
+	for _, fn := range subs {
+		fn(e)
+	}
+}
+
+// filterStage accepts detected files unless the rule excludes them.
+func filterStage(bus *Bus) Consumer {
+	return func(e Event) {
+		if e.Rule.partial(e.Source) {
+			logDebugf("Rule %s: %s is still being written, waiting for its final name", e.Rule.Name, e.Source)
+			return
+		}
+		rel, err := filepath.Rel(e.Rule.SourceDir, e.Source)
+		if err == nil && e.Rule.excluded(rel) {
+			logDebugf("Rule %s: excluded %s", e.Rule.Name, e.Source)
+			return
+		}
+		e.Kind, e.Time = EventAccepted, time.Time{}
+		bus.Publish(e)
+	}
+}
+
+// logOutcome writes copy outcomes to the service log.
+func logOutcome(e Event) {
+	switch {
+	case e.RenamedFrom != "":
+		logEvent(CategoryCopy, LevelInfo, "Rule %s: renamed %s to %s, %s is a renamed copy of it", e.Rule.Name, e.RenamedFrom, e.Dest, e.Source)
+	case e.Err == nil:
+		logEvent(CategoryCopy, LevelInfo, "Rule %s: copied file %s to %s", e.Rule.Name, e.Source, e.Dest)
+	case e.Class == ClassCanceled:
+		logEvent(CategoryCopyFailure, LevelInfo, "Rule %s: copy of %s canceled", e.Rule.Name, e.Source)
+	case e.Class == ClassTransient:
+		logEvent(CategoryCopyFailure, LevelWarning, "Rule %s: transient error copying file: %v", e.Rule.Name, e.Err)
+	case e.Class == ClassDestination:
+		logEvent(CategoryDestination, LevelError, "Rule %s: destination %s needs attention: %v", e.Rule.Name, e.Rule.DestDir, e.Err)
+	default:
+		logEvent(CategoryCopyFailure, LevelError, "Rule %s: error copying file: %v", e.Rule.Name, e.Err)
+	}
+}
@@ -0,0 +1,112 @@
+package foldermonitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"time"
+)
+
+// defaultChaosSlowRate is the write speed of an injected slow destination.
+const defaultChaosSlowRate = 1 << 20
+
+// errChaos marks failures injected by chaos mode.
+var errChaos = errors.New("chaos: injected fault")
+
+// ChaosConfig injects faults at random for operational drills, to check
+// that retries, alerts and dashboards behave before real footage is
+// trusted to the system. It is not meant for production; the monitor
+// warns when it is on. Probabilities are between 0 and 1.
+type ChaosConfig struct {
+	// CopyFailure is the probability that a copy fails partway with a
+	// transient error, which is retried like a network blip.
+	CopyFailure float64 `json:"copy_failure,omitempty"`
+	// SlowDestination is the probability that a copy is written at only
+	// SlowRate bytes per second (default 1 MiB/s).
+	SlowDestination float64 `json:"slow_destination,omitempty"`
+	SlowRate        int64   `json:"slow_rate,omitempty"`
+	// WatcherError is the probability, at each health probe of a source
+	// folder, that its watch is declared dead and has to be recreated.
+	WatcherError float64 `json:"watcher_error,omitempty"`
+}
+
+// roll reports whether an event of probability p happens.
+func roll(p float64) bool {
+	return p > 0 && rand.Float64() < p
+}
+
+// wrapper returns a writerWrapper injecting the copy faults chosen for one
+// copy, or nil when none is.
+func (c *ChaosConfig) wrapper(ctx context.Context) writerWrapper {
+	fail, slow := roll(c.CopyFailure), roll(c.SlowDestination)
+	if !fail && !slow {
+		return nil
+	}
+	w := &chaosWriter{ctx: ctx, failAt: -1}
+	if fail {
+		// Fail somewhere in the first MiB, so small files fail too.
+		w.failAt = rand.Int64N(1 << 20)
+	}
+	if slow {
+		w.rate = c.SlowRate
+		if w.rate <= 0 {
+			w.rate = defaultChaosSlowRate
+		}
+	}
+	return func(next io.Writer) (io.WriteCloser, error) {
+		w.w = next
+		return w, nil
+	}
+}
+
+// chaosWriter fails or slows down the writes of one copy.
+type chaosWriter struct {
+	ctx     context.Context
+	w       io.Writer
+	written int64
+	failAt  int64 // byte offset of the injected failure, -1 for none
+	rate    int64 // bytes per second, 0 for full speed
+}
+
+func (c *chaosWriter) Write(p []byte) (int, error) {
+	if c.failAt >= 0 && c.written+int64(len(p)) > c.failAt {
+		return 0, fmt.Errorf("%w: copy failed after %s", errChaos, FormatBytes(c.written))
+	}
+	if c.rate > 0 {
+		timer := time.NewTimer(time.Duration(float64(len(p)) / float64(c.rate) * float64(time.Second)))
+		select {
+		case <-timer.C:
+		case <-c.ctx.Done():
+			timer.Stop()
+			return 0, c.ctx.Err()
+		}
+	}
+	n, err := c.w.Write(p)
+	c.written += int64(n)
+	return n, err
+}
+
+func (c *chaosWriter) Close() error { return nil }
+
+// validateChaos checks the chaos settings.
+func validateChaos(c *ChaosConfig) ConfigErrors {
+	var problems ConfigErrors
+	for _, p := range []struct {
+		key   string
+		value float64
+	}{
+		{"copy_failure", c.CopyFailure},
+		{"slow_destination", c.SlowDestination},
+		{"watcher_error", c.WatcherError},
+	} {
+		if p.value < 0 || p.value > 1 {
+			problems = append(problems, fmt.Sprintf("chaos.%s must be a probability between 0 and 1", p.key))
+		}
+	}
+	if c.SlowRate < 0 {
+		problems = append(problems, "chaos.slow_rate must not be negative")
+	}
+	return problems
+}
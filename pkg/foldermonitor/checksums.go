@@ -0,0 +1,154 @@
+package foldermonitor
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// checksumsFile is the manifest kept in each destination folder of rules
+// with checksums enabled.
+const checksumsFile = "checksums.txt"
+
+// checksumAlgorithms are the supported hashes for the manifest, with the
+// names used in BSD-style lines.
+var checksumAlgorithms = map[string]struct {
+	tag string
+	new func() hash.Hash
+}{
+	"md5":    {"MD5", md5.New},
+	"sha1":   {"SHA1", sha1.New},
+	"sha256": {"SHA256", sha256.New},
+	"crc32":  {"CRC32", func() hash.Hash { return crc32.NewIEEE() }},
+}
+
+// parseChecksumLine splits a manifest line in md5sum ("<hash>  <name>")
+// or BSD ("MD5 (<name>) = <hash>") format.
+func parseChecksumLine(line string) (name, sum string, ok bool) {
+	if i := strings.Index(line, ") = "); i > 0 {
+		if j := strings.Index(line, " ("); j > 0 && j < i {
+			return line[j+2 : i], line[i+4:], true
+		}
+	}
+	if i := strings.IndexByte(line, ' '); i > 0 && len(line) > i+2 {
+		return line[i+2:], line[:i], true
+	}
+	return "", "", false
+}
+
+// manifestMu serialises updates of the manifests of one process.
+var manifestMu sync.Mutex
+
+// updateChecksums records the hash of the archived file dest in the
+// manifest of its folder, replacing the entry for dest and, after a rename,
+// the one for the old name. The manifest is rewritten sorted by name.
+func updateChecksums(rule Rule, dest, sum, renamedFrom string) error {
+	dir := filepath.Dir(dest)
+	manifest := filepath.Join(dir, checksumsFile)
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	sums := make(map[string]string)
+	if data, err := os.ReadFile(manifest); err == nil {
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		for sc.Scan() {
+			if name, s, ok := parseChecksumLine(strings.TrimRight(sc.Text(), "\r")); ok {
+				sums[name] = s
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if renamedFrom != "" && samePath(filepath.Dir(renamedFrom), dir) {
+		delete(sums, filepath.Base(renamedFrom))
+	}
+	sums[filepath.Base(dest)] = sum
+
+	names := make([]string, 0, len(sums))
+	for name := range sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	tag := checksumAlgorithms[rule.checksumAlgorithm()].tag
+	for _, name := range names {
+		if rule.ChecksumFormat == "bsd" {
+			fmt.Fprintf(&buf, "%s (%s) = %s\n", tag, name, sums[name])
+		} else {
+			fmt.Fprintf(&buf, "%s  %s\n", sums[name], name)
+		}
+	}
+	tmp := manifest + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, manifest); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// checksumAlgorithm returns the manifest's hash, "" when it is disabled.
+func (o RuleOptions) checksumAlgorithm() string {
+	if o.Checksums == "" || o.Archive != "" {
+		return ""
+	}
+	return strings.ToLower(o.Checksums)
+}
+
+// recordChecksum is the bus consumer adding copied files to the manifest of
+// their destination folder. The archived bytes are hashed, which differ
+// from the source when the file was compressed or encrypted.
+func (c *Copier) recordChecksum(e Event) {
+	algo := e.Rule.checksumAlgorithm()
+	if algo == "" || e.Dest == "" {
+		return
+	}
+	sum := e.SHA256
+	if algo != "sha256" || sum == "" || shouldCompress(e.Rule, e.Source) || e.Rule.Encrypt {
+		f, err := os.Open(e.Dest)
+		if err != nil {
+			logEvent(CategoryCopyFailure, LevelWarning, "Rule %s: cannot hash %s for %s: %v", e.Rule.Name, e.Dest, checksumsFile, err)
+			return
+		}
+		h := checksumAlgorithms[algo].new()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			logEvent(CategoryCopyFailure, LevelWarning, "Rule %s: cannot hash %s for %s: %v", e.Rule.Name, e.Dest, checksumsFile, err)
+			return
+		}
+		sum = hex.EncodeToString(h.Sum(nil))
+	}
+	if err := updateChecksums(e.Rule, e.Dest, sum, e.RenamedFrom); err != nil {
+		logEvent(CategoryDestination, LevelWarning, "Rule %s: cannot update %s: %v", e.Rule.Name, filepath.Join(filepath.Dir(e.Dest), checksumsFile), err)
+	}
+}
+
+// validateChecksums checks a rule's manifest settings.
+func validateChecksums(key func(string) string, o RuleOptions) ConfigErrors {
+	var problems ConfigErrors
+	if _, ok := checksumAlgorithms[strings.ToLower(o.Checksums)]; o.Checksums != "" && !ok {
+		problems = append(problems, fmt.Sprintf("%s %q is not supported (want md5, sha1, sha256 or crc32)", key("checksums"), o.Checksums))
+	}
+	if o.ChecksumFormat != "" && o.ChecksumFormat != "md5sum" && o.ChecksumFormat != "bsd" {
+		problems = append(problems, fmt.Sprintf("%s %q is not supported (want md5sum or bsd)", key("checksum_format"), o.ChecksumFormat))
+	}
+	if o.Checksums != "" && o.Archive != "" {
+		problems = append(problems, key("checksums")+" cannot be combined with archive, whose index lists the hashes")
+	}
+	return problems
+}
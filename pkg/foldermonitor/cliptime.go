@@ -0,0 +1,172 @@
+package foldermonitor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Clip time defaults.
+const (
+	// defaultClipEarliest is before any clip a working camera recorded;
+	// earlier times come from a clock that was reset.
+	defaultClipEarliest = "2010-01-01"
+	// clipFutureSlack is how far ahead of this machine's clock a time may
+	// be before it counts as wrong.
+	clipFutureSlack = 24 * time.Hour
+)
+
+// defaultClipSources is the precedence of the sources of a clip's time.
+var defaultClipSources = []string{"filename", "mtime", "detected"}
+
+// defaultNameLayouts are the timestamps looked for in file names when no
+// layout is configured, as written by common cameras and phones.
+var defaultNameLayouts = []string{
+	"20060102_150405", "20060102-150405", "2006-01-02_15-04-05",
+	"2006-01-02 15.04.05", "2006-01-02-15-04-05", "20060102150405",
+}
+
+// ClipTimeOptions decide when a clip was recorded, which fills the {date},
+// {hour} and {time} placeholders and picks the lesson of a student lookup.
+// Cameras whose clock was never set stamp their files years in the past,
+// so other sources can be preferred to the modification time.
+type ClipTimeOptions struct {
+	// Sources lists where the time is taken from, in order of preference:
+	// "filename" (a timestamp in the file name), "mtime" (the modification
+	// time) and "detected" (when the monitor copies the file). The default
+	// is all three in that order. A source that gives no time or an
+	// implausible one is passed over.
+	Sources []string `json:"sources,omitempty"`
+	// Layout is the timestamp in file names, in Go's reference layout:
+	// "20060102_150405" finds VID_20261014_153000.mp4. By default a number
+	// of common forms are tried.
+	Layout string `json:"layout,omitempty"`
+	// Earliest is the first plausible date, as YYYY-MM-DD (default
+	// "2010-01-01"). Times after tomorrow are never plausible.
+	Earliest string `json:"earliest,omitempty"`
+	// MaxSkew, when set, also passes over a modification time more than
+	// this far from when the file is copied. It suits folders copied as
+	// clips are recorded, not the first sync of an old backlog.
+	MaxSkew Duration `json:"max_skew,omitempty"`
+}
+
+// skewWarned records the rules whose camera clock was reported wrong, so
+// that it is logged once per rule rather than for every clip.
+var skewWarned sync.Map
+
+// clipTime returns when the clip at path was recorded according to the
+// rule: its modification time unless the rule configures clip_time.
+func clipTime(rule Rule, path string, info os.FileInfo) time.Time {
+	o := rule.ClipTime
+	if o == nil {
+		return info.ModTime()
+	}
+	now := time.Now()
+	earliest, err := time.ParseInLocation(time.DateOnly, o.Earliest, time.Local)
+	if o.Earliest == "" || err != nil {
+		earliest, _ = time.ParseInLocation(time.DateOnly, defaultClipEarliest, time.Local)
+	}
+	plausible := func(t time.Time) bool {
+		return !t.Before(earliest) && t.Before(now.Add(clipFutureSlack))
+	}
+	sources := o.Sources
+	if len(sources) == 0 {
+		sources = defaultClipSources
+	}
+	for _, source := range sources {
+		switch source {
+		case "filename":
+			if t, ok := o.nameTime(filepath.Base(path)); ok && plausible(t) {
+				return t
+			}
+		case "mtime":
+			mod := info.ModTime()
+			if plausible(mod) && (o.MaxSkew <= 0 || mod.Sub(now).Abs() <= time.Duration(o.MaxSkew)) {
+				return mod
+			}
+			if _, warned := skewWarned.LoadOrStore(rule.Name, true); !warned {
+				logEvent(CategoryCopy, LevelWarning, "Rule %s: %s was modified %s, the camera's clock looks wrong; using the next clip_time source",
+					rule.Name, path, mod.Format(time.DateTime))
+			}
+		case "detected":
+			return now
+		}
+	}
+	return info.ModTime()
+}
+
+// nameTime finds the timestamp of the layout, or one of the default ones,
+// in the file name.
+func (o *ClipTimeOptions) nameTime(name string) (time.Time, bool) {
+	layouts := defaultNameLayouts
+	if o.Layout != "" {
+		layouts = []string{o.Layout}
+	}
+	for _, layout := range layouts {
+		for _, m := range layoutPattern(layout).FindAllString(name, -1) {
+			if t, err := time.ParseInLocation(layout, m, time.Local); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// layoutElements are the parts of a time layout that stand for digits.
+var layoutElements = []string{"2006", "01", "02", "15", "04", "05"}
+
+// layoutPatterns caches the patterns of the layouts.
+var layoutPatterns sync.Map
+
+// layoutPattern returns a pattern matching text in the layout, with the
+// digits of its elements and the rest taken literally.
+func layoutPattern(layout string) *regexp.Regexp {
+	if re, ok := layoutPatterns.Load(layout); ok {
+		return re.(*regexp.Regexp)
+	}
+	var b strings.Builder
+	rest := layout
+outer:
+	for rest != "" {
+		for _, el := range layoutElements {
+			if strings.HasPrefix(rest, el) {
+				fmt.Fprintf(&b, `\d{%d}`, len(el))
+				rest = rest[len(el):]
+				continue outer
+			}
+		}
+		b.WriteString(regexp.QuoteMeta(rest[:1]))
+		rest = rest[1:]
+	}
+	re := regexp.MustCompile(b.String())
+	layoutPatterns.Store(layout, re)
+	return re
+}
+
+// validateClipTime checks a rule's clip time settings.
+func validateClipTime(key func(string) string, o *ClipTimeOptions) ConfigErrors {
+	var problems ConfigErrors
+	for _, s := range o.Sources {
+		if s != "filename" && s != "mtime" && s != "detected" {
+			problems = append(problems, fmt.Sprintf("%s.sources: %q is not supported (want filename, mtime or detected)", key("clip_time"), s))
+		}
+	}
+	if o.Layout != "" {
+		if !strings.Contains(o.Layout, "2006") || !strings.Contains(o.Layout, "01") || !strings.Contains(o.Layout, "02") {
+			problems = append(problems, fmt.Sprintf("%s.layout %q needs at least the year, month and day as 2006, 01 and 02", key("clip_time"), o.Layout))
+		}
+	}
+	if o.Earliest != "" {
+		if _, err := time.Parse(time.DateOnly, o.Earliest); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.earliest %q must be a date such as \"2010-01-01\"", key("clip_time"), o.Earliest))
+		}
+	}
+	if o.MaxSkew < 0 {
+		problems = append(problems, key("clip_time")+".max_skew must be a positive duration such as \"24h\"")
+	}
+	return problems
+}
@@ -0,0 +1,310 @@
+package foldermonitor
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Cold tier defaults.
+const (
+	defaultColdStorageClass = "GLACIER"
+	defaultColdTier         = "Archive"
+	coldInterval            = time.Hour
+	coldUploadTimeout       = 2 * time.Hour
+	// maxColdUpload is the largest object uploaded in a single request,
+	// within the limits of both S3 and Azure Blob Storage.
+	maxColdUpload = 5000 << 20
+	azureVersion  = "2021-08-06"
+)
+
+// ColdTierOptions move a rule's archived files to cold storage some days
+// after they were copied, so the destination works as a fast local
+// archive of recent lessons. Uploads are recorded in the store's history,
+// and a file is uploaded only once.
+type ColdTierOptions struct {
+	// AfterDays is the age of a copy in days when it is uploaded.
+	AfterDays int `json:"after_days"`
+	// Backend is "s3" or "azure".
+	Backend string `json:"backend"`
+	// Bucket and Region select the S3 bucket. Endpoint replaces
+	// AWS's, e.g. for MinIO, with path-style addressing. AccessKey and
+	// SecretKey default to $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY;
+	// $AWS_SESSION_TOKEN is sent when set. StorageClass defaults to
+	// "GLACIER"; "DEEP_ARCHIVE" and "GLACIER_IR" also suit.
+	Bucket       string `json:"bucket,omitempty"`
+	Region       string `json:"region,omitempty"`
+	Endpoint     string `json:"endpoint,omitempty"`
+	AccessKey    string `json:"access_key,omitempty"`
+	SecretKey    string `json:"secret_key,omitempty"`
+	StorageClass string `json:"storage_class,omitempty"`
+	// ContainerURL is the URL of the Azure container with a SAS token
+	// allowing writes, e.g.
+	// "https://club.blob.core.windows.net/lessons?sv=...&sig=...". Tier
+	// is the access tier of uploaded blobs (default "Archive").
+	ContainerURL string `json:"container_url,omitempty"`
+	Tier         string `json:"tier,omitempty"`
+	// Prefix is prepended to the path of each file below the destination
+	// folder to form its object name.
+	Prefix string `json:"prefix,omitempty"`
+	// RemoveLocal deletes the local copy once it is uploaded.
+	RemoveLocal bool `json:"remove_local,omitempty"`
+}
+
+// tierCold runs the cold stage of the rules once an hour until ctx is
+// cancelled.
+func (c *Copier) tierCold(ctx context.Context, rules []Rule) {
+	ticker := time.NewTicker(coldInterval)
+	defer ticker.Stop()
+	for {
+		for _, r := range rules {
+			if r.Cold != nil && ctx.Err() == nil {
+				c.tierRule(ctx, r)
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// tierRule uploads the copies of the rule that are old enough and not
+// uploaded yet.
+func (c *Copier) tierRule(ctx context.Context, rule Rule) {
+	if rule.Rollup != nil && rule.Rollup.Cold {
+		// The monthly archives are uploaded instead.
+		return
+	}
+	cold := rule.Cold
+	cutoff := time.Now().AddDate(0, 0, -cold.AfterDays)
+	moved, failed := 0, 0
+	var lastErr error
+	for _, rec := range c.store.historyByRule(rule.Name) {
+		if rec.ColdURL != "" || rec.Time.After(cutoff) || ctx.Err() != nil {
+			continue
+		}
+		if _, err := os.Stat(rec.Dest); err != nil {
+			continue
+		}
+		location, err := cold.upload(ctx, rule, rec.Dest)
+		if err != nil {
+			failed, lastErr = failed+1, err
+			continue
+		}
+		rec.ColdURL, rec.ColdAt, rec.RenamedFrom = location, time.Now(), ""
+		if err := c.store.recordCopy(rec); err != nil {
+			failed, lastErr = failed+1, err
+			continue
+		}
+		moved++
+		logDebugf("Rule %s: uploaded %s to %s", rule.Name, rec.Dest, location)
+		if cold.RemoveLocal {
+			if err := os.Remove(rec.Dest); err != nil {
+				logEvent(CategoryDestination, LevelWarning, "Rule %s: cannot remove %s after uploading it: %v", rule.Name, rec.Dest, err)
+			}
+		}
+	}
+	if failed > 0 {
+		logEvent(CategoryDestination, LevelWarning, "Rule %s: moved %d files to cold storage, %d failed, the last with: %v", rule.Name, moved, failed, lastErr)
+	} else if moved > 0 {
+		logEvent(CategoryCopy, LevelInfo, "Rule %s: moved %d files to cold storage", rule.Name, moved)
+	}
+}
+
+// objectName returns the name under which the archived file dest of the
+// rule is stored.
+func (o *ColdTierOptions) objectName(rule Rule, dest string) string {
+	rel, ok := relInside(rule.DestDir, dest)
+	if !ok {
+		rel = filepath.Base(dest)
+	}
+	return strings.TrimLeft(o.Prefix+filepath.ToSlash(rel), "/")
+}
+
+// upload stores the file at dest in the backend and returns where.
+func (o *ColdTierOptions) upload(ctx context.Context, rule Rule, dest string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, coldUploadTimeout)
+	defer cancel()
+	f, err := os.Open(dest)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if info.Size() > maxColdUpload {
+		return "", fmt.Errorf("%s is larger than the %s a single upload allows", filepath.Base(dest), FormatBytes(maxColdUpload))
+	}
+	name := o.objectName(rule, dest)
+	var req *http.Request
+	var location string
+	if o.Backend == "s3" {
+		// The payload hash is signed, so S3 rejects a corrupted upload.
+		sum, err := hashFile(dest)
+		if err != nil {
+			return "", err
+		}
+		if req, err = o.s3Request(ctx, name, f, sum); err != nil {
+			return "", err
+		}
+		location = "s3://" + o.Bucket + "/" + name
+	} else {
+		u, err := url.Parse(o.ContainerURL)
+		if err != nil {
+			return "", err
+		}
+		u.Path = strings.TrimRight(u.Path, "/") + "/" + name
+		if req, err = http.NewRequestWithContext(ctx, http.MethodPut, u.String(), f); err != nil {
+			return "", err
+		}
+		tier := o.Tier
+		if tier == "" {
+			tier = defaultColdTier
+		}
+		req.Header.Set("x-ms-blob-type", "BlockBlob")
+		req.Header.Set("x-ms-access-tier", tier)
+		req.Header.Set("x-ms-version", azureVersion)
+		u.RawQuery = ""
+		location = u.String()
+	}
+	req.ContentLength = info.Size()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("uploading %s: %s: %s", name, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return location, nil
+}
+
+// s3Request builds the signed PUT of an object whose content has the
+// given SHA-256, using AWS Signature Version 4.
+func (o *ColdTierOptions) s3Request(ctx context.Context, name string, body io.Reader, sum string) (*http.Request, error) {
+	access, secret := o.AccessKey, o.SecretKey
+	if access == "" {
+		access, secret = os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if access == "" || secret == "" {
+		return nil, fmt.Errorf("no S3 credentials: set access_key and secret_key or $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY")
+	}
+	class := o.StorageClass
+	if class == "" {
+		class = defaultColdStorageClass
+	}
+	var target string
+	if o.Endpoint != "" {
+		target = strings.TrimRight(o.Endpoint, "/") + "/" + o.Bucket + "/" + s3Escape(name)
+	} else {
+		target = "https://" + o.Bucket + ".s3." + o.Region + ".amazonaws.com/" + s3Escape(name)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, body)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	amzDate, day := now.Format("20060102T150405Z"), now.Format("20060102")
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": sum,
+		"x-amz-date":           amzDate,
+		"x-amz-storage-class":  class,
+	}
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" && o.AccessKey == "" {
+		headers["x-amz-security-token"] = token
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	for _, k := range names {
+		canonical.WriteString(k + ":" + headers[k] + "\n")
+		if k != "host" {
+			req.Header.Set(k, headers[k])
+		}
+	}
+	signed := strings.Join(names, ";")
+	creq := strings.Join([]string{http.MethodPut, req.URL.EscapedPath(), "", canonical.String(), signed, sum}, "\n")
+	scope := day + "/" + o.Region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(creq))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+	key := []byte("AWS4" + secret)
+	for _, part := range []string{day, o.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		access, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+	return req, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape escapes an object name for the URL path the way SigV4 expects,
+// keeping the slashes.
+func s3Escape(name string) string {
+	parts := strings.Split(name, "/")
+	for i, p := range parts {
+		parts[i] = strings.ReplaceAll(url.PathEscape(p), "+", "%2B")
+	}
+	return strings.Join(parts, "/")
+}
+
+// inColdStorage reports whether the copy of the source file at path was
+// moved to cold storage, which reconciliation counts as intact.
+func (c *Copier) inColdStorage(ctx context.Context, rule Rule, path string, info os.FileInfo) bool {
+	if rule.Cold == nil {
+		return false
+	}
+	studentRel, _ := studentDir(ctx, rule, path, info)
+	rec, ok := c.store.historyByDest(filepath.Join(rule.DestDir, studentRel, destFileName(rule, path)))
+	return ok && rec.ColdURL != ""
+}
+
+// validateCold checks a rule's cold tier settings.
+func validateCold(key func(string) string, o *ColdTierOptions) ConfigErrors {
+	var problems ConfigErrors
+	if o.AfterDays < 0 {
+		problems = append(problems, key("cold")+".after_days must not be negative")
+	}
+	switch o.Backend {
+	case "s3":
+		if o.Bucket == "" || o.Region == "" {
+			problems = append(problems, key("cold")+" needs a bucket and region for s3")
+		}
+		if (o.AccessKey == "") != (o.SecretKey == "") {
+			problems = append(problems, key("cold")+".access_key and secret_key must be set together")
+		}
+		if o.Endpoint != "" && !strings.HasPrefix(o.Endpoint, "http://") && !strings.HasPrefix(o.Endpoint, "https://") {
+			problems = append(problems, key("cold")+".endpoint must be an http:// or https:// URL")
+		}
+	case "azure":
+		if !strings.HasPrefix(o.ContainerURL, "https://") {
+			problems = append(problems, key("cold")+".container_url must be the https:// URL of the container with a SAS token")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("%s.backend %q is not supported (want s3 or azure)", key("cold"), o.Backend))
+	}
+	return problems
+}
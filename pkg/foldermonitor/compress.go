@@ -0,0 +1,71 @@
+package foldermonitor
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// defaultCompressExtensions are compressed when a rule enables compression
+// without listing extensions: the text payloads written next to videos.
+var defaultCompressExtensions = []string{".csv", ".log", ".txt", ".xml", ".json"}
+
+// compressionSuffix returns the file extension added by algorithm.
+func compressionSuffix(algorithm string) string {
+	switch algorithm {
+	case "gzip":
+		return ".gz"
+	case "zstd":
+		return ".zst"
+	}
+	return ""
+}
+
+// validCompression reports whether algorithm is supported.
+func validCompression(algorithm string) bool {
+	return algorithm == "" || compressionSuffix(algorithm) != ""
+}
+
+// shouldCompress reports whether rule compresses the file at path.
+func shouldCompress(rule Rule, path string) bool {
+	if rule.Compress == "" {
+		return false
+	}
+	exts := rule.CompressExtensions
+	if len(exts) == 0 {
+		exts = defaultCompressExtensions
+	}
+	return matchesExtension(path, exts)
+}
+
+// matchesExtension reports whether path ends in one of exts, ignoring case.
+// Extensions may be given with or without the leading dot.
+func matchesExtension(path string, exts []string) bool {
+	ext := filepath.Ext(path)
+	for _, e := range exts {
+		if !strings.HasPrefix(e, ".") {
+			e = "." + e
+		}
+		if strings.EqualFold(e, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWrapper returns a writerWrapper compressing with algorithm.
+func compressWrapper(algorithm string) writerWrapper {
+	return func(w io.Writer) (io.WriteCloser, error) {
+		switch algorithm {
+		case "gzip":
+			return gzip.NewWriter(w), nil
+		case "zstd":
+			return zstd.NewWriter(w)
+		}
+		return nil, fmt.Errorf("unknown compression %q", algorithm)
+	}
+}
@@ -0,0 +1,531 @@
+package foldermonitor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the source and destination folder paths and service options.
+type Config struct {
+	// Version is the layout of the file, see CurrentConfigVersion. Older
+	// files are migrated when read.
+	Version int `json:"version,omitempty"`
+	// SourceDir and DestDir form the implicit "default" rule in version 1
+	// files; later versions list it in Rules.
+	SourceDir string `json:"source_dir,omitempty"`
+	DestDir   string `json:"dest_dir,omitempty"`
+	// Rules are additional named source/destination pairs.
+	Rules []Rule `json:"rules,omitempty"`
+	// Volumes import from removable media when it is inserted.
+	Volumes []VolumeRule `json:"volumes,omitempty"`
+	// VolumePollInterval is how often mounted volumes are checked (default 5s).
+	VolumePollInterval Duration `json:"volume_poll_interval,omitempty"`
+	// Devices import from cameras and phones connected over MTP/PTP.
+	Devices []DeviceRule `json:"devices,omitempty"`
+	// DevicePollInterval is how often connected devices are checked
+	// (default 10s).
+	DevicePollInterval Duration `json:"device_poll_interval,omitempty"`
+	// Throttle defers or slows copies while CPU or disk are busy.
+	Throttle *ThrottleConfig `json:"throttle,omitempty"`
+	// Upload accepts files pushed over HTTP(S) by mobile devices.
+	Upload *UploadConfig `json:"upload,omitempty"`
+	// Gallery serves the archived videos read-only to browsers on the
+	// local network.
+	Gallery *GalleryConfig `json:"gallery,omitempty"`
+	// UIAddr is the host:port of the web UI; empty disables it. A missing
+	// host binds to localhost only.
+	UIAddr string `json:"ui_addr"`
+	// UIAuth requires a token or client certificates for the web UI and
+	// serves it over HTTPS.
+	UIAuth *UIAuthConfig `json:"ui_auth,omitempty"`
+	// AuditDir receives one append-only CSV of copy events per day; empty
+	// disables auditing.
+	AuditDir string `json:"audit_dir"`
+	// QuietPeriod is how long a new file must go without further events
+	// before it is copied, so files still being written are copied once
+	// they are complete (default 2s).
+	QuietPeriod Duration `json:"quiet_period,omitempty"`
+	// CopyTimeout aborts the copy of a single file that takes longer than
+	// this; zero means no limit.
+	CopyTimeout Duration `json:"copy_timeout,omitempty"`
+	// StallTimeout aborts a copy that makes no progress for this long, as
+	// happens on a hung network share (default 1m). Files from watched
+	// folders are retried after a stall or timeout.
+	StallTimeout Duration `json:"stall_timeout,omitempty"`
+	// CopyBuffer is how many bytes a copy reads and writes at a time
+	// (default 32 KiB). Larger buffers can help on network shares;
+	// "monitor bench" compares sizes on the site's hardware.
+	CopyBuffer int64 `json:"copy_buffer,omitempty"`
+	// LockRetries is how often opening a new file is retried at once when
+	// the program writing it still holds it exclusively, as with
+	// ERROR_SHARING_VIOLATION on Windows (default 5; negative disables).
+	// The first retry waits LockRetryDelay (default 200ms), each further
+	// one twice as long. Files still locked are queued for a later retry.
+	LockRetries    int      `json:"lock_retries,omitempty"`
+	LockRetryDelay Duration `json:"lock_retry_delay,omitempty"`
+	// LogLevels sets the least severe level logged per component: watcher,
+	// copier, notifier or service, e.g. {"notifier": "warn"}. A rule's
+	// log_level takes precedence for messages about the rule. Components
+	// not listed keep the logger's level.
+	LogLevels map[string]string `json:"log_levels,omitempty"`
+	// HealthInterval is how often watched folders are probed (default 30s).
+	HealthInterval Duration `json:"health_interval,omitempty"`
+	// HealthCanary also writes a hidden canary file into each source folder
+	// on every probe and recreates the watch if no event arrives for it.
+	HealthCanary bool `json:"health_canary,omitempty"`
+	// ReconcileInterval, when set, compares every watched source folder
+	// with its destination this often, e.g. "1h", and copies anything
+	// missing, in case events were lost without the watcher noticing.
+	ReconcileInterval Duration `json:"reconcile_interval,omitempty"`
+	// Encryption supplies the key used by rules with encrypt enabled.
+	Encryption *EncryptionConfig `json:"encryption,omitempty"`
+	// Service sets the account and recovery options used by "install".
+	Service *ServiceConfig `json:"service,omitempty"`
+	// Update says where "monitor update" and auto-update find releases.
+	Update *UpdateConfig `json:"update,omitempty"`
+	// SLO alerts when files take too long from detection to archive.
+	SLO *SLOConfig `json:"slo,omitempty"`
+	// Syslog also sends the service log to a syslog receiver.
+	Syslog *SyslogConfig `json:"syslog,omitempty"`
+	// MQTT publishes copy outcomes and health reports to a broker.
+	MQTT *MQTTConfig `json:"mqtt,omitempty"`
+	// Metrics pushes throughput and failure metrics to InfluxDB or
+	// Graphite.
+	Metrics *MetricsConfig `json:"metrics,omitempty"`
+	// StatusFile writes the monitor's state as JSON to a file on an
+	// interval.
+	StatusFile *StatusFileConfig `json:"status_file,omitempty"`
+	// SourceDrives tunes the free space, mount and SMART checks of the
+	// drives holding the source folders, and where their alerts go.
+	SourceDrives *SourceDrivesConfig `json:"source_drives,omitempty"`
+	// Fleet reports to, or collects reports from, the other monitors of
+	// a site.
+	Fleet *FleetConfig `json:"fleet,omitempty"`
+	// Remote fetches the rest of the configuration from a central URL and
+	// reloads when it changes.
+	Remote *RemoteConfig `json:"remote,omitempty"`
+	// Pairs match the videos of two rules filming the same swing from
+	// different angles.
+	Pairs []PairRule `json:"pairs,omitempty"`
+	// Power defers large copies while a laptop runs on battery.
+	Power *PowerConfig `json:"power,omitempty"`
+	// Session defers heavy copies while the console session is in use.
+	Session *SessionConfig `json:"session,omitempty"`
+	// Chaos injects faults for operational drills; never set it in
+	// production.
+	Chaos *ChaosConfig `json:"chaos,omitempty"`
+	// FFmpeg is the ffmpeg executable used by rules that process videos
+	// (default "ffmpeg" on the PATH).
+	FFmpeg string `json:"ffmpeg,omitempty"`
+	// Control tunes the local channel through which commands reach the
+	// running monitor.
+	Control *ControlConfig `json:"control,omitempty"`
+	// Transcode picks the encoder of re-encoded videos and caps how many
+	// are re-encoded at once.
+	Transcode *TranscodeConfig `json:"transcode,omitempty"`
+	// RuleOptions apply to the default rule.
+	RuleOptions
+}
+
+// Rule watches one source folder and copies new files to its destination.
+type Rule struct {
+	Name      string `json:"name"`
+	SourceDir string `json:"source_dir"`
+	DestDir   string `json:"dest_dir"`
+	RuleOptions
+}
+
+// RuleOptions control how a rule processes files. They appear inline both
+// at the top level of the config (for the default rule) and in each rule.
+type RuleOptions struct {
+	// Enabled set to false takes the rule offline without removing it from
+	// the config; rules are enabled when it is omitted.
+	Enabled *bool `json:"enabled,omitempty"`
+	// Exclude lists subpaths of the source that are never copied, such as
+	// "proxies/" or "trash". Patterns without a slash match a folder or file
+	// name at any depth; patterns with one are relative to the source root.
+	// Shell wildcards are allowed.
+	Exclude []string `json:"exclude,omitempty"`
+	// PartialExtensions are the extensions of files still being written
+	// under a temporary name, such as browser downloads and cameras that
+	// rename a recording when it ends. They are never copied; the file is
+	// picked up once renamed to its final name. By default
+	// defaultPartialExtensions; an empty list copies them all.
+	PartialExtensions []string `json:"partial_extensions,omitempty"`
+	// Order is the order in which a backlog is copied when catching up,
+	// syncing or importing a volume: "oldest" or "newest" modified first,
+	// or by name when empty. Files detected while watching are copied as
+	// they settle.
+	Order string `json:"order,omitempty"`
+	// Encrypt stores destination files encrypted with AES-256-GCM and an
+	// added .enc extension.
+	Encrypt bool `json:"encrypt,omitempty"`
+	// Compress is "gzip" or "zstd" to compress files whose extension is in
+	// CompressExtensions (by default common text payloads such as .csv).
+	// Compressed files get a .gz or .zst extension.
+	Compress           string   `json:"compress,omitempty"`
+	CompressExtensions []string `json:"compress_extensions,omitempty"`
+	// Archive is "zip" or "tar.zst" to bundle each day's files into one
+	// archive with an index manifest instead of keeping loose files. Files
+	// are staged under .staging in the destination until the day is over.
+	Archive string `json:"archive,omitempty"`
+	// Rollup consolidates each month of copies into one archive with an
+	// index, optionally uploading it to cold storage and deleting the
+	// originals.
+	Rollup *RollupOptions `json:"rollup,omitempty"`
+	// Snapshot copies files that another program keeps locked, such as a
+	// recorder that has not released its output yet, from a Volume Shadow
+	// Copy of their volume. Windows only; the service needs administrator
+	// rights.
+	Snapshot bool `json:"snapshot,omitempty"`
+	// DetectRenames recognises a new file whose content was already copied
+	// under another name, after its source was renamed, and renames the
+	// destination copy instead of copying it again. It uses the SHA-256 of
+	// each copy kept in the store's history. Not available with archive.
+	DetectRenames bool `json:"detect_renames,omitempty"`
+	// SkipIdentical skips files that capture software rewrote without
+	// changing them, comparing them with their last copy, which must still
+	// be in the destination: "mtime" when the size and modification time
+	// match, "hash" also when the size and SHA-256 match under a new
+	// modification time. Not available with archive.
+	SkipIdentical string `json:"skip_identical,omitempty"`
+	// Group lists the extensions of files that belong together when they
+	// share a base name, such as [".mp4", ".csv", ".xml"] for a video with
+	// its ball data and session file. A group is copied only once all its
+	// files exist and are complete, and appears in the destination all at
+	// once. After GroupTimeout (default 10m) what exists of an incomplete
+	// group is copied anyway.
+	Group        []string `json:"group,omitempty"`
+	GroupTimeout Duration `json:"group_timeout,omitempty"`
+	// Checksums keeps a checksums.txt in each destination folder listing
+	// the md5, sha1, sha256 or crc32 of every archived file, for
+	// verification tools and LTO workflows. ChecksumFormat is "md5sum"
+	// (the default, as read by md5sum -c) or "bsd". Not available with
+	// archive.
+	Checksums      string `json:"checksums,omitempty"`
+	ChecksumFormat string `json:"checksum_format,omitempty"`
+	// Metadata writes tags such as the bay, coach or student into copied
+	// MP4 and MOV files with ffmpeg, without re-encoding them. Tags are
+	// stored as QuickTime metadata; XMP packets are not written.
+	Metadata *MetadataOptions `json:"metadata,omitempty"`
+	// Trim keeps only part of each copied video, cut with ffmpeg.
+	Trim *TrimOptions `json:"trim,omitempty"`
+	// StripAudio removes the audio tracks of copied videos, so that
+	// conversations picked up by range microphones are never archived. The
+	// video is remuxed, not re-encoded.
+	StripAudio bool `json:"strip_audio,omitempty"`
+	// Share writes a second copy of each video with a logo or text
+	// overlay to a student-facing folder.
+	Share *ShareOptions `json:"share,omitempty"`
+	// Rclone pushes each copy to an rclone remote, and Rsync to a server
+	// over SSH or to an rsync daemon, using the destination folder as a
+	// local spool.
+	Rclone *RcloneOptions `json:"rclone,omitempty"`
+	Rsync  *RsyncOptions  `json:"rsync,omitempty"`
+	// Cold uploads copies to S3 Glacier or the Azure archive tier some
+	// days after they were made. Not available with archive.
+	Cold *ColdTierOptions `json:"cold,omitempty"`
+	// Library asks a Plex or Jellyfin server to scan the folders videos
+	// are copied into. Not available with archive.
+	Library *LibraryOptions `json:"library,omitempty"`
+	// Students files each clip under the student whose lesson was in the
+	// bay when it was recorded, from a schedule file or an HTTP endpoint.
+	Students *StudentOptions `json:"students,omitempty"`
+	// ShotData attaches the launch monitor shot of each clip, from the CSV
+	// exports next to it, to its sidecar file and a webhook.
+	ShotData *ShotDataOptions `json:"shot_data,omitempty"`
+	// VisualDuplicates skips videos that look the same as one copied
+	// shortly before at another bitrate. Not available with archive.
+	VisualDuplicates *VisualDuplicateOptions `json:"visual_duplicates,omitempty"`
+	// Content copies only files whose first bytes show one of these kinds:
+	// video, image, audio or data, whatever their extension, e.g.
+	// ["video"] for cameras that leave temporary files named like clips.
+	Content []string `json:"content,omitempty"`
+	// Antivirus waits for the virus scanner to release, or to clear, each
+	// new file before it is copied.
+	Antivirus *AntivirusOptions `json:"antivirus,omitempty"`
+	// Filter copies only files for which this expression is true, e.g.
+	// `size > 10MB && ext in ["mp4", "mov"] && hour(now) < 22`; see
+	// filterexpr.go for the variables it sees.
+	Filter string `json:"filter,omitempty"`
+	// Plugins are Lua scripts whose hooks filter, rename, describe or act
+	// on the rule's copies; see plugin.go for the hooks.
+	Plugins []string `json:"plugins,omitempty"`
+	// Routes send files matching a pattern, or of a kind of content, to
+	// their own destination instead of dest_dir; the first matching route
+	// wins.
+	Routes []Route `json:"routes,omitempty"`
+	// Failover copies to a secondary destination while dest_dir is down.
+	Failover *FailoverOptions `json:"failover,omitempty"`
+	// Schedule limits the rule to the days and times its programs run.
+	Schedule *RuleSchedule `json:"schedule,omitempty"`
+	// PreCopy runs a command before each copy whose exit code decides
+	// whether the file is copied, skipped or quarantined.
+	PreCopy *PreCopyHook `json:"pre_copy,omitempty"`
+	// MinDuration and MaxDuration skip MP4, M4V and MOV clips shorter or
+	// longer than this, such as accidental record taps or a camera left
+	// running. The duration is read from the file's movie header; clips
+	// whose duration cannot be read are copied.
+	MinDuration Duration `json:"min_duration,omitempty"`
+	MaxDuration Duration `json:"max_duration,omitempty"`
+	// ClipTime takes the recording time of clips from their file name or
+	// their detection when the camera's clock is wrong, for the date
+	// placeholders of templates and student lookups.
+	ClipTime *ClipTimeOptions `json:"clip_time,omitempty"`
+	// VerifyWrites flushes each copy to the destination, reads it back and
+	// compares its SHA-256 with what was written, catching storage that
+	// acknowledges writes it later drops. A copy that does not read back
+	// intact is removed and retried like a destination failure.
+	VerifyWrites bool `json:"verify_writes,omitempty"`
+	// WriteThrough writes each copy past the write caches of the system
+	// and the disk, and flushes it before the copy counts as done, so that
+	// an acknowledged copy survives a power cut. Copies are slower.
+	WriteThrough bool `json:"write_through,omitempty"`
+	// PreserveSecurity gives each copy the access restrictions of its
+	// source: the NTFS ACL on Windows, the permissions, owner and extended
+	// attributes including POSIX ACLs on Linux. A copy whose restrictions
+	// cannot be applied is removed and fails.
+	PreserveSecurity bool `json:"preserve_security,omitempty"`
+	// Ownership sets the permission bits and owner of the files and
+	// folders the rule creates at the destination, so that another
+	// program's user can read what a root service copied. Not on Windows,
+	// and not with preserve_security.
+	Ownership *OwnershipOptions `json:"ownership,omitempty"`
+	// LogLevel is the least severe level logged for messages about the
+	// rule (error, warning, info or debug), so that one bay can be debugged
+	// or quietened without changing the rest.
+	LogLevel string `json:"log_level,omitempty"`
+}
+
+// IsEnabled reports whether the rule is switched on.
+func (o RuleOptions) IsEnabled() bool {
+	return o.Enabled == nil || *o.Enabled
+}
+
+// needsEncryptionKey reports whether any rule encrypts its output.
+func (c *Config) needsEncryptionKey() bool {
+	for _, r := range c.ActiveRules() {
+		if r.Encrypt {
+			return true
+		}
+	}
+	for _, v := range c.Volumes {
+		if v.Encrypt {
+			return true
+		}
+	}
+	for _, d := range c.Devices {
+		if d.Encrypt {
+			return true
+		}
+	}
+	return c.Upload != nil && c.Upload.Encrypt
+}
+
+// DefaultRuleName names the rule formed by the top-level source_dir and
+// dest_dir, which version 2 configs list in rules.
+const DefaultRuleName = "default"
+
+// ActiveRules returns the configured rules, with the top-level folder
+// pair first as the "default" rule when it is set.
+func (c *Config) ActiveRules() []Rule {
+	var rules []Rule
+	if c.SourceDir != "" || c.DestDir != "" {
+		rules = append(rules, Rule{Name: DefaultRuleName, SourceDir: c.SourceDir, DestDir: c.DestDir, RuleOptions: c.RuleOptions})
+	}
+	return append(rules, c.Rules...)
+}
+
+// appDirName is the per-machine directory holding the monitor's files.
+const appDirName = "FolderMonitor"
+
+// DefaultConfigDir returns the platform-appropriate configuration directory:
+// %ProgramData%\FolderMonitor on Windows, otherwise
+// $XDG_CONFIG_HOME/foldermonitor (falling back to ~/.config/foldermonitor).
+func DefaultConfigDir() string {
+	if runtime.GOOS == "windows" {
+		base := os.Getenv("ProgramData")
+		if base == "" {
+			base = `C:\ProgramData`
+		}
+		return filepath.Join(base, appDirName)
+	}
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "foldermonitor")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".config", "foldermonitor")
+	}
+	return "."
+}
+
+// configNames are the file names searched for in the config directory, in
+// order of preference.
+var configNames = []string{"config.json", "config.yaml", "config.yml", "config.toml"}
+
+// DefaultConfigPath returns the first existing config file in the default
+// directory, or config.json there if none exists yet.
+func DefaultConfigPath() string {
+	return configPathIn(DefaultConfigDir())
+}
+
+// configPathIn returns the first existing config file in dir, or
+// config.json there if none exists yet.
+func configPathIn(dir string) string {
+	for _, name := range configNames {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return filepath.Join(dir, configNames[0])
+}
+
+// ConfigFormat returns "json", "yaml" or "toml" based on the file extension.
+func ConfigFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	}
+	return "json"
+}
+
+// decodeRawConfig parses data in the given format into a generic document.
+func decodeRawConfig(data []byte, format string) (map[string]interface{}, error) {
+	var m map[string]interface{}
+	var err error
+	switch format {
+	case "yaml":
+		err = yaml.Unmarshal(data, &m)
+	case "toml":
+		err = toml.Unmarshal(data, &m)
+	default:
+		err = json.Unmarshal(data, &m)
+	}
+	return m, err
+}
+
+// DecodeConfig parses data in the given format into cfg. YAML and TOML
+// documents are converted to JSON first so that Config only needs json tags.
+func DecodeConfig(data []byte, format string, cfg *Config) error {
+	if format == "json" {
+		return json.Unmarshal(data, cfg)
+	}
+	m, err := decodeRawConfig(data, format)
+	if err != nil || m == nil {
+		return err
+	}
+	converted, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(converted, cfg)
+}
+
+// EncodeConfig serialises cfg in the given format.
+func EncodeConfig(cfg *Config, format string) ([]byte, error) {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil || format == "json" {
+		return data, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	if format == "yaml" {
+		return yaml.Marshal(m)
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(m); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// legacyConfigPaths lists where older versions looked for config.json: the
+// working directory and the directory containing the executable.
+func legacyConfigPaths() []string {
+	paths := []string{"config.json"}
+	if exe, err := os.Executable(); err == nil {
+		paths = append(paths, filepath.Join(filepath.Dir(exe), "config.json"))
+	}
+	return paths
+}
+
+// MigrateLocalConfig copies a legacy config.json to dst when dst does not
+// exist yet, returning the path it was copied from, or "" when nothing was
+// migrated. The legacy file is left in place.
+func MigrateLocalConfig(dst string) (string, error) {
+	if _, err := os.Stat(dst); err == nil || !os.IsNotExist(err) {
+		return "", err
+	}
+	for _, src := range legacyConfigPaths() {
+		if ConfigFormat(src) != ConfigFormat(dst) {
+			continue
+		}
+		data, err := os.ReadFile(src)
+		if err != nil {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+			return "", err
+		}
+		if err := writeConfigFile(dst, data); err != nil {
+			return "", err
+		}
+		return src, nil
+	}
+	return "", nil
+}
+
+// ReadConfig loads configuration from the file at path, which may be JSON,
+// YAML or TOML depending on its extension. Older layouts are migrated in
+// memory; UpgradeConfigFile also rewrites the file.
+func ReadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := DecodeConfig(data, ConfigFormat(path), &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	upgradeConfig(&cfg)
+	return &cfg, nil
+}
+
+// WriteConfig saves cfg to the file at path in the format implied by its
+// extension, creating its directory if necessary. cfg is migrated to
+// CurrentConfigVersion first. Comments in YAML and TOML files are not
+// preserved.
+func WriteConfig(path string, cfg *Config) error {
+	upgradeConfig(cfg)
+	data, err := EncodeConfig(cfg, ConfigFormat(path))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	return writeConfigFile(path, data)
+}
+
+// writeConfigFile writes data to the config file at path, readable by its
+// owner only since configs hold tokens and keys. Files written with wider
+// permissions by older releases are narrowed.
+func writeConfigFile(path string, data []byte) error {
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0600)
+}
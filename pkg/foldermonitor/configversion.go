@@ -0,0 +1,144 @@
+package foldermonitor
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+)
+
+// CurrentConfigVersion is the config layout this release writes. Files
+// without a version are version 1.
+//
+//   - 1: the default rule is the top-level source_dir, dest_dir and rule
+//     options, next to any further rules.
+//   - 2: every folder rule, including "default", is listed in rules.
+const CurrentConfigVersion = 2
+
+// configMigrations upgrade a config from the version it is indexed by to
+// the next one.
+var configMigrations = map[int]func(*Config){
+	1: (*Config).moveDefaultRule,
+}
+
+// configVersion returns the layout version of c.
+func (c *Config) configVersion() int {
+	if c.Version == 0 {
+		return 1
+	}
+	return c.Version
+}
+
+// upgradeConfig migrates c to CurrentConfigVersion and returns the version
+// it had. Versions above the current one are left for validation to
+// reject.
+func upgradeConfig(c *Config) int {
+	from := c.configVersion()
+	if from > CurrentConfigVersion {
+		return from
+	}
+	for v := from; v < CurrentConfigVersion; v++ {
+		configMigrations[v](c)
+	}
+	c.Version = CurrentConfigVersion
+	return from
+}
+
+// moveDefaultRule moves the top-level folders and rule options of a
+// version 1 config into the rule named "default", which it adds first.
+func (c *Config) moveDefaultRule() {
+	folders := c.SourceDir != "" || c.DestDir != ""
+	if !folders && reflect.ValueOf(c.RuleOptions).IsZero() {
+		return
+	}
+	r := c.defaultRule()
+	if c.SourceDir != "" {
+		r.SourceDir = c.SourceDir
+	}
+	if c.DestDir != "" {
+		r.DestDir = c.DestDir
+	}
+	r.RuleOptions = c.RuleOptions
+	c.SourceDir, c.DestDir, c.RuleOptions = "", "", RuleOptions{}
+}
+
+// defaultRule returns the rule named "default" in c.Rules, adding it first
+// when there is none.
+func (c *Config) defaultRule() *Rule {
+	if r := c.namedRule(DefaultRuleName); r != nil {
+		return r
+	}
+	c.Rules = append([]Rule{{Name: DefaultRuleName}}, c.Rules...)
+	return &c.Rules[0]
+}
+
+// namedRule returns the folder rule called name in c.Rules, or nil.
+func (c *Config) namedRule(name string) *Rule {
+	for i := range c.Rules {
+		if c.Rules[i].Name == name {
+			return &c.Rules[i]
+		}
+	}
+	return nil
+}
+
+// DefaultFolders returns the source and destination of the default rule,
+// wherever it is configured.
+func (c *Config) DefaultFolders() (source, dest string) {
+	if r := c.namedRule(DefaultRuleName); r != nil && c.SourceDir == "" && c.DestDir == "" {
+		return r.SourceDir, r.DestDir
+	}
+	return c.SourceDir, c.DestDir
+}
+
+// SetDefaultFolders sets the source and destination of the default rule,
+// adding it when there is none.
+func (c *Config) SetDefaultFolders(source, dest string) {
+	if c.configVersion() >= 2 || c.namedRule(DefaultRuleName) != nil {
+		r := c.defaultRule()
+		r.SourceDir, r.DestDir = source, dest
+		return
+	}
+	c.SourceDir, c.DestDir = source, dest
+}
+
+// UpgradeConfigFile migrates the config file at path to
+// CurrentConfigVersion, keeping the old file next to it as
+// "<name>.v<version>.bak". It returns the backup's path, or "" when the
+// file was current or missing.
+func UpgradeConfigFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	var cfg Config
+	if err := DecodeConfig(data, ConfigFormat(path), &cfg); err != nil {
+		return "", fmt.Errorf("%s: %v", path, err)
+	}
+	from := cfg.configVersion()
+	if from >= CurrentConfigVersion {
+		return "", nil
+	}
+	backup := fmt.Sprintf("%s.v%d.bak", path, from)
+	if _, err := os.Stat(backup); os.IsNotExist(err) {
+		if err := writeConfigFile(backup, data); err != nil {
+			return "", err
+		}
+	}
+	if err := WriteConfig(path, &cfg); err != nil {
+		return "", err
+	}
+	return backup, nil
+}
+
+// validateVersion checks that this release understands the config.
+func validateVersion(c *Config) ConfigErrors {
+	if c.Version < 0 || c.Version > CurrentConfigVersion {
+		return ConfigErrors{fmt.Sprintf("version %d is not supported by this release, which reads versions up to %d; update the monitor", c.Version, CurrentConfigVersion)}
+	}
+	if c.configVersion() >= 2 && (c.SourceDir != "" || c.DestDir != "" || !reflect.ValueOf(c.RuleOptions).IsZero()) {
+		return ConfigErrors{fmt.Sprintf("version %d lists the default rule in rules; move the top-level source_dir, dest_dir and rule options into the rule named %q", c.configVersion(), DefaultRuleName)}
+	}
+	return nil
+}
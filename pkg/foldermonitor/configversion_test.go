@@ -0,0 +1,192 @@
+package foldermonitor
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestUpgradeConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		in   Config
+		want []Rule
+	}{
+		{
+			name: "v1 default rule moves into rules",
+			in: Config{SourceDir: "/src", DestDir: "/dst", RuleOptions: RuleOptions{Compress: "gzip"},
+				Rules: []Rule{{Name: "bay2", SourceDir: "/bay2", DestDir: "/archive2"}}},
+			want: []Rule{
+				{Name: DefaultRuleName, SourceDir: "/src", DestDir: "/dst", RuleOptions: RuleOptions{Compress: "gzip"}},
+				{Name: "bay2", SourceDir: "/bay2", DestDir: "/archive2"},
+			},
+		},
+		{
+			name: "v1 without a default rule is left alone",
+			in:   Config{Rules: []Rule{{Name: "bay2", SourceDir: "/bay2", DestDir: "/archive2"}}},
+			want: []Rule{{Name: "bay2", SourceDir: "/bay2", DestDir: "/archive2"}},
+		},
+		{
+			name: "v2 rules are not touched",
+			in: Config{Version: 2, Rules: []Rule{
+				{Name: DefaultRuleName, SourceDir: "/src", DestDir: "/dst", RuleOptions: RuleOptions{Encrypt: true}},
+			}},
+			want: []Rule{{Name: DefaultRuleName, SourceDir: "/src", DestDir: "/dst", RuleOptions: RuleOptions{Encrypt: true}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.in
+			upgradeConfig(&cfg)
+			if cfg.Version != CurrentConfigVersion {
+				t.Errorf("version %d, want %d", cfg.Version, CurrentConfigVersion)
+			}
+			if cfg.SourceDir != "" || cfg.DestDir != "" || !reflect.ValueOf(cfg.RuleOptions).IsZero() {
+				t.Errorf("top-level folders or options left: %q %q %+v", cfg.SourceDir, cfg.DestDir, cfg.RuleOptions)
+			}
+			if !reflect.DeepEqual(cfg.Rules, tt.want) {
+				t.Errorf("rules\n got %+v\nwant %+v", cfg.Rules, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpgradeConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"source_dir": "/src", "dest_dir": "/dst", "ui_auth": {"token": "0123456789abcdef"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	backup, err := UpgradeConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup != path+".v1.bak" {
+		t.Errorf("backup %q", backup)
+	}
+	cfg, err := ReadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Version != CurrentConfigVersion || cfg.namedRule(DefaultRuleName) == nil {
+		t.Errorf("version %d rules %+v", cfg.Version, cfg.Rules)
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+	for _, p := range []string{path, backup} {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("%s: mode %v, want 0600", p, info.Mode().Perm())
+		}
+	}
+}
+
+func TestApplyOverrides(t *testing.T) {
+	base := func() *Config {
+		return &Config{Version: 2, Rules: []Rule{
+			{Name: DefaultRuleName, SourceDir: "/src", DestDir: "/dst", RuleOptions: RuleOptions{
+				Encrypt: true, StripAudio: true, VerifyWrites: true, Order: "oldest"}},
+			{Name: "bay2", SourceDir: "/bay2", DestDir: "/archive2", RuleOptions: RuleOptions{Encrypt: true}},
+		}}
+	}
+	tests := []struct {
+		name  string
+		cfg   *Config
+		env   map[string]string
+		flags []string
+		check func(t *testing.T, cfg *Config)
+	}{
+		{
+			name:  "override turns options off",
+			cfg:   base(),
+			env:   map[string]string{"FM_ENCRYPT": "false"},
+			flags: []string{"-strip-audio=false"},
+			check: func(t *testing.T, cfg *Config) {
+				r := cfg.namedRule(DefaultRuleName)
+				if r.Encrypt || r.StripAudio {
+					t.Errorf("encrypt=%v strip_audio=%v, want both false", r.Encrypt, r.StripAudio)
+				}
+				if !r.VerifyWrites || r.Order != "oldest" {
+					t.Errorf("options not overridden were lost: %+v", r.RuleOptions)
+				}
+				if !cfg.namedRule("bay2").Encrypt {
+					t.Error("override changed another rule")
+				}
+			},
+		},
+		{
+			name:  "override sets one option and keeps the rest",
+			cfg:   base(),
+			env:   map[string]string{"FM_COMPRESS": "gzip"},
+			flags: []string{"-order", "newest"},
+			check: func(t *testing.T, cfg *Config) {
+				r := cfg.namedRule(DefaultRuleName)
+				if r.Compress != "gzip" || r.Order != "newest" {
+					t.Errorf("compress=%q order=%q", r.Compress, r.Order)
+				}
+				if !r.Encrypt || !r.StripAudio || !r.VerifyWrites {
+					t.Errorf("options not overridden were lost: %+v", r.RuleOptions)
+				}
+			},
+		},
+		{
+			name:  "folders apply to the default rule",
+			cfg:   base(),
+			flags: []string{"-source-dir", "/other"},
+			check: func(t *testing.T, cfg *Config) {
+				if r := cfg.namedRule(DefaultRuleName); r.SourceDir != "/other" || r.DestDir != "/dst" {
+					t.Errorf("source %q dest %q", r.SourceDir, r.DestDir)
+				}
+				if cfg.SourceDir != "" {
+					t.Errorf("top-level source_dir set to %q", cfg.SourceDir)
+				}
+			},
+		},
+		{
+			name:  "options without a default rule add one",
+			cfg:   &Config{Version: 2, Rules: []Rule{{Name: "bay2", SourceDir: "/bay2", DestDir: "/archive2"}}},
+			flags: []string{"-compress", "zstd"},
+			check: func(t *testing.T, cfg *Config) {
+				r := cfg.namedRule(DefaultRuleName)
+				if r == nil || r.Compress != "zstd" {
+					t.Fatalf("default rule %+v", r)
+				}
+				if cfg.namedRule("bay2").Compress != "" {
+					t.Error("override changed another rule")
+				}
+			},
+		},
+		{
+			name:  "top-level settings stay top-level",
+			cfg:   base(),
+			flags: []string{"-ui-addr", ":9000"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.UIAddr != ":9000" {
+					t.Errorf("ui_addr %q", cfg.UIAddr)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			RegisterConfigFlags(fs)
+			if err := fs.Parse(tt.flags); err != nil {
+				t.Fatal(err)
+			}
+			if err := ApplyOverrides(tt.cfg, fs); err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, tt.cfg)
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package foldermonitor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+// The running monitor serves the web UI's API on a local control channel
+// as well: a Unix socket beside the config file, or a named pipe on
+// Windows, which only the monitor's own account and administrators may
+// open. Commands on the same machine use it to reach the service without a
+// TCP port, and so without ui_addr or ui_auth.
+
+// errControlInUse reports a control channel another monitor serves.
+var errControlInUse = errors.New("another monitor is already serving it")
+
+// controlBase is the base URL of requests over the control channel; its
+// host is not resolved.
+const controlBase = "http://control"
+
+// ControlConfig tunes the local control channel.
+type ControlConfig struct {
+	// Disabled turns the control channel off.
+	Disabled bool `json:"disabled,omitempty"`
+	// Path is the Unix socket, by default control.sock beside the config
+	// file, or on Windows the named pipe, by default
+	// \\.\pipe\foldermonitor-<id> where id derives from the config folder.
+	Path string `json:"path,omitempty"`
+}
+
+// controlPath returns the control channel of the monitor whose config file
+// is configPath, or "" when it is disabled.
+func (c *Config) controlPath(configPath string) string {
+	if c.Control != nil && c.Control.Disabled {
+		return ""
+	}
+	if c.Control != nil && c.Control.Path != "" {
+		return c.Control.Path
+	}
+	dir, err := filepath.Abs(filepath.Dir(configPath))
+	if err != nil {
+		dir = filepath.Dir(configPath)
+	}
+	return defaultControlPath(dir)
+}
+
+// controlID names the control channel of the store in dir.
+func controlID(dir string) string {
+	sum := sha256.Sum256([]byte(filepath.Clean(dir)))
+	return hex.EncodeToString(sum[:6])
+}
+
+// startControl serves h on the control channel at path in the background.
+func startControl(path string, h http.Handler) (*http.Server, error) {
+	ln, err := listenControl(path)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Handler: h, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed && svcLogger != nil {
+			svcLogger.Errorf("Control channel stopped: %v", err)
+		}
+	}()
+	return srv, nil
+}
+
+// ServiceClient returns an HTTP client for the API of the monitor running
+// with the config file at configPath, and the API's base URL. It prefers
+// the control channel and falls back to the web UI when ui_addr is set; it
+// fails when neither is available.
+func ServiceClient(cfg *Config, configPath string, timeout time.Duration) (*http.Client, string, error) {
+	path := cfg.controlPath(configPath)
+	if path != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		conn, err := dialControl(ctx, path)
+		cancel()
+		if err == nil {
+			conn.Close()
+			transport := &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialControl(ctx, path)
+				},
+			}
+			return &http.Client{Timeout: timeout, Transport: transport}, controlBase, nil
+		}
+		logDebugf("Control channel %s: %v", path, err)
+	}
+	if cfg.UIAddr != "" {
+		return UIClient(cfg, timeout)
+	}
+	if path == "" {
+		return nil, "", errors.New("the control channel is disabled and ui_addr is not configured")
+	}
+	return nil, "", fmt.Errorf("the monitor is not running: nothing answers on %s, and ui_addr is not configured", path)
+}
+
+// Rescan looks through the watched folders for files that were not copied,
+// as after dropped watch events. It fails while copying is paused.
+func (m *Monitor) Rescan() error {
+	m.mu.Lock()
+	paused := m.paused
+	m.mu.Unlock()
+	if paused {
+		return errors.New("copying is paused")
+	}
+	select {
+	case m.rescan <- struct{}{}:
+	default:
+		// A rescan is already pending.
+	}
+	return nil
+}
+
+// handleRescan starts a rescan of the watched folders.
+func (u *uiServer) handleRescan(w http.ResponseWriter, r *http.Request) {
+	if u.prg == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "monitor is not running in this process"})
+		return
+	}
+	if err := u.prg.Rescan(); err != nil {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"running": true})
+}
+
+// validateControl checks the control channel settings.
+func validateControl(c *ControlConfig) ConfigErrors {
+	if c.Path != "" && !controlPathValid(c.Path) {
+		return ConfigErrors{fmt.Sprintf("control.path %q %s", c.Path, controlPathRule)}
+	}
+	return nil
+}
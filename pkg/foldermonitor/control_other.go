@@ -0,0 +1,56 @@
+//go:build !windows
+
+package foldermonitor
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// controlPathRule describes the paths controlPathValid accepts. Socket
+// paths are limited to about a hundred bytes.
+const controlPathRule = "must be an absolute path of at most 100 bytes"
+
+func controlPathValid(path string) bool {
+	return filepath.IsAbs(path) && len(path) <= 100
+}
+
+func defaultControlPath(dir string) string {
+	path := filepath.Join(dir, "control.sock")
+	if len(path) > 100 {
+		path = filepath.Join(os.TempDir(), "foldermonitor-"+controlID(dir)+".sock")
+	}
+	return path
+}
+
+// listenControl listens on the Unix socket at path, which only this user
+// may open. A socket left behind by a monitor that crashed is replaced.
+func listenControl(path string) (net.Listener, error) {
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, &net.OpError{Op: "listen", Net: "unix", Addr: &net.UnixAddr{Name: path, Net: "unix"}, Err: errControlInUse}
+		}
+		os.Remove(path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+func dialControl(ctx context.Context, path string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, "unix", path)
+}
@@ -0,0 +1,32 @@
+package foldermonitor
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/Microsoft/go-winio"
+)
+
+// controlSecurity lets LocalSystem, administrators and the account that
+// created the pipe open it.
+const controlSecurity = "D:P(A;;GA;;;SY)(A;;GA;;;BA)(A;;GA;;;OW)"
+
+// controlPathRule describes the paths controlPathValid accepts.
+const controlPathRule = `must be a named pipe such as \\.\pipe\foldermonitor`
+
+func controlPathValid(path string) bool {
+	return strings.HasPrefix(strings.ToLower(path), `\\.\pipe\`) && len(path) > len(`\\.\pipe\`)
+}
+
+func defaultControlPath(dir string) string {
+	return `\\.\pipe\foldermonitor-` + controlID(strings.ToLower(dir))
+}
+
+func listenControl(path string) (net.Listener, error) {
+	return winio.ListenPipe(path, &winio.PipeConfig{SecurityDescriptor: controlSecurity})
+}
+
+func dialControl(ctx context.Context, path string) (net.Conn, error) {
+	return winio.DialPipeContext(ctx, path)
+}
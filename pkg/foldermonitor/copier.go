@@ -0,0 +1,828 @@
+package foldermonitor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Copier copies files for rules, applying their compression, encryption
+// and archiving, and publishes every outcome on its event bus. One copier
+// serves all rules of a monitoring run.
+type Copier struct {
+	bus    *Bus   // pipeline stages and sinks of the run
+	store  *Store // state kept across restarts
+	status *statusTracker
+	audit  *auditLog
+	key    []byte       // encryption key, loaded if any rule encrypts
+	keyErr error        // why key could not be loaded
+	load   *loadMonitor // nil unless throttling is configured
+	// snapshots provide locked files to rules with snapshot enabled.
+	snapshots *snapshotSet
+	slo       *sloTracker // measures latency; enforces an SLO if one is configured
+	stats     *statsRecorder
+	dests     *destClaims
+	failover  *failoverState
+	groups    *groupTracker
+	pairs     *pairTracker // nil unless pair rules are configured
+	library   *libraryRefresher
+	ffmpeg    string // executable for rules that process videos
+	transcode *transcoder
+	plugins   *pluginSet
+	// retries are the queued copies this copier will retry, by queue ID.
+	retryMu sync.Mutex
+	retries map[string]*pendingRetry
+	// timeout and stall abort copies that run too long or stop making
+	// progress; zero disables either check.
+	timeout, stall time.Duration
+	lock           lockRetry
+	chaos          *ChaosConfig // faults to inject, nil normally
+	buffer         int          // copy buffer size, 0 for io.Copy's
+	// session, console, power and onBattery defer large copies while the
+	// console is in use or the machine runs on battery; console and
+	// onBattery are the monitor's, set by its run.
+	session   *SessionConfig
+	console   *atomic.Int32
+	power     *PowerConfig
+	onBattery *atomic.Bool
+	deferMu   sync.Mutex
+	deferred  map[string]*deferredCopy
+	// skips are the source files archiveFile skipped, so that
+	// reconciliation does not take them for missing copies.
+	skipMu sync.Mutex
+	skips  map[string]skippedFile
+}
+
+// lockRetry retries operations on a source file that fail because the
+// program writing it still holds it.
+type lockRetry struct {
+	attempts int
+	delay    time.Duration
+}
+
+func newLockRetry(cfg *Config) lockRetry {
+	attempts := cfg.LockRetries
+	switch {
+	case attempts == 0:
+		attempts = defaultLockRetries
+	case attempts < 0:
+		attempts = 0
+	}
+	return lockRetry{attempts: attempts, delay: cfg.LockRetryDelay.or(defaultLockRetryDelay)}
+}
+
+// do calls fn until it succeeds, fails for another reason than a lock, the
+// attempts are used up or ctx is cancelled, and returns its last error.
+func (l lockRetry) do(ctx context.Context, path string, fn func() error) error {
+	delay := l.delay
+	for i := 0; ; i++ {
+		err := fn()
+		if err == nil || i >= l.attempts || ErrorReason(err) != "locked" {
+			return err
+		}
+		logDebugf("%s is locked by its writer, retrying in %s", path, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+	}
+}
+
+// Defaults for aborting and retrying stuck copies.
+const (
+	defaultStallTimeout   = time.Minute
+	maxCopyAttempts       = 3
+	copyRetryDelay        = 30 * time.Second
+	defaultLockRetries    = 5
+	defaultLockRetryDelay = 200 * time.Millisecond
+)
+
+// maxCopyBuffer bounds copy_buffer, which is allocated for every copy.
+const maxCopyBuffer = 64 << 20
+
+// Causes of aborted copies, recorded as the copy's error.
+var (
+	errCopyStalled = errors.New("copy stalled")
+	errCopyTimeout = errors.New("copy timed out")
+)
+
+// NewCopier prepares a copier for cfg outside a Monitor, e.g. for one-shot
+// synchronisation. It fails if a rule encrypts and the key is unavailable.
+// Close it when done.
+func NewCopier(cfg *Config, store *Store) (*Copier, error) {
+	if svcLogger == nil {
+		svcLogger = NewConsoleLogger(os.Stderr, LevelWarning)
+	}
+	c := newCopier(cfg, store, newStatusTracker())
+	if c.keyErr != nil {
+		c.Close()
+		return nil, c.keyErr
+	}
+	return c, nil
+}
+
+// newCopier creates a copier with the standard stages and sinks connected.
+// A missing encryption key is recorded in keyErr rather than failing.
+func newCopier(cfg *Config, store *Store, status *statusTracker) *Copier {
+	c := &Copier{
+		bus:       NewBus(),
+		snapshots: newSnapshotSet(),
+		store:     store,
+		status:    status,
+		timeout:   time.Duration(cfg.CopyTimeout),
+		stall:     cfg.StallTimeout.or(defaultStallTimeout),
+		buffer:    int(cfg.CopyBuffer),
+		ffmpeg:    cfg.ffmpegPath(),
+		transcode: newTranscoder(cfg.Transcode, cfg.ffmpegPath()),
+		plugins:   newPluginSet(),
+		retries:   make(map[string]*pendingRetry),
+		lock:      newLockRetry(cfg),
+		chaos:     cfg.Chaos,
+		session:   cfg.Session,
+		power:     cfg.Power,
+		deferred:  make(map[string]*deferredCopy),
+		skips:     make(map[string]skippedFile),
+		library:   newLibraryRefresher(),
+		stats:     newStatsRecorder(store),
+		dests:     newDestClaims(),
+		failover:  newFailoverState(),
+	}
+	if cfg.AuditDir != "" {
+		c.audit = newAuditLog(cfg.AuditDir)
+	}
+	if cfg.needsEncryptionKey() {
+		c.key, c.keyErr = LoadEncryptionKey(cfg.Encryption)
+	}
+	c.groups = newGroupTracker(c, cfg.QuietPeriod.or(defaultQuietPeriod))
+	c.slo = newSLOTracker(cfg.SLO, c.bus, status, c.stats)
+	if len(cfg.Pairs) > 0 {
+		c.pairs = newPairTracker(c, cfg.Pairs)
+	}
+	c.connect()
+	return c
+}
+
+// Bus returns the event bus the copier publishes on, for registering
+// further consumers.
+func (c *Copier) Bus() *Bus {
+	return c.bus
+}
+
+// Close releases the copier's resources.
+func (c *Copier) Close() {
+	c.snapshots.close()
+	c.library.close()
+	c.stats.close()
+	c.plugins.close()
+	if c.audit != nil {
+		c.audit.Close()
+	}
+}
+
+// connect wires the standard stages and sinks onto the copier's bus: the
+// filter and copy stages, the status tracker, the audit log, the latency
+// SLO and the service log.
+func (c *Copier) connect() {
+	bus := c.bus
+	bus.Subscribe(c.slo.detected, EventDetected)
+	bus.Subscribe(c.slo.copied, EventCopied)
+	if c.slo.cfg != nil {
+		bus.Subscribe(c.slo.alert, EventLate)
+	}
+	bus.Subscribe(filterStage(bus), EventDetected)
+	bus.Subscribe(func(e Event) {
+		if q, ok := c.isQuarantined(e.Source); ok {
+			logDebugf("Rule %s: %s is quarantined (%s), not copying", e.Rule.Name, e.Source, q.Reason)
+			return
+		}
+		if c.expire(e) {
+			return
+		}
+		if c.groups.accept(e) {
+			return
+		}
+		c.requeue(e, c.handleCreate(e.Context(), e.Rule, e.Source))
+	}, EventAccepted)
+	bus.Subscribe(func(e Event) {
+		c.store.Release(e.Source)
+	}, EventCopied)
+	bus.Subscribe(c.recordHistory, EventCopied)
+	bus.Subscribe(c.indexClip, EventCopied)
+	bus.Subscribe(c.recordChecksum, EventCopied)
+	bus.Subscribe(c.shareCopy, EventCopied)
+	bus.Subscribe(c.library.copied, EventCopied)
+	bus.Subscribe(c.stats.record, EventCopied, EventFailed)
+	if c.pairs != nil {
+		bus.Subscribe(c.pairs.copied, EventCopied)
+	}
+	bus.Subscribe(c.pluginCopied, EventCopied)
+	bus.Subscribe(func(e Event) {
+		if e.transfer != nil {
+			c.status.finish(e.transfer, e.Err)
+		}
+	}, EventCopied, EventFailed)
+	if c.audit != nil {
+		bus.Subscribe(func(e Event) {
+			c.audit.record(auditRecord{
+				Time:     e.Time,
+				Rule:     e.Rule.Name,
+				Source:   e.Source,
+				Dest:     e.Dest,
+				Bytes:    e.Bytes,
+				SHA256:   e.SHA256,
+				Duration: e.Duration,
+				Err:      e.Err,
+			})
+		}, EventCopied, EventFailed)
+	}
+	bus.Subscribe(logOutcome, EventCopied, EventFailed)
+}
+
+// Copy copies the file or directory tree at path, which lies in the rule's
+// source folder, to the rule's destination. Cancelling ctx aborts the copy
+// and removes the partly written file.
+func (c *Copier) Copy(ctx context.Context, rule Rule, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return c.copyTree(ctx, rule, path)
+	}
+	rel, _ := relInside(rule.SourceDir, filepath.Dir(path))
+	return c.archiveFile(ctx, rule, path, info, rel)
+}
+
+// quarantine records that the file of e could not be copied, unless it has
+// disappeared in the meantime.
+func (c *Copier) quarantine(e Event, err error) {
+	info, serr := os.Stat(e.Source)
+	if serr != nil || !info.Mode().IsRegular() {
+		return
+	}
+	entry := QuarantineEntry{
+		Rule:     e.Rule.Name,
+		Source:   e.Source,
+		Reason:   ErrorReason(err),
+		Class:    ClassifyError(err),
+		Error:    err.Error(),
+		Attempts: e.Attempt + 1,
+		Since:    time.Now(),
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+	}
+	if err := c.store.quarantine(entry); err != nil {
+		logEvent(CategoryQuarantine, LevelError, "Error saving quarantine: %v", err)
+		return
+	}
+	logEvent(CategoryQuarantine, LevelError, "Rule %s: quarantined %s (%s); release it once fixed", e.Rule.Name, e.Source, entry.Reason)
+}
+
+// isQuarantined reports whether the file at path is quarantined and has
+// not changed since.
+func (c *Copier) isQuarantined(path string) (QuarantineEntry, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return QuarantineEntry{}, false
+	}
+	return c.store.quarantined(path, info)
+}
+
+// handleCreate copies a newly created file to the rule's destination.
+func (c *Copier) handleCreate(ctx context.Context, rule Rule, path string) error {
+	if svcLogger != nil {
+		svcLogger.Infof("New file detected: %s", path)
+	}
+	// Check that it is a file (not a directory). The writer may still hold
+	// it for a moment after the quiet period.
+	var info os.FileInfo
+	err := c.lock.do(ctx, path, func() (err error) {
+		info, err = os.Stat(path)
+		return err
+	})
+	if err != nil {
+		if svcLogger != nil {
+			svcLogger.Errorf("Error stating file: %v", err)
+		}
+		return err
+	}
+	if info.IsDir() {
+		// Failures within the tree are logged per file, not requeued.
+		c.copyTree(ctx, rule, path)
+		return nil
+	}
+	if c.deferLarge(Event{Kind: EventAccepted, Rule: rule, Source: path}.WithContext(ctx), info.Size()) {
+		return nil
+	}
+	return c.archiveFile(ctx, rule, path, info, "")
+}
+
+// requeue queues another copy of the accepted file e after a transient or
+// destination error, up to maxCopyAttempts in total, waiting longer before
+// each attempt. Files that fail permanently or too often are
+// quarantined.
+func (c *Copier) requeue(e Event, err error) {
+	class := ClassifyError(err)
+	if class == ClassPermanent {
+		c.quarantine(e, err)
+	}
+	if !class.retryable() {
+		return
+	}
+	if e.Attempt+1 >= maxCopyAttempts {
+		logEvent(CategoryCopyFailure, LevelError, "Rule %s: giving up on %s after %d attempts", e.Rule.Name, e.Source, maxCopyAttempts)
+		c.quarantine(e, err)
+		return
+	}
+	delay := copyRetryDelay << e.Attempt
+	logEvent(CategoryCopyFailure, LevelWarning, "Rule %s: retrying %s in %s", e.Rule.Name, e.Source, delay)
+	e.Attempt++
+	e.Time = time.Time{}
+	q := QueueEntry{
+		ID:      newQueueID(),
+		Rule:    e.Rule.Name,
+		Source:  e.Source,
+		Error:   err.Error(),
+		Class:   class,
+		Attempt: e.Attempt,
+		Since:   time.Now(),
+		Due:     time.Now().Add(delay),
+	}
+	if err := c.store.enqueue(q); err != nil {
+		logEvent(CategoryCopyFailure, LevelError, "Error saving the retry queue: %v", err)
+	}
+	c.scheduleRetry(q.ID, e, q.Due)
+}
+
+// copyTree copies every file below a directory that appeared in the rule's
+// source folder, keeping its structure below the destination.
+func (c *Copier) copyTree(ctx context.Context, rule Rule, dir string) error {
+	if svcLogger != nil {
+		svcLogger.Infof("Directory created, copying its contents: %s", dir)
+	}
+	var copied, failed int
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(rule.SourceDir, path)
+		if rule.excluded(rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel = filepath.Dir(rel)
+		if c.archiveFile(ctx, rule, path, info, rel) != nil {
+			failed++
+		} else {
+			copied++
+		}
+		return nil
+	})
+	if err != nil {
+		if svcLogger != nil {
+			svcLogger.Errorf("Rule %s: copy of directory %s stopped: %v", rule.Name, dir, err)
+		}
+		return err
+	}
+	if svcLogger != nil {
+		svcLogger.Infof("Rule %s: directory %s done, %d copied, %d failed", rule.Name, dir, copied, failed)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files in %s could not be copied", failed, copied+failed, dir)
+	}
+	return nil
+}
+
+// filtered returns why the rule's filters on name, content, duration and
+// plugins leave out the file at path, or "" when they let it through.
+func (c *Copier) filtered(ctx context.Context, rule Rule, path string, info os.FileInfo) string {
+	why := rule.outsideFilter(path, info)
+	if why == "" {
+		why = rule.outsideContent(path)
+	}
+	if why == "" {
+		why = rule.outsideDuration(path)
+	}
+	if why == "" && len(rule.Plugins) > 0 {
+		why = c.pluginFilter(ctx, rule, path, info)
+	}
+	return why
+}
+
+// archiveFile copies the file at path to the rule's destination folder, or
+// to today's staging folder when the rule archives sessions, placing it in
+// the subfolder relDir, below the folder of its student when the rule
+// looks students up. The outcome is published on the copier's event bus
+// and returned. The copy can be aborted through ctx or, while in flight,
+// through its status entry, and is aborted when it exceeds the copier's
+// timeout or stalls.
+func (c *Copier) archiveFile(ctx context.Context, rule Rule, path string, info os.FileInfo, relDir string) error {
+	rule = c.failedOver(rule).routed(path)
+	studentRel, lesson := studentDir(ctx, rule, path, info)
+	relDir = filepath.Join(studentRel, relDir)
+	destDir, err := c.destDir(rule, relDir)
+	if err != nil {
+		return err
+	}
+	a, err := c.admit(ctx, rule, path, info, destDir)
+	if a == nil || err != nil {
+		return err
+	}
+	defer a.release()
+	e := c.copyTo(ctx, rule, path, info, a.dest)
+	e.visual, e.lesson = a.visual, lesson
+	return c.finish(ctx, rule, e, info)
+}
+
+// admitted is a file that passed the copier's checks, and the destination
+// path it holds until release is called.
+type admitted struct {
+	dest    string
+	release func()
+	visual  visualPrint
+}
+
+// admit runs the checks that decide whether the file at path is copied to
+// destDir: the schedule, antivirus, filters, plugin renames, the pre-copy
+// hook, the claim on the destination path, and unchanged, renamed and
+// visually duplicate files. It returns nil for files that are not copied.
+func (c *Copier) admit(ctx context.Context, rule Rule, path string, info os.FileInfo, destDir string) (*admitted, error) {
+	why := rule.outsideSchedule(info)
+	if why == "" && rule.Antivirus != nil {
+		if err := rule.Antivirus.clear(ctx, rule, path); err != nil {
+			return nil, err
+		}
+	}
+	if why == "" {
+		why = c.filtered(ctx, rule, path, info)
+	}
+	dest := filepath.Join(destDir, destFileName(rule, path))
+	if why == "" && len(rule.Plugins) > 0 {
+		dest = c.pluginRename(ctx, rule, path, info, dest)
+	}
+	if why == "" && rule.PreCopy != nil {
+		var err error
+		why, err = rule.PreCopy.decide(ctx, rule, path, info, dest)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if why != "" {
+		logEvent(CategoryCopy, LevelInfo, "Rule %s: skipped %s, %s", rule.Name, path, why)
+		c.skipped(path, info)
+		return nil, nil
+	}
+	destPath, release, err := c.dests.claim(ctx, path, dest)
+	if err != nil {
+		return nil, err
+	}
+	if rule.SkipIdentical != "" && c.unchanged(ctx, rule, path, info, destPath) {
+		release()
+		return nil, nil
+	}
+	if rule.DetectRenames && rule.Archive == "" && c.renameArchived(ctx, rule, path, info, destPath) {
+		release()
+		return nil, nil
+	}
+	var visual visualPrint
+	if rule.VisualDuplicates != nil && isMedia(path) {
+		var dup string
+		if visual, dup = c.visualDuplicate(ctx, rule, path, info); dup != "" {
+			logEvent(CategoryCopy, LevelInfo, "Rule %s: skipped %s, it looks the same as %s", rule.Name, path, dup)
+			c.skipped(path, info)
+			release()
+			return nil, nil
+		}
+	}
+	return &admitted{dest: destPath, release: release, visual: visual}, nil
+}
+
+// finish adds the sidecar, shot data and plugin metadata to a copy of the
+// file with info, pushes it to the remote destination of the rule, and
+// publishes the outcome, which it returns.
+func (c *Copier) finish(ctx context.Context, rule Rule, e Event, info os.FileInfo) error {
+	if e.Err == nil && rule.Students != nil && rule.Students.Sidecar {
+		if err := rule.Students.writeSidecar(e, info, e.lesson); err != nil {
+			logEvent(CategoryCopy, LevelWarning, "Rule %s: cannot write the sidecar of %s: %v", rule.Name, e.Dest, err)
+		}
+	}
+	if e.Err == nil && rule.ShotData != nil {
+		rule.ShotData.attachShot(e, info)
+	}
+	if e.Err == nil && len(rule.Plugins) > 0 {
+		c.pluginMetadata(e, info)
+	}
+	local, push := e.Dest, rule.remotePush()
+	if e.Err == nil && push != nil {
+		e = c.pushRemote(ctx, rule, e)
+	}
+	c.bus.Publish(e)
+	if e.Err == nil && push != nil && !push.keepLocal() {
+		// Consumers have read the spooled copy by now.
+		os.Remove(local)
+	}
+	return e.Err
+}
+
+// destDir creates and returns the folder that files of the rule from the
+// source subfolder relDir are copied to.
+func (c *Copier) destDir(rule Rule, relDir string) (string, error) {
+	destDir := filepath.Join(rule.DestDir, relDir)
+	if rule.Archive != "" {
+		destDir = filepath.Join(sessionStagingDir(rule, time.Now()), relDir)
+	}
+	mkdir := func(dir string) error { return os.MkdirAll(dir, os.ModePerm) }
+	if rule.Ownership != nil {
+		mkdir = rule.Ownership.mkdirOwned
+	}
+	if err := mkdir(destDir); err != nil {
+		if svcLogger != nil {
+			svcLogger.Errorf("Error creating destination directory: %v", err)
+		}
+		return "", err
+	}
+	return destDir, nil
+}
+
+// copyTo copies the file at path to destPath and returns the outcome for
+// the caller to publish.
+func (c *Copier) copyTo(ctx context.Context, rule Rule, path string, info os.FileInfo, destPath string) Event {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	if c.timeout > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeoutCause(ctx, c.timeout, fmt.Errorf("%w after %s", errCopyTimeout, c.timeout))
+		defer stop()
+	}
+	var wrappers []writerWrapper
+	if c.load != nil {
+		wrappers = append(wrappers, c.load.wrapper(ctx))
+	}
+	if shouldCompress(rule, path) {
+		wrappers = append(wrappers, compressWrapper(rule.Compress))
+	}
+	if rule.Encrypt {
+		wrappers = append(wrappers, func(w io.Writer) (io.WriteCloser, error) {
+			return newEncryptWriter(w, c.key)
+		})
+	}
+	if c.chaos != nil {
+		if w := c.chaos.wrapper(ctx); w != nil {
+			wrappers = append(wrappers, w)
+		}
+	}
+	var written hash.Hash
+	if rule.VerifyWrites {
+		written = sha256.New()
+		wrappers = append(wrappers, hashWrapper(written))
+	}
+	c.lock.do(ctx, path, func() error {
+		f, err := os.Open(path)
+		if err == nil {
+			f.Close()
+		}
+		return err
+	})
+	src := path
+	if rule.Snapshot {
+		src = c.unlockedSource(ctx, rule, path, info)
+	}
+	size := info.Size()
+	if rule.editsVideo() {
+		// ffmpeg runs before the transfer is tracked, so the stall check
+		// does not abort a long re-encode.
+		processed, err := c.processMedia(ctx, rule, src, info)
+		if err != nil {
+			return Event{Kind: EventFailed, Rule: rule, Source: path, Dest: destPath, Err: err, Class: ClassifyError(err)}
+		}
+		if processed != src {
+			defer os.Remove(processed)
+			src = processed
+			if pi, err := os.Stat(processed); err == nil {
+				size = pi.Size()
+			}
+		}
+	}
+	t := c.status.begin(path, destPath, size, func() { cancel(nil) })
+	if c.stall > 0 {
+		go c.watchStall(ctx, t, cancel)
+	}
+	hash := sha256.New()
+	err := copyFile(ctx, src, destPath, rule.WriteThrough, c.buffer, io.MultiWriter(t, hash), wrappers...)
+	if err == nil && rule.PreserveSecurity {
+		if err = copySecurity(path, destPath); err != nil {
+			os.Remove(destPath)
+		}
+	}
+	if err == nil && rule.Ownership != nil {
+		if err = rule.Ownership.apply(destPath, false); err != nil {
+			os.Remove(destPath)
+		}
+	}
+	if err == nil && written != nil {
+		if err = readBack(ctx, destPath, hex.EncodeToString(written.Sum(nil))); err != nil && ctx.Err() == nil {
+			os.Remove(destPath)
+		}
+	}
+	if err != nil && ctx.Err() != nil {
+		err = context.Cause(ctx)
+		os.Remove(destPath)
+	}
+	kind, sum := EventCopied, ""
+	if err != nil {
+		kind = EventFailed
+	} else {
+		sum = hex.EncodeToString(hash.Sum(nil))
+	}
+	return Event{
+		Kind:     kind,
+		Rule:     rule,
+		Source:   path,
+		Dest:     destPath,
+		Bytes:    t.Copied,
+		SHA256:   sum,
+		Duration: time.Since(t.Started),
+		Err:      err,
+		Class:    ClassifyError(err),
+		transfer: t,
+		modTime:  info.ModTime(),
+	}
+}
+
+// unlockedSource returns where the file at path can be read from: path
+// itself, or its copy in a shadow copy of the volume when another program
+// holds it locked.
+func (c *Copier) unlockedSource(ctx context.Context, rule Rule, path string, info os.FileInfo) string {
+	f, err := os.Open(path)
+	if err == nil {
+		f.Close()
+		return path
+	}
+	if ErrorReason(err) != "locked" {
+		return path
+	}
+	shadow, err := c.snapshots.resolve(ctx, path, info)
+	if err != nil {
+		if svcLogger != nil {
+			svcLogger.Warningf("Rule %s: %s is locked and no shadow copy is available: %v", rule.Name, path, err)
+		}
+		return path
+	}
+	if svcLogger != nil {
+		svcLogger.Infof("Rule %s: %s is locked, copying it from a shadow copy", rule.Name, path)
+	}
+	return shadow
+}
+
+// watchStall cancels the copy tracked by t when no data moves for the
+// copier's stall timeout. Time spent deferring to a busy machine does not
+// count as a stall.
+func (c *Copier) watchStall(ctx context.Context, t *Transfer, cancel context.CancelCauseFunc) {
+	ticker := time.NewTicker(max(c.stall/4, time.Second))
+	defer ticker.Stop()
+	last, since := int64(-1), time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			copied := atomic.LoadInt64(&t.Copied)
+			if copied != last || (c.load != nil && c.load.isBusy()) {
+				last, since = copied, now
+			} else if now.Sub(since) >= c.stall {
+				cancel(fmt.Errorf("%w: no data for %s", errCopyStalled, c.stall))
+				return
+			}
+		}
+	}
+}
+
+// destFileName returns the name under which rule stores the file at path,
+// including any compression and encryption extensions.
+func destFileName(rule Rule, path string) string {
+	name := filepath.Base(path)
+	if shouldCompress(rule, path) {
+		name += compressionSuffix(rule.Compress)
+	}
+	if rule.Encrypt {
+		name += EncSuffix
+	}
+	return name
+}
+
+// writerWrapper transforms data on its way to the destination file, e.g.
+// by encrypting it. Closing the returned writer must flush any buffered
+// output but not close the underlying writer.
+type writerWrapper func(io.Writer) (io.WriteCloser, error)
+
+// copyFile copies a file from src to dst, bufSize bytes at a time or in
+// io.Copy's default chunks when it is zero. If progress is non-nil it also
+// receives every byte read from src. Wrappers are applied in order, the
+// first one seeing the source data. With writeThrough the destination
+// bypasses the write cache and is flushed, with its folder, before
+// copyFile returns. Cancelling ctx stops the copy, and
+// copyFile returns even if a read or write is blocked on a hung device.
+func copyFile(ctx context.Context, src, dst string, writeThrough bool, bufSize int, progress io.Writer, wrappers ...writerWrapper) error {
+	sourceFileStat, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !sourceFileStat.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", src)
+	}
+	source, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	create := os.Create
+	if writeThrough {
+		create = createWriteThrough
+	}
+	destination, err := create(dst)
+	if err != nil {
+		return err
+	}
+	defer destination.Close()
+
+	var w io.Writer = destination
+	closers := make([]io.Closer, len(wrappers))
+	for i := len(wrappers) - 1; i >= 0; i-- {
+		wc, err := wrappers[i](w)
+		if err != nil {
+			return err
+		}
+		w, closers[i] = wc, wc
+	}
+	if progress != nil {
+		w = io.MultiWriter(w, progress)
+	}
+	var buf []byte
+	if bufSize > 0 {
+		buf = make([]byte, bufSize)
+	}
+	copied := make(chan error, 1)
+	go func() {
+		_, err := io.CopyBuffer(w, ctxReader{ctx, source}, buf)
+		copied <- err
+	}()
+	select {
+	case err = <-copied:
+		if err != nil {
+			return err
+		}
+	case <-ctx.Done():
+		// The copying goroutine ends once the blocked call returns and
+		// fails on the closed files.
+		return context.Cause(ctx)
+	}
+	for _, c := range closers {
+		if err := c.Close(); err != nil {
+			return err
+		}
+	}
+	if writeThrough {
+		// Also flush the file's size and times, and its folder entry.
+		if err := destination.Sync(); err != nil {
+			return err
+		}
+		if err := destination.Close(); err != nil {
+			return err
+		}
+		return syncDir(filepath.Dir(dst))
+	}
+	return nil
+}
+
+// ctxReader fails reads once its context is cancelled.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,64 @@
+package foldermonitor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRelInside(t *testing.T) {
+	base := filepath.FromSlash("/archive/bay1")
+	tests := []struct {
+		path string
+		rel  string
+		ok   bool
+	}{
+		{"/archive/bay1", ".", true},
+		{"/archive/bay1/2026/swing.mp4", "2026/swing.mp4", true},
+		{"/archive/bay1/..clips/swing.mp4", "..clips/swing.mp4", true},
+		{"/archive/bay1/...", "...", true},
+		{"/archive", "", false},
+		{"/archive/bay2/swing.mp4", "", false},
+		{"/archive/bay1..old/swing.mp4", "", false},
+	}
+	for _, tt := range tests {
+		rel, ok := relInside(base, filepath.FromSlash(tt.path))
+		if rel != filepath.FromSlash(tt.rel) || ok != tt.ok {
+			t.Errorf("relInside(%q, %q) = %q, %v, want %q, %v", base, tt.path, rel, ok, tt.rel, tt.ok)
+		}
+	}
+}
+
+func TestCopyKeepsFolders(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{name: "subfolder", path: filepath.Join(src, "2026", "swing.mp4"), want: filepath.Join(dst, "2026", "swing.mp4")},
+		{name: "subfolder starting with dots", path: filepath.Join(src, "..clips", "swing.mp4"), want: filepath.Join(dst, "..clips", "swing.mp4")},
+		{name: "outside the source folder", path: filepath.Join(dir, "other", "swing2.mp4"), want: filepath.Join(dst, "swing2.mp4")},
+	}
+	rule := Rule{Name: DefaultRuleName, SourceDir: src, DestDir: dst}
+	cfg := &Config{Version: CurrentConfigVersion, Rules: []Rule{rule}}
+	c := newCopier(cfg, NewStore(dir), newStatusTracker())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.MkdirAll(filepath.Dir(tt.path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(tt.path, []byte(tt.name), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Copy(context.Background(), rule, tt.path); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(tt.want); err != nil {
+				t.Errorf("copy not at %s: %v", tt.want, err)
+			}
+		})
+	}
+}
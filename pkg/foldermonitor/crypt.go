@@ -0,0 +1,269 @@
+package foldermonitor
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/zalando/go-keyring"
+)
+
+// EncryptionConfig says where to find the 256-bit encryption key. Exactly
+// one source should be set; keys are hex or base64 encoded.
+type EncryptionConfig struct {
+	Key      string `json:"key,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	Keychain string `json:"keychain,omitempty"` // account name in the OS credential store
+}
+
+// keychainService is the service name used for OS credential store entries.
+const keychainService = "FolderMonitor"
+
+// Encrypted files start with encMagic followed by a random nonce prefix and
+// a sequence of independently sealed chunks, in the style of the STREAM
+// construction: each chunk's nonce is the prefix, a big-endian counter and
+// a final-chunk flag, which detects reordering and truncation.
+const (
+	encMagic       = "FMENC1\n"
+	EncSuffix      = ".enc"
+	encChunkSize   = 64 * 1024
+	encPrefixSize  = 7
+	encOverhead    = 16
+	encKeySize     = 32
+	encNonceLength = 12
+)
+
+// LoadEncryptionKey resolves the key described by c.
+func LoadEncryptionKey(c *EncryptionConfig) ([]byte, error) {
+	if c == nil {
+		return nil, errors.New("no encryption key configured")
+	}
+	switch {
+	case c.Key != "":
+		return parseKey(c.Key)
+	case c.KeyFile != "":
+		data, err := os.ReadFile(c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == encKeySize {
+			return data, nil
+		}
+		return parseKey(string(data))
+	case c.Keychain != "":
+		secret, err := keyring.Get(keychainService, c.Keychain)
+		if err != nil {
+			return nil, fmt.Errorf("reading key %q from the OS keychain: %v", c.Keychain, err)
+		}
+		return parseKey(secret)
+	}
+	return nil, errors.New("encryption needs key, key_file or keychain")
+}
+
+// parseKey decodes a hex or base64 encoded 256-bit key.
+func parseKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if key, err := hex.DecodeString(s); err == nil && len(key) == encKeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == encKeySize {
+		return key, nil
+	}
+	return nil, errors.New("encryption key must be 32 bytes, hex or base64 encoded")
+}
+
+// GenerateKey returns a new random key, hex encoded.
+func GenerateKey() (string, error) {
+	key := make([]byte, encKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// StoreKeychainKey saves a hex key in the OS credential store.
+func StoreKeychainKey(account, key string) error {
+	return keyring.Set(keychainService, account, key)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce builds the nonce for chunk n.
+func chunkNonce(prefix []byte, n uint32, last bool) []byte {
+	nonce := make([]byte, encNonceLength)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encPrefixSize:], n)
+	if last {
+		nonce[encNonceLength-1] = 1
+	}
+	return nonce
+}
+
+// encryptWriter encrypts everything written to it into w.
+type encryptWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	prefix []byte
+	n      uint32
+	buf    []byte
+}
+
+// newEncryptWriter writes the file header to w and returns a writer that
+// encrypts into it. Close must be called to seal the final chunk.
+func newEncryptWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, encPrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, encMagic); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(prefix); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, prefix: prefix, buf: make([]byte, 0, encChunkSize)}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data arrives, so that the
+		// final chunk is always the one sealed by Close.
+		if len(e.buf) == encChunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):encChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) seal(last bool) error {
+	out := e.aead.Seal(nil, chunkNonce(e.prefix, e.n, last), e.buf, nil)
+	e.n++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(out)
+	return err
+}
+
+// Close seals the final chunk. It does not close the underlying writer.
+func (e *encryptWriter) Close() error {
+	return e.seal(true)
+}
+
+// decryptReader reverses encryptWriter.
+type decryptReader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	n      uint32
+	buf    []byte
+	done   bool
+}
+
+// newDecryptReader reads the header from r and returns a reader yielding
+// the plaintext.
+func newDecryptReader(r io.Reader, key []byte) (io.Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReaderSize(r, encChunkSize+encOverhead+1)
+	header := make([]byte, len(encMagic)+encPrefixSize)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("reading header: %v", err)
+	}
+	if string(header[:len(encMagic)]) != encMagic {
+		return nil, errors.New("not an encrypted monitor file")
+	}
+	return &decryptReader{r: br, aead: aead, prefix: header[len(encMagic):]}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func (d *decryptReader) open() error {
+	chunk := make([]byte, encChunkSize+encOverhead)
+	n, err := io.ReadFull(d.r, chunk)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			return errors.New("encrypted file is truncated")
+		}
+		return err
+	}
+	last := n < len(chunk)
+	if !last {
+		if _, err := d.r.Peek(1); err == io.EOF {
+			last = true
+		}
+	}
+	plain, err := d.aead.Open(nil, chunkNonce(d.prefix, d.n, last), chunk[:n], nil)
+	if err != nil {
+		return fmt.Errorf("chunk %d failed authentication (wrong key or corrupted file)", d.n)
+	}
+	d.n++
+	d.buf = plain
+	d.done = last
+	return nil
+}
+
+// DecryptFile decrypts src into dst using the configured key.
+func DecryptFile(c *EncryptionConfig, src, dst string) error {
+	key, err := LoadEncryptionKey(c)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	r, err := newDecryptReader(in, key)
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
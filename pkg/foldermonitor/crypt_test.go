@@ -0,0 +1,76 @@
+package foldermonitor
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+func TestEncryptChunks(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	tests := []struct {
+		name   string
+		size   int
+		chunks int
+	}{
+		{name: "empty", size: 0, chunks: 1},
+		{name: "one byte", size: 1, chunks: 1},
+		{name: "just under a chunk", size: encChunkSize - 1, chunks: 1},
+		{name: "exactly a chunk", size: encChunkSize, chunks: 1},
+		{name: "just over a chunk", size: encChunkSize + 1, chunks: 2},
+		{name: "three chunks", size: 3 * encChunkSize, chunks: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain := make([]byte, tt.size)
+			rand.Read(plain)
+			var sealed bytes.Buffer
+			w, err := newEncryptWriter(&sealed, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Odd write sizes must not change the chunking.
+			if _, err := io.CopyBuffer(w, bytes.NewReader(plain), make([]byte, 1000)); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if want := len(encMagic) + encPrefixSize + tt.size + tt.chunks*encOverhead; sealed.Len() != want {
+				t.Errorf("%d bytes encrypted, want %d for %d chunks", sealed.Len(), want, tt.chunks)
+			}
+			r, err := newDecryptReader(bytes.NewReader(sealed.Bytes()), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Errorf("decrypted %d bytes differ from the %d written", len(got), len(plain))
+			}
+
+			// A file cut after a full chunk must not pass as complete.
+			if tt.chunks > 1 {
+				cut := sealed.Bytes()[:len(encMagic)+encPrefixSize+encChunkSize+encOverhead]
+				r, err := newDecryptReader(bytes.NewReader(cut), key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := io.ReadAll(r); err == nil {
+					t.Error("a truncated file decrypted without error")
+				}
+			}
+			wrong := make([]byte, 32)
+			rand.Read(wrong)
+			if r, err := newDecryptReader(bytes.NewReader(sealed.Bytes()), wrong); err == nil {
+				if _, err := io.ReadAll(r); err == nil {
+					t.Error("decrypted with the wrong key")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package foldermonitor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// defaultQuietPeriod is how long a new file must see no further events
+// before it is copied when the config does not say otherwise.
+const defaultQuietPeriod = 2 * time.Second
+
+// pendingFile is a new file waiting for its events to settle.
+type pendingFile struct {
+	path string
+	rule Rule
+	due  time.Time
+	seen time.Time // first event
+	tree string    // contents signature when path is a directory
+}
+
+// coalescer merges the bursts of Create and Write events the OS reports
+// while a file is being written, so that each new file is handled once
+// after it has been quiet for a while.
+type coalescer struct {
+	quiet   time.Duration
+	pending map[string]*pendingFile
+}
+
+func newCoalescer(quiet time.Duration) *coalescer {
+	return &coalescer{quiet: quiet, pending: make(map[string]*pendingFile)}
+}
+
+// observe records event for a file watched by rule. A Create starts or
+// restarts the quiet period, a Write or Chmod of a pending file extends it,
+// and a Remove or Rename drops the file since it is no longer there.
+func (c *coalescer) observe(event fsnotify.Event, rule Rule, now time.Time) {
+	f, ok := c.pending[event.Name]
+	switch {
+	case event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename):
+		delete(c.pending, event.Name)
+	case event.Has(fsnotify.Create):
+		if !ok {
+			f = &pendingFile{path: event.Name, seen: now}
+			c.pending[event.Name] = f
+		}
+		f.rule = rule
+		f.due = now.Add(c.quiet)
+	case ok && (event.Has(fsnotify.Write) || event.Has(fsnotify.Chmod)):
+		f.due = now.Add(c.quiet)
+	}
+}
+
+// ready removes and returns the files whose quiet period has passed, oldest
+// first. Files written into a new directory raise no events here because
+// only the source folder itself is watched, so a directory is returned only
+// once its contents have stopped changing for a whole quiet period.
+func (c *coalescer) ready(now time.Time) []pendingFile {
+	var files []pendingFile
+	for path, f := range c.pending {
+		if now.Before(f.due) {
+			continue
+		}
+		if tree, ok := treeSignature(path); ok && tree != f.tree {
+			f.tree = tree
+			f.due = now.Add(c.quiet)
+			continue
+		}
+		files = append(files, *f)
+		delete(c.pending, path)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].due.Before(files[j].due) })
+	return files
+}
+
+// pollInterval returns how often ready should be called.
+func (c *coalescer) pollInterval() time.Duration {
+	if d := c.quiet / 4; d > 50*time.Millisecond {
+		return d
+	}
+	return 50 * time.Millisecond
+}
+
+// treeSignature summarises the files below dir by count, total size and
+// latest modification time. ok is false when dir is not a directory.
+func treeSignature(dir string) (sig string, ok bool) {
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return "", false
+	}
+	var count, size int64
+	var latest time.Time
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		count++
+		size += info.Size()
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		return nil
+	})
+	return fmt.Sprintf("%d/%d/%d", count, size, latest.UnixNano()), true
+}
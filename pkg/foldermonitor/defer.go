@@ -0,0 +1,102 @@
+package foldermonitor
+
+import "time"
+
+// deferredCopy is a large copy waiting for the console to be left alone or
+// for AC power. Once it has waited its maximum it is marked overdue, so
+// that the next attempt goes ahead.
+type deferredCopy struct {
+	e       Event
+	timer   *time.Timer // nil when there is no maximum wait
+	max     time.Duration
+	overdue bool
+}
+
+// deferral returns why a copy of size bytes should wait now and for how
+// long at most (0 for no limit), or "" when it can go ahead.
+func (c *Copier) deferral(size int64) (reason string, max time.Duration) {
+	if s := c.session; s != nil && s.DeferHeavy && c.console != nil && c.console.Load() == consoleInUse {
+		if size >= orSize(s.HeavySize, defaultHeavySize) {
+			return "while the console session is in use", s.MaxDefer.or(defaultSessionMaxDefer)
+		}
+	}
+	if p := c.power; p != nil && p.DeferOnBattery && c.onBattery != nil && c.onBattery.Load() {
+		if size >= orSize(p.LargeSize, defaultLargeSize) {
+			return "until the machine is on AC power", time.Duration(p.MaxDefer)
+		}
+	}
+	return "", 0
+}
+
+// orSize returns size, or def when it is not set.
+func orSize(size, def int64) int64 {
+	if size <= 0 {
+		return def
+	}
+	return size
+}
+
+// deferLarge holds back the accepted file of e when its copy should wait,
+// reporting whether it did.
+func (c *Copier) deferLarge(e Event, size int64) bool {
+	reason, max := c.deferral(size)
+	if reason == "" {
+		return false
+	}
+	c.deferMu.Lock()
+	defer c.deferMu.Unlock()
+	if d, ok := c.deferred[e.Source]; ok {
+		if d.overdue {
+			delete(c.deferred, e.Source)
+			return false
+		}
+		return true
+	}
+	d := &deferredCopy{e: e, max: max}
+	if max > 0 {
+		d.timer = time.AfterFunc(max, func() { c.releaseOverdue(e.Source) })
+	}
+	c.deferred[e.Source] = d
+	logEvent(CategoryCopy, LevelInfo, "Rule %s: deferring %s (%s) %s", e.Rule.Name, e.Source, FormatBytes(size), reason)
+	return true
+}
+
+// releaseDeferred publishes every deferred copy; those that still have to
+// wait are deferred again.
+func (c *Copier) releaseDeferred() {
+	c.deferMu.Lock()
+	var ready []Event
+	for path, d := range c.deferred {
+		if !d.overdue {
+			if d.timer != nil {
+				d.timer.Stop()
+			}
+			ready = append(ready, d.e)
+		}
+		delete(c.deferred, path)
+	}
+	c.deferMu.Unlock()
+	if len(ready) > 0 {
+		logDebugf("Starting %d deferred copies", len(ready))
+	}
+	for _, e := range ready {
+		if e.Context().Err() == nil {
+			c.bus.Publish(e)
+		}
+	}
+}
+
+// releaseOverdue publishes the copy of the file at path, which has waited
+// as long as it may.
+func (c *Copier) releaseOverdue(path string) {
+	c.deferMu.Lock()
+	d, ok := c.deferred[path]
+	if ok {
+		d.overdue = true
+	}
+	c.deferMu.Unlock()
+	if ok && d.e.Context().Err() == nil {
+		logEvent(CategoryCopy, LevelInfo, "Rule %s: copying %s after deferring it for %s", d.e.Rule.Name, path, d.max)
+		c.bus.Publish(d.e)
+	}
+}
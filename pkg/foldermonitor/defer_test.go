@@ -0,0 +1,40 @@
+package foldermonitor
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeferral(t *testing.T) {
+	var inUse, idle atomic.Int32
+	inUse.Store(consoleInUse)
+	idle.Store(consoleIdle)
+	var battery, ac atomic.Bool
+	battery.Store(true)
+	session := &SessionConfig{DeferHeavy: true, MaxDefer: Duration(time.Hour)}
+	power := &PowerConfig{DeferOnBattery: true, LargeSize: 10 << 20}
+	tests := []struct {
+		name    string
+		c       *Copier
+		size    int64
+		reason  string
+		maxWait time.Duration
+	}{
+		{name: "nothing configured", c: &Copier{}, size: 1 << 30},
+		{name: "heavy while the console is in use", c: &Copier{session: session, console: &inUse}, size: defaultHeavySize,
+			reason: "console session", maxWait: time.Hour},
+		{name: "small while the console is in use", c: &Copier{session: session, console: &inUse}, size: defaultHeavySize - 1},
+		{name: "heavy while the console is idle", c: &Copier{session: session, console: &idle}, size: 1 << 30},
+		{name: "large on battery", c: &Copier{power: power, onBattery: &battery}, size: 10 << 20, reason: "AC power"},
+		{name: "small on battery", c: &Copier{power: power, onBattery: &battery}, size: 10<<20 - 1},
+		{name: "large on AC power", c: &Copier{power: power, onBattery: &ac}, size: 1 << 30},
+	}
+	for _, tt := range tests {
+		reason, max := tt.c.deferral(tt.size)
+		if !strings.Contains(reason, tt.reason) || (reason == "") != (tt.reason == "") || max != tt.maxWait {
+			t.Errorf("%s: deferral = %q, %s; want %q, %s", tt.name, reason, max, tt.reason, tt.maxWait)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package foldermonitor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// destClaims serialises the copies into each destination path. Rules,
+// volumes, uploads and retries copy concurrently, so two sources with the
+// same name could otherwise write into one file at once.
+type destClaims struct {
+	mu     sync.Mutex
+	claims map[string]*destClaim // by cleaned destination path
+}
+
+// destClaim is a destination path being written for source; done is
+// closed when it is released.
+type destClaim struct {
+	source string
+	done   chan struct{}
+}
+
+func newDestClaims() *destClaims {
+	return &destClaims{claims: make(map[string]*destClaim)}
+}
+
+// claim reserves dest for copying the file at source and returns the path
+// to write and the function releasing it. While another source is being
+// copied to dest, source is written next to it under its collisionName
+// instead; a claim of the same source, such as a retry racing the watcher,
+// waits for the earlier one to finish. The error is ctx's if it ends while
+// waiting.
+func (d *destClaims) claim(ctx context.Context, source, dest string) (string, func(), error) {
+	d.mu.Lock()
+	for {
+		cl := d.claims[cleanPath(dest)]
+		if cl == nil {
+			cl = &destClaim{source: cleanPath(source), done: make(chan struct{})}
+			key := cleanPath(dest)
+			d.claims[key] = cl
+			d.mu.Unlock()
+			release := func() {
+				d.mu.Lock()
+				delete(d.claims, key)
+				d.mu.Unlock()
+				close(cl.done)
+			}
+			return dest, release, nil
+		}
+		if cl.source != cleanPath(source) {
+			if alt := collisionName(source, dest); alt != dest {
+				logDebugf("%s is being written from %s, copying %s as %s", dest, cl.source, source, filepath.Base(alt))
+				dest = alt
+				continue
+			}
+		}
+		d.mu.Unlock()
+		select {
+		case <-cl.done:
+		case <-ctx.Done():
+			return "", nil, context.Cause(ctx)
+		}
+		d.mu.Lock()
+	}
+}
+
+// claimed reports whether dest is being written.
+func (d *destClaims) claimed(dest string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.claims[cleanPath(dest)] != nil
+}
+
+// collisionName returns the name the file at source is copied as when
+// dest is taken by another copy: dest with a short hash of the source path
+// before its extension, so that each source always gets the same name and
+// a retry overwrites its own earlier copy rather than making another.
+func collisionName(source, dest string) string {
+	sum := sha256.Sum256([]byte(cleanPath(source)))
+	tag := "~" + hex.EncodeToString(sum[:4])
+	ext := filepath.Ext(dest)
+	base := strings.TrimSuffix(dest, ext)
+	if strings.HasSuffix(base, tag) {
+		return dest
+	}
+	return base + tag + ext
+}
@@ -0,0 +1,166 @@
+package foldermonitor
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// listRemovableVolumes returns mounted filesystems whose block device is
+// flagged removable in sysfs, labelled from /dev/disk/by-label.
+func listRemovableVolumes() ([]volume, error) {
+	f, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	labels := make(map[string]string)
+	if entries, err := os.ReadDir("/dev/disk/by-label"); err == nil {
+		for _, e := range entries {
+			if target, err := filepath.EvalSymlinks(filepath.Join("/dev/disk/by-label", e.Name())); err == nil {
+				labels[target] = unescapeMount(e.Name())
+			}
+		}
+	}
+
+	var vols []volume
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.HasPrefix(fields[0], "/dev/") {
+			continue
+		}
+		dev, err := filepath.EvalSymlinks(fields[0])
+		if err != nil || !isRemovableDevice(dev) {
+			continue
+		}
+		mount := unescapeMount(fields[1])
+		label := labels[dev]
+		if label == "" {
+			label = filepath.Base(mount)
+		}
+		vols = append(vols, volume{Mount: mount, Label: label, Device: dev})
+	}
+	return vols, scanner.Err()
+}
+
+// isRemovableDevice checks /sys/block/<disk>/removable for dev or, for a
+// partition, its parent disk. USB card readers often report 0 there, so
+// devices attached over USB also count.
+func isRemovableDevice(dev string) bool {
+	sys, err := filepath.EvalSymlinks(filepath.Join("/sys/class/block", filepath.Base(dev)))
+	if err != nil {
+		return false
+	}
+	for _, dir := range []string{sys, filepath.Dir(sys)} {
+		if data, err := os.ReadFile(filepath.Join(dir, "removable")); err == nil {
+			if strings.TrimSpace(string(data)) == "1" {
+				return true
+			}
+			break
+		}
+	}
+	return strings.Contains(sys, "/usb")
+}
+
+// unescapeMount decodes the octal (\040) and hex (\x20) escapes used in
+// /proc/self/mounts and /dev/disk/by-label.
+func unescapeMount(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if s[i+1] == 'x' {
+				if n, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+					b.WriteByte(byte(n))
+					i += 3
+					continue
+				}
+			} else if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// ejectVolume unmounts the volume and powers off the device using udisks,
+// falling back to eject(1).
+func ejectVolume(v volume) error {
+	if _, err := exec.LookPath("udisksctl"); err == nil {
+		if out, err := exec.Command("udisksctl", "unmount", "-b", v.Device).CombinedOutput(); err != nil {
+			return fmt.Errorf("unmount: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+		exec.Command("udisksctl", "power-off", "-b", v.Device).Run()
+		return nil
+	}
+	if out, err := exec.Command("eject", v.Device).CombinedOutput(); err != nil {
+		return fmt.Errorf("eject: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// driveOf returns the mount point of the filesystem holding path, which
+// must exist, and its device.
+func driveOf(path string) (mount, device string, err error) {
+	path, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", "", err
+	}
+	f, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		// The longest match wins, and the later of two mounts on the same
+		// point hides the earlier one.
+		m := unescapeMount(fields[1])
+		if (path == m || strings.HasPrefix(path, strings.TrimSuffix(m, "/")+"/")) && len(m) >= len(mount) {
+			mount, device = m, fields[0]
+		}
+	}
+	if mount == "" {
+		return "", "", fmt.Errorf("no mount holds %s", path)
+	}
+	return mount, device, scanner.Err()
+}
+
+// driveSpace returns the bytes available to the service and the size of
+// the filesystem holding path.
+func driveSpace(path string) (free, total uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), st.Blocks * uint64(st.Bsize), nil
+}
+
+// smartDevice returns the disk smartctl is asked about for device: the
+// whole disk of a partition.
+func smartDevice(device string) string {
+	dev, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return device
+	}
+	sys, err := filepath.EvalSymlinks(filepath.Join("/sys/class/block", filepath.Base(dev)))
+	if err == nil {
+		if _, err := os.Stat(filepath.Join(sys, "partition")); err == nil {
+			return "/dev/" + filepath.Base(filepath.Dir(sys))
+		}
+	}
+	return dev
+}
@@ -0,0 +1,86 @@
+//go:build !windows && !linux
+
+package foldermonitor
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// listRemovableVolumes returns the volumes mounted under /Volumes, which on
+// macOS are external or removable media.
+func listRemovableVolumes() ([]volume, error) {
+	entries, err := os.ReadDir("/Volumes")
+	if err != nil {
+		return nil, err
+	}
+	var vols []volume
+	for _, e := range entries {
+		path := filepath.Join("/Volumes", e.Name())
+		// The boot volume appears as a symlink to /.
+		if target, err := filepath.EvalSymlinks(path); err != nil || target == "/" {
+			continue
+		}
+		vols = append(vols, volume{Mount: path, Label: e.Name(), Device: path})
+	}
+	return vols, nil
+}
+
+// ejectVolume ejects the volume with diskutil.
+func ejectVolume(v volume) error {
+	if out, err := exec.Command("diskutil", "eject", v.Mount).CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// df returns the fields of "df -Pk" for the filesystem holding path:
+// device, size, used and available KiB, capacity and mount point.
+func df(path string) ([]string, error) {
+	out, err := exec.Command("df", "-Pk", path).Output()
+	if err != nil {
+		return nil, fmt.Errorf("df: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(lines) < 2 || len(fields) < 6 {
+		return nil, fmt.Errorf("df: unexpected output %q", out)
+	}
+	// The mount point may contain spaces.
+	return append(fields[:5], strings.Join(fields[5:], " ")), nil
+}
+
+// driveOf returns the mount point of the filesystem holding path, which
+// must exist, and its device.
+func driveOf(path string) (mount, device string, err error) {
+	fields, err := df(path)
+	if err != nil {
+		return "", "", err
+	}
+	return fields[5], fields[0], nil
+}
+
+// driveSpace returns the bytes available to the service and the size of
+// the filesystem holding path.
+func driveSpace(path string) (free, total uint64, err error) {
+	fields, err := df(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	if total, err = strconv.ParseUint(fields[1], 10, 64); err == nil {
+		free, err = strconv.ParseUint(fields[3], 10, 64)
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("df: %v", err)
+	}
+	return free << 10, total << 10, nil
+}
+
+// smartDevice returns the disk smartctl is asked about for device.
+func smartDevice(device string) string {
+	return device
+}
@@ -0,0 +1,81 @@
+package foldermonitor
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// listRemovableVolumes returns mounted removable drives such as SD card
+// readers and USB sticks.
+func listRemovableVolumes() ([]volume, error) {
+	mask, err := windows.GetLogicalDrives()
+	if err != nil {
+		return nil, err
+	}
+	var vols []volume
+	for i := 0; i < 26; i++ {
+		if mask&(1<<uint(i)) == 0 {
+			continue
+		}
+		root := fmt.Sprintf("%c:\\", 'A'+i)
+		rootPtr, _ := windows.UTF16PtrFromString(root)
+		if windows.GetDriveType(rootPtr) != windows.DRIVE_REMOVABLE {
+			continue
+		}
+		label := make([]uint16, windows.MAX_PATH+1)
+		if err := windows.GetVolumeInformation(rootPtr, &label[0], uint32(len(label)), nil, nil, nil, nil, 0); err != nil {
+			// No media in the reader.
+			continue
+		}
+		vols = append(vols, volume{Mount: root, Label: windows.UTF16ToString(label), Device: root[:2]})
+	}
+	return vols, nil
+}
+
+// ejectVolume asks Explorer to safely eject the drive, which flushes it and
+// notifies the user exactly like "Eject" in the shell.
+func ejectVolume(v volume) error {
+	script := fmt.Sprintf(`(New-Object -ComObject Shell.Application).Namespace(17).ParseName('%s').InvokeVerb('Eject')`, strings.TrimSuffix(v.Mount, `\`))
+	out, err := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// driveOf returns the root of the drive or share holding path, e.g. "E:\",
+// and its name, e.g. "E:".
+func driveOf(path string) (mount, device string, err error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", "", err
+	}
+	vol := filepath.VolumeName(abs)
+	if vol == "" {
+		return "", "", fmt.Errorf("no drive holds %s", path)
+	}
+	return vol + `\`, vol, nil
+}
+
+// driveSpace returns the bytes available to the service and the size of
+// the drive holding path.
+func driveSpace(path string) (free, total uint64, err error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := windows.GetDiskFreeSpaceEx(p, &free, &total, nil); err != nil {
+		return 0, 0, err
+	}
+	return free, total, nil
+}
+
+// smartDevice returns the disk smartctl is asked about for device;
+// smartctl accepts drive letters such as "E:".
+func smartDevice(device string) string {
+	return device
+}
@@ -0,0 +1,94 @@
+package foldermonitor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+)
+
+// ErrorClass groups copy errors by how they should be handled.
+type ErrorClass string
+
+const (
+	// ClassTransient errors, such as a file locked by the recorder or a
+	// network blip, usually clear up; the copy is retried.
+	ClassTransient ErrorClass = "transient"
+	// ClassPermanent errors, such as access denied or an invalid name,
+	// recur on every attempt; the file is not retried.
+	ClassPermanent ErrorClass = "permanent"
+	// ClassDestination errors, such as a full or read-only destination,
+	// affect every copy until someone intervenes; they are alerted on and
+	// retried.
+	ClassDestination ErrorClass = "destination"
+	// ClassCanceled marks copies stopped on purpose, by shutdown or by the
+	// user; they are neither retried nor alerted on.
+	ClassCanceled ErrorClass = "canceled"
+)
+
+// errorKind is the class of an error and a short code naming its cause,
+// such as "locked" or "disk-full".
+type errorKind struct {
+	class  ErrorClass
+	reason string
+}
+
+// ClassifyError returns the class of a copy error, or "" for nil.
+// Unrecognised errors count as transient, as retries are bounded.
+func ClassifyError(err error) ErrorClass {
+	return classify(err).class
+}
+
+// ErrorReason returns a short code for the cause of a copy error, such as
+// "locked", "name-too-long" or "disk-full"; "other" if it is not known.
+func ErrorReason(err error) string {
+	return classify(err).reason
+}
+
+func classify(err error) errorKind {
+	switch {
+	case err == nil:
+		return errorKind{}
+	case errors.Is(err, errCopyStalled):
+		return errorKind{ClassTransient, "stalled"}
+	case errors.Is(err, errCopyTimeout):
+		return errorKind{ClassTransient, "timeout"}
+	case errors.Is(err, errReadBack):
+		return errorKind{ClassDestination, "read-back"}
+	case errors.Is(err, errPush):
+		return errorKind{ClassDestination, "remote"}
+	case errors.Is(err, errInfected):
+		return errorKind{ClassPermanent, "infected"}
+	case errors.Is(err, errScan):
+		return errorKind{ClassTransient, "virus-scan"}
+	case errors.Is(err, errExpired):
+		return errorKind{ClassPermanent, "expired"}
+	case errors.Is(err, errRejected):
+		return errorKind{ClassPermanent, "rejected"}
+	case errors.Is(err, errPreCopy):
+		return errorKind{ClassTransient, "pre-copy"}
+	case errors.Is(err, errChaos):
+		return errorKind{ClassTransient, "injected"}
+	case errors.Is(err, context.Canceled):
+		return errorKind{ClassCanceled, "canceled"}
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		if kind, ok := errnoKinds[errno]; ok {
+			return kind
+		}
+	}
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return errorKind{ClassPermanent, "not-found"}
+	case errors.Is(err, os.ErrPermission):
+		return errorKind{ClassPermanent, "access-denied"}
+	}
+	return errorKind{ClassTransient, "other"}
+}
+
+// retryable reports whether a copy failing with an error of this class
+// should be tried again.
+func (c ErrorClass) retryable() bool {
+	return c == ClassTransient || c == ClassDestination
+}
@@ -0,0 +1,34 @@
+//go:build !windows
+
+package foldermonitor
+
+import "syscall"
+
+// errnoKinds classifies the system error numbers that have a known class.
+var errnoKinds = map[syscall.Errno]errorKind{
+	syscall.EAGAIN:       {ClassTransient, "locked"},
+	syscall.EBUSY:        {ClassTransient, "locked"},
+	syscall.ETXTBSY:      {ClassTransient, "locked"},
+	syscall.EINTR:        {ClassTransient, "interrupted"},
+	syscall.EIO:          {ClassTransient, "io-error"},
+	syscall.ETIMEDOUT:    {ClassTransient, "network"},
+	syscall.ESTALE:       {ClassTransient, "network"},
+	syscall.ECONNRESET:   {ClassTransient, "network"},
+	syscall.ECONNABORTED: {ClassTransient, "network"},
+	syscall.ENETDOWN:     {ClassTransient, "network"},
+	syscall.ENETUNREACH:  {ClassTransient, "network"},
+	syscall.EHOSTUNREACH: {ClassTransient, "network"},
+	syscall.EACCES:       {ClassPermanent, "access-denied"},
+	syscall.EPERM:        {ClassPermanent, "access-denied"},
+	syscall.ENOENT:       {ClassPermanent, "not-found"},
+	syscall.ENAMETOOLONG: {ClassPermanent, "name-too-long"},
+	syscall.EINVAL:       {ClassPermanent, "invalid-name"},
+	syscall.EILSEQ:       {ClassPermanent, "invalid-name"},
+	syscall.EISDIR:       {ClassPermanent, "invalid-name"},
+	syscall.ENOTDIR:      {ClassPermanent, "invalid-name"},
+	syscall.ELOOP:        {ClassPermanent, "invalid-name"},
+	syscall.ENOSPC:       {ClassDestination, "disk-full"},
+	syscall.EDQUOT:       {ClassDestination, "quota-exceeded"},
+	syscall.EROFS:        {ClassDestination, "read-only"},
+	syscall.EFBIG:        {ClassDestination, "file-too-large"},
+}
@@ -0,0 +1,33 @@
+package foldermonitor
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// errnoKinds classifies the Windows error codes that have a known class.
+var errnoKinds = map[syscall.Errno]errorKind{
+	windows.ERROR_SHARING_VIOLATION:    {ClassTransient, "locked"},
+	windows.ERROR_LOCK_VIOLATION:       {ClassTransient, "locked"},
+	windows.ERROR_NOT_READY:            {ClassTransient, "device-unavailable"},
+	windows.ERROR_DEV_NOT_EXIST:        {ClassTransient, "device-unavailable"},
+	windows.ERROR_SEM_TIMEOUT:          {ClassTransient, "network"},
+	windows.ERROR_NETNAME_DELETED:      {ClassTransient, "network"},
+	windows.ERROR_UNEXP_NET_ERR:        {ClassTransient, "network"},
+	windows.ERROR_NETWORK_BUSY:         {ClassTransient, "network"},
+	windows.ERROR_BAD_NETPATH:          {ClassTransient, "network"},
+	windows.ERROR_BAD_NET_NAME:         {ClassTransient, "network"},
+	windows.ERROR_CONNECTION_ABORTED:   {ClassTransient, "network"},
+	windows.ERROR_ACCESS_DENIED:        {ClassPermanent, "access-denied"},
+	windows.ERROR_FILE_NOT_FOUND:       {ClassPermanent, "not-found"},
+	windows.ERROR_PATH_NOT_FOUND:       {ClassPermanent, "not-found"},
+	windows.ERROR_INVALID_NAME:         {ClassPermanent, "invalid-name"},
+	windows.ERROR_DIRECTORY:            {ClassPermanent, "invalid-name"},
+	windows.ERROR_FILENAME_EXCED_RANGE: {ClassPermanent, "name-too-long"},
+	windows.ERROR_CRC:                  {ClassPermanent, "corrupt"},
+	windows.ERROR_DISK_FULL:            {ClassDestination, "disk-full"},
+	windows.ERROR_HANDLE_DISK_FULL:     {ClassDestination, "disk-full"},
+	windows.ERROR_DISK_QUOTA_EXCEEDED:  {ClassDestination, "quota-exceeded"},
+	windows.ERROR_WRITE_PROTECT:        {ClassDestination, "read-only"},
+}
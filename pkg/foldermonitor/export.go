@@ -0,0 +1,303 @@
+package foldermonitor
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// stateFiles are the store files an export carries besides the config file
+// and the device indexes.
+var stateFiles = []string{historyFile, searchFile, queueFile, quarantineFile, statsFile, pauseFile, remoteCacheFile}
+
+// deviceIndexDir is the store folder of the per-device import indexes.
+const deviceIndexDir = "devices"
+
+// exportManifestName is the manifest's name in an export archive.
+const exportManifestName = "manifest.json"
+
+// exportConfigDir is the archive folder holding the config file under its
+// original name, so its format is kept.
+const exportConfigDir = "config"
+
+// ExportManifest describes an export archive.
+type ExportManifest struct {
+	Host    string    `json:"host"`
+	Version string    `json:"version"`
+	Created time.Time `json:"created"`
+	// Config is the name of the config file, e.g. "config.yaml".
+	Config string `json:"config"`
+	// Files are the store files, relative to the store folder.
+	Files []string `json:"files"`
+}
+
+// ExportState writes the config file at configPath and its store (copy
+// history, retry queue, quarantine, statistics, pause state and device
+// indexes) to a zip archive at archive, so that the monitor can be moved to
+// another machine with ImportState. Files the config refers to by path,
+// such as certificates and key files, and keys in the OS keychain are not
+// included.
+func ExportState(configPath, archive string) (*ExportManifest, error) {
+	if _, err := os.Stat(configPath); err != nil {
+		return nil, err
+	}
+	store := NewStore(filepath.Dir(configPath))
+	files, err := storeFiles(store)
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	m := &ExportManifest{Host: host, Version: Version, Created: time.Now(), Config: filepath.Base(configPath), Files: files}
+
+	tmp := archive + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	err = writeExport(f, m, configPath, store)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, archive)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return m, nil
+}
+
+// storeFiles lists the store files that exist, with slash separators.
+func storeFiles(store *Store) ([]string, error) {
+	var files []string
+	for _, name := range stateFiles {
+		if _, err := os.Stat(store.path(name)); err == nil {
+			files = append(files, name)
+		}
+	}
+	entries, err := os.ReadDir(store.path(deviceIndexDir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Type().IsRegular() && strings.HasSuffix(e.Name(), ".json") {
+			files = append(files, path.Join(deviceIndexDir, e.Name()))
+		}
+	}
+	return files, nil
+}
+
+func writeExport(w io.Writer, m *ExportManifest, configPath string, store *Store) error {
+	zw := zip.NewWriter(w)
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := addZipEntry(zw, exportManifestName, m.Created, func(w io.Writer) error {
+		_, err := w.Write(append(manifest, '\n'))
+		return err
+	}); err != nil {
+		return err
+	}
+	if err := addZipFile(zw, path.Join(exportConfigDir, m.Config), configPath); err != nil {
+		return err
+	}
+	// The service may be running; under the store lock no file is copied
+	// while it is half written.
+	for _, name := range m.Files {
+		store.mu.Lock()
+		err := addZipFile(zw, name, store.path(filepath.FromSlash(name)))
+		store.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// addZipFile stores the file at src in zw as name.
+func addZipFile(zw *zip.Writer, name, src string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	return addZipEntry(zw, name, info.ModTime(), func(w io.Writer) error {
+		return appendFile(w, src)
+	})
+}
+
+func addZipEntry(zw *zip.Writer, name string, modified time.Time, write func(io.Writer) error) error {
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+	return write(fw)
+}
+
+// ImportState restores an archive written by ExportState, placing the
+// config file at configPath and the store beside it. The config keeps the
+// format it was exported in unless configPath names another one. Existing
+// state is only replaced with force, in which case store files missing
+// from the archive are removed so the result matches the exported machine.
+// The monitor must not be running.
+func ImportState(configPath, archive string, force bool) (*ExportManifest, error) {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	entries := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		entries[f.Name] = f
+	}
+	m, err := readExportManifest(entries)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", archive, err)
+	}
+
+	store := NewStore(filepath.Dir(configPath))
+	existing, err := storeFiles(store)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(configPath); err == nil {
+		existing = append(existing, filepath.Base(configPath))
+	}
+	if len(existing) > 0 && !force {
+		return nil, fmt.Errorf("%s already holds %s; use -force to replace them", store.Dir(), strings.Join(existing, ", "))
+	}
+
+	cfg, err := readExportConfig(entries[path.Join(exportConfigDir, m.Config)], m.Config)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range existing {
+		if name != filepath.Base(configPath) {
+			if err := os.Remove(store.path(filepath.FromSlash(name))); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+	}
+	for _, name := range m.Files {
+		if err := extractZipFile(entries[name], store.path(filepath.FromSlash(name))); err != nil {
+			return nil, err
+		}
+	}
+	if ConfigFormat(configPath) == ConfigFormat(m.Config) {
+		err = extractZipFile(entries[path.Join(exportConfigDir, m.Config)], configPath)
+	} else {
+		err = WriteConfig(configPath, cfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// readExportManifest reads the manifest and checks that the archive holds
+// every file it lists, and only files a store may contain.
+func readExportManifest(entries map[string]*zip.File) (*ExportManifest, error) {
+	f := entries[exportManifestName]
+	if f == nil {
+		return nil, fmt.Errorf("not a monitor export: %s is missing", exportManifestName)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var m ExportManifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("%s: %v", exportManifestName, err)
+	}
+	if !configNameValid(m.Config) || entries[path.Join(exportConfigDir, m.Config)] == nil {
+		return nil, fmt.Errorf("config file %q is missing", m.Config)
+	}
+	for _, name := range m.Files {
+		if !storeFileValid(name) {
+			return nil, fmt.Errorf("%q is not a store file", name)
+		}
+		if entries[name] == nil {
+			return nil, fmt.Errorf("%s is missing", name)
+		}
+	}
+	return &m, nil
+}
+
+// configNameValid reports whether name, from an archive, is a plain file
+// name rather than a path.
+func configNameValid(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\:`)
+}
+
+// storeFileValid reports whether name, from an archive, is a store file
+// that storeFiles would list, so that nothing is written outside the store.
+func storeFileValid(name string) bool {
+	for _, n := range stateFiles {
+		if name == n {
+			return true
+		}
+	}
+	dir, file := path.Split(name)
+	return dir == deviceIndexDir+"/" && strings.HasSuffix(file, ".json") && !nonWord.MatchString(file)
+}
+
+// readExportConfig decodes and validates the exported config before
+// anything is replaced.
+func readExportConfig(f *zip.File, name string) (*Config, error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := DecodeConfig(data, ConfigFormat(name), &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	upgradeConfig(&cfg)
+	if problems := validateVersion(&cfg); len(problems) > 0 {
+		return nil, fmt.Errorf("%s: %v", name, problems)
+	}
+	return &cfg, nil
+}
+
+// extractZipFile writes f to dst, keeping its modification time.
+func extractZipFile(f *zip.File, dst string) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	os.Chtimes(dst, f.Modified, f.Modified)
+	return nil
+}
@@ -0,0 +1,209 @@
+package foldermonitor
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Failover defaults.
+const (
+	defaultFailoverAfter = 5 * time.Minute
+	failoverProbe        = 30 * time.Second
+)
+
+// FailoverOptions give a rule a secondary destination, so that archiving
+// continues while the primary, such as a NAS under maintenance, is down.
+// Once the primary has been unreachable for After, copies go to DestDir;
+// when it is back, they are moved to the primary and removed from
+// DestDir.
+type FailoverOptions struct {
+	// DestDir is the secondary destination folder.
+	DestDir string `json:"dest_dir"`
+	// After is how long the primary must be unreachable before copies go
+	// to the secondary (default 5m).
+	After Duration `json:"after,omitempty"`
+}
+
+// failoverState tracks which rules copy to their secondary destination.
+type failoverState struct {
+	mu sync.Mutex
+	// down holds when the primary of a rule was first found unreachable;
+	// active the rules copying to their secondary.
+	down   map[string]time.Time
+	active map[string]bool
+}
+
+func newFailoverState() *failoverState {
+	return &failoverState{down: make(map[string]time.Time), active: make(map[string]bool)}
+}
+
+// failedOver returns rule with its destination replaced by the secondary
+// while it has failed over.
+func (c *Copier) failedOver(rule Rule) Rule {
+	if rule.Failover == nil {
+		return rule
+	}
+	c.failover.mu.Lock()
+	defer c.failover.mu.Unlock()
+	if c.failover.active[rule.Name] {
+		rule.DestDir = rule.Failover.DestDir
+	}
+	return rule
+}
+
+// watchFailover probes the primary destination of the rules every
+// failoverProbe until ctx is cancelled, failing over when one has been
+// down for long enough and moving copies back once it is up.
+func (c *Copier) watchFailover(ctx context.Context, rules []Rule) {
+	ticker := time.NewTicker(failoverProbe)
+	defer ticker.Stop()
+	for {
+		for _, r := range rules {
+			if ctx.Err() == nil {
+				c.probeFailover(ctx, r)
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// probeFailover checks the primary destination of the rule once.
+func (c *Copier) probeFailover(ctx context.Context, rule Rule) {
+	err := probeDir(ctx, rule.DestDir)
+	after := rule.Failover.After.or(defaultFailoverAfter)
+	s := c.failover
+	s.mu.Lock()
+	since, down := s.down[rule.Name]
+	active := s.active[rule.Name]
+	switch {
+	case err != nil && !down:
+		s.down[rule.Name] = time.Now()
+	case err != nil && !active && time.Since(since) >= after:
+		s.active[rule.Name] = true
+		logEvent(CategoryDestination, LevelWarning, "Rule %s: destination %s has been unreachable for %s (%v), copying to %s until it is back",
+			rule.Name, rule.DestDir, time.Since(since).Round(time.Second), err, rule.Failover.DestDir)
+	case err == nil:
+		delete(s.down, rule.Name)
+		delete(s.active, rule.Name)
+		if active {
+			logEvent(CategoryDestination, LevelInfo, "Rule %s: destination %s is back, moving the copies made to %s meanwhile", rule.Name, rule.DestDir, rule.Failover.DestDir)
+		}
+	}
+	s.mu.Unlock()
+	if err == nil {
+		c.failBack(ctx, rule)
+	}
+}
+
+// failBack moves the files in the rule's secondary destination to the
+// same place below its primary.
+func (c *Copier) failBack(ctx context.Context, rule Rule) {
+	secondary := rule.Failover.DestDir
+	moved, failed := 0, 0
+	var lastErr error
+	var dirs []string
+	filepath.WalkDir(secondary, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != secondary {
+				dirs = append(dirs, path)
+			}
+			return nil
+		}
+		// Copies still being written are moved on a later probe.
+		if !d.Type().IsRegular() || c.dests.claimed(path) {
+			return nil
+		}
+		rel, err := filepath.Rel(secondary, path)
+		if err != nil {
+			return nil
+		}
+		if err := c.moveBack(ctx, rule, path, filepath.Join(rule.DestDir, rel)); err != nil {
+			failed, lastErr = failed+1, err
+		} else {
+			moved++
+		}
+		return nil
+	})
+	// Remove the emptied folders, deepest first.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+	if failed > 0 {
+		logEvent(CategoryDestination, LevelWarning, "Rule %s: moved %d copies back to %s, %d failed, the last with: %v", rule.Name, moved, rule.DestDir, failed, lastErr)
+	} else if moved > 0 {
+		logEvent(CategoryCopy, LevelInfo, "Rule %s: moved %d copies from %s back to %s", rule.Name, moved, secondary, rule.DestDir)
+	}
+}
+
+// moveBack copies the file at path in the secondary destination to dest
+// in the primary, checks that it reads back intact and removes it from the
+// secondary. A file already at dest with the same content is only removed.
+func (c *Copier) moveBack(ctx context.Context, rule Rule, path, dest string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	want, err := hashFile(path)
+	if err != nil {
+		return err
+	}
+	if got, err := hashFile(dest); err == nil {
+		if got != want {
+			return fmt.Errorf("%s already exists with other content, leaving %s", dest, path)
+		}
+	} else {
+		if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+			return err
+		}
+		tmp := dest + ".tmp"
+		err := copyFile(ctx, path, tmp, rule.WriteThrough, c.buffer, nil)
+		if err == nil {
+			err = readBack(ctx, tmp, want)
+		}
+		if err == nil {
+			os.Chtimes(tmp, info.ModTime(), info.ModTime())
+			err = os.Rename(tmp, dest)
+		}
+		if err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if rec, ok := c.store.historyByDest(path); ok {
+		rec.Dest, rec.RenamedFrom = dest, path
+		if err := c.store.recordCopy(rec); err != nil {
+			return err
+		}
+	}
+	if err := c.store.moveClip(path, dest); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// validateFailover checks a rule's failover settings.
+func validateFailover(key func(string) string, f *FailoverOptions) ConfigErrors {
+	var problems ConfigErrors
+	if f.DestDir == "" {
+		problems = append(problems, key("failover")+".dest_dir is required")
+	}
+	if f.After < 0 {
+		problems = append(problems, key("failover")+".after must be a positive duration such as \"5m\"")
+	}
+	return problems
+}
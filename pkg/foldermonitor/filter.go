@@ -0,0 +1,107 @@
+package foldermonitor
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultPartialExtensions name files being downloaded or recorded under
+// a temporary name: browsers, download tools, file transfer clients and
+// cameras that write to a temporary file until a clip ends.
+var defaultPartialExtensions = []string{
+	".crdownload", ".part", ".partial", ".download", ".filepart",
+	".tmp", ".temp", ".inprogress", ".!qb", ".!ut",
+}
+
+// partial reports whether the file at path is still being written under a
+// temporary name.
+func (o RuleOptions) partial(path string) bool {
+	exts := o.PartialExtensions
+	if exts == nil {
+		exts = defaultPartialExtensions
+	}
+	return matchesExtension(path, exts)
+}
+
+// excluded reports whether rel, a path relative to the rule's source folder,
+// lies in one of the rule's excluded subpaths or names a partial file.
+func (o RuleOptions) excluded(rel string) bool {
+	if o.partial(rel) {
+		return true
+	}
+	if len(o.Exclude) == 0 {
+		return false
+	}
+	rel = normalizeRel(rel)
+	for _, pattern := range o.Exclude {
+		pattern = normalizeRel(pattern)
+		if pattern == "" {
+			continue
+		}
+		anchored := strings.Contains(pattern, "/")
+		parts := strings.Split(rel, "/")
+		for i := range parts {
+			candidate := parts[i]
+			if anchored {
+				candidate = strings.Join(parts[:i+1], "/")
+			}
+			if ok, _ := path.Match(pattern, candidate); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// outsideDuration returns why the video at path is skipped for its length,
+// or "" when it is copied.
+func (o RuleOptions) outsideDuration(path string) string {
+	if (o.MinDuration <= 0 && o.MaxDuration <= 0) || !isMedia(path) {
+		return ""
+	}
+	d, err := videoDuration(path)
+	if err != nil {
+		logDebugf("Cannot read the duration of %s, copying it: %v", path, err)
+		return ""
+	}
+	switch {
+	case o.MinDuration > 0 && d < time.Duration(o.MinDuration):
+		return fmt.Sprintf("it is %s long, shorter than %s", d.Round(100*time.Millisecond), time.Duration(o.MinDuration))
+	case o.MaxDuration > 0 && d > time.Duration(o.MaxDuration):
+		return fmt.Sprintf("it is %s long, longer than %s", d.Round(time.Second), time.Duration(o.MaxDuration))
+	}
+	return ""
+}
+
+// scannedFile is a file found by a scan of a folder.
+type scannedFile struct {
+	path string
+	info os.FileInfo
+}
+
+// sortScan puts files in the rule's backlog order. Files modified at the
+// same time keep their order, which is by name.
+func (o RuleOptions) sortScan(files []scannedFile) {
+	switch o.Order {
+	case "oldest":
+		sort.SliceStable(files, func(i, j int) bool { return files[i].info.ModTime().Before(files[j].info.ModTime()) })
+	case "newest":
+		sort.SliceStable(files, func(i, j int) bool { return files[i].info.ModTime().After(files[j].info.ModTime()) })
+	}
+}
+
+// normalizeRel converts a relative path or exclude pattern to slash form
+// without leading or trailing slashes, folding case on Windows.
+func normalizeRel(p string) string {
+	p = strings.Trim(filepath.ToSlash(p), "/")
+	if runtime.GOOS == "windows" {
+		p = strings.ToLower(p)
+	}
+	return p
+}
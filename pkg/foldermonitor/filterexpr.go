@@ -0,0 +1,169 @@
+package foldermonitor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// A rule's filter is an expression in the expr language
+// (https://expr-lang.org) deciding whether a file is copied, such as
+//
+//	size > 10MB && ext in ["mp4", "mov"] && hour(now) < 22
+//
+// It sees these variables and functions:
+//
+//	name        the file name, e.g. "clip01.MP4"
+//	ext         the lower-case extension without the dot, e.g. "mp4"
+//	path        the full path of the file
+//	rel         the path relative to the source folder, with slashes
+//	size        the size in bytes; numbers may end in KB, MB, GB or TB
+//	modified    when the file was last written
+//	now         the current time
+//	rule        the rule's name
+//	hour(t), minute(t) and weekday(t), e.g. "Monday", in local time
+//	age(t)      the seconds since t, e.g. age(modified) > 60
+//	content()   the sniffed kind of the file: video, image, audio or data
+//	duration()  the length of an MP4 or MOV video in seconds, 0 if unknown
+
+// sizeUnits are the size suffixes a filter's numbers may carry.
+var sizeUnits = map[string]int64{"KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30, "TB": 1 << 40}
+
+// filterPrograms caches compiled filters by source.
+var filterPrograms sync.Map
+
+// filterEnv returns the variables and functions a filter sees for the file
+// at path; a zero rule and info give the types checked when compiling.
+func filterEnv(rule Rule, path string, info os.FileInfo) map[string]any {
+	env := map[string]any{
+		"name": "", "ext": "", "path": path, "rel": "", "size": int64(0),
+		"modified": time.Time{}, "now": time.Now(), "rule": rule.Name,
+		"hour":    func(t time.Time) int { return t.Local().Hour() },
+		"minute":  func(t time.Time) int { return t.Local().Minute() },
+		"weekday": func(t time.Time) string { return t.Local().Weekday().String() },
+		"age":     func(t time.Time) float64 { return time.Since(t).Seconds() },
+		"content": func() string {
+			kind, err := sniffContent(path)
+			if err != nil {
+				return ContentData
+			}
+			return kind
+		},
+		"duration": func() float64 {
+			d, err := videoDuration(path)
+			if err != nil {
+				return 0
+			}
+			return d.Seconds()
+		},
+	}
+	if path == "" {
+		return env
+	}
+	name := filepath.Base(path)
+	env["name"] = name
+	env["ext"] = strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+	if rel, ok := relInside(rule.SourceDir, path); ok {
+		env["rel"] = filepath.ToSlash(rel)
+	}
+	if info != nil {
+		env["size"], env["modified"] = info.Size(), info.ModTime()
+	}
+	return env
+}
+
+// compileFilter compiles a filter, checking that it gives true or false.
+func compileFilter(src string) (*vm.Program, error) {
+	if p, ok := filterPrograms.Load(src); ok {
+		return p.(*vm.Program), nil
+	}
+	p, err := expr.Compile(expandSizeUnits(src), expr.Env(filterEnv(Rule{}, "", nil)), expr.AsBool())
+	if err != nil {
+		return nil, err
+	}
+	filterPrograms.Store(src, p)
+	return p, nil
+}
+
+// expandSizeUnits rewrites numbers with a size suffix, such as 10MB, to
+// the number of bytes, leaving string literals alone.
+func expandSizeUnits(src string) string {
+	var b strings.Builder
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '"' || c == '\'' || c == '`':
+			j := i + 1
+			for j < len(src) && src[j] != c {
+				if src[j] == '\\' && c != '`' {
+					j++
+				}
+				j++
+			}
+			j = min(j+1, len(src))
+			b.WriteString(src[i:j])
+			i = j
+			continue
+		case c >= '0' && c <= '9' && (i == 0 || !isIdentByte(src[i-1])):
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.' || src[j] == '_') {
+				j++
+			}
+			if j+2 <= len(src) && (j+2 == len(src) || !isIdentByte(src[j+2])) {
+				if unit, ok := sizeUnits[src[j:j+2]]; ok {
+					if n, err := strconv.ParseFloat(strings.ReplaceAll(src[i:j], "_", ""), 64); err == nil {
+						b.WriteString(strconv.FormatInt(int64(n*float64(unit)), 10))
+						i = j + 2
+						continue
+					}
+				}
+			}
+			b.WriteString(src[i:j])
+			i = j
+			continue
+		}
+		b.WriteByte(c)
+		i++
+	}
+	return b.String()
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// outsideFilter returns why the file at path is skipped by the rule's
+// filter, or "" when it is copied. A filter that fails on a file, such as
+// one dividing by zero, copies it.
+func (rule Rule) outsideFilter(path string, info os.FileInfo) string {
+	if rule.Filter == "" {
+		return ""
+	}
+	p, err := compileFilter(rule.Filter)
+	if err == nil {
+		var out any
+		if out, err = expr.Run(p, filterEnv(rule, path, info)); err == nil {
+			if out.(bool) {
+				return ""
+			}
+			return "the filter is false for it"
+		}
+	}
+	logEvent(CategoryCopy, LevelWarning, "Rule %s: filter failed on %s, copying it: %v", rule.Name, path, err)
+	return ""
+}
+
+// validateFilter checks that a rule's filter compiles.
+func validateFilter(key func(string) string, src string) ConfigErrors {
+	if _, err := compileFilter(src); err != nil {
+		return ConfigErrors{fmt.Sprintf("%s %q: %v", key("filter"), src, strings.SplitN(err.Error(), "\n", 2)[0])}
+	}
+	return nil
+}
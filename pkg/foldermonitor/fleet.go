@@ -0,0 +1,380 @@
+package foldermonitor
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fleet defaults.
+const (
+	defaultFleetInterval   = 30 * time.Second
+	defaultFleetStaleAfter = 2 * time.Minute
+	fleetCheckInterval     = 15 * time.Second
+	fleetFirstReport       = 5 * time.Second
+	fleetTimeout           = 20 * time.Second
+	maxFleetReportSize     = 1 << 20
+	fleetReportPath        = "/api/fleet/report"
+)
+
+// FleetConfig joins monitors into a fleet: members report their state to
+// one monitor acting as collector, whose web UI shows every bay of the
+// site and which alerts when one stops reporting or reports problems.
+type FleetConfig struct {
+	// Collector is the web UI of the collecting monitor reports are sent
+	// to, e.g. "https://office-pc:8080". Token is sent as a bearer token
+	// and must match the collector's report_token.
+	Collector string `json:"collector,omitempty"`
+	Token     string `json:"token,omitempty"`
+	// Name identifies this monitor in the fleet (default the host name).
+	Name string `json:"name,omitempty"`
+	// Interval is how often a report is sent (default 30s).
+	Interval Duration `json:"interval,omitempty"`
+	// CAFile verifies the collector against this PEM bundle instead of the
+	// system roots; CertFile and KeyFile authenticate with a client
+	// certificate when the collector's UI requires one.
+	CAFile   string `json:"ca_file,omitempty"`
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+
+	// Collect makes this monitor the collector. Reports are accepted on
+	// its web UI with ReportToken, and it reports its own rules too.
+	Collect     bool   `json:"collect,omitempty"`
+	ReportToken string `json:"report_token,omitempty"`
+	// StaleAfter is how long a member may go without reporting before it
+	// is considered down (default 2m).
+	StaleAfter Duration `json:"stale_after,omitempty"`
+	// Webhook receives a JSON POST whenever a member becomes unhealthy or
+	// recovers.
+	Webhook string `json:"webhook,omitempty"`
+}
+
+// name returns the member name of this monitor.
+func (c *FleetConfig) name() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	host, _ := os.Hostname()
+	return host
+}
+
+// tlsConfig returns the TLS settings for the connection to the collector.
+func (c *FleetConfig) tlsConfig() (*tls.Config, error) {
+	tc := &tls.Config{}
+	if c.CAFile != "" {
+		pool, err := loadCertPool(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tc.RootCAs = pool
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
+
+// FleetReport is what a member sends to the collector.
+type FleetReport struct {
+	Name   string        `json:"name"`
+	Time   time.Time     `json:"time"`
+	Status StatusFile    `json:"status"`
+	Health HealthReport  `json:"health"`
+	Errors []ErrorRecord `json:"errors,omitempty"`
+}
+
+// FleetMember is the last report of a member as seen by the collector.
+type FleetMember struct {
+	FleetReport
+	Received time.Time `json:"received"`
+	Addr     string    `json:"addr"`
+	// Stale is set when the member stopped reporting; Problem is what was
+	// last alerted about it, empty while it is healthy.
+	Stale   bool   `json:"stale"`
+	Problem string `json:"problem,omitempty"`
+}
+
+// FleetAlert is the webhook payload sent when a member changes state.
+type FleetAlert struct {
+	Member  string    `json:"member"`
+	OK      bool      `json:"ok"`
+	Problem string    `json:"problem,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// fleetMembers is the collector's view of the fleet, kept across reloads.
+type fleetMembers struct {
+	mu      sync.Mutex
+	members map[string]*FleetMember
+}
+
+// record keeps the report of a member that sent it from addr.
+func (f *fleetMembers) record(r FleetReport, addr string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.members == nil {
+		f.members = make(map[string]*FleetMember)
+	}
+	m := f.members[r.Name]
+	if m == nil {
+		m = &FleetMember{}
+		f.members[r.Name] = m
+	}
+	m.FleetReport = r
+	m.Received = time.Now()
+	m.Addr = addr
+}
+
+// list returns the members sorted by name, marking stale ones.
+func (f *fleetMembers) list(staleAfter time.Duration) []FleetMember {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	list := make([]FleetMember, 0, len(f.members))
+	for _, m := range f.members {
+		m.Stale = time.Since(m.Received) > staleAfter
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// check alerts about members whose state changed since the last check.
+func (f *fleetMembers) check(cfg *FleetConfig) {
+	staleAfter := cfg.StaleAfter.or(defaultFleetStaleAfter)
+	var alerts []FleetAlert
+	f.mu.Lock()
+	for name, m := range f.members {
+		problem := strings.Join(m.Health.Problems, "; ")
+		if time.Since(m.Received) > staleAfter {
+			problem = fmt.Sprintf("no report since %s", m.Received.Format(time.DateTime))
+		}
+		if problem == m.Problem {
+			continue
+		}
+		if problem == "" {
+			logEvent(CategoryHealth, LevelInfo, "Fleet: %s is healthy again", name)
+		} else {
+			logEvent(CategoryHealth, LevelWarning, "Fleet: %s: %s", name, problem)
+		}
+		m.Problem = problem
+		alerts = append(alerts, FleetAlert{Member: name, OK: problem == "", Problem: problem, Time: time.Now()})
+	}
+	f.mu.Unlock()
+	if cfg.Webhook == "" {
+		return
+	}
+	for _, a := range alerts {
+		go func() {
+			if err := postWebhook(cfg.Webhook, a); err != nil {
+				logEvent(CategoryHealth, LevelError, "Fleet webhook: %v", err)
+			}
+		}()
+	}
+}
+
+// watch checks the members until ctx is cancelled.
+func (f *fleetMembers) watch(ctx context.Context, cfg *FleetConfig) {
+	ticker := time.NewTicker(fleetCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.check(cfg)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// fleetReporter sends the state of a monitor to the collector on an
+// interval, and records it directly when the monitor is the collector.
+type fleetReporter struct {
+	m       *Monitor
+	cfg     *Config
+	client  *http.Client
+	failing bool
+}
+
+// newFleetReporter returns the reporter of m for cfg.
+func newFleetReporter(m *Monitor, cfg *Config) (*fleetReporter, error) {
+	r := &fleetReporter{m: m, cfg: cfg}
+	if cfg.Fleet.Collector != "" {
+		tc, err := cfg.Fleet.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tc
+		r.client = &http.Client{Timeout: fleetTimeout, Transport: transport}
+	}
+	return r, nil
+}
+
+// run reports on every interval until ctx is cancelled, the first time
+// once the watcher has started. Failures are logged when reporting starts
+// and stops failing, not on every interval.
+func (r *fleetReporter) run(ctx context.Context) {
+	fc := r.cfg.Fleet
+	ticker := time.NewTicker(fc.Interval.or(defaultFleetInterval))
+	defer ticker.Stop()
+	first := time.After(fleetFirstReport)
+	for {
+		select {
+		case <-first:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		report := r.report(ctx)
+		// A collector without rules of its own is not a bay.
+		if fc.Collect && len(r.cfg.ListRules()) > 0 {
+			r.m.fleet.record(report, "local")
+		}
+		if r.client != nil {
+			err := r.send(ctx, report)
+			switch {
+			case err != nil && !r.failing && ctx.Err() == nil:
+				logEvent(CategoryHealth, LevelWarning, "Fleet: reporting to %s failed: %v", fc.Collector, err)
+			case err == nil && r.failing:
+				logEvent(CategoryHealth, LevelInfo, "Fleet: reporting to %s again", fc.Collector)
+			}
+			r.failing = err != nil
+		}
+	}
+}
+
+// report builds the current report of the monitor.
+func (r *fleetReporter) report(ctx context.Context) FleetReport {
+	snap := r.m.status.snapshot()
+	return FleetReport{
+		Name:   r.cfg.Fleet.name(),
+		Time:   time.Now(),
+		Status: statusDocument(r.m.status, &r.m.metrics, r.m.store),
+		Health: r.m.health(ctx, r.cfg, 0),
+		Errors: snap.Errors,
+	}
+}
+
+// send posts report to the collector.
+func (r *fleetReporter) send(ctx context.Context, report FleetReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	target := strings.TrimRight(r.cfg.Fleet.Collector, "/") + fleetReportPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.cfg.Fleet.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.cfg.Fleet.Token)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// collecting returns the fleet settings when the monitor of u collects
+// reports, or nil.
+func (u *uiServer) collecting() *FleetConfig {
+	if u.prg == nil {
+		return nil
+	}
+	if fc := u.prg.Config().Fleet; fc != nil && fc.Collect {
+		return fc
+	}
+	return nil
+}
+
+// handleFleetReport accepts the report of a member. It is checked against
+// the report token rather than the UI tokens, so members need no UI access.
+func (u *uiServer) handleFleetReport(w http.ResponseWriter, r *http.Request) {
+	fc := u.collecting()
+	if fc == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "this monitor does not collect fleet reports"})
+		return
+	}
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(got), []byte(fc.ReportToken)) != 1 {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "a valid report token is required"})
+		return
+	}
+	var report FleetReport
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxFleetReportSize)).Decode(&report); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if report.Name == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "the report has no name"})
+		return
+	}
+	u.prg.fleet.record(report, r.RemoteAddr)
+	writeJSON(w, http.StatusOK, map[string]string{"received": report.Name})
+}
+
+// handleFleet lists the members of the fleet.
+func (u *uiServer) handleFleet(w http.ResponseWriter, r *http.Request) {
+	fc := u.collecting()
+	if fc == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "this monitor does not collect fleet reports"})
+		return
+	}
+	writeJSON(w, http.StatusOK, u.prg.fleet.list(fc.StaleAfter.or(defaultFleetStaleAfter)))
+}
+
+// validateFleet checks the fleet settings of cfg.
+func validateFleet(cfg *Config) ConfigErrors {
+	var problems ConfigErrors
+	c := cfg.Fleet
+	if c.Collector == "" && !c.Collect {
+		problems = append(problems, "fleet needs a collector to report to, or collect enabled")
+	}
+	if c.Collector != "" && !strings.HasPrefix(c.Collector, "http://") && !strings.HasPrefix(c.Collector, "https://") {
+		problems = append(problems, fmt.Sprintf("fleet.collector %q must be an http:// or https:// URL", c.Collector))
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		problems = append(problems, "fleet.cert_file and fleet.key_file must be set together")
+	}
+	if c.Collector != "" {
+		if _, err := c.tlsConfig(); err != nil {
+			problems = append(problems, fmt.Sprintf("fleet: %v", err))
+		}
+	}
+	if c.Collect {
+		if cfg.UIAddr == "" {
+			problems = append(problems, "fleet.collect needs ui_addr, where members report")
+		}
+		if len(c.ReportToken) < minUITokenSize {
+			problems = append(problems, fmt.Sprintf("fleet.report_token must be at least %d characters", minUITokenSize))
+		}
+	}
+	if c.Webhook != "" && !strings.HasPrefix(c.Webhook, "http://") && !strings.HasPrefix(c.Webhook, "https://") {
+		problems = append(problems, "fleet.webhook must be an http:// or https:// URL")
+	}
+	if c.Interval < 0 || c.StaleAfter < 0 {
+		problems = append(problems, "fleet.interval and fleet.stale_after must be positive durations")
+	}
+	return problems
+}
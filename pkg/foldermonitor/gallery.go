@@ -0,0 +1,444 @@
+package foldermonitor
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Gallery defaults.
+const (
+	galleryCookie       = "gallery_token"
+	thumbnailDirName    = "thumbnails"
+	thumbnailWidth      = 320
+	galleryThumbJobs    = 2
+	defaultGalleryTitle = "Swing Gallery"
+)
+
+// galleryImages are the still images the gallery shows besides videos.
+var galleryImages = map[string]bool{".jpg": true, ".jpeg": true, ".png": true}
+
+// GalleryConfig runs a read-only web gallery of the archived videos, so
+// that students can browse and replay their swings on a TV or tablet in
+// a plain browser. Videos stream with range requests, so they can be
+// scrubbed without downloading them first.
+type GalleryConfig struct {
+	// Addr is the host:port to listen on, e.g. ":8090".
+	Addr string `json:"addr"`
+	// CertFile and KeyFile enable HTTPS.
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	// Token, when set, must be sent as "Authorization: Bearer <token>" or
+	// given once as "?token=<token>", after which a cookie keeps the
+	// browser signed in.
+	Token string `json:"token,omitempty"`
+	// Rules limits the gallery to the destinations of these rules; all by
+	// default.
+	Rules []string `json:"rules,omitempty"`
+	// Title heads the gallery page (default "Swing Gallery").
+	Title string `json:"title,omitempty"`
+}
+
+// galleryServer serves the destinations of the configured rules.
+type galleryServer struct {
+	cfg *GalleryConfig
+	// names lists the rules in config order; dirs holds their
+	// destinations.
+	names  []string
+	dirs   map[string]string
+	ffmpeg string
+	thumbs string
+	jobs   chan struct{}
+}
+
+// galleryEntry is a folder or file of a gallery listing.
+type galleryEntry struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Size     int64     `json:"size,omitempty"`
+	Modified time.Time `json:"modified"`
+	Video    bool      `json:"video,omitempty"`
+}
+
+// galleryListing is the content of one gallery folder.
+type galleryListing struct {
+	Title   string         `json:"title"`
+	Path    string         `json:"path"`
+	Folders []galleryEntry `json:"folders"`
+	Files   []galleryEntry `json:"files"`
+}
+
+// newGalleryServer returns the gallery of cfg, caching thumbnails in
+// store.
+func newGalleryServer(cfg *Config, store *Store) *galleryServer {
+	g := &galleryServer{
+		cfg:    cfg.Gallery,
+		dirs:   make(map[string]string),
+		ffmpeg: cfg.ffmpegPath(),
+		thumbs: store.path(thumbnailDirName),
+		jobs:   make(chan struct{}, galleryThumbJobs),
+	}
+	add := func(name, dir string) {
+		if dir == "" || g.dirs[name] != "" || !g.shows(name) {
+			return
+		}
+		g.names = append(g.names, name)
+		g.dirs[name] = dir
+	}
+	for _, r := range cfg.ActiveRules() {
+		add(r.Name, r.DestDir)
+	}
+	for _, v := range cfg.Volumes {
+		add(v.Name, v.DestDir)
+	}
+	for _, d := range cfg.Devices {
+		add(d.Name, d.DestDir)
+	}
+	if u := cfg.Upload; u != nil {
+		add(u.Name, u.DestDir)
+	}
+	return g
+}
+
+// shows reports whether the gallery includes the rule called name.
+func (g *galleryServer) shows(name string) bool {
+	if len(g.cfg.Rules) == 0 {
+		return true
+	}
+	for _, r := range g.cfg.Rules {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+// handler returns the HTTP handler of the gallery.
+func (g *galleryServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", g.handlePage)
+	mux.HandleFunc("GET /api/gallery", g.handleList)
+	mux.HandleFunc("GET /media/{path...}", g.handleMedia)
+	mux.HandleFunc("GET /thumb/{path...}", g.handleThumb)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !g.authorized(w, r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// ServeGallery serves the gallery of cfg until ctx is cancelled, caching
+// thumbnails in store.
+func ServeGallery(ctx context.Context, cfg *Config, store *Store) error {
+	if cfg.Gallery == nil {
+		return errors.New("no gallery is configured")
+	}
+	stop, err := startGallery(ctx, cfg, store)
+	if err != nil {
+		return err
+	}
+	<-ctx.Done()
+	stop()
+	return nil
+}
+
+// startGallery serves the gallery until the returned function is called.
+// Requests are cancelled along with ctx.
+func startGallery(ctx context.Context, cfg *Config, store *Store) (func(), error) {
+	gc := cfg.Gallery
+	ln, err := net.Listen("tcp", gc.Addr)
+	if err != nil {
+		return nil, err
+	}
+	g := newGalleryServer(cfg, store)
+	srv := &http.Server{
+		Handler:           g.handler(),
+		ReadHeaderTimeout: 30 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		var err error
+		if gc.CertFile != "" {
+			err = srv.ServeTLS(ln, gc.CertFile, gc.KeyFile)
+		} else {
+			err = srv.Serve(ln)
+		}
+		if err != nil && err != http.ErrServerClosed && svcLogger != nil {
+			svcLogger.Errorf("Gallery stopped: %v", err)
+		}
+	}()
+	scheme := "http"
+	if gc.CertFile != "" {
+		scheme = "https"
+	}
+	if svcLogger != nil {
+		svcLogger.Infof("Gallery of %s at %s://%s/", strings.Join(g.names, ", "), scheme, ln.Addr())
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}, nil
+}
+
+// authorized checks the token of a request, if the gallery has one. A
+// valid token in the query sets the cookie used by the page's images and
+// videos.
+func (g *galleryServer) authorized(w http.ResponseWriter, r *http.Request) bool {
+	want := []byte(g.cfg.Token)
+	if len(want) == 0 {
+		return true
+	}
+	if q := r.URL.Query().Get("token"); q != "" && subtle.ConstantTimeCompare([]byte(q), want) == 1 {
+		http.SetCookie(w, &http.Cookie{Name: galleryCookie, Value: q, Path: "/", HttpOnly: true, SameSite: http.SameSiteStrictMode, Secure: r.TLS != nil})
+		return true
+	}
+	if c, err := r.Cookie(galleryCookie); err == nil && subtle.ConstantTimeCompare([]byte(c.Value), want) == 1 {
+		return true
+	}
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(got), want) == 1
+}
+
+func (g *galleryServer) handlePage(w http.ResponseWriter, r *http.Request) {
+	page, err := webFiles.ReadFile("web/gallery.html")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page)
+}
+
+// handleList lists the folder named by the "path" query parameter, or the
+// rules when it is empty. Folders and files come newest first; hidden
+// files, sidecars and the rollup and staging folders are left out.
+func (g *galleryServer) handleList(w http.ResponseWriter, r *http.Request) {
+	title := g.cfg.Title
+	if title == "" {
+		title = defaultGalleryTitle
+	}
+	p := strings.Trim(r.URL.Query().Get("path"), "/")
+	list := galleryListing{Title: title, Path: p, Folders: []galleryEntry{}, Files: []galleryEntry{}}
+	if p == "" {
+		for _, name := range g.names {
+			e := galleryEntry{Name: name, Path: name}
+			if info, err := os.Stat(g.dirs[name]); err == nil {
+				e.Modified = info.ModTime()
+			}
+			list.Folders = append(list.Folders, e)
+		}
+		writeJSON(w, http.StatusOK, list)
+		return
+	}
+	root, rel, err := g.open(p)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	defer root.Close()
+	f, err := root.Open(rel)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no such folder"})
+		return
+	}
+	entries, err := f.ReadDir(-1)
+	f.Close()
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no such folder"})
+		return
+	}
+	for _, d := range entries {
+		name := d.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			continue
+		}
+		e := galleryEntry{Name: name, Path: p + "/" + name, Modified: info.ModTime()}
+		ext := strings.ToLower(filepath.Ext(name))
+		switch {
+		case d.IsDir():
+			if name != rollupDirName && name != stagingDirName {
+				list.Folders = append(list.Folders, e)
+			}
+		case info.Mode().IsRegular() && (mediaExtensions[ext] || galleryImages[ext]):
+			e.Size, e.Video = info.Size(), mediaExtensions[ext]
+			list.Files = append(list.Files, e)
+		}
+	}
+	for _, entries := range [][]galleryEntry{list.Folders, list.Files} {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Modified.After(entries[j].Modified) })
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleMedia streams a video or image, answering range requests so that
+// players can seek.
+func (g *galleryServer) handleMedia(w http.ResponseWriter, r *http.Request) {
+	root, rel, err := g.open(r.PathValue("path"))
+	if err != nil || !g.viewable(rel) {
+		http.NotFound(w, r)
+		return
+	}
+	defer root.Close()
+	f, err := root.Open(rel)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// handleThumb serves a JPEG frame of a video, extracting it with ffmpeg
+// the first time and caching it in the store.
+func (g *galleryServer) handleThumb(w http.ResponseWriter, r *http.Request) {
+	p := r.PathValue("path")
+	root, rel, err := g.open(p)
+	if err != nil || !isMedia(rel) {
+		http.NotFound(w, r)
+		return
+	}
+	info, err := root.Stat(rel)
+	dir := root.Name()
+	root.Close()
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%d", p, info.Size(), info.ModTime().UnixNano())))
+	thumb := filepath.Join(g.thumbs, hex.EncodeToString(sum[:16])+".jpg")
+	if _, err := os.Stat(thumb); err != nil {
+		if err := g.thumbnail(r.Context(), filepath.Join(dir, filepath.FromSlash(rel)), thumb); err != nil {
+			logDebugf("Gallery: no thumbnail of %s: %v", p, err)
+			http.Error(w, "no thumbnail", http.StatusNotFound)
+			return
+		}
+	}
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeFile(w, r, thumb)
+}
+
+// thumbnail writes a frame of the video at src, a second in or else the
+// first, to dst, running at most galleryThumbJobs ffmpegs at once.
+func (g *galleryServer) thumbnail(ctx context.Context, src, dst string) error {
+	select {
+	case g.jobs <- struct{}{}:
+		defer func() { <-g.jobs }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	// Another request may have made it while this one waited.
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".thumb-*.jpg")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	for _, at := range []string{"1", "0"} {
+		args := []string{"-hide_banner", "-loglevel", "error", "-nostdin", "-y",
+			"-ss", at, "-i", src, "-frames:v", "1",
+			"-vf", "scale=" + strconv.Itoa(thumbnailWidth) + ":-2", "-q:v", "5", "-f", "image2", tmp.Name()}
+		cmd := exec.CommandContext(ctx, g.ffmpeg, args...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		err = cmd.Run()
+		if err == nil {
+			// Seeking past the end of a short clip writes nothing.
+			if info, serr := os.Stat(tmp.Name()); serr == nil && info.Size() > 0 {
+				return os.Rename(tmp.Name(), dst)
+			}
+			err = errors.New("no frame extracted")
+		} else {
+			err = fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+// open opens the destination of the rule named by the first element of
+// the slash-separated path p, returning the rest as a path within it.
+// The root keeps requests from leaving the destination, even through
+// symlinks; hidden files and folders are not served.
+func (g *galleryServer) open(p string) (*os.Root, string, error) {
+	name, rel, _ := strings.Cut(strings.Trim(p, "/"), "/")
+	dir, ok := g.dirs[name]
+	if !ok {
+		return nil, "", fmt.Errorf("no rule %q in the gallery", name)
+	}
+	if rel == "" {
+		rel = "."
+	}
+	if !fs.ValidPath(rel) {
+		return nil, "", fmt.Errorf("invalid path %q", p)
+	}
+	for _, elem := range strings.Split(rel, "/") {
+		if strings.HasPrefix(elem, ".") && elem != "." {
+			return nil, "", fmt.Errorf("invalid path %q", p)
+		}
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	return root, rel, nil
+}
+
+// viewable reports whether the gallery serves the file at rel.
+func (g *galleryServer) viewable(rel string) bool {
+	ext := strings.ToLower(path.Ext(rel))
+	return mediaExtensions[ext] || galleryImages[ext]
+}
+
+// validateGallery checks the gallery settings; names holds the rules of
+// the config.
+func validateGallery(gc *GalleryConfig, names map[string]bool) ConfigErrors {
+	var problems ConfigErrors
+	if _, _, err := net.SplitHostPort(gc.Addr); err != nil {
+		problems = append(problems, fmt.Sprintf("gallery.addr %q must be host:port or :port: %v", gc.Addr, err))
+	}
+	if (gc.CertFile == "") != (gc.KeyFile == "") {
+		problems = append(problems, "gallery.cert_file and gallery.key_file must be set together")
+	}
+	for _, name := range gc.Rules {
+		if !names[name] {
+			problems = append(problems, fmt.Sprintf("gallery.rules: no rule is named %q", name))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,260 @@
+package foldermonitor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultGroupTimeout is how long an incomplete file group is waited for.
+const defaultGroupTimeout = 10 * time.Minute
+
+// groupStagingPrefix starts the hidden folder in the destination that the
+// members of a group are copied to before they are moved into place.
+const groupStagingPrefix = ".group-"
+
+// fileGroup is a set of files with the same base name and extensions from
+// the rule's group, waiting for all of them to settle.
+type fileGroup struct {
+	rule    Rule
+	settled map[string]bool // members that passed their quiet period
+	started time.Time
+	ctx     context.Context
+}
+
+// groupTracker collects the members of file groups on their way from the
+// filter stage to the copier.
+type groupTracker struct {
+	copier *Copier
+	quiet  time.Duration
+	mu     sync.Mutex
+	groups map[string]*fileGroup // keyed by folder and base name
+}
+
+func newGroupTracker(c *Copier, quiet time.Duration) *groupTracker {
+	return &groupTracker{copier: c, quiet: quiet, groups: make(map[string]*fileGroup)}
+}
+
+// groupKey returns the folder and base name that identify the group of
+// path, or "" if the rule does not group files with its extension.
+func groupKey(rule Rule, path string) string {
+	ext := filepath.Ext(path)
+	for _, g := range rule.Group {
+		if strings.EqualFold(g, ext) {
+			return strings.TrimSuffix(path, ext)
+		}
+	}
+	return ""
+}
+
+// accept takes a settled file of a group and copies the group once it is
+// complete. It reports false for files that are not part of a group.
+func (g *groupTracker) accept(e Event) bool {
+	key := groupKey(e.Rule, e.Source)
+	if key == "" {
+		return false
+	}
+	g.mu.Lock()
+	fg, ok := g.groups[key]
+	if !ok {
+		fg = &fileGroup{rule: e.Rule, settled: make(map[string]bool), started: time.Now(), ctx: e.Context()}
+		g.groups[key] = fg
+		timeout := e.Rule.GroupTimeout.or(defaultGroupTimeout)
+		time.AfterFunc(timeout, func() { g.expire(key, fg, e) })
+	}
+	fg.settled[e.Source] = true
+	members, complete := g.members(key, fg)
+	if complete {
+		delete(g.groups, key)
+	}
+	g.mu.Unlock()
+	if complete {
+		g.copier.requeue(e, g.copier.copyGroup(e.Context(), e.Rule, members))
+	} else {
+		logDebugf("Rule %s: %s waits for the rest of its group", e.Rule.Name, e.Source)
+	}
+	return true
+}
+
+// expire copies what exists of a group once its timeout has passed
+// without it becoming complete.
+func (g *groupTracker) expire(key string, fg *fileGroup, e Event) {
+	g.mu.Lock()
+	if g.groups[key] != fg {
+		g.mu.Unlock()
+		return
+	}
+	delete(g.groups, key)
+	members, _ := g.members(key, fg)
+	g.mu.Unlock()
+	if fg.ctx.Err() != nil || len(members) == 0 {
+		return
+	}
+	logEvent(CategoryCopy, LevelWarning, "Rule %s: group %s is incomplete after %s, copying %d of %d files",
+		fg.rule.Name, filepath.Base(key), time.Since(fg.started).Round(time.Second), len(members), len(fg.rule.Group))
+	g.copier.requeue(e, g.copier.copyGroup(fg.ctx, fg.rule, members))
+}
+
+// members lists the existing files of the group and reports whether every
+// extension is present and no member is still being written. Files that
+// have not been seen settling count as stable once unmodified for the
+// quiet period, as after a retry. g.mu must be held.
+func (g *groupTracker) members(key string, fg *fileGroup) ([]string, bool) {
+	dir, base := filepath.Dir(key), filepath.Base(key)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, false
+	}
+	found := make(map[string]string)
+	for _, de := range entries {
+		name := de.Name()
+		ext := filepath.Ext(name)
+		if de.IsDir() || strings.TrimSuffix(name, ext) != base {
+			continue
+		}
+		for _, want := range fg.rule.Group {
+			if strings.EqualFold(want, ext) {
+				found[strings.ToLower(want)] = filepath.Join(dir, name)
+			}
+		}
+	}
+	complete := len(found) == len(fg.rule.Group)
+	var members []string
+	for _, want := range fg.rule.Group {
+		path, ok := found[strings.ToLower(want)]
+		if !ok {
+			continue
+		}
+		members = append(members, path)
+		if fg.settled[path] {
+			continue
+		}
+		if info, err := os.Stat(path); err != nil || time.Since(info.ModTime()) < g.quiet {
+			complete = false
+		}
+	}
+	return members, complete
+}
+
+// copyGroup copies the files of a group to a hidden folder in the
+// destination and moves them into place only once all of them have been
+// copied, so the destination never holds part of a group. Each file passes
+// the same checks as a file copied on its own, and those it leaves out are
+// not copied. If one fails, none is kept and the error is returned.
+func (c *Copier) copyGroup(ctx context.Context, rule Rule, paths []string) error {
+	// A group is filed with the route and student of its first file.
+	var studentRel string
+	var lesson map[string]string
+	rule = c.failedOver(rule)
+	if len(paths) > 0 {
+		rule = rule.routed(paths[0])
+		if info, err := os.Stat(paths[0]); err == nil {
+			studentRel, lesson = studentDir(ctx, rule, paths[0], info)
+		}
+	}
+	destDir, err := c.destDir(rule, studentRel)
+	if err != nil {
+		return err
+	}
+	stage, err := os.MkdirTemp(destDir, groupStagingPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stage)
+
+	var events []Event
+	var infos []os.FileInfo
+	var failed error
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			failed = err
+			break
+		}
+		// The admitted file holds its final path until the group is moved
+		// into place.
+		a, err := c.admit(ctx, rule, path, info, destDir)
+		if err != nil {
+			failed = err
+			break
+		}
+		if a == nil {
+			continue
+		}
+		defer a.release()
+		e := c.copyTo(ctx, rule, path, info, filepath.Join(stage, filepath.Base(a.dest)))
+		e.visual, e.lesson = a.visual, lesson
+		events = append(events, e)
+		infos = append(infos, info)
+		if e.Err != nil {
+			failed = e.Err
+			break
+		}
+	}
+	if failed == nil {
+		failed = moveGroup(events, destDir)
+	}
+	if failed != nil {
+		// Report every member, including those copied already, as failed
+		// so that the group is retried as a whole.
+		for i := range events {
+			if events[i].Err == nil {
+				events[i].Kind, events[i].SHA256 = EventFailed, ""
+				events[i].Err = fmt.Errorf("%s not copied with the rest of its group: %w", filepath.Base(events[i].Source), failed)
+				events[i].Class = ClassifyError(failed)
+			}
+			events[i].Dest = filepath.Join(destDir, filepath.Base(events[i].Dest))
+			c.bus.Publish(events[i])
+		}
+		return failed
+	}
+	for i, e := range events {
+		if err := c.finish(ctx, rule, e, infos[i]); err != nil && failed == nil {
+			failed = err
+		}
+	}
+	return failed
+}
+
+// moveGroup moves the staged copies of events into destDir, updating their
+// destinations. If a move fails, those already moved are put back.
+func moveGroup(events []Event, destDir string) error {
+	for i := range events {
+		dest := filepath.Join(destDir, filepath.Base(events[i].Dest))
+		if err := os.Rename(events[i].Dest, dest); err != nil {
+			for j := 0; j < i; j++ {
+				os.Rename(events[j].Dest, filepath.Join(filepath.Dir(events[i].Dest), filepath.Base(events[j].Dest)))
+				events[j].Dest = filepath.Join(filepath.Dir(events[i].Dest), filepath.Base(events[j].Dest))
+			}
+			return err
+		}
+		events[i].Dest = dest
+	}
+	return nil
+}
+
+// validateGroup checks a rule's file group settings.
+func validateGroup(key func(string) string, o RuleOptions) ConfigErrors {
+	var problems ConfigErrors
+	seen := make(map[string]bool)
+	for _, ext := range o.Group {
+		if !strings.HasPrefix(ext, ".") || strings.ContainsAny(ext, `/\`) || len(ext) < 2 {
+			problems = append(problems, fmt.Sprintf("%s %q must be a file extension such as \".csv\"", key("group"), ext))
+		}
+		if seen[strings.ToLower(ext)] {
+			problems = append(problems, fmt.Sprintf("%s lists %q twice", key("group"), ext))
+		}
+		seen[strings.ToLower(ext)] = true
+	}
+	if len(o.Group) == 1 {
+		problems = append(problems, key("group")+" needs at least two extensions")
+	}
+	if o.GroupTimeout < 0 {
+		problems = append(problems, key("group_timeout")+" must not be negative")
+	}
+	return problems
+}
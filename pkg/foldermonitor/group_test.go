@@ -0,0 +1,57 @@
+package foldermonitor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCopyGroup(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		want   []string
+	}{
+		{name: "every member is copied", want: []string{"swing.csv", "swing.mp4"}},
+		{name: "members the filter leaves out are not copied", filter: `ext != "csv"`, want: []string{"swing.mp4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+			if err := os.MkdirAll(src, 0755); err != nil {
+				t.Fatal(err)
+			}
+			var paths []string
+			for _, name := range []string{"swing.mp4", "swing.csv"} {
+				path := filepath.Join(src, name)
+				if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+					t.Fatal(err)
+				}
+				paths = append(paths, path)
+			}
+			rule := Rule{Name: DefaultRuleName, SourceDir: src, DestDir: dst,
+				RuleOptions: RuleOptions{Group: []string{".mp4", ".csv"}, Filter: tt.filter}}
+			cfg := &Config{Version: CurrentConfigVersion, Rules: []Rule{rule}}
+			c := newCopier(cfg, NewStore(dir), newStatusTracker())
+			if err := c.copyGroup(context.Background(), rule, paths); err != nil {
+				t.Fatal(err)
+			}
+			entries, err := os.ReadDir(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range entries {
+				got = append(got, e.Name())
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("destination holds %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package foldermonitor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// heartbeatTimeout is how long the watcher may go without reporting in,
+	// while no copy is holding it up, before it is considered dead.
+	heartbeatTimeout = 30 * time.Second
+	// destProbeTimeout bounds the check of one destination, so a hung
+	// network share is reported instead of blocking the health check.
+	destProbeTimeout = 5 * time.Second
+)
+
+// HealthReport tells whether the monitor is working, for uptime checks.
+type HealthReport struct {
+	// OK is false when any problem was found; Problems says which.
+	OK       bool     `json:"ok"`
+	Problems []string `json:"problems,omitempty"`
+	// WatcherAlive is false when the watcher stopped reporting in.
+	WatcherAlive bool      `json:"watcher_alive"`
+	Heartbeat    time.Time `json:"heartbeat"`
+	Paused       bool      `json:"paused"`
+	// QueueDepth counts the files settling, being copied and waiting to be
+	// retried.
+	QueueDepth int        `json:"queue_depth"`
+	InFlight   int        `json:"in_flight"`
+	Settling   int        `json:"settling"`
+	Retrying   int        `json:"retrying"`
+	LastCopy   *time.Time `json:"last_copy,omitempty"`
+	// SourcesDown names the rules whose source folder is unavailable.
+	SourcesDown []string `json:"sources_down,omitempty"`
+	// Drives are the drives holding the source folders, as last checked.
+	Drives       []DriveHealth       `json:"drives,omitempty"`
+	Destinations []DestinationHealth `json:"destinations"`
+}
+
+// DestinationHealth is the result of probing one destination folder.
+type DestinationHealth struct {
+	Path      string `json:"path"`
+	Reachable bool   `json:"reachable"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Health checks the watcher, the copy queue and every destination. A
+// maxIdle above zero also reports a problem when nothing has been copied
+// for that long.
+func (m *Monitor) Health(ctx context.Context, maxIdle time.Duration) HealthReport {
+	return m.health(ctx, m.Config(), maxIdle)
+}
+
+// health is Health for the destinations of cfg. Monitoring runs pass their
+// own config, since m.mu may be held while they are stopped.
+func (m *Monitor) health(ctx context.Context, cfg *Config, maxIdle time.Duration) HealthReport {
+	snap := m.status.snapshot()
+	h := HealthReport{
+		Heartbeat:   snap.Heartbeat,
+		Paused:      snap.PausedSince != nil,
+		InFlight:    len(snap.InFlight),
+		Settling:    snap.Settling,
+		Retrying:    snap.Retrying,
+		SourcesDown: snap.SourcesDown,
+		Drives:      snap.Drives,
+	}
+	h.QueueDepth = h.InFlight + h.Settling + h.Retrying
+	// Copies run on the watcher's goroutine, so a long copy legitimately
+	// delays its heartbeat; stalled copies are aborted separately.
+	h.WatcherAlive = !snap.Heartbeat.IsZero() && (time.Since(snap.Heartbeat) < heartbeatTimeout || h.InFlight > 0)
+	if snap.Degraded != "" {
+		h.Problems = append(h.Problems, "monitoring is restarting after: "+snap.Degraded)
+	} else if !h.WatcherAlive {
+		h.Problems = append(h.Problems, "watcher is not running")
+	}
+	for _, name := range h.SourcesDown {
+		h.Problems = append(h.Problems, fmt.Sprintf("rule %s: source folder is unavailable", name))
+	}
+	for _, d := range h.Drives {
+		if d.Problem != "" {
+			h.Problems = append(h.Problems, fmt.Sprintf("source drive %s: %s", d.Mount, d.Problem))
+		}
+	}
+	if !snap.LastCopy.IsZero() {
+		h.LastCopy = &snap.LastCopy
+	}
+	if since := snap.LastCopy; maxIdle > 0 {
+		if since.IsZero() {
+			since = snap.Started
+		}
+		if idle := time.Since(since); idle > maxIdle {
+			h.Problems = append(h.Problems, fmt.Sprintf("nothing copied for %s", idle.Round(time.Second)))
+		}
+	}
+	h.Destinations = probeDestinations(ctx, destinations(cfg))
+	for _, d := range h.Destinations {
+		if !d.Reachable {
+			h.Problems = append(h.Problems, fmt.Sprintf("destination %s is unreachable: %s", d.Path, d.Error))
+		}
+	}
+	h.OK = len(h.Problems) == 0
+	return h
+}
+
+// destinations returns the distinct destination folders of the enabled
+// rules of cfg.
+func destinations(cfg *Config) []string {
+	seen := make(map[string]bool)
+	var dirs []string
+	add := func(dir string, o RuleOptions) {
+		if !o.IsEnabled() {
+			return
+		}
+		check := []string{dir}
+		if o.Share != nil {
+			check = append(check, o.Share.DestDir)
+		}
+		if o.Failover != nil {
+			check = append(check, o.Failover.DestDir)
+		}
+		for _, dir := range check {
+			if dir == "" || seen[cleanPath(dir)] {
+				continue
+			}
+			seen[cleanPath(dir)] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	for _, r := range cfg.ActiveRules() {
+		add(r.DestDir, r.RuleOptions)
+	}
+	for _, v := range cfg.Volumes {
+		add(v.DestDir, v.RuleOptions)
+	}
+	for _, d := range cfg.Devices {
+		add(d.DestDir, d.RuleOptions)
+	}
+	if u := cfg.Upload; u != nil {
+		add(u.DestDir, u.RuleOptions)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// probeDestinations checks in parallel that each folder, or its parent if
+// it has not been created yet, can be reached.
+func probeDestinations(ctx context.Context, dirs []string) []DestinationHealth {
+	results := make([]DestinationHealth, len(dirs))
+	var wg sync.WaitGroup
+	for i, dir := range dirs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = DestinationHealth{Path: dir}
+			if err := probeDir(ctx, dir); err != nil {
+				results[i].Error = err.Error()
+			} else {
+				results[i].Reachable = true
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// probeDir stats dir, giving up after destProbeTimeout.
+func probeDir(ctx context.Context, dir string) error {
+	ctx, cancel := context.WithTimeout(ctx, destProbeTimeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		info, err := os.Stat(dir)
+		if os.IsNotExist(err) {
+			info, err = os.Stat(filepath.Dir(dir))
+		}
+		if err == nil && !info.IsDir() {
+			err = fmt.Errorf("%s is not a folder", info.Name())
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("no response within %s", destProbeTimeout)
+	}
+}
@@ -0,0 +1,281 @@
+package foldermonitor
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// historyFile is the store file recording the copies of rules that detect
+// renames or skip identical or visually duplicate files, one JSON record
+// per line. It is only appended to while running and compacted when
+// loaded.
+const historyFile = "history.jsonl"
+
+// HistoryRecord is one copy in the history: which source content ended up
+// at which destination.
+type HistoryRecord struct {
+	Time   time.Time `json:"time"`
+	Rule   string    `json:"rule"`
+	Source string    `json:"source"`
+	Dest   string    `json:"dest"`
+	Size   int64     `json:"size"`
+	SHA256 string    `json:"sha256"`
+	// ModTime is the source's modification time when it was copied.
+	ModTime time.Time `json:"mod_time,omitempty"`
+	// RenamedFrom is the earlier destination that was renamed to Dest
+	// instead of copying the file again.
+	RenamedFrom string `json:"renamed_from,omitempty"`
+	// VisualHash and Length are the sampled look and the length of a
+	// video copied by a rule that skips visual duplicates.
+	VisualHash string        `json:"visual_hash,omitempty"`
+	Length     time.Duration `json:"length,omitempty"`
+	// ColdURL is where the copy was uploaded by the rule's cold tier, at
+	// ColdAt.
+	ColdURL string    `json:"cold_url,omitempty"`
+	ColdAt  time.Time `json:"cold_at,omitempty"`
+}
+
+// copyHistory is the loaded history, keyed by destination.
+type copyHistory struct {
+	byDest map[string]HistoryRecord
+	lines  int
+}
+
+// apply adds rec to the index.
+func (h *copyHistory) apply(rec HistoryRecord) {
+	if rec.RenamedFrom != "" {
+		delete(h.byDest, rec.RenamedFrom)
+	}
+	h.byDest[rec.Dest] = rec
+	h.lines++
+}
+
+// loadHistory reads the history file once, rewriting it without superseded
+// records when they make up most of it. s.mu must be held.
+func (s *Store) loadHistory() *copyHistory {
+	if s.history != nil {
+		return s.history
+	}
+	h := &copyHistory{byDest: make(map[string]HistoryRecord)}
+	s.history = h
+	f, err := os.Open(s.path(historyFile))
+	if err != nil {
+		return h
+	}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var rec HistoryRecord
+		if json.Unmarshal(sc.Bytes(), &rec) == nil && rec.Dest != "" {
+			h.apply(rec)
+		}
+	}
+	f.Close()
+	if h.lines > 2*len(h.byDest)+100 {
+		s.compactHistory(h)
+	}
+	return h
+}
+
+// compactHistory rewrites the history file with the current records only.
+// s.mu must be held.
+func (s *Store) compactHistory(h *copyHistory) {
+	tmp := s.path(historyFile + ".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, rec := range h.byDest {
+		rec.RenamedFrom = ""
+		enc.Encode(rec)
+	}
+	err = w.Flush()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || os.Rename(tmp, s.path(historyFile)) != nil {
+		os.Remove(tmp)
+		return
+	}
+	h.lines = len(h.byDest)
+}
+
+// recordCopy appends rec to the history.
+func (s *Store) recordCopy(rec HistoryRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := s.loadHistory()
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path(historyFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	h.apply(rec)
+	return nil
+}
+
+// historyBySize returns the recorded copies of the rule with the given
+// source size.
+func (s *Store) historyBySize(rule string, size int64) []HistoryRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var recs []HistoryRecord
+	for _, rec := range s.loadHistory().byDest {
+		if rec.Rule == rule && rec.Size == size {
+			recs = append(recs, rec)
+		}
+	}
+	return recs
+}
+
+// historyByRule returns the recorded copies of the rule.
+func (s *Store) historyByRule(rule string) []HistoryRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var recs []HistoryRecord
+	for _, rec := range s.loadHistory().byDest {
+		if rec.Rule == rule {
+			recs = append(recs, rec)
+		}
+	}
+	return recs
+}
+
+// historyByDest returns the recorded copy at dest.
+func (s *Store) historyByDest(dest string) (HistoryRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.loadHistory().byDest[dest]
+	return rec, ok
+}
+
+// keepsHistory reports whether the copies of a rule are recorded.
+func (o RuleOptions) keepsHistory() bool {
+	return o.DetectRenames || o.SkipIdentical != "" || o.VisualDuplicates != nil || o.Cold != nil
+}
+
+// recordHistory is the bus consumer adding copies of rules that detect
+// renames, skip identical or visually duplicate files or tier to cold
+// storage to the history.
+func (c *Copier) recordHistory(e Event) {
+	if !e.Rule.keepsHistory() || e.SHA256 == "" {
+		return
+	}
+	err := c.store.recordCopy(HistoryRecord{
+		Time:        e.Time,
+		Rule:        e.Rule.Name,
+		Source:      e.Source,
+		Dest:        e.Dest,
+		Size:        e.Bytes,
+		SHA256:      e.SHA256,
+		ModTime:     e.modTime,
+		RenamedFrom: e.RenamedFrom,
+		VisualHash:  e.visual.String(),
+		Length:      e.visual.length,
+	})
+	if err != nil && svcLogger != nil {
+		svcLogger.Errorf("Error recording copy history: %v", err)
+	}
+}
+
+// renameArchived handles a new file that is an archived file under another
+// name: if the history has a copy of the same content whose source is gone,
+// that copy is renamed to destPath instead of copying the file again. It
+// reports whether it did so.
+func (c *Copier) renameArchived(ctx context.Context, rule Rule, path string, info os.FileInfo, destPath string) bool {
+	if _, err := os.Lstat(destPath); err == nil {
+		return false
+	}
+	// The name transformations, such as an added .gz, must be the same.
+	suffix := strings.TrimPrefix(filepath.Base(destPath), filepath.Base(path))
+	var candidates []HistoryRecord
+	for _, rec := range c.store.historyBySize(rule.Name, info.Size()) {
+		if strings.TrimPrefix(filepath.Base(rec.Dest), filepath.Base(rec.Source)) != suffix {
+			continue
+		}
+		if _, err := os.Stat(rec.Source); !os.IsNotExist(err) {
+			continue
+		}
+		if _, err := os.Stat(rec.Dest); err != nil {
+			continue
+		}
+		candidates = append(candidates, rec)
+	}
+	if len(candidates) == 0 || ctx.Err() != nil {
+		return false
+	}
+	sum, err := hashFile(path)
+	if err != nil {
+		return false
+	}
+	for _, rec := range candidates {
+		if rec.SHA256 != sum {
+			continue
+		}
+		if err := os.Rename(rec.Dest, destPath); err != nil {
+			logDebugf("Rule %s: could not rename %s for %s: %v", rule.Name, rec.Dest, path, err)
+			return false
+		}
+		c.bus.Publish(Event{
+			Kind:        EventCopied,
+			Rule:        rule,
+			Source:      path,
+			Dest:        destPath,
+			Bytes:       info.Size(),
+			SHA256:      sum,
+			RenamedFrom: rec.Dest,
+		})
+		return true
+	}
+	return false
+}
+
+// unchanged reports whether the file at path is the same as its last copy
+// to destPath, which still exists, so that copying it again can be
+// skipped: its size and modification time match or, with skip_identical
+// set to "hash", its content does.
+func (c *Copier) unchanged(ctx context.Context, rule Rule, path string, info os.FileInfo, destPath string) bool {
+	rec, ok := c.store.historyByDest(destPath)
+	if !ok || rec.Source != path || rec.Size != info.Size() {
+		return false
+	}
+	if _, err := os.Stat(destPath); err != nil {
+		return false
+	}
+	if rec.ModTime.Equal(info.ModTime()) {
+		logDebugf("Rule %s: %s is unchanged since its last copy, skipping", rule.Name, path)
+		return true
+	}
+	// Edited videos are hashed after processing, so their sums cannot be
+	// compared with the source.
+	if rule.SkipIdentical != "hash" || rule.editsVideo() || ctx.Err() != nil {
+		return false
+	}
+	sum, err := hashFile(path)
+	if err != nil || sum != rec.SHA256 {
+		return false
+	}
+	rec.ModTime, rec.Time, rec.RenamedFrom = info.ModTime(), time.Now(), ""
+	if err := c.store.recordCopy(rec); err != nil && svcLogger != nil {
+		svcLogger.Errorf("Error recording copy history: %v", err)
+	}
+	logDebugf("Rule %s: %s was rewritten with identical content, skipping", rule.Name, path)
+	return true
+}
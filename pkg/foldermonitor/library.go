@@ -0,0 +1,171 @@
+package foldermonitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Library refresh defaults.
+const (
+	defaultLibraryDelay = 10 * time.Second
+	libraryTimeout      = 30 * time.Second
+)
+
+// LibraryOptions ask a Plex or Jellyfin server that shows the rule's
+// destination as a library to scan the folders new videos were copied
+// into, so they appear in the review app without waiting for its
+// periodic scan.
+type LibraryOptions struct {
+	// Server is "plex" or "jellyfin".
+	Server string `json:"server"`
+	// URL is the base URL of the server, e.g. "http://nas:32400".
+	URL string `json:"url"`
+	// Token is the X-Plex-Token or a Jellyfin API key.
+	Token string `json:"token"`
+	// Section is the Plex library section id, as in
+	// /library/sections/<id>. Jellyfin finds the library from the path.
+	Section string `json:"section,omitempty"`
+	// ServerDir is the rule's destination folder as the server sees it,
+	// e.g. "/data/lessons" when the server runs on the NAS sharing it
+	// (default the destination folder itself).
+	ServerDir string `json:"server_dir,omitempty"`
+	// Delay collects the copies into a folder for this long (default 10s)
+	// and asks for one scan of it.
+	Delay Duration `json:"delay,omitempty"`
+}
+
+// libraryRefresher batches the scans requested for copied files by folder.
+type libraryRefresher struct {
+	mu      sync.Mutex
+	pending map[string]*time.Timer // by server URL and folder
+	closed  bool
+}
+
+func newLibraryRefresher() *libraryRefresher {
+	return &libraryRefresher{pending: make(map[string]*time.Timer)}
+}
+
+// copied is the bus consumer scheduling a scan of the folder of each
+// copied file of rules with a library.
+func (l *libraryRefresher) copied(e Event) {
+	lib := e.Rule.Library
+	if lib == nil || e.Dest == "" || !isMedia(e.Source) {
+		return
+	}
+	rule, folder := e.Rule, lib.serverPath(e.Rule.DestDir, filepath.Dir(e.Dest))
+	key := lib.URL + "\x00" + folder
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed || l.pending[key] != nil {
+		return
+	}
+	l.pending[key] = time.AfterFunc(lib.Delay.or(defaultLibraryDelay), func() {
+		l.mu.Lock()
+		delete(l.pending, key)
+		l.mu.Unlock()
+		if err := lib.refresh(context.Background(), folder); err != nil {
+			logEvent(CategoryGeneral, LevelWarning, "Rule %s: cannot ask %s to scan %s: %v", rule.Name, lib.Server, folder, err)
+			return
+		}
+		logDebugf("Rule %s: asked %s to scan %s", rule.Name, lib.Server, folder)
+	})
+}
+
+// close drops the scans not yet requested.
+func (l *libraryRefresher) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	for key, t := range l.pending {
+		t.Stop()
+		delete(l.pending, key)
+	}
+}
+
+// serverPath translates dir, inside the local destination folder dest, to
+// the path the server knows it by.
+func (lib *LibraryOptions) serverPath(dest, dir string) string {
+	if lib.ServerDir == "" {
+		return dir
+	}
+	rel, ok := relInside(dest, dir)
+	if !ok {
+		return dir
+	}
+	if rel == "." {
+		return lib.ServerDir
+	}
+	sep := "/"
+	if strings.Contains(lib.ServerDir, `\`) {
+		sep = `\`
+	}
+	return strings.TrimRight(lib.ServerDir, `/\`) + sep + strings.ReplaceAll(filepath.ToSlash(rel), "/", sep)
+}
+
+// refresh asks the server to scan folder.
+func (lib *LibraryOptions) refresh(ctx context.Context, folder string) error {
+	ctx, cancel := context.WithTimeout(ctx, libraryTimeout)
+	defer cancel()
+	base := strings.TrimRight(lib.URL, "/")
+	var req *http.Request
+	var err error
+	if lib.Server == "plex" {
+		u := base + "/library/sections/" + url.PathEscape(lib.Section) + "/refresh?path=" + url.QueryEscape(folder)
+		if req, err = http.NewRequestWithContext(ctx, http.MethodGet, u, nil); err != nil {
+			return err
+		}
+		req.Header.Set("X-Plex-Token", lib.Token)
+	} else {
+		body, _ := json.Marshal(map[string]any{
+			"Updates": []map[string]string{{"Path": folder, "UpdateType": "Created"}},
+		})
+		if req, err = http.NewRequestWithContext(ctx, http.MethodPost, base+"/Library/Media/Updated", bytes.NewReader(body)); err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", fmt.Sprintf("MediaBrowser Token=%q", lib.Token))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// validateLibrary checks a rule's library refresh settings.
+func validateLibrary(key func(string) string, lib *LibraryOptions) ConfigErrors {
+	var problems ConfigErrors
+	switch lib.Server {
+	case "plex":
+		if lib.Section == "" {
+			problems = append(problems, key("library")+".section is needed for plex")
+		}
+	case "jellyfin":
+	default:
+		problems = append(problems, fmt.Sprintf("%s.server %q is not supported (want plex or jellyfin)", key("library"), lib.Server))
+	}
+	if !strings.HasPrefix(lib.URL, "http://") && !strings.HasPrefix(lib.URL, "https://") {
+		problems = append(problems, key("library")+".url must be an http:// or https:// URL")
+	}
+	if lib.Token == "" {
+		problems = append(problems, key("library")+".token is needed")
+	}
+	if lib.Delay < 0 {
+		problems = append(problems, key("library")+".delay must be a positive duration such as \"10s\"")
+	}
+	return problems
+}
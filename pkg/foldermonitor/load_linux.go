@@ -0,0 +1,67 @@
+package foldermonitor
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// procLoadProbe reads /proc/stat and /proc/diskstats.
+type procLoadProbe struct {
+	prevBusy, prevTotal uint64
+}
+
+func newLoadProbe() (loadProbe, error) {
+	p := &procLoadProbe{}
+	if _, _, err := p.sample(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *procLoadProbe) sample() (float64, float64, error) {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+	line := strings.SplitN(string(data), "\n", 2)[0]
+	fields := strings.Fields(line)
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0, errors.New("unexpected /proc/stat format")
+	}
+	var total, idle uint64
+	for i, f := range fields[1:] {
+		n, _ := strconv.ParseUint(f, 10, 64)
+		total += n
+		if i == 3 || i == 4 { // idle, iowait
+			idle += n
+		}
+	}
+	busy := total - idle
+	var cpu float64
+	if total > p.prevTotal {
+		cpu = 100 * float64(busy-p.prevBusy) / float64(total-p.prevTotal)
+	}
+	p.prevBusy, p.prevTotal = busy, total
+
+	// Sum "I/Os currently in progress" over whole disks.
+	var queue float64
+	if f, err := os.Open("/proc/diskstats"); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fs := strings.Fields(scanner.Text())
+			if len(fs) < 12 {
+				continue
+			}
+			if _, err := os.Stat("/sys/block/" + fs[2]); err != nil {
+				continue // partitions double-count their disk
+			}
+			n, _ := strconv.ParseFloat(fs[11], 64)
+			queue += n
+		}
+		f.Close()
+	}
+	return cpu, queue, nil
+}
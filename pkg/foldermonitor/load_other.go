@@ -0,0 +1,9 @@
+//go:build !windows && !linux
+
+package foldermonitor
+
+import "errors"
+
+func newLoadProbe() (loadProbe, error) {
+	return nil, errors.New("load measurement is not supported on this platform")
+}
@@ -0,0 +1,80 @@
+package foldermonitor
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	pdh                             = windows.NewLazySystemDLL("pdh.dll")
+	procPdhOpenQuery                = pdh.NewProc("PdhOpenQueryW")
+	procPdhAddEnglishCounter        = pdh.NewProc("PdhAddEnglishCounterW")
+	procPdhCollectQueryData         = pdh.NewProc("PdhCollectQueryData")
+	procPdhGetFormattedCounterValue = pdh.NewProc("PdhGetFormattedCounterValue")
+)
+
+const pdhFmtDouble = 0x00000200
+
+// pdhCounterValue mirrors PDH_FMT_COUNTERVALUE for PDH_FMT_DOUBLE.
+type pdhCounterValue struct {
+	CStatus uint32
+	_       uint32
+	Value   float64
+}
+
+// pdhLoadProbe reads performance counters through the PDH API.
+type pdhLoadProbe struct {
+	query, cpu, queue uintptr
+}
+
+func newLoadProbe() (loadProbe, error) {
+	if err := pdh.Load(); err != nil {
+		return nil, err
+	}
+	p := &pdhLoadProbe{}
+	if r, _, _ := procPdhOpenQuery.Call(0, 0, uintptr(unsafe.Pointer(&p.query))); r != 0 {
+		return nil, fmt.Errorf("PdhOpenQuery failed: 0x%x", r)
+	}
+	if err := p.add(`\Processor(_Total)\% Processor Time`, &p.cpu); err != nil {
+		return nil, err
+	}
+	if err := p.add(`\PhysicalDisk(_Total)\Current Disk Queue Length`, &p.queue); err != nil {
+		return nil, err
+	}
+	// Rate counters need a first collection to compare against.
+	procPdhCollectQueryData.Call(p.query)
+	return p, nil
+}
+
+func (p *pdhLoadProbe) add(path string, counter *uintptr) error {
+	ptr, _ := windows.UTF16PtrFromString(path)
+	if r, _, _ := procPdhAddEnglishCounter.Call(p.query, uintptr(unsafe.Pointer(ptr)), 0, uintptr(unsafe.Pointer(counter))); r != 0 {
+		return fmt.Errorf("adding counter %s failed: 0x%x", path, r)
+	}
+	return nil
+}
+
+func (p *pdhLoadProbe) value(counter uintptr) (float64, error) {
+	var v pdhCounterValue
+	if r, _, _ := procPdhGetFormattedCounterValue.Call(counter, pdhFmtDouble, 0, uintptr(unsafe.Pointer(&v))); r != 0 {
+		return 0, fmt.Errorf("reading counter failed: 0x%x", r)
+	}
+	return v.Value, nil
+}
+
+func (p *pdhLoadProbe) sample() (float64, float64, error) {
+	if r, _, _ := procPdhCollectQueryData.Call(p.query); r != 0 {
+		return 0, 0, fmt.Errorf("PdhCollectQueryData failed: 0x%x", r)
+	}
+	cpu, err := p.value(p.cpu)
+	if err != nil {
+		return 0, 0, err
+	}
+	queue, err := p.value(p.queue)
+	if err != nil {
+		return 0, 0, err
+	}
+	return cpu, queue, nil
+}
@@ -0,0 +1,417 @@
+package foldermonitor
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// LogLevel orders log messages by severity; lower levels are more severe.
+type LogLevel int
+
+const (
+	LevelError LogLevel = iota
+	LevelWarning
+	LevelInfo
+	LevelDebug
+)
+
+// ParseLogLevel converts a verbosity name such as "warn" into a LogLevel.
+func ParseLogLevel(s string) (LogLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "error":
+		return LevelError, nil
+	case "warn", "warning":
+		return LevelWarning, nil
+	case "info", "":
+		return LevelInfo, nil
+	case "debug":
+		return LevelDebug, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q (want error, warning, info or debug)", s)
+}
+
+// ConsoleLogger implements service.Logger for foreground runs, writing
+// timestamped lines to a stream and dropping anything below its level.
+type ConsoleLogger struct {
+	level LogLevel
+	out   *log.Logger
+}
+
+// NewConsoleLogger creates a ConsoleLogger writing to w.
+func NewConsoleLogger(w io.Writer, level LogLevel) *ConsoleLogger {
+	return &ConsoleLogger{level: level, out: log.New(w, "", log.LstdFlags)}
+}
+
+// consoleTags are the level names written by the console logger.
+var consoleTags = map[LogLevel]string{LevelError: "ERROR", LevelWarning: "WARN", LevelInfo: "INFO", LevelDebug: "DEBUG"}
+
+func (l *ConsoleLogger) LogEvent(cat LogCategory, level LogLevel, msg string) error {
+	if level > logThreshold(l.level, cat, msg) {
+		return nil
+	}
+	l.out.Printf("%-5s %s", consoleTags[level], msg)
+	return nil
+}
+
+func (l *ConsoleLogger) Error(v ...interface{}) error {
+	return l.LogEvent(CategoryGeneral, LevelError, fmt.Sprint(v...))
+}
+
+func (l *ConsoleLogger) Warning(v ...interface{}) error {
+	return l.LogEvent(CategoryGeneral, LevelWarning, fmt.Sprint(v...))
+}
+
+func (l *ConsoleLogger) Info(v ...interface{}) error {
+	return l.LogEvent(CategoryGeneral, LevelInfo, fmt.Sprint(v...))
+}
+
+func (l *ConsoleLogger) Errorf(format string, a ...interface{}) error {
+	return l.LogEvent(CategoryGeneral, LevelError, fmt.Sprintf(format, a...))
+}
+
+func (l *ConsoleLogger) Warningf(format string, a ...interface{}) error {
+	return l.LogEvent(CategoryGeneral, LevelWarning, fmt.Sprintf(format, a...))
+}
+
+func (l *ConsoleLogger) Infof(format string, a ...interface{}) error {
+	return l.LogEvent(CategoryGeneral, LevelInfo, fmt.Sprintf(format, a...))
+}
+
+// Debugf logs verbose diagnostics; only the console logger supports it.
+func (l *ConsoleLogger) Debugf(format string, a ...interface{}) error {
+	return l.LogEvent(CategoryGeneral, LevelDebug, fmt.Sprintf(format, a...))
+}
+
+// LogComponents are the parts of the program whose log level can be set in
+// log_levels.
+var LogComponents = []string{"watcher", "copier", "notifier", "service"}
+
+// logLevels holds the log_levels and per-rule log_level settings of the
+// running configuration; nil leaves every logger at its own level.
+var logLevels atomic.Pointer[levelOverrides]
+
+// levelOverrides are the log levels configured per component and per rule.
+type levelOverrides struct {
+	components map[string]LogLevel
+	rules      map[string]LogLevel
+}
+
+// setLogLevels applies the log levels configured in cfg. Invalid levels
+// were rejected by ValidateConfig and are ignored.
+func setLogLevels(cfg *Config) {
+	o := &levelOverrides{components: make(map[string]LogLevel), rules: make(map[string]LogLevel)}
+	for name, s := range cfg.LogLevels {
+		if level, err := ParseLogLevel(s); err == nil {
+			o.components[strings.ToLower(name)] = level
+		}
+	}
+	rule := func(name, s string) {
+		if level, err := ParseLogLevel(s); err == nil && s != "" {
+			o.rules[name] = level
+		}
+	}
+	for _, r := range cfg.ActiveRules() {
+		rule(r.Name, r.LogLevel)
+	}
+	for _, v := range cfg.Volumes {
+		rule(v.Name, v.LogLevel)
+	}
+	for _, d := range cfg.Devices {
+		rule(d.Name, d.LogLevel)
+	}
+	if cfg.Upload != nil {
+		rule(cfg.Upload.Name, cfg.Upload.LogLevel)
+	}
+	if len(o.components) == 0 && len(o.rules) == 0 {
+		o = nil
+	}
+	logLevels.Store(o)
+}
+
+// logThreshold returns the least severe level logged for msg: the level of
+// the rule it is about, else that of its component, else base, the
+// logger's own level.
+func logThreshold(base LogLevel, cat LogCategory, msg string) LogLevel {
+	o := logLevels.Load()
+	if o == nil {
+		return base
+	}
+	if level, ok := o.rules[logRule(msg)]; ok {
+		return level
+	}
+	if level, ok := o.components[logComponent(cat, msg)]; ok {
+		return level
+	}
+	return base
+}
+
+// logRule returns the name of the rule a message such as "Rule bay3: ..."
+// is about, or "".
+func logRule(msg string) string {
+	for _, prefix := range []string{"Rule ", "Volume ", "Device ", "Upload "} {
+		if strings.HasPrefix(msg, prefix) {
+			if i := strings.Index(msg, ": "); i > len(prefix) {
+				return msg[len(prefix):i]
+			}
+		}
+	}
+	return ""
+}
+
+// logComponent returns the component that logged a message, judged by its
+// category or, for uncategorised ones, its wording.
+func logComponent(cat LogCategory, msg string) string {
+	if strings.HasPrefix(msg, "SLO webhook") {
+		return "notifier"
+	}
+	switch cat {
+	case CategoryHealth:
+		return "watcher"
+	case CategoryCopy, CategoryCopyFailure, CategoryDestination, CategoryQuarantine, CategoryLatency:
+		return "copier"
+	case CategoryGeneral:
+		switch {
+		case strings.HasPrefix(msg, "Watcher "):
+			return "watcher"
+		case strings.HasPrefix(msg, "MQTT"), strings.HasPrefix(msg, "Syslog"), strings.HasPrefix(msg, "Metrics"):
+			return "notifier"
+		case logRule(msg) != "", strings.HasPrefix(msg, "Pair "):
+			return "copier"
+		}
+	}
+	return "service"
+}
+
+// filteredLogger applies the configured log levels to a logger without
+// levels of its own, such as the service framework's, which records
+// information and above. Debug messages let through are written as
+// information.
+type filteredLogger struct {
+	Logger
+}
+
+func (l filteredLogger) LogEvent(cat LogCategory, level LogLevel, msg string) error {
+	if level > logThreshold(LevelInfo, cat, msg) {
+		return nil
+	}
+	if level == LevelDebug {
+		level = LevelInfo
+	}
+	if e, ok := l.Logger.(EventLogger); ok {
+		return e.LogEvent(cat, level, msg)
+	}
+	return logAt(l.Logger, level, msg)
+}
+
+func (l filteredLogger) Debugf(format string, a ...interface{}) error {
+	return l.LogEvent(CategoryGeneral, LevelDebug, fmt.Sprintf(format, a...))
+}
+
+func (l filteredLogger) Error(v ...interface{}) error {
+	return l.LogEvent(CategoryGeneral, LevelError, fmt.Sprint(v...))
+}
+
+func (l filteredLogger) Warning(v ...interface{}) error {
+	return l.LogEvent(CategoryGeneral, LevelWarning, fmt.Sprint(v...))
+}
+
+func (l filteredLogger) Info(v ...interface{}) error {
+	return l.LogEvent(CategoryGeneral, LevelInfo, fmt.Sprint(v...))
+}
+
+func (l filteredLogger) Errorf(format string, a ...interface{}) error {
+	return l.LogEvent(CategoryGeneral, LevelError, fmt.Sprintf(format, a...))
+}
+
+func (l filteredLogger) Warningf(format string, a ...interface{}) error {
+	return l.LogEvent(CategoryGeneral, LevelWarning, fmt.Sprintf(format, a...))
+}
+
+func (l filteredLogger) Infof(format string, a ...interface{}) error {
+	return l.LogEvent(CategoryGeneral, LevelInfo, fmt.Sprintf(format, a...))
+}
+
+// logDebugf writes a debug message when the active logger supports it.
+func logDebugf(format string, a ...interface{}) {
+	if d, ok := svcLogger.(interface {
+		Debugf(string, ...interface{}) error
+	}); ok {
+		d.Debugf(format, a...)
+	}
+}
+
+// LogCategory groups log messages by subject so that the Windows Event Log
+// and syslog receivers can filter them, e.g. copy failures apart from
+// routine copies. The value is the base of the category's event IDs.
+type LogCategory uint32
+
+const (
+	// CategoryGeneral is used for messages not logged with a category; its
+	// event IDs are those the service framework always used.
+	CategoryGeneral     LogCategory = 0
+	CategoryService     LogCategory = 100 // start, stop, reload, pause
+	CategoryCopy        LogCategory = 200 // files copied
+	CategoryCopyFailure LogCategory = 300 // copies that failed or are retried
+	CategoryDestination LogCategory = 400 // destinations needing attention
+	CategoryQuarantine  LogCategory = 500 // files quarantined
+	CategoryHealth      LogCategory = 600 // watches lost and recreated
+	CategoryConfig      LogCategory = 700 // configuration fetched or rejected
+	CategoryUpdate      LogCategory = 800 // self-update
+	CategoryLatency     LogCategory = 900 // files archived later than the SLO
+)
+
+// categoryNames are the syslog MSGIDs of the categories.
+var categoryNames = map[LogCategory]string{
+	CategoryGeneral:     "general",
+	CategoryService:     "service",
+	CategoryCopy:        "copy",
+	CategoryCopyFailure: "copy-failure",
+	CategoryDestination: "destination",
+	CategoryQuarantine:  "quarantine",
+	CategoryHealth:      "health",
+	CategoryConfig:      "config",
+	CategoryUpdate:      "update",
+	CategoryLatency:     "latency",
+}
+
+// String returns the category's name.
+func (c LogCategory) String() string {
+	if name, ok := categoryNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("category-%d", uint32(c))
+}
+
+// EventID returns the event ID of a message of the category at level: the
+// category base plus 1 for information, 2 for warnings and 3 for errors,
+// so "CategoryCopyFailure at LevelError" is event 303.
+func (c LogCategory) EventID(level LogLevel) uint32 {
+	switch level {
+	case LevelError:
+		return uint32(c) + 3
+	case LevelWarning:
+		return uint32(c) + 2
+	}
+	return uint32(c) + 1
+}
+
+// EventLogger is a Logger that records the category of messages.
+type EventLogger interface {
+	Logger
+	LogEvent(cat LogCategory, level LogLevel, msg string) error
+}
+
+// numberedLogger is implemented by the Windows Event Log logger of the
+// service framework, which takes an event ID with each message.
+type numberedLogger interface {
+	NError(eventID uint32, v ...interface{}) error
+	NWarning(eventID uint32, v ...interface{}) error
+	NInfo(eventID uint32, v ...interface{}) error
+}
+
+// eventIDLogger logs categorised messages to the Windows Event Log under
+// their event IDs.
+type eventIDLogger struct {
+	Logger
+	n numberedLogger
+}
+
+func (l eventIDLogger) LogEvent(cat LogCategory, level LogLevel, msg string) error {
+	switch level {
+	case LevelError:
+		return l.n.NError(cat.EventID(level), msg)
+	case LevelWarning:
+		return l.n.NWarning(cat.EventID(level), msg)
+	case LevelInfo:
+		return l.n.NInfo(cat.EventID(level), msg)
+	}
+	return nil
+}
+
+// logAt writes msg to l at level, for loggers without categories.
+func logAt(l Logger, level LogLevel, msg string) error {
+	switch level {
+	case LevelError:
+		return l.Error(msg)
+	case LevelWarning:
+		return l.Warning(msg)
+	case LevelInfo:
+		return l.Info(msg)
+	}
+	if d, ok := l.(interface {
+		Debugf(string, ...interface{}) error
+	}); ok {
+		return d.Debugf("%s", msg)
+	}
+	return nil
+}
+
+// logEvent writes a message of the given category to the service log.
+func logEvent(cat LogCategory, level LogLevel, format string, a ...interface{}) {
+	if svcLogger == nil {
+		return
+	}
+	msg := fmt.Sprintf(format, a...)
+	if l, ok := svcLogger.(EventLogger); ok {
+		l.LogEvent(cat, level, msg)
+		return
+	}
+	logAt(svcLogger, level, msg)
+}
+
+// TeeLogger returns a logger writing every message to each of loggers.
+func TeeLogger(loggers ...Logger) Logger {
+	return teeLogger(loggers)
+}
+
+type teeLogger []Logger
+
+func (t teeLogger) each(fn func(Logger) error) error {
+	var first error
+	for _, l := range t {
+		if err := fn(l); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (t teeLogger) LogEvent(cat LogCategory, level LogLevel, msg string) error {
+	return t.each(func(l Logger) error {
+		if e, ok := l.(EventLogger); ok {
+			return e.LogEvent(cat, level, msg)
+		}
+		return logAt(l, level, msg)
+	})
+}
+
+func (t teeLogger) Debugf(format string, a ...interface{}) error {
+	return t.LogEvent(CategoryGeneral, LevelDebug, fmt.Sprintf(format, a...))
+}
+
+func (t teeLogger) Error(v ...interface{}) error {
+	return t.each(func(l Logger) error { return l.Error(v...) })
+}
+
+func (t teeLogger) Warning(v ...interface{}) error {
+	return t.each(func(l Logger) error { return l.Warning(v...) })
+}
+
+func (t teeLogger) Info(v ...interface{}) error {
+	return t.each(func(l Logger) error { return l.Info(v...) })
+}
+
+func (t teeLogger) Errorf(format string, a ...interface{}) error {
+	return t.each(func(l Logger) error { return l.Errorf(format, a...) })
+}
+
+func (t teeLogger) Warningf(format string, a ...interface{}) error {
+	return t.each(func(l Logger) error { return l.Warningf(format, a...) })
+}
+
+func (t teeLogger) Infof(format string, a ...interface{}) error {
+	return t.each(func(l Logger) error { return l.Infof(format, a...) })
+}
@@ -0,0 +1,371 @@
+package foldermonitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Videos are processed on their way to the destination by running ffmpeg
+// from the source into a temporary file, which is then copied like any
+// other file. Only the containers in mediaExtensions are processed.
+
+// mediaExtensions are the video containers the media stage handles.
+var mediaExtensions = map[string]bool{".mp4": true, ".m4v": true, ".mov": true}
+
+// defaultFFmpeg is the ffmpeg executable looked up on the PATH.
+const defaultFFmpeg = "ffmpeg"
+
+// MetadataOptions write tags into the destination video, so analysis
+// software shows the bay, coach or student without a sidecar file. Values
+// may contain {rule}, {file} (the base name without extension), {date}
+// (YYYY-MM-DD), {hour}, {time} (HHMMSS) and {host}, taken from the clip's
+// time (see ClipTimeOptions) and the machine, and with a student lookup
+// the fields of the lesson such as {student}.
+type MetadataOptions struct {
+	// Tags maps tag names to values, e.g. {"bay": "3", "session": "{file}"}.
+	// The standard names title, comment, artist and the like go into the
+	// usual udta atoms; others are written as custom metadata keys.
+	Tags map[string]string `json:"tags,omitempty"`
+	// Lookup is a CSV file with a header row whose first column is matched
+	// against LookupKey; the other columns of the matching row become tags,
+	// e.g. "bay-hour,student,coach" with rows like "3-2026-10-14-15,Ana,Sam".
+	// It is read for every file, so it can be updated while running.
+	Lookup string `json:"lookup,omitempty"`
+	// LookupKey is the template of the key looked up (default "{file}").
+	LookupKey string `json:"lookup_key,omitempty"`
+}
+
+// editsVideo reports whether videos are processed on their way to the
+// archive.
+func (o RuleOptions) editsVideo() bool {
+	return o.Metadata != nil || o.Trim != nil || o.StripAudio
+}
+
+// processesMedia reports whether the options need ffmpeg.
+func (o RuleOptions) processesMedia() bool {
+	return o.editsVideo() || o.Share != nil || o.VisualDuplicates != nil
+}
+
+// usesMedia reports whether any rule of c runs ffmpeg.
+func (c *Config) usesMedia() bool {
+	for _, r := range c.ActiveRules() {
+		if r.processesMedia() {
+			return true
+		}
+	}
+	for _, v := range c.Volumes {
+		if v.processesMedia() {
+			return true
+		}
+	}
+	for _, d := range c.Devices {
+		if d.processesMedia() {
+			return true
+		}
+	}
+	for _, p := range c.Pairs {
+		if p.merges() {
+			return true
+		}
+	}
+	return c.Upload != nil && c.Upload.processesMedia()
+}
+
+// ffmpegPath returns the configured ffmpeg executable.
+func (c *Config) ffmpegPath() string {
+	if c.FFmpeg != "" {
+		return c.FFmpeg
+	}
+	return defaultFFmpeg
+}
+
+// isMedia reports whether path is a video the media stage handles.
+func isMedia(path string) bool {
+	return mediaExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// expandMediaTemplate replaces the placeholders of a metadata template.
+func expandMediaTemplate(t string, rule Rule, path string, info os.FileInfo) string {
+	if !strings.Contains(t, "{") {
+		return t
+	}
+	mod := clipTime(rule, path, info)
+	host, _ := os.Hostname()
+	name := filepath.Base(path)
+	return strings.NewReplacer(
+		"{rule}", rule.Name,
+		"{file}", strings.TrimSuffix(name, filepath.Ext(name)),
+		"{date}", mod.Format("2006-01-02"),
+		"{hour}", mod.Format("15"),
+		"{time}", mod.Format("150405"),
+		"{host}", host,
+	).Replace(t)
+}
+
+// metadataTags returns the tags to write into the video at path, the fixed
+// ones overriding those from the lookup file.
+func metadataTags(m *MetadataOptions, rule Rule, path string, info os.FileInfo) (map[string]string, error) {
+	tags := make(map[string]string)
+	if m.Lookup != "" {
+		key := m.LookupKey
+		if key == "" {
+			key = "{file}"
+		}
+		row, err := lookupRow(m.Lookup, expandMediaTemplate(expandLessonTemplate(key, rule, path, info), rule, path, info))
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range row {
+			tags[k] = v
+		}
+	}
+	for k, v := range m.Tags {
+		tags[k] = expandMediaTemplate(expandLessonTemplate(v, rule, path, info), rule, path, info)
+	}
+	return tags, nil
+}
+
+// lookupRow returns the row of the CSV file whose first column is key, as
+// a map from the other column names to their values. A missing row is not
+// an error.
+func lookupRow(file, key string) (map[string]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("metadata lookup: %v", err)
+	}
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("metadata lookup %s: %v", file, err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	header := records[0]
+	for _, rec := range records[1:] {
+		if len(rec) == 0 || strings.TrimSpace(rec[0]) != key {
+			continue
+		}
+		row := make(map[string]string)
+		for i := 1; i < len(rec) && i < len(header); i++ {
+			if name := strings.TrimSpace(header[i]); name != "" {
+				row[name] = strings.TrimSpace(rec[i])
+			}
+		}
+		return row, nil
+	}
+	return nil, nil
+}
+
+// TrimOptions keep only part of each video. Either Last is set, or Start
+// and End give the part to keep measured from the beginning.
+type TrimOptions struct {
+	// Last keeps the final stretch of the video, e.g. "15s" of a buffer
+	// that ends with the swing.
+	Last Duration `json:"last,omitempty"`
+	// Start drops the video before this point; End drops it after this
+	// point (the end of the video when zero).
+	Start Duration `json:"start,omitempty"`
+	End   Duration `json:"end,omitempty"`
+	// Precise re-encodes the video so the cut is exact. Otherwise streams
+	// are copied and the cut snaps to the nearest earlier keyframe, keeping
+	// up to a few extra seconds.
+	Precise bool `json:"precise,omitempty"`
+}
+
+// ffmpegJob collects the options of one ffmpeg run.
+type ffmpegJob struct {
+	input  []string // options applying to the input, before -i
+	inputs []string // further inputs such as an overlay image
+	filter string   // filtergraph producing the video stream [v]
+	output []string // options applying to the output
+	encode bool     // whether the video must be re-encoded
+	// encoder is the ffmpegEncoders entry re-encoding the video, libx264
+	// when empty.
+	encoder string
+}
+
+// args returns the ffmpeg command line reading src and writing dst.
+func (j *ffmpegJob) args(src, dst string) []string {
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin", "-y"}
+	args = append(args, j.input...)
+	args = append(args, "-i", src)
+	for _, in := range j.inputs {
+		args = append(args, "-i", in)
+	}
+	if j.filter != "" {
+		// The filtered video replaces the original; audio is kept.
+		args = append(args, "-filter_complex", j.filter, "-map", "[v]", "-map", "0:a?")
+	} else {
+		args = append(args, "-map", "0")
+	}
+	args = append(args, "-map_metadata", "0", "-c", "copy")
+	if j.encode {
+		encoder := j.encoder
+		if encoder == "" {
+			encoder = EncoderSoftware
+		}
+		args = append(args, ffmpegEncoders[encoder]...)
+	}
+	args = append(args, j.output...)
+	return append(args, dst)
+}
+
+// seconds formats d for ffmpeg.
+func seconds(d Duration) string {
+	return fmt.Sprintf("%.3f", time.Duration(d).Seconds())
+}
+
+// mediaJob returns the ffmpeg run applying the rule's media processing to
+// the file at path.
+func mediaJob(rule Rule, path string, info os.FileInfo) (*ffmpegJob, error) {
+	j := &ffmpegJob{}
+	if t := rule.Trim; t != nil {
+		switch {
+		case t.Last > 0:
+			j.input = append(j.input, "-sseof", "-"+seconds(t.Last))
+		case t.Start > 0:
+			j.input = append(j.input, "-ss", seconds(t.Start))
+		}
+		if t.End > 0 {
+			j.output = append(j.output, "-t", seconds(t.End-t.Start))
+		}
+		j.encode = j.encode || t.Precise
+	}
+	if rule.StripAudio {
+		j.output = append(j.output, "-an")
+	}
+	if m := rule.Metadata; m != nil {
+		tags, err := metadataTags(m, rule, path, info)
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(tags))
+		for k := range tags {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			j.output = append(j.output, "-metadata", k+"="+tags[k])
+		}
+		j.output = append(j.output, "-movflags", "use_metadata_tags")
+	}
+	return j, nil
+}
+
+// processMedia runs ffmpeg on the video at src and returns the temporary
+// file holding the result, which the caller removes. Files the stage does
+// not handle are returned unchanged.
+func (c *Copier) processMedia(ctx context.Context, rule Rule, src string, info os.FileInfo) (string, error) {
+	if !rule.editsVideo() || !isMedia(src) {
+		return src, nil
+	}
+	job, err := mediaJob(rule, src, info)
+	if err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp("", "foldermonitor-*"+filepath.Ext(src))
+	if err != nil {
+		return "", err
+	}
+	tmp.Close()
+	if err := c.runFFmpeg(ctx, rule, job, src, tmp.Name()); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// runFFmpeg runs job from src to dst. Re-encodes wait for a transcode slot
+// and use the chosen encoder; one that fails on a hardware encoder picked
+// automatically is retried with libx264.
+func (c *Copier) runFFmpeg(ctx context.Context, rule Rule, job *ffmpegJob, src, dst string) error {
+	if !job.encode {
+		return c.execFFmpeg(ctx, rule, job, src, dst)
+	}
+	if err := c.transcode.acquire(ctx); err != nil {
+		return err
+	}
+	defer c.transcode.release()
+	job.encoder = c.transcode.choose(ctx)
+	err := c.execFFmpeg(ctx, rule, job, src, dst)
+	if err != nil && ctx.Err() == nil && c.transcode.want == EncoderAuto && job.encoder != EncoderSoftware {
+		logEvent(CategoryCopy, LevelWarning, "Rule %s: %s failed on %s, re-encoding with %s: %v", rule.Name, encoderName(job.encoder), filepath.Base(src), encoderName(EncoderSoftware), err)
+		job.encoder = EncoderSoftware
+		err = c.execFFmpeg(ctx, rule, job, src, dst)
+	}
+	return err
+}
+
+// execFFmpeg runs ffmpeg once for job.
+func (c *Copier) execFFmpeg(ctx context.Context, rule Rule, job *ffmpegJob, src, dst string) error {
+	args := job.args(src, dst)
+	cmd := exec.CommandContext(ctx, c.ffmpeg, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		return fmt.Errorf("ffmpeg on %s: %v: %s", filepath.Base(src), err, strings.TrimSpace(stderr.String()))
+	}
+	logDebugf("Rule %s: processed %s with %s %s", rule.Name, src, c.ffmpeg, strings.Join(args, " "))
+	return nil
+}
+
+// validateMedia checks that ffmpeg can be found when a rule needs it.
+func validateMedia(cfg *Config) ConfigErrors {
+	if !cfg.usesMedia() {
+		return nil
+	}
+	if _, err := exec.LookPath(cfg.ffmpegPath()); err != nil {
+		return ConfigErrors{fmt.Sprintf("ffmpeg %q is needed for video trimming, audio stripping, metadata, share copies, visual duplicates and merged pairs but was not found: %v", cfg.ffmpegPath(), err)}
+	}
+	return nil
+}
+
+// validateMetadata checks a rule's metadata settings.
+func validateMetadata(key func(string) string, m *MetadataOptions) ConfigErrors {
+	var problems ConfigErrors
+	if len(m.Tags) == 0 && m.Lookup == "" {
+		problems = append(problems, key("metadata")+" needs tags or a lookup file")
+	}
+	for name := range m.Tags {
+		if name == "" || strings.ContainsAny(name, "= \t\r\n") {
+			problems = append(problems, fmt.Sprintf("%s tag name %q must not be empty or contain spaces or \"=\"", key("metadata"), name))
+		}
+	}
+	if m.Lookup != "" {
+		if _, err := os.Stat(m.Lookup); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.lookup: %v", key("metadata"), err))
+		}
+	} else if m.LookupKey != "" {
+		problems = append(problems, key("metadata")+".lookup_key needs a lookup file")
+	}
+	return problems
+}
+
+// validateTrim checks a rule's trim settings.
+func validateTrim(key func(string) string, t *TrimOptions) ConfigErrors {
+	var problems ConfigErrors
+	switch {
+	case t.Last < 0 || t.Start < 0 || t.End < 0:
+		problems = append(problems, key("trim")+" durations must not be negative")
+	case t.Last > 0 && (t.Start > 0 || t.End > 0):
+		problems = append(problems, key("trim")+".last cannot be combined with start and end")
+	case t.Last == 0 && t.Start == 0 && t.End == 0:
+		problems = append(problems, key("trim")+" needs last, start or end")
+	case t.End > 0 && t.End <= t.Start:
+		problems = append(problems, key("trim")+".end must be after start")
+	}
+	return problems
+}
@@ -0,0 +1,263 @@
+package foldermonitor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics defaults.
+const (
+	defaultMetricsInterval = time.Minute
+	defaultMetricsPrefix   = "foldermonitor"
+	metricsTimeout         = 10 * time.Second
+)
+
+// MetricsConfig pushes throughput and failure metrics to InfluxDB or
+// Graphite on an interval, for sites that cannot accept inbound
+// connections for scraping. Counters run from the start of the service.
+type MetricsConfig struct {
+	// Format is "influx" to POST line protocol to URL, or "graphite" to
+	// send plaintext lines to Addr.
+	Format string `json:"format"`
+	// URL is the InfluxDB write endpoint, such as
+	// "http://influx:8086/write?db=swing" for 1.x or
+	// "http://influx:8086/api/v2/write?org=club&bucket=swing" for 2.x.
+	// Token, when set, is sent as "Authorization: Token <token>".
+	URL   string `json:"url,omitempty"`
+	Token string `json:"token,omitempty"`
+	// Addr is the host:port of Graphite's plaintext listener, usually 2003.
+	Addr string `json:"addr,omitempty"`
+	// Prefix is the InfluxDB measurement and the first element of Graphite
+	// paths (default "foldermonitor").
+	Prefix string `json:"prefix,omitempty"`
+	// Interval is how often metrics are pushed (default 1m).
+	Interval Duration `json:"interval,omitempty"`
+}
+
+// ruleMetrics are the counters of one rule.
+type ruleMetrics struct {
+	copied, failed int64
+	bytes          int64
+	seconds        float64
+}
+
+// metricCounters counts copy outcomes per rule across reloads.
+type metricCounters struct {
+	mu    sync.Mutex
+	rules map[string]*ruleMetrics
+}
+
+// record is the bus consumer counting copied and failed files.
+func (m *metricCounters) record(e Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.rules == nil {
+		m.rules = make(map[string]*ruleMetrics)
+	}
+	r := m.rules[e.Rule.Name]
+	if r == nil {
+		r = &ruleMetrics{}
+		m.rules[e.Rule.Name] = r
+	}
+	if e.Kind == EventFailed {
+		r.failed++
+		return
+	}
+	r.copied++
+	r.bytes += e.Bytes
+	r.seconds += e.Duration.Seconds()
+}
+
+// metricSample is one set of values pushed together; rule is empty for
+// the values of the whole service.
+type metricSample struct {
+	rule   string
+	fields [][2]string // name and value, in order
+}
+
+// collect returns the current values, the service's first and then the
+// rules' sorted by name.
+func (m *metricCounters) collect(s StatusSnapshot) []metricSample {
+	samples := []metricSample{{fields: [][2]string{
+		{"in_flight", fmt.Sprint(len(s.InFlight))},
+		{"settling", fmt.Sprint(s.Settling)},
+		{"retrying", fmt.Sprint(s.Retrying)},
+		{"sources_down", fmt.Sprint(len(s.SourcesDown))},
+		{"late_files", fmt.Sprint(s.LateFiles)},
+		{"files_copied", fmt.Sprint(s.FilesCopied)},
+		{"files_failed", fmt.Sprint(s.FilesFailed)},
+		{"bytes_copied", fmt.Sprint(s.BytesCopied)},
+	}}}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.rules))
+	for name := range m.rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r := m.rules[name]
+		samples = append(samples, metricSample{rule: name, fields: [][2]string{
+			{"files_copied", fmt.Sprint(r.copied)},
+			{"files_failed", fmt.Sprint(r.failed)},
+			{"bytes_copied", fmt.Sprint(r.bytes)},
+			{"copy_seconds", fmt.Sprintf("%.3f", r.seconds)},
+		}})
+	}
+	return samples
+}
+
+// influxLines renders samples in InfluxDB line protocol.
+func influxLines(prefix, host string, samples []metricSample, t time.Time) []byte {
+	esc := strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+	var buf bytes.Buffer
+	for _, s := range samples {
+		buf.WriteString(esc.Replace(prefix) + ",host=" + esc.Replace(host))
+		if s.rule != "" {
+			buf.WriteString(",rule=" + esc.Replace(s.rule))
+		}
+		for i, f := range s.fields {
+			sep := ","
+			if i == 0 {
+				sep = " "
+			}
+			value := f[1]
+			if !strings.Contains(value, ".") {
+				value += "i"
+			}
+			buf.WriteString(sep + f[0] + "=" + value)
+		}
+		fmt.Fprintf(&buf, " %d\n", t.UnixNano())
+	}
+	return buf.Bytes()
+}
+
+// graphiteLines renders samples in Graphite's plaintext protocol, as
+// prefix.host.name or prefix.host.rules.rule.name.
+func graphiteLines(prefix, host string, samples []metricSample, t time.Time) []byte {
+	clean := strings.NewReplacer(".", "_", " ", "_", "/", "_", `\`, "_")
+	base := prefix + "." + clean.Replace(host)
+	var buf bytes.Buffer
+	for _, s := range samples {
+		path := base
+		if s.rule != "" {
+			path += ".rules." + clean.Replace(s.rule)
+		}
+		for _, f := range s.fields {
+			fmt.Fprintf(&buf, "%s.%s %s %d\n", path, f[0], f[1], t.Unix())
+		}
+	}
+	return buf.Bytes()
+}
+
+// metricsPusher sends the metrics of a monitor on an interval.
+type metricsPusher struct {
+	cfg      *MetricsConfig
+	counters *metricCounters
+	status   *statusTracker
+	failing  bool
+}
+
+// run pushes metrics until ctx is cancelled. Failures are logged when
+// pushing starts and stops failing, not on every interval.
+func (p *metricsPusher) run(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.Interval.or(defaultMetricsInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		err := p.push(ctx)
+		switch {
+		case err != nil && !p.failing && ctx.Err() == nil:
+			logEvent(CategoryGeneral, LevelWarning, "Metrics: push to %s failed: %v", p.target(), err)
+		case err == nil && p.failing:
+			logEvent(CategoryGeneral, LevelInfo, "Metrics: pushing to %s again", p.target())
+		}
+		p.failing = err != nil
+	}
+}
+
+// target names where metrics go, for log messages.
+func (p *metricsPusher) target() string {
+	if p.cfg.Format == "graphite" {
+		return p.cfg.Addr
+	}
+	return p.cfg.URL
+}
+
+// push sends the current values once.
+func (p *metricsPusher) push(ctx context.Context) error {
+	prefix := p.cfg.Prefix
+	if prefix == "" {
+		prefix = defaultMetricsPrefix
+	}
+	host, _ := os.Hostname()
+	now := time.Now()
+	samples := p.counters.collect(p.status.snapshot())
+	ctx, cancel := context.WithTimeout(ctx, metricsTimeout)
+	defer cancel()
+
+	if p.cfg.Format == "graphite" {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", p.cfg.Addr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		conn.SetDeadline(now.Add(metricsTimeout))
+		_, err = conn.Write(graphiteLines(prefix, host, samples, now))
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.URL, bytes.NewReader(influxLines(prefix, host, samples, now)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if p.cfg.Token != "" {
+		req.Header.Set("Authorization", "Token "+p.cfg.Token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// validateMetrics checks the metrics settings.
+func validateMetrics(c *MetricsConfig) ConfigErrors {
+	var problems ConfigErrors
+	switch c.Format {
+	case "influx":
+		if !strings.HasPrefix(c.URL, "http://") && !strings.HasPrefix(c.URL, "https://") {
+			problems = append(problems, "metrics.url must be an http:// or https:// InfluxDB write URL")
+		}
+	case "graphite":
+		if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+			problems = append(problems, fmt.Sprintf("metrics.addr %q must be host:port", c.Addr))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("metrics.format %q is not supported (want influx or graphite)", c.Format))
+	}
+	if c.Interval < 0 {
+		problems = append(problems, "metrics.interval must be a positive duration such as \"1m\"")
+	}
+	return problems
+}
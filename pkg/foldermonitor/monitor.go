@@ -0,0 +1,655 @@
+// Package foldermonitor watches source folders and copies new files to
+// their destinations, optionally compressing, encrypting and archiving them.
+// It also imports media from removable volumes, MTP/PTP cameras and HTTP
+// uploads. A Monitor runs all of this from a Config; Watcher and Copier
+// expose the detection and copy stages for programs that need only part
+// of it.
+package foldermonitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Logger receives the monitor's log messages. It matches the logger of
+// github.com/kardianos/service so the service log can be used directly.
+type Logger interface {
+	Error(v ...interface{}) error
+	Warning(v ...interface{}) error
+	Info(v ...interface{}) error
+	Errorf(format string, a ...interface{}) error
+	Warningf(format string, a ...interface{}) error
+	Infof(format string, a ...interface{}) error
+}
+
+// svcLogger is the package-wide logger; nil discards messages.
+var svcLogger Logger
+
+// SetLogger sets the logger used by every monitor in the process. A
+// Windows Event Log logger records messages under their category's event
+// IDs. Loggers without levels of their own record information and above,
+// unless log_levels or a rule's log_level say otherwise.
+func SetLogger(l Logger) {
+	if n, ok := l.(numberedLogger); ok {
+		l = eventIDLogger{Logger: l, n: n}
+	}
+	switch l.(type) {
+	case nil, *ConsoleLogger, *SyslogLogger:
+	default:
+		l = filteredLogger{Logger: l}
+	}
+	svcLogger = l
+}
+
+// Options configure a Monitor beyond its Config.
+type Options struct {
+	// ConfigPath is the file that changes made through the web UI, rule
+	// toggles and the like are saved to.
+	ConfigPath string
+	// Store keeps state across restarts. By default it is the folder
+	// containing ConfigPath.
+	Store *Store
+}
+
+// Monitor runs the rules of a Config until stopped, restarting them when
+// the configuration is reloaded or copying is paused and resumed.
+type Monitor struct {
+	mu         sync.Mutex
+	cancel     context.CancelFunc // stops the current monitoring goroutine
+	done       chan struct{}
+	config     *Config
+	configPath string
+	// local is the configuration before the remote document was applied;
+	// nil when no remote configuration is set. stopRemote ends its polling.
+	local      *Config
+	stopRemote context.CancelFunc
+	store      *Store
+	status     *statusTracker
+	ui         *http.Server
+	control    *http.Server
+	// rescan asks the running loop to look for files it has not copied.
+	rescan chan struct{}
+	syslog *SyslogLogger
+	subs   []subscription
+	// console is the state of the console session, for deferring heavy
+	// copies.
+	console atomic.Int32
+	// onBattery is set while the machine runs on battery.
+	onBattery atomic.Bool
+	// metrics counts copy outcomes per rule for the metrics pusher.
+	metrics metricCounters
+	// fleet holds the reports of other monitors when this one collects.
+	fleet fleetMembers
+	// copier is the current run's, for acting on its queued retries.
+	copier atomic.Pointer[Copier]
+	// paused stops all copying until resumed; catchUp makes the next loop
+	// copy files that arrived in the meantime.
+	paused  bool
+	catchUp bool
+}
+
+// subscription is a consumer registered with Monitor.Subscribe.
+type subscription struct {
+	fn    Consumer
+	kinds []EventKind
+}
+
+// New creates a monitor for cfg. It does nothing until started.
+func New(cfg *Config, opts Options) *Monitor {
+	store := opts.Store
+	if store == nil {
+		store = NewStore(filepath.Dir(opts.ConfigPath))
+	}
+	m := &Monitor{config: cfg, configPath: opts.ConfigPath, store: store, status: newStatusTracker(), rescan: make(chan struct{}, 1)}
+	if cfg.Remote != nil {
+		m.local = cfg
+		m.useCachedRemote()
+	}
+	return m
+}
+
+// Subscribe registers fn for pipeline events of the given kinds. It applies
+// from the next start or reload.
+func (m *Monitor) Subscribe(fn Consumer, kinds ...EventKind) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subs = append(m.subs, subscription{fn: fn, kinds: kinds})
+}
+
+// Start begins monitoring in the background and serves the web UI when an
+// address is configured.
+func (m *Monitor) Start() error {
+	if s := m.config.Syslog; s != nil {
+		l, err := NewSyslogLogger(s)
+		if err != nil {
+			if svcLogger != nil {
+				svcLogger.Errorf("Syslog: %v", err)
+			}
+		} else {
+			m.syslog = l
+			if svcLogger != nil {
+				svcLogger = TeeLogger(svcLogger, l)
+			} else {
+				svcLogger = l
+			}
+		}
+	}
+	logEvent(CategoryService, LevelInfo, "Service starting...")
+	if svcLogger != nil {
+		svcLogger = statusLogger{Logger: svcLogger, status: m.status}
+	}
+	// Pick up the central configuration before the rules start.
+	if m.local != nil {
+		m.syncRemote(context.Background(), false)
+		var ctx context.Context
+		ctx, m.stopRemote = context.WithCancel(context.Background())
+		go m.pollRemote(ctx)
+	}
+	m.mu.Lock()
+	if paused, since := m.store.Paused(); paused {
+		m.paused = true
+		m.status.setPaused(since)
+		logEvent(CategoryService, LevelInfo, "Copying paused since %s; run \"resume\" to continue", since.Format(time.RFC1123))
+	}
+	m.startLoop()
+	m.mu.Unlock()
+
+	// Serve the web UI when an address is configured.
+	if addr := m.config.UIAddr; addr != "" {
+		srv, base, err := StartUI(addr, m.config.UIAuth, UIHandler(m, m.configPath))
+		if err != nil {
+			if svcLogger != nil {
+				svcLogger.Errorf("Error starting web UI: %v", err)
+			}
+		} else {
+			m.ui = srv
+			if svcLogger != nil {
+				svcLogger.Infof("Web UI available at %s/", base)
+			}
+		}
+	}
+	// Serve the same API to local commands on the control channel.
+	if path := m.config.controlPath(m.configPath); path != "" {
+		srv, err := startControl(path, UIHandler(m, m.configPath))
+		if err != nil {
+			logEvent(CategoryService, LevelWarning, "Control channel %s: %v", path, err)
+		} else {
+			m.control = srv
+			logDebugf("Control channel listening on %s", path)
+		}
+	}
+	return nil
+}
+
+// Stop ends monitoring and shuts down the web UI.
+func (m *Monitor) Stop() error {
+	if m.stopRemote != nil {
+		m.stopRemote()
+	}
+	for _, srv := range []*http.Server{m.ui, m.control} {
+		if srv != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			srv.Shutdown(ctx)
+			cancel()
+		}
+	}
+	m.mu.Lock()
+	m.stopLoop()
+	m.mu.Unlock()
+	logEvent(CategoryService, LevelInfo, "Service stopped")
+	if m.syslog != nil {
+		m.syslog.Close()
+	}
+	return nil
+}
+
+// Run starts the monitor and blocks until ctx is cancelled, then stops it.
+// Monitoring that fails is restarted by the watchdog rather than ending
+// Run; it returns an error if the loop ends on its own anyway.
+func (m *Monitor) Run(ctx context.Context) error {
+	if err := m.Start(); err != nil {
+		return err
+	}
+	for {
+		done := m.Done()
+		select {
+		case <-ctx.Done():
+			return m.Stop()
+		case <-done:
+			// A reload replaces the loop; only a stop of the current one is fatal.
+			if m.Done() == done {
+				m.Stop()
+				return errors.New("monitoring stopped unexpectedly")
+			}
+		}
+	}
+}
+
+// startLoop starts folder monitoring in a new goroutine. While copying is
+// paused the goroutine only waits to be stopped. m.mu must be held.
+func (m *Monitor) startLoop() {
+	var ctx context.Context
+	ctx, m.cancel = context.WithCancel(context.Background())
+	m.done = make(chan struct{})
+	if m.paused {
+		go func(done chan<- struct{}) {
+			<-ctx.Done()
+			close(done)
+		}(m.done)
+		return
+	}
+	setLogLevels(m.config)
+	subs := append([]subscription(nil), m.subs...)
+	catchUp := ""
+	if m.catchUp {
+		catchUp = "paused"
+	}
+	go m.supervise(ctx, m.config, subs, catchUp, m.done)
+	m.catchUp = false
+}
+
+// stopLoop stops the monitoring goroutine and waits for it to exit. m.mu
+// must be held.
+func (m *Monitor) stopLoop() {
+	m.cancel()
+	<-m.done
+}
+
+// Reload restarts monitoring with a new configuration.
+func (m *Monitor) Reload(cfg *Config) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stopLoop()
+	m.config = cfg
+	m.startLoop()
+	logEvent(CategoryService, LevelInfo, "Configuration reloaded")
+}
+
+// Config returns the configuration in use.
+func (m *Monitor) Config() *Config {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.config
+}
+
+// Store returns the store holding the monitor's persistent state.
+func (m *Monitor) Store() *Store {
+	return m.store
+}
+
+// Status returns a snapshot of what the monitor is doing.
+func (m *Monitor) Status() StatusSnapshot {
+	return m.status.snapshot()
+}
+
+// CancelTransfer aborts the in-flight copy with the given status ID, as
+// listed in Status, and reports whether there was one.
+func (m *Monitor) CancelTransfer(id int) bool {
+	return m.status.cancel(id)
+}
+
+// Done returns a channel closed when the current monitoring goroutine
+// exits; it changes when the configuration is reloaded.
+func (m *Monitor) Done() <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.done
+}
+
+// run contains the main logic for folder monitoring until ctx is
+// cancelled. subs are connected to the run's event bus; a catchUp reason,
+// such as "paused", first copies files that arrived in the watched folders
+// meanwhile. It returns why monitoring ended when it ended on its own.
+func (m *Monitor) run(ctx context.Context, cfg *Config, subs []subscription, catchUp string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	copier := newCopier(cfg, m.store, m.status)
+	defer copier.Close()
+	copier.console = &m.console
+	copier.onBattery = &m.onBattery
+	m.copier.Store(copier)
+	defer m.copier.CompareAndSwap(copier, nil)
+	for _, s := range subs {
+		copier.bus.Subscribe(s.fn, s.kinds...)
+	}
+	if copier.keyErr != nil && svcLogger != nil {
+		svcLogger.Errorf("Encryption key unavailable, encrypting rules will not start: %v", copier.keyErr)
+	}
+
+	// Publish outcomes and health to MQTT.
+	if cfg.MQTT != nil {
+		pub, err := startMQTT(cfg.MQTT)
+		if err != nil {
+			if svcLogger != nil {
+				svcLogger.Errorf("MQTT: %v", err)
+			}
+		} else {
+			defer pub.close()
+			copier.bus.Subscribe(pub.publishEvent, EventCopied, EventFailed, EventLate)
+			go pub.publishHealth(ctx, func(ctx context.Context) HealthReport {
+				return m.health(ctx, cfg, 0)
+			})
+		}
+	}
+
+	// Push metrics to InfluxDB or Graphite, and write the status file.
+	if cfg.Metrics != nil || cfg.StatusFile != nil || cfg.Fleet != nil {
+		copier.bus.Subscribe(m.metrics.record, EventCopied, EventFailed)
+	}
+	if cfg.Metrics != nil {
+		p := &metricsPusher{cfg: cfg.Metrics, counters: &m.metrics, status: m.status}
+		go p.run(ctx)
+	}
+	if cfg.StatusFile != nil {
+		w := &statusFileWriter{cfg: cfg.StatusFile, counters: &m.metrics, status: m.status, store: m.store}
+		go w.run(ctx)
+	}
+
+	// Report to the fleet collector, or collect the fleet's reports.
+	if cfg.Fleet != nil {
+		if r, err := newFleetReporter(m, cfg); err != nil {
+			logEvent(CategoryHealth, LevelError, "Fleet: %v", err)
+		} else {
+			go r.run(ctx)
+		}
+		if cfg.Fleet.Collect {
+			go m.fleet.watch(ctx, cfg.Fleet)
+		}
+	}
+
+	// Watch machine load if copies should be throttled.
+	if cfg.Throttle != nil {
+		if copier.load = startLoadMonitor(cfg.Throttle); copier.load != nil {
+			defer copier.load.Stop()
+		}
+	}
+
+	// Attach every rule, keyed by its source directory.
+	opts := WatcherOptions{
+		Canary:         cfg.HealthCanary,
+		HealthInterval: cfg.HealthInterval.or(defaultHealthInterval),
+		QuietPeriod:    cfg.QuietPeriod.or(defaultQuietPeriod),
+		Heartbeat:      m.status.heartbeat,
+	}
+	// A scan requested while one runs is kept, so files of a later burst
+	// are not missed.
+	overflowed := make(chan struct{}, 1)
+	opts.Overflow = func() {
+		select {
+		case overflowed <- struct{}{}:
+		default:
+		}
+	}
+	if c := cfg.Chaos; c != nil {
+		opts.ChaosError = c.WatcherError
+		logEvent(CategoryService, LevelWarning, "Chaos mode is on: injecting copy failures (%g), slow destinations (%g) and watcher errors (%g)",
+			c.CopyFailure, c.SlowDestination, c.WatcherError)
+	}
+	watcher, err := NewWatcher(copier.bus, opts)
+	if err != nil {
+		if svcLogger != nil {
+			svcLogger.Errorf("Error creating watcher: %v", err)
+		}
+		return fmt.Errorf("creating watcher: %w", err)
+	}
+	defer watcher.Close()
+	var watched []Rule
+	for _, rule := range cfg.ActiveRules() {
+		if !rule.IsEnabled() {
+			if svcLogger != nil {
+				svcLogger.Infof("Rule %s: disabled", rule.Name)
+			}
+			continue
+		}
+		if rule.Encrypt && copier.key == nil {
+			continue
+		}
+		// Ensure the destination directory exists.
+		if _, err := os.Stat(rule.DestDir); os.IsNotExist(err) {
+			if err = os.MkdirAll(rule.DestDir, os.ModePerm); err != nil {
+				if svcLogger != nil {
+					svcLogger.Errorf("Rule %s: error creating destination directory: %v", rule.Name, err)
+				}
+				continue
+			}
+		}
+		// Add the source directory to the watcher. One that is not
+		// available yet is attached by the watcher once it appears.
+		watched = append(watched, rule)
+		if err := watcher.Add(rule); err != nil {
+			logEvent(CategoryHealth, LevelWarning, "Rule %s: source directory %s unavailable, waiting for it: %v", rule.Name, rule.SourceDir, err)
+			continue
+		}
+		if svcLogger != nil {
+			svcLogger.Infof("Rule %s: monitoring directory %s", rule.Name, rule.SourceDir)
+		}
+	}
+	// Import removable media matching the volume rules.
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	if len(cfg.Volumes) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			watchVolumes(ctx, cfg, copier)
+		}()
+	}
+	// Follow the power source if large copies wait for AC power.
+	if p := cfg.Power; p != nil && p.DeferOnBattery {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.watchPower(ctx, p, copier)
+		}()
+	}
+	// Check the drives of the source folders.
+	if len(watched) > 0 {
+		d := newSourceDrives(cfg.SourceDrives, watched, m.status)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.run(ctx)
+		}()
+	}
+	// Move old copies to cold storage.
+	var tiered []Rule
+	for _, r := range watched {
+		if r.Cold != nil {
+			tiered = append(tiered, r)
+		}
+	}
+	if len(tiered) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			copier.tierCold(ctx, tiered)
+		}()
+	}
+	// Roll up each month of copies into an archive.
+	var rollups []Rule
+	for _, r := range watched {
+		if r.Rollup != nil {
+			rollups = append(rollups, r)
+		}
+	}
+	if len(rollups) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			copier.rollUp(ctx, rollups)
+		}()
+	}
+	// Fail over to secondary destinations while primaries are down.
+	var failover []Rule
+	for _, r := range watched {
+		if r.Failover != nil {
+			failover = append(failover, r)
+		}
+	}
+	if len(failover) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			copier.watchFailover(ctx, failover)
+		}()
+	}
+	// Import from cameras connected over MTP/PTP.
+	if len(cfg.Devices) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			watchDevices(ctx, cfg, copier)
+		}()
+	}
+	// Accept uploads from mobile devices.
+	var uploading bool
+	if u := cfg.Upload; cfg.uploadEnabled() && (!u.Encrypt || copier.key != nil) {
+		stop, err := startUploads(ctx, u, copier)
+		if err != nil {
+			if svcLogger != nil {
+				svcLogger.Errorf("Upload %s: cannot listen on %s: %v", u.Name, u.Addr, err)
+			}
+		} else {
+			uploading = true
+			defer stop()
+		}
+	}
+	// Serve the archive to the lounge TV and tablets.
+	if cfg.Gallery != nil {
+		stop, err := startGallery(ctx, cfg, copier.store)
+		if err != nil {
+			if svcLogger != nil {
+				svcLogger.Errorf("Gallery: cannot listen on %s: %v", cfg.Gallery.Addr, err)
+			}
+		} else {
+			defer stop()
+		}
+	}
+	// A fleet collector may only collect.
+	collectOnly := cfg.Fleet != nil && cfg.Fleet.Collect && len(cfg.ActiveRules()) == 0
+	if len(watched) == 0 && len(cfg.Volumes) == 0 && len(cfg.Devices) == 0 && !uploading && !collectOnly {
+		logEvent(CategoryService, LevelError, "No rules could be started")
+		return errors.New("no rules could be started")
+	}
+	m.status.setWatching(watcher.Dirs())
+	m.status.loopRunning()
+	defer m.status.setWatching(nil)
+	copier.resumeRetries(ctx, watched)
+
+	if catchUp != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			copier.copyArrivals(ctx, watched, catchUp)
+		}()
+	}
+
+	// Rescan the sources for files whose events the OS dropped, once the
+	// burst has had time to settle, and reconcile them on schedule.
+	var scheduled <-chan time.Time
+	if d := time.Duration(cfg.ReconcileInterval); d > 0 {
+		ticker := time.NewTicker(d)
+		defer ticker.Stop()
+		scheduled = ticker.C
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-overflowed:
+			case <-scheduled:
+				copier.reconcileWatched(ctx, watched)
+				continue
+			case <-m.rescan:
+				logEvent(CategoryHealth, LevelInfo, "Rescanning the source folders on request")
+				copier.copyArrivals(ctx, watched, "a rescan was requested")
+				continue
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case <-time.After(4 * opts.QuietPeriod):
+			case <-ctx.Done():
+				return
+			}
+			logEvent(CategoryHealth, LevelInfo, "Rescanning the source folders for files whose events were dropped")
+			copier.copyArrivals(ctx, watched, "watch events were dropped")
+		}
+	}()
+
+	// Deliver settled files from the watched folders. Copies run on this
+	// goroutine, so a panic in one ends the loop for the watchdog to
+	// restart instead of the process.
+	failed := make(chan error, 1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer func() {
+			if v := recover(); v != nil {
+				failed <- loopPanic(v)
+				cancel()
+			}
+		}()
+		if err := watcher.Run(ctx); err != nil {
+			if svcLogger != nil {
+				svcLogger.Errorf("Watcher stopped: %v", err)
+			}
+			failed <- fmt.Errorf("watcher stopped: %w", err)
+			cancel()
+		}
+	}()
+
+	// Seal finished archive sessions now and then once a minute.
+	sealArchives := func() {
+		for _, r := range watched {
+			if r.Archive != "" {
+				SealSessions(r, time.Now())
+			}
+		}
+		for _, v := range cfg.Volumes {
+			if v.Archive != "" {
+				SealSessions(v.asRule(""), time.Now())
+			}
+		}
+		for _, d := range cfg.Devices {
+			if d.Archive != "" {
+				SealSessions(d.asRule(""), time.Now())
+			}
+		}
+		if cfg.Upload != nil && cfg.Upload.Archive != "" {
+			SealSessions(cfg.Upload.asRule(""), time.Now())
+		}
+	}
+	sealArchives()
+	archiveTicker := time.NewTicker(time.Minute)
+	defer archiveTicker.Stop()
+
+	for {
+		select {
+		case <-archiveTicker.C:
+			sealArchives()
+		case <-ctx.Done():
+			select {
+			case err := <-failed:
+				return err
+			default:
+			}
+			if svcLogger != nil {
+				svcLogger.Info("Service stopping...")
+			}
+			return nil
+		}
+	}
+}
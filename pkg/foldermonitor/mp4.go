@@ -0,0 +1,82 @@
+package foldermonitor
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// errNoDuration is returned for videos whose movie header cannot be found.
+var errNoDuration = errors.New("no movie header")
+
+// videoDuration reads the duration of an MP4, M4V or MOV file from the
+// movie header (mvhd) inside its moov box, without ffmpeg.
+func videoDuration(path string) (time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	moov, size, err := findBox(f, 0, info.Size(), "moov")
+	if err != nil {
+		return 0, err
+	}
+	mvhd, size, err := findBox(f, moov, moov+size, "mvhd")
+	if err != nil {
+		return 0, err
+	}
+	header := make([]byte, 32)
+	if size < 32 {
+		return 0, errNoDuration
+	}
+	if _, err := f.ReadAt(header, mvhd); err != nil {
+		return 0, err
+	}
+	var timescale, units uint64
+	if header[0] == 1 {
+		timescale = uint64(binary.BigEndian.Uint32(header[20:24]))
+		units = binary.BigEndian.Uint64(header[24:32])
+	} else {
+		timescale = uint64(binary.BigEndian.Uint32(header[12:16]))
+		units = uint64(binary.BigEndian.Uint32(header[16:20]))
+	}
+	if timescale == 0 {
+		return 0, errNoDuration
+	}
+	return time.Duration(float64(units) / float64(timescale) * float64(time.Second)), nil
+}
+
+// findBox returns the offset and size of the payload of the first box of
+// the given type between start and end.
+func findBox(r io.ReaderAt, start, end int64, kind string) (int64, int64, error) {
+	head := make([]byte, 16)
+	for off := start; off+8 <= end; {
+		if _, err := r.ReadAt(head[:8], off); err != nil {
+			return 0, 0, err
+		}
+		size, hdr := int64(binary.BigEndian.Uint32(head[:4])), int64(8)
+		switch size {
+		case 0: // extends to the end
+			size = end - off
+		case 1: // 64-bit size follows the type
+			if _, err := r.ReadAt(head[8:16], off+8); err != nil {
+				return 0, 0, err
+			}
+			size, hdr = int64(binary.BigEndian.Uint64(head[8:16])), 16
+		}
+		if size < hdr || off+size > end {
+			break
+		}
+		if string(head[4:8]) == kind {
+			return off + hdr, size - hdr, nil
+		}
+		off += size
+	}
+	return 0, 0, errNoDuration
+}
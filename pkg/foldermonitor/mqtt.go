@@ -0,0 +1,278 @@
+package foldermonitor
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// MQTT defaults.
+const (
+	defaultMQTTTopic          = "foldermonitor/{host}/{event}"
+	defaultMQTTHealthInterval = time.Minute
+	mqttConnectTimeout        = 10 * time.Second
+)
+
+// MQTTConfig publishes copy outcomes and health reports to an MQTT broker,
+// for facility automation such as Home Assistant or Node-RED.
+type MQTTConfig struct {
+	// Broker is the broker URL: "tcp://host:1883", "ssl://host:8883" for
+	// TLS, or "ws://" and "wss://" for WebSockets.
+	Broker   string `json:"broker"`
+	ClientID string `json:"client_id,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Topic is the topic template. {host}, {rule} and {event} are replaced
+	// by the machine name, the rule name and copied, failed, late or health
+	// (default "foldermonitor/{host}/{event}"). Health reports use an empty
+	// rule.
+	Topic string `json:"topic,omitempty"`
+	// QoS is the MQTT quality of service, 0, 1 or 2 (default 0).
+	QoS byte `json:"qos,omitempty"`
+	// Retain asks the broker to keep the last message of each topic.
+	Retain bool `json:"retain,omitempty"`
+	// Events limits the published events to these of copied, failed, late
+	// (over the latency SLO) and health; all are published when it is empty.
+	Events []string `json:"events,omitempty"`
+	// HealthInterval is how often a health report is published (default 1m).
+	HealthInterval Duration `json:"health_interval,omitempty"`
+	// CAFile verifies the broker against this PEM bundle instead of the
+	// system roots; CertFile and KeyFile authenticate with a client
+	// certificate.
+	CAFile   string `json:"ca_file,omitempty"`
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	// InsecureSkipVerify accepts any broker certificate.
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+	// RateLimit collapses repeated failed and late messages of a rule.
+	RateLimit *RateLimit `json:"rate_limit,omitempty"`
+}
+
+// wants reports whether events of the given name are published.
+func (c *MQTTConfig) wants(event string) bool {
+	if len(c.Events) == 0 {
+		return true
+	}
+	for _, e := range c.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// topic expands the topic template for rule and event.
+func (c *MQTTConfig) topic(rule, event string) string {
+	t := c.Topic
+	if t == "" {
+		t = defaultMQTTTopic
+	}
+	host, _ := os.Hostname()
+	t = strings.NewReplacer("{host}", host, "{rule}", rule, "{event}", event).Replace(t)
+	// An empty rule must not leave an empty topic level behind.
+	for strings.Contains(t, "//") {
+		t = strings.ReplaceAll(t, "//", "/")
+	}
+	return strings.Trim(t, "/")
+}
+
+// tlsConfig returns the TLS settings for the broker connection.
+func (c *MQTTConfig) tlsConfig() (*tls.Config, error) {
+	tc := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s contains no certificates", c.CAFile)
+		}
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
+
+// mqttEvent is the payload of a copied, failed or late message.
+type mqttEvent struct {
+	Event    string    `json:"event"`
+	Time     time.Time `json:"time"`
+	Rule     string    `json:"rule"`
+	Source   string    `json:"source"`
+	Dest     string    `json:"dest,omitempty"`
+	Bytes    int64     `json:"bytes,omitempty"`
+	SHA256   string    `json:"sha256,omitempty"`
+	Duration float64   `json:"duration_seconds,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Class    string    `json:"class,omitempty"`
+	Attempt  int       `json:"attempt,omitempty"`
+	Latency  float64   `json:"latency_seconds,omitempty"`
+	// Repeated is set on a summary of collapsed messages to the number of
+	// times the problem occurred.
+	Repeated int `json:"repeated,omitempty"`
+}
+
+// mqttPublisher sends events of one monitoring run to the broker.
+type mqttPublisher struct {
+	cfg    *MQTTConfig
+	client mqtt.Client
+	limit  *rateLimiter // nil unless repeats are collapsed
+}
+
+// startMQTT connects to the broker in the background. The client keeps
+// reconnecting while the broker is down; messages published meanwhile are
+// dropped at QoS 0 and queued in memory otherwise.
+func startMQTT(cfg *MQTTConfig) (*mqttPublisher, error) {
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetConnectTimeout(mqttConnectTimeout).
+		SetAutoReconnect(true).
+		SetConnectRetry(true)
+	id := cfg.ClientID
+	if id == "" {
+		host, _ := os.Hostname()
+		id = "foldermonitor-" + host
+	}
+	opts.SetClientID(id)
+	if b := strings.ToLower(cfg.Broker); strings.HasPrefix(b, "ssl://") || strings.HasPrefix(b, "tls://") ||
+		strings.HasPrefix(b, "mqtts://") || strings.HasPrefix(b, "wss://") {
+		tc, err := cfg.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tc)
+	}
+	opts.SetOnConnectHandler(func(mqtt.Client) {
+		if svcLogger != nil {
+			svcLogger.Infof("MQTT: connected to %s", cfg.Broker)
+		}
+	})
+	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		if svcLogger != nil {
+			svcLogger.Warningf("MQTT: connection to %s lost: %v", cfg.Broker, err)
+		}
+	})
+	p := &mqttPublisher{cfg: cfg, client: mqtt.NewClient(opts)}
+	p.limit = newRateLimiter(cfg.RateLimit, func(sample interface{}, count int, window time.Duration) {
+		msg := sample.(mqttEvent)
+		msg.Repeated, msg.Time = count, time.Now()
+		msg.Error += repeatedSuffix(count, window)
+		p.publish(msg.Rule, msg.Event, msg)
+	})
+	p.client.Connect()
+	return p, nil
+}
+
+// publish sends v as JSON to the topic for rule and event without waiting
+// for the broker, so a slow broker never holds up copying.
+func (p *mqttPublisher) publish(rule, event string, v interface{}) {
+	if !p.cfg.wants(event) {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	p.client.Publish(p.cfg.topic(rule, event), p.cfg.QoS, p.cfg.Retain, data)
+}
+
+// publishEvent is the bus consumer for copy outcomes.
+func (p *mqttPublisher) publishEvent(e Event) {
+	if e.Class == ClassCanceled {
+		return
+	}
+	msg := mqttEvent{
+		Event:    string(e.Kind),
+		Time:     e.Time,
+		Rule:     e.Rule.Name,
+		Source:   e.Source,
+		Dest:     e.Dest,
+		Bytes:    e.Bytes,
+		SHA256:   e.SHA256,
+		Duration: e.Duration.Seconds(),
+		Attempt:  e.Attempt,
+		Latency:  e.Latency.Seconds(),
+	}
+	if e.Err != nil {
+		msg.Error = e.Err.Error()
+		msg.Class = string(e.Class)
+	}
+	if e.Kind != EventCopied && !p.limit.allow(e.Rule.Name+"|"+string(e.Kind)+"|"+notificationKey(msg.Error), msg) {
+		return
+	}
+	p.publish(e.Rule.Name, string(e.Kind), msg)
+}
+
+// publishHealth sends a health report every interval until ctx is
+// cancelled.
+func (p *mqttPublisher) publishHealth(ctx context.Context, report func(context.Context) HealthReport) {
+	if !p.cfg.wants("health") {
+		return
+	}
+	ticker := time.NewTicker(p.cfg.HealthInterval.or(defaultMQTTHealthInterval))
+	defer ticker.Stop()
+	for {
+		p.publish("", "health", report(ctx))
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// close disconnects after giving queued messages a moment to go out.
+func (p *mqttPublisher) close() {
+	p.limit.close()
+	p.client.Disconnect(250)
+}
+
+// validateMQTT checks the MQTT settings.
+func validateMQTT(c *MQTTConfig) ConfigErrors {
+	var problems ConfigErrors
+	scheme, _, ok := strings.Cut(c.Broker, "://")
+	switch strings.ToLower(scheme) {
+	case "tcp", "mqtt", "ssl", "tls", "mqtts", "ws", "wss":
+	default:
+		ok = false
+	}
+	if !ok {
+		problems = append(problems, fmt.Sprintf("mqtt.broker %q must be a URL such as tcp://host:1883 or ssl://host:8883", c.Broker))
+	}
+	if c.QoS > 2 {
+		problems = append(problems, fmt.Sprintf("mqtt.qos %d is not supported (want 0, 1 or 2)", c.QoS))
+	}
+	for _, e := range c.Events {
+		if e != string(EventCopied) && e != string(EventFailed) && e != string(EventLate) && e != "health" {
+			problems = append(problems, fmt.Sprintf("mqtt.events %q is not supported (want copied, failed, late or health)", e))
+		}
+	}
+	if t := c.Topic; strings.ContainsAny(t, "+#") {
+		problems = append(problems, fmt.Sprintf("mqtt.topic %q must not contain wildcards", t))
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		problems = append(problems, "mqtt.cert_file and mqtt.key_file must be set together")
+	}
+	if c.HealthInterval < 0 {
+		problems = append(problems, "mqtt.health_interval must be a positive duration")
+	}
+	if c.RateLimit != nil {
+		problems = append(problems, validateRateLimit("mqtt.rate_limit", c.RateLimit)...)
+	}
+	return problems
+}
@@ -0,0 +1,213 @@
+package foldermonitor
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultDevicePollInterval is how often connected cameras are listed.
+const defaultDevicePollInterval = 10 * time.Second
+
+// DeviceRule imports media from cameras and phones connected over MTP or
+// PTP, where the storage is not mounted as a filesystem.
+type DeviceRule struct {
+	Name string `json:"name"`
+	// Match is a case-insensitive wildcard pattern for the device name or
+	// model as reported by the OS (e.g. "Canon EOS*"); empty matches all.
+	Match string `json:"match,omitempty"`
+	// Folder limits the import to device paths containing this folder
+	// (default "DCIM").
+	Folder  string `json:"folder,omitempty"`
+	DestDir string `json:"dest_dir"`
+	RuleOptions
+}
+
+// mtpDevice is a connected camera or phone.
+type mtpDevice struct {
+	ID   string // stable identifier used by the backend (port or shell path)
+	Name string
+}
+
+// mtpFile is a file on a device's storage.
+type mtpFile struct {
+	Folder string `json:"folder"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Handle string `json:"handle"` // backend-specific reference used to fetch it
+}
+
+// key identifies the file for the imported-files index.
+func (f mtpFile) key() string {
+	return f.Folder + "/" + f.Name
+}
+
+// matches reports whether the rule applies to d.
+func (r DeviceRule) matches(d mtpDevice) bool {
+	if r.Match == "" {
+		return true
+	}
+	ok, _ := filepath.Match(strings.ToLower(r.Match), strings.ToLower(d.Name))
+	return ok
+}
+
+// asRule returns the equivalent folder rule for importing from src.
+func (r DeviceRule) asRule(src string) Rule {
+	return Rule{Name: r.Name, SourceDir: src, DestDir: r.DestDir, RuleOptions: r.RuleOptions}
+}
+
+// wants reports whether f is below the rule's folder.
+func (r DeviceRule) wants(f mtpFile) bool {
+	folder := r.Folder
+	if folder == "" {
+		folder = "DCIM"
+	}
+	for _, part := range strings.Split(strings.ReplaceAll(f.Folder, `\`, "/"), "/") {
+		if strings.EqualFold(part, folder) {
+			return true
+		}
+	}
+	return false
+}
+
+// watchDevices polls for connected MTP/PTP devices until ctx is cancelled
+// and imports new files from each matching device when it is connected.
+func watchDevices(ctx context.Context, cfg *Config, c *Copier) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ticker := time.NewTicker(cfg.DevicePollInterval.or(defaultDevicePollInterval))
+	defer ticker.Stop()
+
+	seen := make(map[string]bool)
+	for {
+		devices, err := listMTPDevices(ctx)
+		if err != nil {
+			logDebugf("Listing MTP/PTP devices: %v", err)
+		}
+		current := make(map[string]bool)
+		for _, d := range devices {
+			current[d.ID] = true
+			if seen[d.ID] {
+				continue
+			}
+			logDebugf("Device connected: %s (%s)", d.Name, d.ID)
+			for _, rule := range cfg.Devices {
+				if !rule.IsEnabled() || !rule.matches(d) || (rule.Encrypt && c.key == nil) {
+					continue
+				}
+				wg.Add(1)
+				go func(rule DeviceRule, d mtpDevice) {
+					defer wg.Done()
+					importDevice(ctx, rule, d, c)
+				}(rule, d)
+				break
+			}
+		}
+		seen = current
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// importDevice downloads files from d that were not imported before into
+// a temporary folder and archives them through the rule.
+func importDevice(ctx context.Context, rule DeviceRule, d mtpDevice, c *Copier) {
+	files, err := listMTPFiles(ctx, d)
+	if err != nil {
+		if svcLogger != nil {
+			svcLogger.Errorf("Device %s: cannot list files on %s: %v", rule.Name, d.Name, err)
+		}
+		return
+	}
+	index := loadDeviceIndex(c.store, rule.Name, d)
+	tmp, err := os.MkdirTemp("", "foldermonitor-mtp-")
+	if err != nil {
+		if svcLogger != nil {
+			svcLogger.Errorf("Device %s: %v", rule.Name, err)
+		}
+		return
+	}
+	defer os.RemoveAll(tmp)
+
+	target := rule.asRule(d.Name)
+	var copied, failed int
+	for _, f := range files {
+		if ctx.Err() != nil {
+			return
+		}
+		if !rule.wants(f) || rule.excluded(f.key()) || index.has(f) {
+			continue
+		}
+		if copied+failed == 0 && svcLogger != nil {
+			svcLogger.Infof("Device %s: importing new media from %s", rule.Name, d.Name)
+		}
+		local := filepath.Join(tmp, f.Name)
+		if err := fetchMTPFile(ctx, d, f, local); err != nil {
+			failed++
+			if svcLogger != nil {
+				svcLogger.Errorf("Device %s: cannot download %s: %v", rule.Name, f.key(), err)
+			}
+			continue
+		}
+		info, err := os.Stat(local)
+		if err == nil {
+			err = c.archiveFile(ctx, target, local, info, filepath.Base(f.Folder))
+		}
+		os.Remove(local)
+		if err != nil {
+			failed++
+			continue
+		}
+		copied++
+		index.add(f)
+	}
+	if copied+failed > 0 && svcLogger != nil {
+		svcLogger.Infof("Device %s: import finished, %d copied, %d failed", rule.Name, copied, failed)
+	}
+}
+
+// deviceIndex remembers which files were imported from one device, so
+// reconnecting the same camera only imports new media.
+type deviceIndex struct {
+	path  string
+	Files map[string]int64 `json:"files"`
+}
+
+// nonWord matches characters that are unsafe in index file names.
+var nonWord = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+func loadDeviceIndex(store *Store, rule string, d mtpDevice) *deviceIndex {
+	name := nonWord.ReplaceAllString(rule+"-"+d.Name, "_") + ".json"
+	idx := &deviceIndex{path: store.path(deviceIndexDir, name), Files: make(map[string]int64)}
+	if data, err := os.ReadFile(idx.path); err == nil {
+		json.Unmarshal(data, idx)
+	}
+	return idx
+}
+
+func (i *deviceIndex) has(f mtpFile) bool {
+	size, ok := i.Files[f.key()]
+	return ok && (f.Size == 0 || size == f.Size)
+}
+
+// add records f and saves the index immediately so an interrupted import
+// resumes where it stopped.
+func (i *deviceIndex) add(f mtpFile) {
+	i.Files[f.key()] = f.Size
+	data, err := json.MarshalIndent(i, "", "  ")
+	if err != nil {
+		return
+	}
+	os.MkdirAll(filepath.Dir(i.path), os.ModePerm)
+	if err := os.WriteFile(i.path, data, 0644); err != nil && svcLogger != nil {
+		svcLogger.Errorf("Error saving device index: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+//go:build !windows
+
+package foldermonitor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// On Unix MTP/PTP access goes through the gphoto2 command-line tool, which
+// supports practically every camera and most phones in PTP mode.
+
+var (
+	gphotoFolderLine = regexp.MustCompile(`in folder '([^']*)'`)
+	gphotoFileLine   = regexp.MustCompile(`^#(\d+)\s+(\S+)\s+\S*\s+(\d+)\s*KB`)
+)
+
+// gphoto2 runs the tool and returns its standard output.
+func gphoto2(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "gphoto2", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("gphoto2 %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// listMTPDevices parses "gphoto2 --auto-detect".
+func listMTPDevices(ctx context.Context) ([]mtpDevice, error) {
+	if _, err := exec.LookPath("gphoto2"); err != nil {
+		return nil, fmt.Errorf("gphoto2 is not installed")
+	}
+	out, err := gphoto2(ctx, "--auto-detect")
+	if err != nil {
+		return nil, err
+	}
+	var devices []mtpDevice
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		i := strings.LastIndex(line, "usb:")
+		if i < 0 {
+			continue
+		}
+		devices = append(devices, mtpDevice{ID: strings.TrimSpace(line[i:]), Name: strings.TrimSpace(line[:i])})
+	}
+	return devices, nil
+}
+
+// listMTPFiles parses "gphoto2 --list-files". Sizes are only reported in
+// KB, so they are approximate.
+func listMTPFiles(ctx context.Context, d mtpDevice) ([]mtpFile, error) {
+	out, err := gphoto2(ctx, "--port", d.ID, "--list-files")
+	if err != nil {
+		return nil, err
+	}
+	var files []mtpFile
+	folder := ""
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := gphotoFolderLine.FindStringSubmatch(line); m != nil {
+			folder = m[1]
+			continue
+		}
+		if m := gphotoFileLine.FindStringSubmatch(line); m != nil {
+			kb, _ := strconv.ParseInt(m[3], 10, 64)
+			files = append(files, mtpFile{Folder: folder, Name: m[2], Size: kb * 1024, Handle: m[1]})
+		}
+	}
+	return files, scanner.Err()
+}
+
+// fetchMTPFile downloads f to local.
+func fetchMTPFile(ctx context.Context, d mtpDevice, f mtpFile, local string) error {
+	_, err := gphoto2(ctx, "--port", d.ID, "--folder", f.Folder, "--get-file", f.Handle, "--filename", local, "--force-overwrite")
+	return err
+}
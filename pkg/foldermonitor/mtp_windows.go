@@ -0,0 +1,127 @@
+package foldermonitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// On Windows MTP/PTP devices are reached through the Shell namespace
+// (the portable devices shown under "This PC"), scripted with PowerShell.
+
+// shellPrelude defines helpers shared by the scripts below.
+const shellPrelude = `
+$ErrorActionPreference = 'Stop'
+$shell = New-Object -ComObject Shell.Application
+function Devices { $shell.Namespace(17).Items() | Where-Object { -not $_.IsFileSystem -and $_.IsFolder } }
+function Resolve($parts) {
+  $item = Devices | Where-Object { $_.Path -eq $parts[0] } | Select-Object -First 1
+  foreach ($p in $parts[1..($parts.Count-1)]) {
+    if (-not $p) { continue }
+    $item = $item.GetFolder.Items() | Where-Object { $_.Name -eq $p } | Select-Object -First 1
+  }
+  $item
+}
+`
+
+// powershell runs script with the Shell helpers defined and decodes its
+// JSON output into v.
+func powershell(ctx context.Context, script string, v interface{}) error {
+	return runPowerShell(ctx, shellPrelude+script, v)
+}
+
+// runPowerShell runs script and decodes its JSON output into v, which must
+// point to a slice unless it is nil.
+func runPowerShell(ctx context.Context, script string, v interface{}) error {
+	out, err := exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command", script).Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(ee.Stderr)))
+		}
+		return err
+	}
+	out = []byte(strings.TrimSpace(string(out)))
+	if v == nil || len(out) == 0 {
+		return nil
+	}
+	// ConvertTo-Json emits a bare object for single-element arrays.
+	if out[0] == '{' {
+		out = append(append([]byte{'['}, out...), ']')
+	}
+	return json.Unmarshal(out, v)
+}
+
+// psQuote quotes s as a PowerShell single-quoted string.
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// listMTPDevices lists portable devices in the Shell namespace.
+func listMTPDevices(ctx context.Context) ([]mtpDevice, error) {
+	var out []struct{ Path, Name string }
+	if err := powershell(ctx, `@(Devices | ForEach-Object { @{ Path = $_.Path; Name = $_.Name } }) | ConvertTo-Json -Compress`, &out); err != nil {
+		return nil, err
+	}
+	var devices []mtpDevice
+	for _, d := range out {
+		devices = append(devices, mtpDevice{ID: d.Path, Name: d.Name})
+	}
+	return devices, nil
+}
+
+// listMTPFiles walks the device's storage recursively.
+func listMTPFiles(ctx context.Context, d mtpDevice) ([]mtpFile, error) {
+	script := `
+function Walk($folder, $path) {
+  foreach ($i in $folder.Items()) {
+    if ($i.IsFolder) { Walk $i.GetFolder ($path + '/' + $i.Name) }
+    else { @{ folder = $path; name = $i.Name; size = [int64]$i.Size; handle = $path + '/' + $i.Name } }
+  }
+}
+$dev = Resolve @(` + psQuote(d.ID) + `)
+@(Walk $dev.GetFolder '') | ConvertTo-Json -Compress`
+	var files []mtpFile
+	if err := powershell(ctx, script, &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// fetchMTPFile copies f into local's folder with the Shell and waits for
+// the copy, which the Shell performs asynchronously, to complete.
+func fetchMTPFile(ctx context.Context, d mtpDevice, f mtpFile, local string) error {
+	parts := []string{psQuote(d.ID)}
+	for _, p := range strings.Split(strings.TrimPrefix(f.Handle, "/"), "/") {
+		parts = append(parts, psQuote(p))
+	}
+	script := fmt.Sprintf(`$item = Resolve @(%s)
+if (-not $item) { throw 'file not found on device' }
+$shell.Namespace(%s).CopyHere($item, 4 + 16 + 1024)`, strings.Join(parts, ","), psQuote(filepath.Dir(local)))
+	if err := powershell(ctx, script, nil); err != nil {
+		return err
+	}
+	copied := filepath.Join(filepath.Dir(local), f.Name)
+	deadline := time.Now().Add(10 * time.Minute)
+	var last int64 = -1
+	for time.Now().Before(deadline) {
+		if info, err := os.Stat(copied); err == nil && info.Size() == last && (f.Size == 0 || info.Size() >= f.Size) {
+			if copied != local {
+				return os.Rename(copied, local)
+			}
+			return nil
+		} else if err == nil {
+			last = info.Size()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+	return fmt.Errorf("timed out waiting for the device copy of %s", f.Name)
+}
@@ -0,0 +1,204 @@
+package foldermonitor
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envPrefix prefixes environment variables that override config fields,
+// e.g. FM_SOURCE_DIR overrides source_dir.
+const envPrefix = "FM_"
+
+// ConfigFieldNames returns the json names of the top-level Config fields
+// that can be overridden from the environment or command line. The layout
+// version belongs to the file and is not one of them.
+func ConfigFieldNames() []string {
+	var names []string
+	for _, f := range jsonFields(reflect.TypeOf(Config{})) {
+		if isScalarKind(f.typ) && f.name != "version" {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// fieldRef locates a json-visible field within a struct type.
+type fieldRef struct {
+	name  string
+	index []int
+	typ   reflect.Type
+}
+
+// jsonFields lists the fields of struct type t as encoding/json sees them,
+// flattening embedded structs without a json tag.
+func jsonFields(t reflect.Type) []fieldRef {
+	var fields []fieldRef
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && f.Tag.Get("json") == "" {
+			for _, sub := range jsonFields(f.Type) {
+				sub.index = append([]int{i}, sub.index...)
+				fields = append(fields, sub)
+			}
+			continue
+		}
+		if name := jsonName(f); name != "" {
+			fields = append(fields, fieldRef{name: name, index: []int{i}, typ: f.Type})
+		}
+	}
+	return fields
+}
+
+// jsonName returns the json key of a struct field, or "" if it is skipped.
+func jsonName(f reflect.StructField) string {
+	tag := strings.Split(f.Tag.Get("json"), ",")[0]
+	if tag == "-" || !f.IsExported() {
+		return ""
+	}
+	if tag == "" {
+		return f.Name
+	}
+	return tag
+}
+
+// isScalarKind reports whether setConfigValue knows how to parse type t.
+func isScalarKind(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Uint,
+		reflect.Uint64, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+// SetConfigField sets the Config field with json name key from the string s.
+// From version 2 on, source_dir, dest_dir and the rule options belong to
+// the rule named "default", which they are set on instead; the rule is
+// added when there is none, and its other fields are kept.
+func SetConfigField(cfg *Config, key, s string) error {
+	v := reflect.ValueOf(cfg).Elem()
+	if cfg.configVersion() >= 2 && key != "name" {
+		for _, f := range jsonFields(reflect.TypeOf(Rule{})) {
+			if f.name == key {
+				r := reflect.ValueOf(cfg.defaultRule()).Elem()
+				if err := setConfigValue(r.FieldByIndex(f.index), s); err != nil {
+					return fmt.Errorf("%s: %v", key, err)
+				}
+				return nil
+			}
+		}
+	}
+	for _, f := range jsonFields(v.Type()) {
+		if f.name == key {
+			if err := setConfigValue(v.FieldByIndex(f.index), s); err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown config field %q", key)
+}
+
+// setConfigValue parses s according to the kind of v and stores it.
+// Durations accept Go syntax ("30s"); string lists are comma separated.
+func setConfigValue(v reflect.Value, s string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) || v.Type() == reflect.TypeOf(Duration(0)) {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// envName returns the environment variable overriding config field key.
+func envName(key string) string {
+	return envPrefix + strings.ToUpper(key)
+}
+
+// flagName returns the command-line flag overriding config field key.
+func flagName(key string) string {
+	return strings.ReplaceAll(key, "_", "-")
+}
+
+// RegisterConfigFlags defines one string flag per overridable config field.
+func RegisterConfigFlags(fs *flag.FlagSet) {
+	for _, key := range ConfigFieldNames() {
+		fs.String(flagName(key), "", fmt.Sprintf("Override config field %s (env %s)", key, envName(key)))
+	}
+}
+
+// ApplyOverrides applies environment variables and then explicitly set
+// command-line flags on top of cfg, so flags take precedence over the
+// environment, which takes precedence over the config file. Overridden
+// source_dir, dest_dir and rule options apply to the default rule.
+func ApplyOverrides(cfg *Config, fs *flag.FlagSet) error {
+	upgradeConfig(cfg)
+	for _, key := range ConfigFieldNames() {
+		if s, ok := os.LookupEnv(envName(key)); ok {
+			if err := SetConfigField(cfg, key, s); err != nil {
+				return fmt.Errorf("%s: %v", envName(key), err)
+			}
+		}
+	}
+	keys := make(map[string]string)
+	for _, key := range ConfigFieldNames() {
+		keys[flagName(key)] = key
+	}
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if key, ok := keys[f.Name]; ok && err == nil {
+			if e := SetConfigField(cfg, key, f.Value.String()); e != nil {
+				err = fmt.Errorf("-%s: %v", f.Name, e)
+			}
+		}
+	})
+	return err
+}
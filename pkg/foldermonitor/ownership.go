@@ -0,0 +1,129 @@
+package foldermonitor
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// OwnershipOptions set the permissions and owner of copies and of the
+// destination folders created for them. Each part is left alone when
+// unset.
+type OwnershipOptions struct {
+	// FileMode and DirMode are octal permission bits, e.g. "0640" and
+	// "0750".
+	FileMode string `json:"file_mode,omitempty"`
+	DirMode  string `json:"dir_mode,omitempty"`
+	// Owner and Group are a user and group name or a numeric uid and
+	// gid. Giving files away needs the service to run as root; a group can
+	// also be one the service's user belongs to.
+	Owner string `json:"owner,omitempty"`
+	Group string `json:"group,omitempty"`
+}
+
+// parseMode reads octal permission bits.
+func parseMode(s string) (os.FileMode, error) {
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || n > 0o777 {
+		return 0, fmt.Errorf("%q is not an octal mode such as 0640", s)
+	}
+	return os.FileMode(n), nil
+}
+
+// ids resolves Owner and Group; -1 leaves either unchanged.
+func (o *OwnershipOptions) ids() (uid, gid int, err error) {
+	uid, gid = -1, -1
+	if o.Owner != "" {
+		id := o.Owner
+		if _, err := strconv.Atoi(id); err != nil {
+			u, err := user.Lookup(o.Owner)
+			if err != nil {
+				return 0, 0, err
+			}
+			id = u.Uid
+		}
+		if uid, err = strconv.Atoi(id); err != nil {
+			return 0, 0, fmt.Errorf("user %s has no numeric uid", o.Owner)
+		}
+	}
+	if o.Group != "" {
+		id := o.Group
+		if _, err := strconv.Atoi(id); err != nil {
+			g, err := user.LookupGroup(o.Group)
+			if err != nil {
+				return 0, 0, err
+			}
+			id = g.Gid
+		}
+		if gid, err = strconv.Atoi(id); err != nil {
+			return 0, 0, fmt.Errorf("group %s has no numeric gid", o.Group)
+		}
+	}
+	return uid, gid, nil
+}
+
+// apply gives path the configured owner and the mode for a file or, with
+// dir set, a folder.
+func (o *OwnershipOptions) apply(path string, dir bool) error {
+	mode := o.FileMode
+	if dir {
+		mode = o.DirMode
+	}
+	if mode != "" {
+		perm, err := parseMode(mode)
+		if err != nil {
+			return err
+		}
+		if err := os.Chmod(path, perm); err != nil {
+			return err
+		}
+	}
+	if o.Owner == "" && o.Group == "" {
+		return nil
+	}
+	uid, gid, err := o.ids()
+	if err != nil {
+		return err
+	}
+	return os.Chown(path, uid, gid)
+}
+
+// mkdirOwned creates dir and its missing parents, giving the folders it
+// creates the configured ownership.
+func (o *OwnershipOptions) mkdirOwned(dir string) error {
+	var created []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil || filepath.Dir(d) == d {
+			break
+		}
+		created = append(created, d)
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	for i := len(created) - 1; i >= 0; i-- {
+		if err := o.apply(created[i], true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateOwnership checks a rule's ownership settings.
+func validateOwnership(key func(string) string, o *OwnershipOptions) ConfigErrors {
+	var problems ConfigErrors
+	for _, m := range [][2]string{{"file_mode", o.FileMode}, {"dir_mode", o.DirMode}} {
+		if m[1] == "" {
+			continue
+		}
+		if _, err := parseMode(m[1]); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.%s: %v", key("ownership"), m[0], err))
+		}
+	}
+	if _, _, err := o.ids(); err != nil {
+		problems = append(problems, fmt.Sprintf("%s: %v", key("ownership"), err))
+	}
+	return problems
+}
@@ -0,0 +1,268 @@
+package foldermonitor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Pairing defaults.
+const (
+	defaultPairWindow = 5 * time.Second
+	defaultPairWait   = 5 * time.Minute
+	defaultPairHeight = 720
+)
+
+// PairRule brings together the videos of two rules that film the same
+// swing from different angles, such as face-on and down-the-line cameras
+// writing to separate folders. Each rule still archives its own files; the
+// pair is written in addition.
+type PairRule struct {
+	Name string `json:"name"`
+	// Rules names the two rules whose videos are paired; the first is the
+	// left clip of a merge.
+	Rules []string `json:"rules"`
+	// Window is the largest difference between the modification times of
+	// two clips of the same swing (default 5s).
+	Window Duration `json:"window,omitempty"`
+	// Wait is how long a clip waits for its other angle before it is left
+	// unpaired (default 5m).
+	Wait Duration `json:"wait,omitempty"`
+	// Mode is "folder" (the default) to copy both clips into a session
+	// folder, DestDir/YYYY-MM-DD/HHMMSS, or "merge" to write one
+	// side-by-side video, DestDir/YYYY-MM-DD/HHMMSS.mp4, with ffmpeg.
+	Mode    string `json:"mode,omitempty"`
+	DestDir string `json:"dest_dir"`
+	// Height is the height in pixels both clips are scaled to when merged
+	// (default 720).
+	Height int `json:"height,omitempty"`
+}
+
+// merges reports whether the pair is merged into one video.
+func (p *PairRule) merges() bool {
+	return p.Mode == "merge"
+}
+
+// pairClip is a copied video waiting for its other angle.
+type pairClip struct {
+	rule  Rule
+	path  string
+	taken time.Time // modification time of the source
+	timer *time.Timer
+}
+
+// pairTracker matches the copied videos of pair rules.
+type pairTracker struct {
+	copier  *Copier
+	pairs   []PairRule
+	mu      sync.Mutex
+	pending map[string][]*pairClip // keyed by pair name
+}
+
+func newPairTracker(c *Copier, pairs []PairRule) *pairTracker {
+	return &pairTracker{copier: c, pairs: pairs, pending: make(map[string][]*pairClip)}
+}
+
+// copied is the bus consumer that pairs a copied video with the waiting
+// clip of the other rule closest in time, or leaves it waiting.
+func (t *pairTracker) copied(e Event) {
+	if e.RenamedFrom != "" || !isMedia(e.Source) {
+		return
+	}
+	info, err := os.Stat(e.Source)
+	if err != nil {
+		return
+	}
+	for i := range t.pairs {
+		p := &t.pairs[i]
+		side := pairSide(p, e.Rule.Name)
+		if side < 0 {
+			continue
+		}
+		clip := &pairClip{rule: e.Rule, path: e.Source, taken: clipTime(e.Rule, e.Source, info)}
+		other := t.match(p, clip)
+		if other == nil {
+			logDebugf("Pair %s: %s waits for its other angle", p.Name, e.Source)
+			continue
+		}
+		clips := [2]*pairClip{clip, other}
+		if side == 1 {
+			clips = [2]*pairClip{other, clip}
+		}
+		if err := t.copier.writePair(e.Context(), p, clips); err != nil && e.Context().Err() == nil {
+			logEvent(CategoryCopyFailure, LevelWarning, "Pair %s: %s and %s could not be paired: %v",
+				p.Name, clips[0].path, clips[1].path, err)
+		}
+	}
+}
+
+// pairSide returns 0 or 1 for the first or second rule of p, or -1.
+func pairSide(p *PairRule, rule string) int {
+	for i, name := range p.Rules {
+		if name == rule {
+			return i
+		}
+	}
+	return -1
+}
+
+// match removes and returns the waiting clip of the other rule nearest to
+// clip in time, or queues clip when there is none within the window.
+func (t *pairTracker) match(p *PairRule, clip *pairClip) *pairClip {
+	window := p.Window.or(defaultPairWindow)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	queue := t.pending[p.Name]
+	best := -1
+	var bestDiff time.Duration
+	for i, c := range queue {
+		if c.rule.Name == clip.rule.Name {
+			continue
+		}
+		diff := c.taken.Sub(clip.taken)
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff <= window && (best < 0 || diff < bestDiff) {
+			best, bestDiff = i, diff
+		}
+	}
+	if best >= 0 {
+		other := queue[best]
+		other.timer.Stop()
+		t.pending[p.Name] = append(queue[:best:best], queue[best+1:]...)
+		return other
+	}
+	clip.timer = time.AfterFunc(p.Wait.or(defaultPairWait), func() { t.expire(p, clip) })
+	t.pending[p.Name] = append(queue, clip)
+	return nil
+}
+
+// expire gives up on a clip whose other angle never arrived.
+func (t *pairTracker) expire(p *PairRule, clip *pairClip) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	queue := t.pending[p.Name]
+	for i, c := range queue {
+		if c == clip {
+			t.pending[p.Name] = append(queue[:i:i], queue[i+1:]...)
+			logEvent(CategoryCopy, LevelInfo, "Pair %s: no other angle for %s within %s, left unpaired",
+				p.Name, clip.path, p.Window.or(defaultPairWindow))
+			return
+		}
+	}
+}
+
+// writePair writes the session folder or merged video of two clips, named
+// after the time of the earlier one. The rules' video edits, such as
+// trimming, are applied first.
+func (c *Copier) writePair(ctx context.Context, p *PairRule, clips [2]*pairClip) error {
+	taken := clips[0].taken
+	if clips[1].taken.Before(taken) {
+		taken = clips[1].taken
+	}
+	dayDir := filepath.Join(p.DestDir, taken.Format("2006-01-02"))
+	var srcs [2]string
+	for i, clip := range clips {
+		info, err := os.Stat(clip.path)
+		if err != nil {
+			return err
+		}
+		src, err := c.processMedia(ctx, clip.rule, clip.path, info)
+		if err != nil {
+			return err
+		}
+		if src != clip.path {
+			defer os.Remove(src)
+		}
+		srcs[i] = src
+	}
+
+	if !p.merges() {
+		session := filepath.Join(dayDir, taken.Format("150405"))
+		if err := os.MkdirAll(session, os.ModePerm); err != nil {
+			return err
+		}
+		for i, clip := range clips {
+			dest := filepath.Join(session, clip.rule.Name+"_"+filepath.Base(clip.path))
+			if err := copyFile(ctx, srcs[i], dest, clip.rule.WriteThrough, 0, nil); err != nil {
+				os.Remove(dest)
+				return err
+			}
+		}
+		logEvent(CategoryCopy, LevelInfo, "Pair %s: %s and %s placed in %s", p.Name, clips[0].path, clips[1].path, session)
+		return nil
+	}
+
+	if err := os.MkdirAll(dayDir, os.ModePerm); err != nil {
+		return err
+	}
+	dest := filepath.Join(dayDir, taken.Format("150405")+".mp4")
+	tmp := filepath.Join(dayDir, ".pair-"+filepath.Base(dest))
+	height := p.Height
+	if height <= 0 {
+		height = defaultPairHeight
+	}
+	job := &ffmpegJob{
+		inputs: []string{srcs[1]},
+		filter: fmt.Sprintf("[0:v]scale=-2:%[1]d[a];[1:v]scale=-2:%[1]d[b];[a][b]hstack=inputs=2[v]", height),
+		output: []string{"-shortest"},
+		encode: true,
+	}
+	if err := c.runFFmpeg(ctx, clips[0].rule, job, srcs[0], tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	logEvent(CategoryCopy, LevelInfo, "Pair %s: merged %s and %s into %s", p.Name, clips[0].path, clips[1].path, dest)
+	return nil
+}
+
+// validatePairs checks the pair rules against the names of the configured
+// rules and the watched folders.
+func validatePairs(pairs []PairRule, names map[string]bool, rules []Rule) ConfigErrors {
+	var problems ConfigErrors
+	seen := make(map[string]bool)
+	for i, p := range pairs {
+		key := func(field string) string { return fmt.Sprintf("pairs[%d].%s", i, field) }
+		if p.Name == "" {
+			problems = append(problems, key("name")+" is required")
+		} else if seen[p.Name] {
+			problems = append(problems, fmt.Sprintf("%s %q is used by more than one pair", key("name"), p.Name))
+		}
+		seen[p.Name] = true
+		if len(p.Rules) != 2 || p.Rules[0] == p.Rules[1] {
+			problems = append(problems, key("rules")+" must name two different rules")
+		}
+		for _, r := range p.Rules {
+			if !names[r] {
+				problems = append(problems, fmt.Sprintf("%s %q is not a configured rule", key("rules"), r))
+			}
+		}
+		if p.DestDir == "" {
+			problems = append(problems, key("dest_dir")+" is required")
+		}
+		if p.Mode != "" && p.Mode != "folder" && p.Mode != "merge" {
+			problems = append(problems, fmt.Sprintf("%s %q is not supported (want folder or merge)", key("mode"), p.Mode))
+		}
+		if p.Window < 0 || p.Wait < 0 || p.Height < 0 {
+			problems = append(problems, key("window")+", wait and height must not be negative")
+		}
+		if p.DestDir == "" {
+			continue
+		}
+		dst := cleanPath(p.DestDir)
+		for _, r := range rules {
+			if src := cleanPath(r.SourceDir); r.SourceDir != "" && (samePath(src, dst) || isWithin(dst, src) || isWithin(src, dst)) {
+				problems = append(problems, fmt.Sprintf("%s %s overlaps the source of rule %s; paired clips would be detected as new files", key("dest_dir"), dst, r.Name))
+			}
+		}
+	}
+	return problems
+}
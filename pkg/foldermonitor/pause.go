@@ -0,0 +1,93 @@
+package foldermonitor
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+)
+
+// pauseFile is the store file whose presence pauses copying. It holds the
+// time the pause began and survives service restarts and reboots.
+const pauseFile = "paused"
+
+// Paused reports whether copying is paused and since when.
+func (s *Store) Paused() (bool, time.Time) {
+	data, err := os.ReadFile(s.path(pauseFile))
+	if err != nil {
+		return false, time.Time{}
+	}
+	since, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	if err != nil {
+		since = time.Now()
+		if info, err := os.Stat(s.path(pauseFile)); err == nil {
+			since = info.ModTime()
+		}
+	}
+	return true, since
+}
+
+// SetPaused records or clears the pause marker. A running Monitor only
+// notices the change through Monitor.SetPaused or when it next starts.
+func (s *Store) SetPaused(paused bool) error {
+	if !paused {
+		if err := os.Remove(s.path(pauseFile)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(s.dir, os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(s.path(pauseFile), []byte(time.Now().Format(time.RFC3339)+"\n"), 0644)
+}
+
+// SetPaused pauses or resumes the monitor and persists the state.
+// Pausing stops every watch; resuming restarts them and copies whatever
+// arrived in the source folders in the meantime.
+func (m *Monitor) SetPaused(paused bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.store.SetPaused(paused); err != nil {
+		return err
+	}
+	if paused == m.paused {
+		return nil
+	}
+	m.stopLoop()
+	m.paused = paused
+	if paused {
+		m.status.setPaused(time.Now())
+	} else {
+		m.catchUp = true
+		m.status.setPaused(time.Time{})
+	}
+	m.startLoop()
+	if paused {
+		logEvent(CategoryService, LevelInfo, "Copying paused")
+	} else {
+		logEvent(CategoryService, LevelInfo, "Copying resumed")
+	}
+	return nil
+}
+
+// copyArrivals copies the files that reached the rules' source folders while
+// the monitor was paused or, when restarted, while it was down; why says
+// which for the log.
+func (c *Copier) copyArrivals(ctx context.Context, rules []Rule, why string) {
+	for _, rule := range rules {
+		if ctx.Err() != nil {
+			return
+		}
+		report, err := c.Reconcile(ctx, rule, ReconcileOptions{Fix: true})
+		if err != nil {
+			if svcLogger != nil {
+				svcLogger.Errorf("Rule %s: catching up on files that arrived while %s: %v", rule.Name, why, err)
+			}
+			continue
+		}
+		if report.Fixed > 0 && svcLogger != nil {
+			svcLogger.Infof("Rule %s: copied %d files that arrived while %s", rule.Name, report.Fixed, why)
+		}
+	}
+}
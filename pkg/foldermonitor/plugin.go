@@ -0,0 +1,310 @@
+package foldermonitor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Plugins are Lua scripts that extend the copy of a rule's files without
+// rebuilding the monitor. A script defines any of these global functions,
+// each called with a table describing the file (rule, source, name, ext,
+// rel, size and modified in Unix seconds, plus dest once it is known, and
+// sha256 after the copy):
+//
+//	filter(file)    return false, and optionally a reason, to skip the file
+//	rename(file)    return a new file name for the copy, or nil to keep it
+//	metadata(file)  return a table, written to the copy's <dest>.json
+//	                sidecar under the script's name
+//	copied(file)    runs after each copy, for actions such as notifying
+//	                another system
+//
+// Scripts may call log(message) to write to the service log. A script is
+// reloaded when it changes. Each call is bounded by pluginTimeout; a hook
+// that fails is logged and the copy goes ahead as if it were not defined.
+
+// pluginTimeout bounds loading a script and each call of a hook.
+const pluginTimeout = 10 * time.Second
+
+// Plugin hooks.
+const (
+	hookFilter   = "filter"
+	hookRename   = "rename"
+	hookMetadata = "metadata"
+	hookCopied   = "copied"
+)
+
+// pluginSet holds the loaded scripts by path.
+type pluginSet struct {
+	mu     sync.Mutex
+	loaded map[string]*luaPlugin
+}
+
+// luaPlugin is a loaded script. A Lua state runs one call at a time.
+type luaPlugin struct {
+	name    string
+	mu      sync.Mutex
+	state   *lua.LState
+	modTime time.Time
+}
+
+func newPluginSet() *pluginSet {
+	return &pluginSet{loaded: make(map[string]*luaPlugin)}
+}
+
+// get returns the script at path, loading it again when it changed.
+func (s *pluginSet) get(path string) (*luaPlugin, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p := s.loaded[path]; p != nil && p.modTime.Equal(info.ModTime()) {
+		return p, nil
+	}
+	p, err := loadPlugin(path, info.ModTime())
+	if err != nil {
+		return nil, err
+	}
+	if old := s.loaded[path]; old != nil {
+		old.close()
+	}
+	s.loaded[path] = p
+	return p, nil
+}
+
+// close closes every loaded script.
+func (s *pluginSet) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for path, p := range s.loaded {
+		p.close()
+		delete(s.loaded, path)
+	}
+}
+
+// loadPlugin runs the script at path, which defines its hooks.
+func loadPlugin(path string, modTime time.Time) (*luaPlugin, error) {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	L := lua.NewState()
+	L.SetGlobal("log", L.NewFunction(func(L *lua.LState) int {
+		logEvent(CategoryCopy, LevelInfo, "Plugin %s: %s", name, L.CheckString(1))
+		return 0
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), pluginTimeout)
+	defer cancel()
+	L.SetContext(ctx)
+	err := L.DoFile(path)
+	L.RemoveContext()
+	if err != nil {
+		L.Close()
+		return nil, fmt.Errorf("plugin %s: %v", path, err)
+	}
+	return &luaPlugin{name: name, state: L, modTime: modTime}, nil
+}
+
+func (p *luaPlugin) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state != nil {
+		p.state.Close()
+		p.state = nil
+	}
+}
+
+// call runs the script's hook with file, returning its first two results,
+// or ok false when the script does not define the hook.
+func (p *luaPlugin) call(ctx context.Context, hook string, file map[string]any) (results [2]any, ok bool, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state == nil {
+		return results, false, nil
+	}
+	L := p.state
+	fn, isFn := L.GetGlobal(hook).(*lua.LFunction)
+	if !isFn {
+		return results, false, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, pluginTimeout)
+	defer cancel()
+	L.SetContext(ctx)
+	err = L.CallByParam(lua.P{Fn: fn, NRet: 2, Protect: true}, toLua(L, file))
+	L.RemoveContext()
+	if err != nil {
+		return results, true, fmt.Errorf("plugin %s: %s: %v", p.name, hook, err)
+	}
+	results[0], results[1] = fromLua(L.Get(-2)), fromLua(L.Get(-1))
+	L.Pop(2)
+	return results, true, nil
+}
+
+// pluginFile describes the file at path to a hook.
+func pluginFile(rule Rule, path string, info os.FileInfo) map[string]any {
+	file := map[string]any{
+		"rule":   rule.Name,
+		"source": path,
+		"name":   filepath.Base(path),
+		"ext":    strings.ToLower(strings.TrimPrefix(filepath.Ext(path), ".")),
+	}
+	if rel, ok := relInside(rule.SourceDir, path); ok {
+		file["rel"] = filepath.ToSlash(rel)
+	}
+	if info != nil {
+		file["size"], file["modified"] = info.Size(), info.ModTime().Unix()
+	}
+	return file
+}
+
+// runHook calls hook in each of the rule's scripts that defines it, passing
+// the results to each, which may stop the remaining calls by returning
+// false. Failures are logged.
+func (c *Copier) runHook(ctx context.Context, rule Rule, hook string, file map[string]any, each func(p *luaPlugin, results [2]any) bool) {
+	for _, path := range rule.Plugins {
+		p, err := c.plugins.get(path)
+		if err == nil {
+			var results [2]any
+			var ok bool
+			if results, ok, err = p.call(ctx, hook, file); err == nil {
+				if ok && !each(p, results) {
+					return
+				}
+				continue
+			}
+		}
+		logEvent(CategoryCopy, LevelWarning, "Rule %s: %v", rule.Name, err)
+	}
+}
+
+// pluginFilter returns why a script skips the file at path, or "".
+func (c *Copier) pluginFilter(ctx context.Context, rule Rule, path string, info os.FileInfo) string {
+	var why string
+	c.runHook(ctx, rule, hookFilter, pluginFile(rule, path, info), func(p *luaPlugin, results [2]any) bool {
+		if results[0] != false {
+			return true
+		}
+		why = fmt.Sprintf("plugin %s skipped it", p.name)
+		if reason, ok := results[1].(string); ok && reason != "" {
+			why += ": " + reason
+		}
+		return false
+	})
+	return why
+}
+
+// pluginRename returns dest renamed by the rule's scripts. Names holding a
+// path are ignored, so that copies stay in their folder.
+func (c *Copier) pluginRename(ctx context.Context, rule Rule, path string, info os.FileInfo, dest string) string {
+	file := pluginFile(rule, path, info)
+	file["dest"] = dest
+	c.runHook(ctx, rule, hookRename, file, func(p *luaPlugin, results [2]any) bool {
+		name, ok := results[0].(string)
+		switch {
+		case !ok || name == "":
+		case !configNameValid(name):
+			logEvent(CategoryCopy, LevelWarning, "Rule %s: plugin %s: ignoring the name %q for %s, it is not a file name", rule.Name, p.name, name, path)
+		default:
+			dest = filepath.Join(filepath.Dir(dest), name)
+		}
+		file["dest"] = dest
+		return true
+	})
+	return dest
+}
+
+// pluginMetadata writes what the rule's scripts return for the copy e to
+// its sidecar.
+func (c *Copier) pluginMetadata(e Event, info os.FileInfo) {
+	c.runHook(e.Context(), e.Rule, hookMetadata, copiedFile(e, info), func(p *luaPlugin, results [2]any) bool {
+		if results[0] == nil {
+			return true
+		}
+		if err := mergeSidecar(e.Dest+".json", p.name, results[0]); err != nil {
+			logEvent(CategoryCopy, LevelWarning, "Rule %s: plugin %s: cannot write the sidecar of %s: %v", e.Rule.Name, p.name, e.Dest, err)
+		}
+		return true
+	})
+}
+
+// pluginCopied runs the rule's post-copy scripts for the copy e.
+func (c *Copier) pluginCopied(e Event) {
+	if len(e.Rule.Plugins) == 0 {
+		return
+	}
+	info, _ := os.Stat(e.Source)
+	c.runHook(e.Context(), e.Rule, hookCopied, copiedFile(e, info), func(*luaPlugin, [2]any) bool { return true })
+}
+
+// copiedFile describes the copy e to a hook.
+func copiedFile(e Event, info os.FileInfo) map[string]any {
+	file := pluginFile(e.Rule, e.Source, info)
+	file["dest"], file["sha256"], file["bytes"] = e.Dest, e.SHA256, e.Bytes
+	return file
+}
+
+// toLua converts a Go value to Lua.
+func toLua(L *lua.LState, v any) lua.LValue {
+	switch v := v.(type) {
+	case nil:
+		return lua.LNil
+	case string:
+		return lua.LString(v)
+	case bool:
+		return lua.LBool(v)
+	case int64:
+		return lua.LNumber(v)
+	case map[string]any:
+		t := L.NewTable()
+		for k, val := range v {
+			t.RawSetString(k, toLua(L, val))
+		}
+		return t
+	}
+	return lua.LString(fmt.Sprint(v))
+}
+
+// fromLua converts a Lua value to Go: tables with a sequence become slices
+// and other tables maps with string keys.
+func fromLua(v lua.LValue) any {
+	switch v := v.(type) {
+	case lua.LBool:
+		return bool(v)
+	case lua.LNumber:
+		return float64(v)
+	case lua.LString:
+		return string(v)
+	case *lua.LTable:
+		if n := v.MaxN(); n > 0 {
+			list := make([]any, 0, n)
+			for i := 1; i <= n; i++ {
+				list = append(list, fromLua(v.RawGetInt(i)))
+			}
+			return list
+		}
+		m := make(map[string]any)
+		v.ForEach(func(k, val lua.LValue) {
+			m[k.String()] = fromLua(val)
+		})
+		return m
+	}
+	return nil
+}
+
+// validatePlugins checks that a rule's scripts exist and compile.
+func validatePlugins(key func(string) string, plugins []string) ConfigErrors {
+	var problems ConfigErrors
+	for _, path := range plugins {
+		L := lua.NewState()
+		if _, err := L.LoadFile(path); err != nil {
+			problems = append(problems, fmt.Sprintf("%s %q: %s", key("plugins"), path, strings.TrimSpace(err.Error())))
+		}
+		L.Close()
+	}
+	return problems
+}
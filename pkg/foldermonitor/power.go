@@ -0,0 +1,66 @@
+package foldermonitor
+
+import (
+	"context"
+	"time"
+)
+
+// Power defaults.
+const (
+	defaultLargeSize         = 100 << 20
+	defaultPowerPollInterval = 30 * time.Second
+)
+
+// PowerConfig preserves the battery of capture laptops filming on the
+// course: large copies from watched folders wait until the machine is on AC
+// power again, when they are started at once. Supported on Windows and
+// Linux; machines without a battery always count as on AC power.
+type PowerConfig struct {
+	// DeferOnBattery holds back copies of files of at least LargeSize
+	// bytes (default 100 MiB) while running on battery.
+	DeferOnBattery bool  `json:"defer_on_battery,omitempty"`
+	LargeSize      int64 `json:"large_size,omitempty"`
+	// MaxDefer copies a file anyway after it has waited this long; zero
+	// waits for AC power however long it takes.
+	MaxDefer Duration `json:"max_defer,omitempty"`
+	// PollInterval is how often the power source is checked (default 30s).
+	PollInterval Duration `json:"poll_interval,omitempty"`
+}
+
+// watchPower tracks whether the machine runs on battery until ctx is
+// cancelled, logging each change and starting deferred copies when AC
+// power is back.
+func (m *Monitor) watchPower(ctx context.Context, cfg *PowerConfig, c *Copier) {
+	ticker := time.NewTicker(cfg.PollInterval.or(defaultPowerPollInterval))
+	defer ticker.Stop()
+	for {
+		if ac, ok := onACPower(); ok {
+			battery := !ac
+			if m.onBattery.Swap(battery) != battery {
+				if battery {
+					logEvent(CategoryService, LevelInfo, "Running on battery; large copies wait for AC power")
+				} else {
+					logEvent(CategoryService, LevelInfo, "AC power restored; starting deferred copies")
+					c.releaseDeferred()
+				}
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// validatePower checks the power settings.
+func validatePower(p *PowerConfig) ConfigErrors {
+	var problems ConfigErrors
+	if p.LargeSize < 0 {
+		problems = append(problems, "power.large_size must not be negative")
+	}
+	if p.MaxDefer < 0 || p.PollInterval < 0 {
+		problems = append(problems, "power.max_defer and power.poll_interval must be positive durations such as \"30s\"")
+	}
+	return problems
+}
@@ -0,0 +1,29 @@
+package foldermonitor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// onACPower reads the power supplies in sysfs: the machine is on AC power
+// when a mains adapter is online, or when it has no battery at all.
+func onACPower() (ac, ok bool) {
+	supplies, err := filepath.Glob("/sys/class/power_supply/*")
+	if err != nil || len(supplies) == 0 {
+		return false, false
+	}
+	battery := false
+	for _, dir := range supplies {
+		kind, _ := os.ReadFile(filepath.Join(dir, "type"))
+		switch strings.TrimSpace(string(kind)) {
+		case "Mains", "USB":
+			if online, _ := os.ReadFile(filepath.Join(dir, "online")); strings.TrimSpace(string(online)) == "1" {
+				return true, true
+			}
+		case "Battery":
+			battery = true
+		}
+	}
+	return !battery, true
+}
@@ -0,0 +1,8 @@
+//go:build !windows && !linux
+
+package foldermonitor
+
+// onACPower cannot tell the power source on this platform.
+func onACPower() (ac, ok bool) {
+	return false, false
+}
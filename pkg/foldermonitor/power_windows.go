@@ -0,0 +1,35 @@
+package foldermonitor
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var procGetSystemPowerStatus = windows.NewLazySystemDLL("kernel32.dll").NewProc("GetSystemPowerStatus")
+
+// systemPowerStatus mirrors SYSTEM_POWER_STATUS.
+type systemPowerStatus struct {
+	ACLineStatus        byte
+	BatteryFlag         byte
+	BatteryLifePercent  byte
+	SystemStatusFlag    byte
+	BatteryLifeTime     uint32
+	BatteryFullLifeTime uint32
+}
+
+// onACPower asks Windows for the power source; an unknown AC line status
+// counts as not known.
+func onACPower() (ac, ok bool) {
+	var s systemPowerStatus
+	if r, _, _ := procGetSystemPowerStatus.Call(uintptr(unsafe.Pointer(&s))); r == 0 {
+		return false, false
+	}
+	switch s.ACLineStatus {
+	case 0:
+		return false, true
+	case 1:
+		return true, true
+	}
+	return false, false
+}
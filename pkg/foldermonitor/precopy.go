@@ -0,0 +1,121 @@
+package foldermonitor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errRejected marks files a pre-copy command asked to quarantine;
+// errPreCopy marks pre-copy commands that failed to decide.
+var (
+	errRejected = errors.New("rejected by the pre-copy command")
+	errPreCopy  = errors.New("pre-copy command")
+)
+
+// Exit codes of a pre-copy command.
+const (
+	preCopyAccept     = 0
+	preCopySkip       = 1
+	preCopyQuarantine = 2
+)
+
+// defaultPreCopyTimeout bounds a pre-copy command.
+const defaultPreCopyTimeout = 30 * time.Second
+
+// PreCopyHook runs a command before each file is copied, whose exit code
+// decides what happens to it: 0 copies it, 1 skips it and 2 quarantines it.
+// Any other exit code, or a command that cannot run or times out, fails the
+// copy, which is retried. The first line the command prints is logged as
+// its reason. Sites use it for their own policies, such as asking a booking
+// system whether the bay is in a paid session.
+type PreCopyHook struct {
+	// Command is the program and its arguments. The arguments may contain
+	// {rule}, {source} and {dest}; the command also finds them, with the
+	// file's size and modification time, in the FOLDERMONITOR_RULE,
+	// FOLDERMONITOR_SOURCE, FOLDERMONITOR_DEST, FOLDERMONITOR_SIZE and
+	// FOLDERMONITOR_MODIFIED (RFC 3339) environment variables.
+	Command []string `json:"command"`
+	// Timeout bounds each run (default 30s).
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+// decide runs the command for the file at path, about to be copied to dest.
+// It returns why the file is skipped, or "" when it is copied; a file to be
+// quarantined or a command that failed is returned as an error.
+func (h *PreCopyHook) decide(parent context.Context, rule Rule, path string, info os.FileInfo, dest string) (string, error) {
+	timeout := h.Timeout.or(defaultPreCopyTimeout)
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+	r := strings.NewReplacer("{rule}", rule.Name, "{source}", path, "{dest}", dest)
+	args := make([]string, len(h.Command))
+	for i, a := range h.Command {
+		args[i] = r.Replace(a)
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(),
+		"FOLDERMONITOR_RULE="+rule.Name,
+		"FOLDERMONITOR_SOURCE="+path,
+		"FOLDERMONITOR_DEST="+dest,
+		"FOLDERMONITOR_SIZE="+strconv.FormatInt(info.Size(), 10),
+		"FOLDERMONITOR_MODIFIED="+info.ModTime().Format(time.RFC3339),
+	)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	// A script's children may keep the output open after it is killed.
+	cmd.WaitDelay = time.Second
+	err := cmd.Run()
+	reason := strings.TrimSpace(string(firstLine(bytes.TrimSpace(out.Bytes()))))
+	code := preCopyAccept
+	if exit, ok := err.(*exec.ExitError); ok && ctx.Err() == nil {
+		code = exit.ExitCode()
+	} else if err != nil {
+		switch {
+		case parent.Err() != nil:
+			return "", context.Cause(parent)
+		case ctx.Err() != nil:
+			err = fmt.Errorf("%w %s on %s gave no answer within %s", errPreCopy, args[0], path, timeout)
+		default:
+			err = fmt.Errorf("%w %s on %s: %v", errPreCopy, args[0], path, err)
+		}
+		logEvent(CategoryCopyFailure, LevelWarning, "Rule %s: %v", rule.Name, err)
+		return "", err
+	}
+	if reason == "" {
+		reason = fmt.Sprintf("exit code %d", code)
+	}
+	switch code {
+	case preCopyAccept:
+		logDebugf("Rule %s: pre-copy command accepted %s: %s", rule.Name, path, reason)
+		return "", nil
+	case preCopySkip:
+		return "the pre-copy command skipped it: " + reason, nil
+	case preCopyQuarantine:
+		logEvent(CategoryQuarantine, LevelWarning, "Rule %s: the pre-copy command rejected %s: %s", rule.Name, path, reason)
+		return "", fmt.Errorf("%w: %s", errRejected, reason)
+	}
+	err = fmt.Errorf("%w %s on %s: %s", errPreCopy, args[0], path, reason)
+	logEvent(CategoryCopyFailure, LevelWarning, "Rule %s: %v", rule.Name, err)
+	return "", err
+}
+
+// validatePreCopy checks a rule's pre-copy command.
+func validatePreCopy(key func(string) string, h *PreCopyHook) ConfigErrors {
+	var problems ConfigErrors
+	if len(h.Command) == 0 || h.Command[0] == "" {
+		return append(problems, key("pre_copy.command")+" must name a program")
+	}
+	if _, err := exec.LookPath(h.Command[0]); err != nil {
+		problems = append(problems, fmt.Sprintf("%s %q was not found: %v", key("pre_copy.command"), h.Command[0], err))
+	}
+	if h.Timeout < 0 {
+		problems = append(problems, key("pre_copy.timeout")+" must be a positive duration such as \"30s\"")
+	}
+	return problems
+}
@@ -0,0 +1,63 @@
+package foldermonitor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// profilesDir is the folder of the default config directory holding a
+// folder per named profile.
+const profilesDir = "profiles"
+
+// profileName is what a profile may be called: it names a folder and a
+// service, so it is kept to letters, digits, dashes and underscores.
+var profileName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,31}$`)
+
+// ValidateProfileName checks that name can name a profile.
+func ValidateProfileName(name string) error {
+	if !profileName.MatchString(name) {
+		return fmt.Errorf("profile %q must be up to 32 letters, digits, dashes or underscores", name)
+	}
+	return nil
+}
+
+// ProfileConfigDir returns the directory of the named profile, which holds
+// its config file and its store: queue, history, quarantine and pause
+// state. The empty profile is the default config directory.
+func ProfileConfigDir(profile string) string {
+	if profile == "" {
+		return DefaultConfigDir()
+	}
+	return filepath.Join(DefaultConfigDir(), profilesDir, profile)
+}
+
+// ProfileConfigPath returns the config file of the named profile, as
+// DefaultConfigPath does for the default one.
+func ProfileConfigPath(profile string) string {
+	return configPathIn(ProfileConfigDir(profile))
+}
+
+// Profiles lists the named profiles that have a config file.
+func Profiles() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(DefaultConfigDir(), profilesDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() || ValidateProfileName(e.Name()) != nil {
+			continue
+		}
+		if _, err := os.Stat(ProfileConfigPath(e.Name())); err == nil {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,142 @@
+package foldermonitor
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+)
+
+// quarantineFile is the store file listing quarantined files.
+const quarantineFile = "quarantine.json"
+
+// QuarantineEntry records a file that could not be copied and is no longer
+// retried until it changes or is released.
+type QuarantineEntry struct {
+	Rule   string     `json:"rule"`
+	Source string     `json:"source"`
+	Reason string     `json:"reason"` // short cause, see ErrorReason
+	Class  ErrorClass `json:"class"`
+	Error  string     `json:"error"`
+	// Attempts is how many copies were tried.
+	Attempts int       `json:"attempts"`
+	Since    time.Time `json:"since"`
+	// Size and ModTime identify the version of the file that failed, so a
+	// file that is rewritten is copied again.
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Quarantined returns the quarantined files, oldest first.
+func (s *Store) Quarantined() ([]QuarantineEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := s.readQuarantine()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]QuarantineEntry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Since.Before(list[j].Since) })
+	return list, nil
+}
+
+// Release removes the given files from quarantine, or every file when none
+// are given, so they are copied when next detected or synchronised. It
+// returns how many entries were removed.
+func (s *Store) Release(sources ...string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := s.readQuarantine()
+	if err != nil || len(entries) == 0 {
+		return 0, err
+	}
+	n := 0
+	if len(sources) == 0 {
+		n, entries = len(entries), nil
+	}
+	for _, src := range sources {
+		if _, ok := entries[src]; ok {
+			delete(entries, src)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, s.writeQuarantine(entries)
+}
+
+// quarantine adds or replaces the entry for e.Source.
+func (s *Store) quarantine(e QuarantineEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := s.readQuarantine()
+	if err != nil {
+		return err
+	}
+	if entries == nil {
+		entries = make(map[string]QuarantineEntry)
+	}
+	entries[e.Source] = e
+	return s.writeQuarantine(entries)
+}
+
+// quarantined returns the entry for the file at path if the same version
+// of it is quarantined.
+func (s *Store) quarantined(path string, info os.FileInfo) (QuarantineEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, _ := s.readQuarantine()
+	e, ok := entries[path]
+	if !ok || e.Size != info.Size() || !e.ModTime.Equal(info.ModTime()) {
+		return QuarantineEntry{}, false
+	}
+	return e, true
+}
+
+// readQuarantine loads the quarantine keyed by source path; a missing file
+// is an empty quarantine. s.mu must be held.
+func (s *Store) readQuarantine() (map[string]QuarantineEntry, error) {
+	data, err := os.ReadFile(s.path(quarantineFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var list []QuarantineEntry
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	entries := make(map[string]QuarantineEntry, len(list))
+	for _, e := range list {
+		entries[e.Source] = e
+	}
+	return entries, nil
+}
+
+// writeQuarantine saves the entries, removing the file when there are
+// none. s.mu must be held.
+func (s *Store) writeQuarantine(entries map[string]QuarantineEntry) error {
+	if len(entries) == 0 {
+		if err := os.Remove(s.path(quarantineFile)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	list := make([]QuarantineEntry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Source < list[j].Source })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(s.path(quarantineFile), data, 0644)
+}
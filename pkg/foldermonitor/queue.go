@@ -0,0 +1,264 @@
+package foldermonitor
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+)
+
+// queueFile is the store file listing copies waiting to be retried, so
+// that they survive restarts and can be inspected with "monitor queue".
+const queueFile = "queue.json"
+
+// QueueEntry is a failed copy waiting for its next attempt.
+type QueueEntry struct {
+	ID     string     `json:"id"`
+	Rule   string     `json:"rule"`
+	Source string     `json:"source"`
+	Error  string     `json:"error"`
+	Class  ErrorClass `json:"class"`
+	// Attempt is the number of the next attempt, counting from zero.
+	Attempt int       `json:"attempt"`
+	Since   time.Time `json:"since"`
+	Due     time.Time `json:"due"`
+}
+
+// Queued returns the copies waiting to be retried, soonest first.
+func (s *Store) Queued() ([]QueueEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := s.readQueue()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]QueueEntry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Due.Before(list[j].Due) })
+	return list, nil
+}
+
+// RetryQueued makes the queued copy with the given ID due now. A running
+// monitor is told through Monitor.RetryQueued; otherwise the copy is tried
+// when the monitor next starts. It reports whether the ID was queued.
+func (s *Store) RetryQueued(id string) (bool, error) {
+	return s.updateQueue(id, func(e *QueueEntry) bool {
+		e.Due = time.Now()
+		return true
+	})
+}
+
+// DropQueued removes the queued copy with the given ID, so it is not
+// retried. It reports whether the ID was queued.
+func (s *Store) DropQueued(id string) (bool, error) {
+	return s.updateQueue(id, func(*QueueEntry) bool { return false })
+}
+
+// enqueue adds e to the queue.
+func (s *Store) enqueue(e QueueEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := s.readQueue()
+	if err != nil {
+		return err
+	}
+	if entries == nil {
+		entries = make(map[string]QueueEntry)
+	}
+	entries[e.ID] = e
+	return s.writeQueue(entries)
+}
+
+// dequeue removes and returns the entry with the given ID.
+func (s *Store) dequeue(id string) (QueueEntry, bool) {
+	var taken QueueEntry
+	ok, _ := s.updateQueue(id, func(e *QueueEntry) bool {
+		taken = *e
+		return false
+	})
+	return taken, ok
+}
+
+// updateQueue applies fn to the entry with the given ID, keeping it if fn
+// returns true and removing it otherwise.
+func (s *Store) updateQueue(id string, fn func(*QueueEntry) bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := s.readQueue()
+	if err != nil {
+		return false, err
+	}
+	e, ok := entries[id]
+	if !ok {
+		return false, nil
+	}
+	if fn(&e) {
+		entries[id] = e
+	} else {
+		delete(entries, id)
+	}
+	return true, s.writeQueue(entries)
+}
+
+// readQueue loads the queue keyed by ID; a missing file is an empty queue.
+// s.mu must be held.
+func (s *Store) readQueue() (map[string]QueueEntry, error) {
+	data, err := os.ReadFile(s.path(queueFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var list []QueueEntry
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	entries := make(map[string]QueueEntry, len(list))
+	for _, e := range list {
+		entries[e.ID] = e
+	}
+	return entries, nil
+}
+
+// writeQueue saves the entries, removing the file when there are none.
+// s.mu must be held.
+func (s *Store) writeQueue(entries map[string]QueueEntry) error {
+	if len(entries) == 0 {
+		if err := os.Remove(s.path(queueFile)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	list := make([]QueueEntry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Due.Before(list[j].Due) })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(s.path(queueFile), data, 0644)
+}
+
+// newQueueID returns a short random ID for a queue entry.
+func newQueueID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// pendingRetry is a queued copy this copier will retry.
+type pendingRetry struct {
+	e     Event
+	timer *time.Timer
+}
+
+// scheduleRetry publishes e again once it is due. The entry is taken from
+// the store first; if it was dropped meanwhile, nothing happens.
+func (c *Copier) scheduleRetry(id string, e Event, due time.Time) {
+	c.status.addRetrying(1)
+	c.retryMu.Lock()
+	defer c.retryMu.Unlock()
+	r := &pendingRetry{e: e}
+	r.timer = time.AfterFunc(time.Until(due), func() { c.fireRetry(id) })
+	c.retries[id] = r
+}
+
+// fireRetry runs the retry with the given ID now. A stopped run leaves it
+// queued for the next one.
+func (c *Copier) fireRetry(id string) {
+	c.retryMu.Lock()
+	r, ok := c.retries[id]
+	delete(c.retries, id)
+	c.retryMu.Unlock()
+	if !ok {
+		return
+	}
+	r.timer.Stop()
+	c.status.addRetrying(-1)
+	if r.e.Context().Err() != nil {
+		return
+	}
+	if _, ok := c.store.dequeue(id); !ok {
+		logDebugf("Rule %s: retry of %s was dropped", r.e.Rule.Name, r.e.Source)
+		return
+	}
+	c.bus.Publish(r.e)
+}
+
+// dropRetry forgets the retry with the given ID.
+func (c *Copier) dropRetry(id string) {
+	c.retryMu.Lock()
+	r, ok := c.retries[id]
+	delete(c.retries, id)
+	c.retryMu.Unlock()
+	if ok {
+		r.timer.Stop()
+		c.status.addRetrying(-1)
+	}
+}
+
+// resumeRetries schedules the copies left queued by an earlier run for
+// the given rules. Entries whose source has gone are removed.
+func (c *Copier) resumeRetries(ctx context.Context, rules []Rule) {
+	queued, err := c.store.Queued()
+	if err != nil {
+		logEvent(CategoryCopyFailure, LevelError, "Error reading the retry queue: %v", err)
+		return
+	}
+	byName := make(map[string]Rule, len(rules))
+	for _, r := range rules {
+		byName[r.Name] = r
+	}
+	for _, q := range queued {
+		if _, err := os.Stat(q.Source); os.IsNotExist(err) {
+			c.store.dequeue(q.ID)
+			continue
+		}
+		rule, ok := byName[q.Rule]
+		if !ok {
+			continue
+		}
+		c.retryMu.Lock()
+		_, pending := c.retries[q.ID]
+		c.retryMu.Unlock()
+		if pending {
+			continue
+		}
+		e := Event{Kind: EventAccepted, Rule: rule, Source: q.Source, Attempt: q.Attempt}.WithContext(ctx)
+		c.scheduleRetry(q.ID, e, q.Due)
+	}
+}
+
+// RetryQueued retries the queued copy with the given ID now. It reports
+// whether the ID was queued.
+func (m *Monitor) RetryQueued(id string) (bool, error) {
+	ok, err := m.store.RetryQueued(id)
+	if ok && err == nil {
+		if c := m.copier.Load(); c != nil {
+			go c.fireRetry(id)
+		}
+	}
+	return ok, err
+}
+
+// DropQueued removes the queued copy with the given ID. It reports whether
+// the ID was queued.
+func (m *Monitor) DropQueued(id string) (bool, error) {
+	ok, err := m.store.DropQueued(id)
+	if ok && err == nil {
+		if c := m.copier.Load(); c != nil {
+			c.dropRetry(id)
+		}
+	}
+	return ok, err
+}
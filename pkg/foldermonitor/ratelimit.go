@@ -0,0 +1,159 @@
+package foldermonitor
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// defaultRateLimitWindow is how long repeated notifications are collapsed.
+const defaultRateLimitWindow = 10 * time.Minute
+
+// RateLimit collapses repeated notifications of the same problem, such as
+// a flapping NAS failing every copy: the first Burst of them in Window are
+// sent, and the rest are summed up in one "occurred N times in the last
+// 10m" message when the window ends. Notifications count as the same when
+// they differ only in paths and numbers.
+type RateLimit struct {
+	// Window is the period repeats are collapsed over (default 10m).
+	Window Duration `json:"window,omitempty"`
+	// Burst is how many of the same notification are sent in a window
+	// before the rest are held back (default 1).
+	Burst int `json:"burst,omitempty"`
+}
+
+// rateLimiter deduplicates the notifications of one notifier.
+type rateLimiter struct {
+	window time.Duration
+	burst  int
+	// summary sends the last held back notification once its window is
+	// over, with the number of times it occurred in the window.
+	summary func(sample interface{}, count int, window time.Duration)
+
+	mu      sync.Mutex
+	entries map[string]*rateEntry
+	closed  bool
+}
+
+// rateEntry counts the notifications of one kind in the current window.
+type rateEntry struct {
+	sent, held int
+	sample     interface{}
+	timer      *time.Timer
+}
+
+// newRateLimiter returns a limiter for cfg, or nil when cfg is nil; a nil
+// limiter lets everything through.
+func newRateLimiter(cfg *RateLimit, summary func(sample interface{}, count int, window time.Duration)) *rateLimiter {
+	if cfg == nil {
+		return nil
+	}
+	burst := cfg.Burst
+	if burst <= 0 {
+		burst = 1
+	}
+	return &rateLimiter{
+		window:  cfg.Window.or(defaultRateLimitWindow),
+		burst:   burst,
+		summary: summary,
+		entries: make(map[string]*rateEntry),
+	}
+}
+
+// allow reports whether the notification with the given key should be
+// sent now. Otherwise sample is kept for the window's summary.
+func (l *rateLimiter) allow(key string, sample interface{}) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return false
+	}
+	e, ok := l.entries[key]
+	if !ok {
+		e = &rateEntry{}
+		l.entries[key] = e
+		e.timer = time.AfterFunc(l.window, func() { l.expire(key, e) })
+	}
+	if e.sent < l.burst {
+		e.sent++
+		return true
+	}
+	e.held++
+	e.sample = sample
+	return false
+}
+
+// expire ends the window of an entry, sending its summary if anything was
+// held back.
+func (l *rateLimiter) expire(key string, e *rateEntry) {
+	l.mu.Lock()
+	if l.entries[key] == e {
+		delete(l.entries, key)
+	}
+	closed := l.closed
+	l.mu.Unlock()
+	if e.held > 0 && !closed {
+		l.summary(e.sample, e.sent+e.held, l.window)
+	}
+}
+
+// close stops the limiter; held back notifications are dropped.
+func (l *rateLimiter) close() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	for _, e := range l.entries {
+		e.timer.Stop()
+	}
+}
+
+// notificationKey reduces a message to what repeats of the same problem
+// share, replacing paths and numbers.
+func notificationKey(msg string) string {
+	fields := strings.Fields(msg)
+	for i, f := range fields {
+		if strings.ContainsAny(f, `/\`) {
+			fields[i] = "<path>"
+			continue
+		}
+		fields[i] = strings.Map(func(r rune) rune {
+			if unicode.IsDigit(r) {
+				return '#'
+			}
+			return r
+		}, f)
+	}
+	return strings.Join(fields, " ")
+}
+
+// repeatedSuffix describes how often a collapsed notification occurred.
+func repeatedSuffix(count int, window time.Duration) string {
+	d := window.String()
+	if strings.HasSuffix(d, "m0s") {
+		d = strings.TrimSuffix(d, "0s")
+	}
+	if strings.HasSuffix(d, "h0m") {
+		d = strings.TrimSuffix(d, "0m")
+	}
+	return fmt.Sprintf(" (occurred %d times in the last %s)", count, d)
+}
+
+// validateRateLimit checks the rate limit of a notifier.
+func validateRateLimit(key string, r *RateLimit) ConfigErrors {
+	var problems ConfigErrors
+	if r.Window < 0 {
+		problems = append(problems, key+".window must be a positive duration such as \"10m\"")
+	}
+	if r.Burst < 0 {
+		problems = append(problems, key+".burst must not be negative")
+	}
+	return problems
+}
@@ -0,0 +1,65 @@
+package foldermonitor
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// defaultRclone is the rclone executable looked up on the PATH.
+const defaultRclone = "rclone"
+
+// RcloneOptions push each copy with "rclone copyto" into the same
+// subfolder of an rclone remote, reaching any cloud provider rclone
+// supports. The rule's destination folder is the local spool.
+type RcloneOptions struct {
+	// Remote is the rclone remote and path, e.g. "gdrive:swing/bay3".
+	Remote string `json:"remote"`
+	// Rclone is the executable (default "rclone" on the PATH). Config is
+	// passed as --config when set; Flags are added to the command line,
+	// e.g. ["--bwlimit", "10M"].
+	Rclone string   `json:"rclone,omitempty"`
+	Config string   `json:"config,omitempty"`
+	Flags  []string `json:"flags,omitempty"`
+	// KeepLocal keeps the spooled copy, so that features reading the
+	// destination folder, such as checksums or cold tiering, work on it.
+	KeepLocal bool `json:"keep_local,omitempty"`
+}
+
+// executable returns the rclone executable to run.
+func (o *RcloneOptions) executable() string {
+	if o.Rclone != "" {
+		return o.Rclone
+	}
+	return defaultRclone
+}
+
+func (o *RcloneOptions) command(rule Rule, dest string) ([]string, string) {
+	target := strings.TrimRight(o.Remote, "/") + "/" + spoolPath(rule, dest)
+	args := []string{o.executable(), "copyto", dest, target}
+	if o.Config != "" {
+		args = append(args, "--config", o.Config)
+	}
+	return append(args, o.Flags...), target
+}
+
+func (o *RcloneOptions) keepLocal() bool { return o.KeepLocal }
+
+// validateRclone checks a rule's rclone settings.
+func validateRclone(key func(string) string, o RuleOptions) ConfigErrors {
+	var problems ConfigErrors
+	r := o.Rclone
+	if !strings.Contains(r.Remote, ":") {
+		problems = append(problems, fmt.Sprintf("%s.remote %q must be an rclone remote such as \"gdrive:swing\"", key("rclone"), r.Remote))
+	}
+	if r.Config != "" {
+		if _, err := os.Stat(r.Config); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.config: %v", key("rclone"), err))
+		}
+	}
+	if _, err := exec.LookPath(r.executable()); err != nil {
+		problems = append(problems, fmt.Sprintf("%s: rclone %q was not found: %v", key("rclone"), r.executable(), err))
+	}
+	return append(problems, validateSpool(key, o, "rclone", r.KeepLocal)...)
+}
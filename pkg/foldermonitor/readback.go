@@ -0,0 +1,60 @@
+package foldermonitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// errReadBack marks a destination file whose contents differ from what was
+// written to it, as when a NAS acknowledges writes it later drops.
+var errReadBack = errors.New("read-back verification failed")
+
+// hashWriter passes writes on to w and into h.
+type hashWriter struct {
+	w io.Writer
+	h hash.Hash
+}
+
+func (hw hashWriter) Write(p []byte) (int, error) {
+	n, err := hw.w.Write(p)
+	hw.h.Write(p[:n])
+	return n, err
+}
+
+func (hw hashWriter) Close() error { return nil }
+
+// hashWrapper returns a writerWrapper recording in h the bytes that reach
+// the destination file; it must be the last wrapper.
+func hashWrapper(h hash.Hash) writerWrapper {
+	return func(w io.Writer) (io.WriteCloser, error) {
+		return hashWriter{w: w, h: h}, nil
+	}
+}
+
+// readBack flushes the destination file at path to its storage, reads it
+// back and checks that its SHA-256 is want. Where the platform allows, the
+// local cache of the file is dropped first so that the data comes from the
+// storage itself.
+func readBack(ctx context.Context, path, want string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	dropCache(f)
+	got, err := hashReader(ctxReader{ctx, f})
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("%w: %s reads back with SHA-256 %s, %s was written", errReadBack, path, got, want)
+	}
+	return nil
+}
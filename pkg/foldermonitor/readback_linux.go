@@ -0,0 +1,13 @@
+package foldermonitor
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// dropCache asks the kernel to forget the cached pages of f, so that it is
+// read again from its storage.
+func dropCache(f *os.File) {
+	unix.Fadvise(int(f.Fd()), 0, 0, unix.FADV_DONTNEED)
+}
@@ -0,0 +1,345 @@
+package foldermonitor
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ReconcileOptions control a comparison of a rule's source and destination.
+type ReconcileOptions struct {
+	Hash bool // compare SHA-256 digests, not just sizes
+	Fix  bool // copy missing or mismatched files
+	// MaxAge, when above zero, leaves out files last modified longer
+	// ago, so a first sync of a bay with years of footage copies only the
+	// recent part.
+	MaxAge time.Duration
+	// MinAge, when above zero, leaves out files modified more recently,
+	// which may still be being written and are left to the watcher.
+	MinAge time.Duration
+}
+
+// ReconcileReport lists what a comparison found.
+type ReconcileReport struct {
+	Checked, OK int
+	Missing     []string
+	Mismatched  []string
+	Fixed       int
+	Failed      int
+	// Old counts the files left out for being older than MaxAge, Recent
+	// those newer than MinAge.
+	Old, Recent int
+	// Skipped counts the files without a copy because the rule does not
+	// copy them, such as files outside its schedule or filters.
+	Skipped int
+}
+
+// skippedFile is the state of a source file when it was skipped.
+type skippedFile struct {
+	size     int64
+	modified time.Time
+}
+
+// skipped remembers that the file at path, with info, was not copied on
+// purpose.
+func (c *Copier) skipped(path string, info os.FileInfo) {
+	c.skipMu.Lock()
+	defer c.skipMu.Unlock()
+	c.skips[path] = skippedFile{size: info.Size(), modified: info.ModTime()}
+}
+
+// skippedBefore reports whether the file at path was skipped unchanged.
+func (c *Copier) skippedBefore(path string, info os.FileInfo) bool {
+	c.skipMu.Lock()
+	defer c.skipMu.Unlock()
+	s, ok := c.skips[path]
+	return ok && s.size == info.Size() && s.modified.Equal(info.ModTime())
+}
+
+// pruneSkips forgets the skipped files that are gone or have changed
+// since, so that the skips do not outgrow the source folders.
+func (c *Copier) pruneSkips() {
+	c.skipMu.Lock()
+	paths := make([]string, 0, len(c.skips))
+	for path := range c.skips {
+		paths = append(paths, path)
+	}
+	c.skipMu.Unlock()
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		c.skipMu.Lock()
+		if s := c.skips[path]; err != nil || s.size != info.Size() || !s.modified.Equal(info.ModTime()) {
+			delete(c.skips, path)
+		}
+		c.skipMu.Unlock()
+	}
+}
+
+// skipsFile reports whether the rule leaves out the file at path, so that
+// its missing copy is expected. Files skipped before are not run through
+// the checks again, so pre-copy commands and visual duplicate checks do
+// not run on every reconciliation.
+func (c *Copier) skipsFile(ctx context.Context, rule Rule, path string, info os.FileInfo) bool {
+	if c.skippedBefore(path, info) {
+		return true
+	}
+	if rule.outsideSchedule(info) == "" && c.filtered(ctx, rule, path, info) == "" {
+		return false
+	}
+	c.skipped(path, info)
+	return true
+}
+
+// archivedCopy describes a file at the destination: either a loose file or
+// an entry in a staged or sealed session archive.
+type archivedCopy struct {
+	path   string // loose file path, empty for archive entries
+	size   int64  // size of the original content, -1 if unknown
+	sha256 string // digest from an archive manifest, if any
+}
+
+// Reconcile compares every file in the rule's source folder with its
+// copy at the destination. It stops early with ctx's error when ctx is
+// cancelled.
+func (c *Copier) Reconcile(ctx context.Context, rule Rule, opts ReconcileOptions) (*ReconcileReport, error) {
+	entries, err := os.ReadDir(rule.SourceDir)
+	if err != nil {
+		return nil, err
+	}
+	c.pruneSkips()
+	var sessions map[string]archivedCopy
+	if rule.Archive != "" {
+		sessions = archivedSessions(rule)
+	} else if rule.Rollup != nil {
+		sessions = rolledUpCopies(rule)
+	}
+	report := &ReconcileReport{}
+	var cutoff, recent time.Time
+	if opts.MaxAge > 0 {
+		cutoff = time.Now().Add(-opts.MaxAge)
+	}
+	if opts.MinAge > 0 {
+		recent = time.Now().Add(-opts.MinAge)
+	}
+	var files []scannedFile
+	for _, e := range entries {
+		if !e.Type().IsRegular() || e.Name() == canaryName || rule.excluded(e.Name()) {
+			continue
+		}
+		if info, err := e.Info(); err == nil {
+			files = append(files, scannedFile{filepath.Join(rule.SourceDir, e.Name()), info})
+		}
+	}
+	rule.sortScan(files)
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		src, info := f.path, f.info
+		if info.ModTime().Before(cutoff) {
+			report.Old++
+			continue
+		}
+		if !recent.IsZero() && info.ModTime().After(recent) {
+			report.Recent++
+			continue
+		}
+		report.Checked++
+		routed := c.failedOver(rule).routed(src)
+		name := destFileName(routed, src)
+		if len(rule.Plugins) > 0 {
+			name = filepath.Base(c.pluginRename(ctx, routed, src, info, filepath.Join(routed.DestDir, name)))
+		}
+		problem, err := verifyCopy(ctx, routed, src, info, name, sessions, c.key, opts.Hash)
+		if err != nil {
+			problem = err.Error()
+		}
+		switch {
+		case problem == "":
+			report.OK++
+			continue
+		case problem == "missing" && c.inColdStorage(ctx, rule, src, info):
+			report.OK++
+			continue
+		case problem == "missing" && c.skipsFile(ctx, routed, src, info):
+			report.Skipped++
+			continue
+		case problem == "missing":
+			report.Missing = append(report.Missing, src)
+		default:
+			report.Mismatched = append(report.Mismatched, src+": "+problem)
+		}
+		if opts.Fix {
+			if c.archiveFile(ctx, rule, src, info, "") == nil {
+				report.Fixed++
+			} else {
+				report.Failed++
+			}
+		}
+	}
+	return report, nil
+}
+
+// Scheduled reconciliation limits. Files modified within reconcileMinAge
+// may still be being recorded.
+const (
+	minReconcileInterval = time.Minute
+	reconcileMinAge      = 5 * time.Minute
+)
+
+// reconcileWatched compares the rules' source folders with their
+// destinations and copies whatever is missing, as a safety net for events
+// lost in ways the watcher cannot detect. Files modified within
+// reconcileMinAge are left to the watcher.
+func (c *Copier) reconcileWatched(ctx context.Context, rules []Rule) {
+	for _, rule := range rules {
+		if ctx.Err() != nil {
+			return
+		}
+		report, err := c.Reconcile(ctx, rule, ReconcileOptions{Fix: true, MinAge: reconcileMinAge})
+		if err != nil {
+			logEvent(CategoryCopy, LevelError, "Rule %s: scheduled reconciliation: %v", rule.Name, err)
+			continue
+		}
+		if n := len(report.Missing) + len(report.Mismatched); n > 0 {
+			logEvent(CategoryCopy, LevelWarning, "Rule %s: scheduled reconciliation found %d files the watcher had not copied; copied %d, %d failed", rule.Name, n, report.Fixed, report.Failed)
+		} else {
+			logDebugf("Rule %s: scheduled reconciliation checked %d files, all copied", rule.Name, report.Checked)
+		}
+	}
+}
+
+// verifyCopy checks the destination copy of src, named name, and returns ""
+// if it is intact, "missing", or a description of the mismatch.
+func verifyCopy(ctx context.Context, rule Rule, src string, info os.FileInfo, name string, sessions map[string]archivedCopy, key []byte, hash bool) (string, error) {
+	// Edited videos keep their names but not their size or content.
+	edited := rule.editsVideo() && isMedia(src)
+	transformed := edited || name != info.Name()
+	var dst archivedCopy
+	if rule.Archive != "" {
+		var ok bool
+		if dst, ok = sessions[name]; !ok {
+			return "missing", nil
+		}
+		if transformed {
+			dst.size = -1
+		}
+	} else {
+		studentRel, _ := studentDir(ctx, rule, src, info)
+		path := filepath.Join(rule.DestDir, studentRel, name)
+		st, err := os.Stat(path)
+		switch {
+		case os.IsNotExist(err):
+			// Rolled-up copies are in a monthly archive instead.
+			rolled, ok := sessions[filepath.ToSlash(filepath.Join(studentRel, name))]
+			if !ok {
+				return "missing", nil
+			}
+			dst = rolled
+			if transformed {
+				dst.size = -1
+			}
+		case err != nil:
+			return "", err
+		default:
+			dst = archivedCopy{path: path, size: -1}
+			if !transformed {
+				dst.size = st.Size()
+			}
+		}
+	}
+	if dst.size >= 0 && dst.size != info.Size() {
+		return fmt.Sprintf("size %d, source has %d", dst.size, info.Size()), nil
+	}
+	if !hash || edited {
+		return "", nil
+	}
+	want, err := hashFile(src)
+	if err != nil {
+		return "", err
+	}
+	got := dst.sha256
+	if dst.path != "" {
+		if got, err = hashArchivedFile(rule, dst.path, key); err != nil {
+			return "", err
+		}
+	}
+	// Manifests hash the stored bytes, which only match the source for
+	// untransformed files.
+	if got != want && (dst.path != "" || name == info.Name()) {
+		return "content differs (SHA-256 mismatch)", nil
+	}
+	return "", nil
+}
+
+// hashArchivedFile hashes the original content of a destination file,
+// decrypting and decompressing it as needed.
+func hashArchivedFile(rule Rule, path string, key []byte) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	name := path
+	if strings.HasSuffix(name, EncSuffix) {
+		if key == nil {
+			return "", fmt.Errorf("cannot verify %s without the encryption key", path)
+		}
+		if r, err = newDecryptReader(r, key); err != nil {
+			return "", err
+		}
+		name = strings.TrimSuffix(name, EncSuffix)
+	}
+	switch {
+	case strings.HasSuffix(name, ".gz") && rule.Compress == "gzip":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return "", err
+		}
+		r = gz
+	case strings.HasSuffix(name, ".zst") && rule.Compress == "zstd":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return "", err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	return hashReader(r)
+}
+
+// archivedSessions indexes the files of a session-archiving rule: staged
+// loose files and entries listed in sealed archives' manifests.
+func archivedSessions(rule Rule) map[string]archivedCopy {
+	files := make(map[string]archivedCopy)
+	indexes, _ := filepath.Glob(filepath.Join(rule.DestDir, "*.index.json"))
+	for _, path := range indexes {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var m sessionManifest
+		if json.Unmarshal(data, &m) != nil {
+			continue
+		}
+		for _, f := range m.Files {
+			files[f.Name] = archivedCopy{size: f.Size, sha256: f.SHA256}
+		}
+	}
+	staged, _ := filepath.Glob(filepath.Join(rule.DestDir, stagingDirName, "*", "*"))
+	for _, path := range staged {
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			files[filepath.Base(path)] = archivedCopy{path: path, size: info.Size()}
+		}
+	}
+	return files
+}
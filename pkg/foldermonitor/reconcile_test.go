@@ -0,0 +1,51 @@
+package foldermonitor
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestPruneSkips(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{Version: CurrentConfigVersion}
+	c := newCopier(cfg, NewStore(dir), newStatusTracker())
+	paths := map[string]string{
+		"kept":    filepath.Join(src, "kept.mp4"),
+		"deleted": filepath.Join(src, "deleted.mp4"),
+		"changed": filepath.Join(src, "changed.mp4"),
+		"outside": filepath.Join(dir, "outside.mp4"),
+	}
+	for _, path := range paths {
+		if err := os.WriteFile(path, []byte("clip"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.skipped(path, info)
+	}
+	os.Remove(paths["deleted"])
+	os.Remove(paths["outside"])
+	if err := os.WriteFile(paths["changed"], []byte("longer clip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c.pruneSkips()
+	var got []string
+	for path := range c.skips {
+		got = append(got, path)
+	}
+	sort.Strings(got)
+	want := []string{paths["kept"]}
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("skips %v, want %v", got, want)
+	}
+}
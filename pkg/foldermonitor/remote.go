@@ -0,0 +1,265 @@
+package foldermonitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// defaultRemoteInterval is how often the remote configuration is polled.
+const defaultRemoteInterval = 5 * time.Minute
+
+// remoteFetchTimeout bounds a single fetch of the remote configuration.
+const remoteFetchTimeout = 30 * time.Second
+
+// remoteCacheFile is the store file keeping the last remote configuration
+// fetched, so the machine starts with it while headquarters is unreachable.
+const remoteCacheFile = "remote-config.json"
+
+// RemoteConfig points at a configuration document published centrally.
+// Its settings are applied over the local file: every key present in the
+// document replaces the local value, so the local file only needs what is
+// specific to the machine, such as its remote section and ui_addr.
+type RemoteConfig struct {
+	// URL is the HTTPS address of the document, in JSON, YAML or TOML
+	// according to its extension (JSON when there is none).
+	URL string `json:"url"`
+	// Token is sent as a bearer token when set.
+	Token string `json:"token,omitempty"`
+	// Interval is how often the document is checked for changes
+	// (default 5m). Unchanged documents cost one conditional request.
+	Interval Duration `json:"interval,omitempty"`
+}
+
+// remoteCache is the content of remoteCacheFile.
+type remoteCache struct {
+	URL       string    `json:"url"`
+	ETag      string    `json:"etag,omitempty"`
+	FetchedAt time.Time `json:"fetched_at"`
+	Body      string    `json:"body"`
+}
+
+// readRemoteCache returns the cached document for rc, or nil if there is
+// none or it came from another URL.
+func (s *Store) readRemoteCache(rc *RemoteConfig) *remoteCache {
+	data, err := os.ReadFile(s.path(remoteCacheFile))
+	if err != nil {
+		return nil
+	}
+	var c remoteCache
+	if err := json.Unmarshal(data, &c); err != nil || c.URL != rc.URL {
+		return nil
+	}
+	return &c
+}
+
+// writeRemoteCache saves the last document fetched.
+func (s *Store) writeRemoteCache(c *remoteCache) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, os.ModePerm); err != nil {
+		return err
+	}
+	tmp := s.path(remoteCacheFile + ".tmp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(remoteCacheFile))
+}
+
+// remoteFormat returns the config format of the document at rawURL.
+func remoteFormat(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		return ConfigFormat(u.Path)
+	}
+	return "json"
+}
+
+// applyRemoteConfig returns a copy of local with the settings of the
+// remote document body applied. Each top-level key of the document
+// replaces the local value as a whole, so that rules, for example, come
+// entirely from the document; a version 1 document's default rule counts
+// as its rules. The remote section always comes from local so the document
+// cannot redirect the machine elsewhere.
+func applyRemoteConfig(local *Config, body string) (*Config, error) {
+	format := remoteFormat(local.Remote.URL)
+	var remote Config
+	if err := DecodeConfig([]byte(body), format, &remote); err != nil {
+		return nil, fmt.Errorf("remote config: %v", err)
+	}
+	keys, err := configKeys([]byte(body), format)
+	if err != nil {
+		return nil, fmt.Errorf("remote config: %v", err)
+	}
+	if remote.configVersion() < 2 && (remote.SourceDir != "" || remote.DestDir != "" || !reflect.ValueOf(remote.RuleOptions).IsZero()) {
+		keys["rules"] = true
+	}
+	upgradeConfig(&remote)
+	cfg, err := cloneConfig(local)
+	if err != nil {
+		return nil, err
+	}
+	dst, src := reflect.ValueOf(cfg).Elem(), reflect.ValueOf(&remote).Elem()
+	for _, f := range jsonFields(dst.Type()) {
+		if keys[strings.ToLower(f.name)] {
+			dst.FieldByIndex(f.index).Set(src.FieldByIndex(f.index))
+		}
+	}
+	cfg.Remote = local.Remote
+	cfg.Version = CurrentConfigVersion
+	if problems := ValidateConfig(cfg); len(problems) > 0 {
+		return nil, fmt.Errorf("remote config: %v", problems)
+	}
+	return cfg, nil
+}
+
+// configKeys returns the top-level keys of a config document, in lower
+// case as encoding/json matches them without regard to case.
+func configKeys(data []byte, format string) (map[string]bool, error) {
+	keys := make(map[string]bool)
+	if format == "json" {
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, err
+		}
+		for k := range m {
+			keys[strings.ToLower(k)] = true
+		}
+		return keys, nil
+	}
+	m, err := decodeRawConfig(data, format)
+	if err != nil {
+		return nil, err
+	}
+	for k := range m {
+		keys[strings.ToLower(k)] = true
+	}
+	return keys, nil
+}
+
+// fetchRemoteConfig downloads the document unless it still has the ETag of
+// cached, in which case it returns nil.
+func fetchRemoteConfig(ctx context.Context, rc *RemoteConfig, cached *remoteCache) (*remoteCache, error) {
+	ctx, cancel := context.WithTimeout(ctx, remoteFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rc.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if rc.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+rc.Token)
+	}
+	if cached != nil && cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("remote config: %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, fmt.Errorf("remote config: %v", err)
+	}
+	if cached != nil && cached.Body == string(body) {
+		return nil, nil
+	}
+	return &remoteCache{URL: rc.URL, ETag: resp.Header.Get("ETag"), FetchedAt: time.Now(), Body: string(body)}, nil
+}
+
+// useCachedRemote applies the cached remote document, if any, to the
+// monitor's local configuration. It runs before the first start so the
+// machine works offline with the last rules it received.
+func (m *Monitor) useCachedRemote() {
+	c := m.store.readRemoteCache(m.local.Remote)
+	if c == nil {
+		return
+	}
+	cfg, err := applyRemoteConfig(m.local, c.Body)
+	if err != nil {
+		logEvent(CategoryConfig, LevelWarning, "Ignoring cached remote config: %v", err)
+		return
+	}
+	m.config = cfg
+}
+
+// syncRemote fetches the remote document and, when it changed, caches it
+// and reloads the monitor with it. A document that fails to parse or
+// validate is reported and the running configuration is kept.
+func (m *Monitor) syncRemote(ctx context.Context, reload bool) {
+	rc := m.local.Remote
+	cached := m.store.readRemoteCache(rc)
+	next, err := fetchRemoteConfig(ctx, rc, cached)
+	if err != nil {
+		if cached != nil {
+			logEvent(CategoryConfig, LevelWarning, "Fetching %s: %v; using the copy from %s", rc.URL, err, cached.FetchedAt.Format(time.RFC1123))
+		} else {
+			logEvent(CategoryConfig, LevelWarning, "Fetching %s: %v; using the local config", rc.URL, err)
+		}
+		return
+	}
+	if next == nil {
+		return
+	}
+	cfg, err := applyRemoteConfig(m.local, next.Body)
+	if err != nil {
+		logEvent(CategoryConfig, LevelError, "%v; keeping the current config", err)
+		return
+	}
+	if err := m.store.writeRemoteCache(next); err != nil {
+		logEvent(CategoryConfig, LevelWarning, "Caching remote config: %v", err)
+	}
+	logEvent(CategoryConfig, LevelInfo, "Remote config updated from %s", rc.URL)
+	if reload {
+		m.Reload(cfg)
+		return
+	}
+	m.mu.Lock()
+	m.config = cfg
+	m.mu.Unlock()
+}
+
+// pollRemote checks the remote document every interval until ctx is
+// cancelled.
+func (m *Monitor) pollRemote(ctx context.Context) {
+	ticker := time.NewTicker(m.local.Remote.Interval.or(defaultRemoteInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.syncRemote(ctx, true)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// validateRemote checks the remote configuration settings.
+func validateRemote(rc *RemoteConfig) ConfigErrors {
+	var problems ConfigErrors
+	if u, err := url.Parse(rc.URL); err != nil || !strings.EqualFold(u.Scheme, "https") || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("remote.url %q must be an https URL", rc.URL))
+	}
+	if rc.Interval < 0 {
+		problems = append(problems, "remote.interval must be a positive duration such as \"5m\"")
+	}
+	return problems
+}
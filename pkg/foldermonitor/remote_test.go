@@ -0,0 +1,105 @@
+package foldermonitor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestApplyRemoteConfig(t *testing.T) {
+	dir := t.TempDir()
+	folder := func(name string) string { return filepath.Join(dir, name) }
+	for _, name := range []string{"a", "b", "hq", "archive"} {
+		if err := os.MkdirAll(folder(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	local := func(url string) *Config {
+		return &Config{
+			Version: CurrentConfigVersion,
+			UIAddr:  "127.0.0.1:8080",
+			Remote:  &RemoteConfig{URL: url},
+			Rules: []Rule{
+				{Name: "a", SourceDir: folder("a"), DestDir: folder("archive"), RuleOptions: RuleOptions{Exclude: []string{"secret"}}},
+				{Name: "b", SourceDir: folder("b"), DestDir: folder("archive"), RuleOptions: RuleOptions{Compress: "gzip"}},
+			},
+		}
+	}
+	tests := []struct {
+		name  string
+		url   string
+		body  string
+		want  []Rule
+		check func(t *testing.T, cfg *Config)
+	}{
+		{
+			name: "remote rules replace the local ones",
+			url:  "https://hq.example/site.json",
+			body: fmt.Sprintf(`{"rules": [{"name": "hq", "source_dir": %q, "dest_dir": %q}]}`, folder("hq"), folder("archive")),
+			want: []Rule{{Name: "hq", SourceDir: folder("hq"), DestDir: folder("archive")}},
+		},
+		{
+			name: "reordered rules keep their own options",
+			url:  "https://hq.example/site.json",
+			body: fmt.Sprintf(`{"rules": [{"name": "b", "source_dir": %q, "dest_dir": %q}, {"name": "a", "source_dir": %q, "dest_dir": %q, "compress": "zstd"}]}`,
+				folder("b"), folder("archive"), folder("a"), folder("archive")),
+			want: []Rule{
+				{Name: "b", SourceDir: folder("b"), DestDir: folder("archive")},
+				{Name: "a", SourceDir: folder("a"), DestDir: folder("archive"), RuleOptions: RuleOptions{Compress: "zstd"}},
+			},
+		},
+		{
+			name: "keys the document leaves out stay local",
+			url:  "https://hq.example/site.json",
+			body: `{"quiet_period": "10s"}`,
+			want: local("").Rules,
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.UIAddr != "127.0.0.1:8080" || cfg.QuietPeriod != Duration(10e9) {
+					t.Errorf("ui_addr %q quiet_period %v", cfg.UIAddr, cfg.QuietPeriod)
+				}
+			},
+		},
+		{
+			name: "the remote section stays local",
+			url:  "https://hq.example/site.json",
+			body: `{"remote": {"url": "https://evil.example/x.json"}}`,
+			want: local("").Rules,
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Remote.URL != "https://hq.example/site.json" {
+					t.Errorf("remote url %q", cfg.Remote.URL)
+				}
+			},
+		},
+		{
+			name: "a version 1 document gives the default rule",
+			url:  "https://hq.example/site.yaml",
+			body: fmt.Sprintf("source_dir: %s\ndest_dir: %s\nverify_writes: true\n", folder("hq"), folder("archive")),
+			want: []Rule{{Name: DefaultRuleName, SourceDir: folder("hq"), DestDir: folder("archive"), RuleOptions: RuleOptions{VerifyWrites: true}}},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.SourceDir != "" || cfg.Version != CurrentConfigVersion {
+					t.Errorf("source_dir %q version %d", cfg.SourceDir, cfg.Version)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := local(tt.url)
+			cfg, err := applyRemoteConfig(l, tt.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(cfg.Rules, tt.want) {
+				t.Errorf("rules\n got %+v\nwant %+v", cfg.Rules, tt.want)
+			}
+			if tt.check != nil {
+				tt.check(t, cfg)
+			}
+			if !reflect.DeepEqual(l.Rules, local(tt.url).Rules) {
+				t.Errorf("local config changed: %+v", l.Rules)
+			}
+		})
+	}
+}
@@ -0,0 +1,359 @@
+package foldermonitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Monthly rollup defaults.
+const (
+	// rollupDirName holds the rollup archives, below the destination.
+	rollupDirName         = "rollups"
+	rollupLayout          = "2006-01"
+	rollupInterval        = 24 * time.Hour
+	defaultRollupAfterDay = 7
+)
+
+// RollupOptions consolidate a rule's copies into one archive per month,
+// with an index manifest beside it, so that the archive volume does not
+// fill up with millions of small files. A month is rolled up once it has
+// been over for AfterDays; files are assigned to the month of their
+// modification time. Without Prune a month is rolled up once; with it,
+// files of the month copied later go into another archive.
+type RollupOptions struct {
+	// Format is "zip" (the default) or "tar.zst".
+	Format string `json:"format,omitempty"`
+	// AfterDays is how many days after the end of a month it is rolled
+	// up (default 7), leaving time for late copies.
+	AfterDays int `json:"after_days,omitempty"`
+	// Cold uploads each archive and its index with the rule's cold tier.
+	Cold bool `json:"cold,omitempty"`
+	// Prune deletes the originals once they are in an archive.
+	Prune bool `json:"prune,omitempty"`
+}
+
+// format returns the archive format, "zip" by default.
+func (o *RollupOptions) format() string {
+	if o.Format == "" {
+		return "zip"
+	}
+	return o.Format
+}
+
+// rollUp rolls up the months of the rules once a day until ctx is
+// cancelled.
+func (c *Copier) rollUp(ctx context.Context, rules []Rule) {
+	ticker := time.NewTicker(rollupInterval)
+	defer ticker.Stop()
+	for {
+		for _, r := range rules {
+			if r.Rollup != nil && ctx.Err() == nil {
+				c.rollUpRule(ctx, r, time.Now())
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// rollUpRule archives each month of the rule's destination that is over
+// and not rolled up yet, then uploads the archives not in cold storage.
+func (c *Copier) rollUpRule(ctx context.Context, rule Rule, now time.Time) {
+	o := rule.Rollup
+	rolled := rolledUp(rule)
+	months := make(map[string][]string)
+	filepath.WalkDir(rule.DestDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if name := d.Name(); path != rule.DestDir && (name == stagingDirName || name == rollupDirName) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || strings.HasSuffix(path, ".partial") || strings.HasSuffix(path, ".tmp") || c.dests.claimed(path) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		month := info.ModTime().Format(rollupLayout)
+		rel, err := filepath.Rel(rule.DestDir, path)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if size, ok := rolled[rel]; ok && size == info.Size() {
+			// Archived before, but its removal failed.
+			if o.Prune {
+				os.Remove(path)
+			}
+			return nil
+		}
+		months[month] = append(months[month], rel)
+		return nil
+	})
+	if ctx.Err() != nil {
+		return
+	}
+	keys := make([]string, 0, len(months))
+	for month := range months {
+		keys = append(keys, month)
+	}
+	sort.Strings(keys)
+	for _, month := range keys {
+		start, err := time.ParseInLocation(rollupLayout, month, now.Location())
+		if err != nil || now.Before(start.AddDate(0, 1, o.afterDays())) {
+			continue
+		}
+		if !o.Prune && rolledMonth(rule, month) {
+			continue
+		}
+		archive, m, err := c.rollUpMonth(rule, month, months[month])
+		if err != nil {
+			logEvent(CategoryDestination, LevelError, "Rule %s: error rolling up %s: %v", rule.Name, month, err)
+			continue
+		}
+		logEvent(CategoryCopy, LevelInfo, "Rule %s: rolled up %d files of %s into %s", rule.Name, len(m.Files), month, archive)
+		if o.Prune {
+			pruneRolledUp(rule, m)
+		}
+	}
+	if o.Cold && rule.Cold != nil {
+		c.pushRollups(ctx, rule)
+	}
+}
+
+// afterDays returns AfterDays or its default.
+func (o *RollupOptions) afterDays() int {
+	if o.AfterDays > 0 {
+		return o.AfterDays
+	}
+	return defaultRollupAfterDay
+}
+
+// rollUpMonth writes the files named in rels, relative to the rule's
+// destination, into the archive of month and its index, returning the
+// archive's path and the index.
+func (c *Copier) rollUpMonth(rule Rule, month string, rels []string) (string, *sessionManifest, error) {
+	sort.Strings(rels)
+	m := &sessionManifest{Rule: rule.Name, Session: month, Created: time.Now()}
+	for _, rel := range rels {
+		path := filepath.Join(rule.DestDir, filepath.FromSlash(rel))
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		sum, err := hashFile(path)
+		if err != nil {
+			return "", nil, err
+		}
+		m.Files = append(m.Files, manifestEntry{Name: rel, Size: info.Size(), SHA256: sum, Modified: info.ModTime()})
+	}
+	if len(m.Files) == 0 {
+		return "", nil, fmt.Errorf("no files left to archive")
+	}
+	index, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", nil, err
+	}
+	dir := filepath.Join(rule.DestDir, rollupDirName)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", nil, err
+	}
+	suffix := archiveSuffix(rule.Rollup.format())
+	base := filepath.Join(dir, month)
+	path := base + suffix
+	for n := 2; ; n++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			break
+		}
+		base = filepath.Join(dir, fmt.Sprintf("%s-%d", month, n))
+		path = base + suffix
+	}
+	tmp := path + ".partial"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return "", nil, err
+	}
+	if rule.Rollup.format() == "zip" {
+		err = writeZip(f, rule.DestDir, m, index)
+	} else {
+		err = writeTarZst(f, rule.DestDir, m, index)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", nil, err
+	}
+	if err := os.WriteFile(base+".index.json", index, 0644); err != nil {
+		return "", nil, err
+	}
+	if o := rule.Ownership; o != nil {
+		for _, p := range []string{path, base + ".index.json"} {
+			if err := o.apply(p, false); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+	return path, m, nil
+}
+
+// pruneRolledUp deletes the originals listed in m, unless they changed
+// since, and the folders left empty.
+func pruneRolledUp(rule Rule, m *sessionManifest) {
+	dirs := make(map[string]bool)
+	for _, e := range m.Files {
+		path := filepath.Join(rule.DestDir, filepath.FromSlash(e.Name))
+		if info, err := os.Stat(path); err != nil || info.Size() != e.Size || !info.ModTime().Equal(e.Modified) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			logEvent(CategoryDestination, LevelWarning, "Rule %s: cannot remove %s after rolling it up: %v", rule.Name, path, err)
+			continue
+		}
+		for dir := filepath.Dir(path); dir != rule.DestDir && isWithin(dir, rule.DestDir); dir = filepath.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	sorted := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sorted = append(sorted, dir)
+	}
+	// Deepest first, so that parents are empty by the time they are tried.
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	for _, dir := range sorted {
+		os.Remove(dir)
+	}
+}
+
+// pushRollups uploads the rule's rollup archives and indexes that are not
+// in cold storage yet, recording the uploads in the history.
+func (c *Copier) pushRollups(ctx context.Context, rule Rule) {
+	dir := filepath.Join(rule.DestDir, rollupDirName)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.HasSuffix(e.Name(), ".partial") || ctx.Err() != nil {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		if rec, ok := c.store.historyByDest(path); ok && rec.ColdURL != "" {
+			continue
+		}
+		location, err := rule.Cold.upload(ctx, rule, path)
+		if err != nil {
+			logEvent(CategoryDestination, LevelWarning, "Rule %s: cannot upload %s to cold storage: %v", rule.Name, path, err)
+			continue
+		}
+		rec := HistoryRecord{Time: time.Now(), Rule: rule.Name, Source: path, Dest: path, ColdURL: location, ColdAt: time.Now()}
+		if info, err := e.Info(); err == nil {
+			rec.Size = info.Size()
+		}
+		if err := c.store.recordCopy(rec); err != nil {
+			logEvent(CategoryDestination, LevelWarning, "Rule %s: cannot record the upload of %s: %v", rule.Name, path, err)
+			continue
+		}
+		logDebugf("Rule %s: uploaded %s to %s", rule.Name, path, location)
+		if rule.Cold.RemoveLocal && !strings.HasSuffix(path, ".index.json") {
+			if err := os.Remove(path); err != nil {
+				logEvent(CategoryDestination, LevelWarning, "Rule %s: cannot remove %s after uploading it: %v", rule.Name, path, err)
+			}
+		}
+	}
+}
+
+// rolledUp returns the files in the rule's rollup indexes, by their path
+// relative to the destination, with their sizes.
+func rolledUp(rule Rule) map[string]int64 {
+	files := make(map[string]int64)
+	for _, m := range rollupManifests(rule) {
+		for _, f := range m.Files {
+			files[f.Name] = f.Size
+		}
+	}
+	return files
+}
+
+// rolledUpCopies indexes the files in the rule's rollup archives, by
+// their path relative to the destination, for reconciliation.
+func rolledUpCopies(rule Rule) map[string]archivedCopy {
+	files := make(map[string]archivedCopy)
+	for _, m := range rollupManifests(rule) {
+		for _, f := range m.Files {
+			files[f.Name] = archivedCopy{size: f.Size, sha256: f.SHA256}
+		}
+	}
+	return files
+}
+
+// rolledMonth reports whether month has been rolled up.
+func rolledMonth(rule Rule, month string) bool {
+	for _, m := range rollupManifests(rule) {
+		if m.Session == month {
+			return true
+		}
+	}
+	return false
+}
+
+// rollupManifests reads the rule's rollup indexes.
+func rollupManifests(rule Rule) []sessionManifest {
+	paths, _ := filepath.Glob(filepath.Join(rule.DestDir, rollupDirName, "*.index.json"))
+	var manifests []sessionManifest
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var m sessionManifest
+		if json.Unmarshal(data, &m) == nil {
+			manifests = append(manifests, m)
+		}
+	}
+	return manifests
+}
+
+// validateRollup checks a rule's rollup settings.
+func validateRollup(key func(string) string, o RuleOptions) ConfigErrors {
+	var problems ConfigErrors
+	r := o.Rollup
+	if r.Format != "" && archiveSuffix(r.Format) == "" {
+		problems = append(problems, fmt.Sprintf("%s.format %q must be \"zip\" or \"tar.zst\"", key("rollup"), r.Format))
+	}
+	if r.AfterDays < 0 {
+		problems = append(problems, key("rollup")+".after_days must not be negative")
+	}
+	if r.Cold && o.Cold == nil {
+		problems = append(problems, key("rollup")+".cold needs the rule's cold tier")
+	}
+	if o.Archive != "" {
+		problems = append(problems, key("rollup")+" cannot be combined with archive")
+	}
+	return problems
+}
@@ -0,0 +1,89 @@
+package foldermonitor
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Route sends the files of a rule that match it to their own destination,
+// so that one source can feed several folders, such as videos to the NAS
+// archive and CSV ball data to the analytics import folder.
+type Route struct {
+	// Match lists file name patterns, compared without regard to case,
+	// such as "*.mp4" or "shot_*.csv". Files of any name match when it is
+	// empty.
+	Match []string `json:"match,omitempty"`
+	// Content narrows the route to files whose first bytes show one of
+	// these kinds: video, image, audio or data.
+	Content []string `json:"content,omitempty"`
+	// DestDir is where matching files are copied instead of the rule's
+	// dest_dir.
+	DestDir string `json:"dest_dir"`
+}
+
+// matches reports whether the file at path belongs to the route. Files
+// whose content cannot be read do not.
+func (r Route) matches(path string) bool {
+	if len(r.Match) > 0 {
+		name := strings.ToLower(filepath.Base(path))
+		matched := false
+		for _, pattern := range r.Match {
+			if ok, _ := filepath.Match(strings.ToLower(pattern), name); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(r.Content) == 0 {
+		return true
+	}
+	kind, err := sniffContent(path)
+	if err != nil {
+		logDebugf("Cannot read the content of %s to route it: %v", path, err)
+		return false
+	}
+	for _, k := range r.Content {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// routed returns rule with its destination replaced by that of the first
+// route the file at path matches. Files matching no route keep the rule's
+// dest_dir.
+func (rule Rule) routed(path string) Rule {
+	for _, r := range rule.Routes {
+		if r.matches(path) {
+			rule.DestDir = r.DestDir
+			return rule
+		}
+	}
+	return rule
+}
+
+// validateRoutes checks a rule's routes.
+func validateRoutes(key func(string) string, routes []Route) ConfigErrors {
+	var problems ConfigErrors
+	for i, r := range routes {
+		rkey := func(field string) string { return fmt.Sprintf("%s[%d].%s", key("routes"), i, field) }
+		if r.DestDir == "" {
+			problems = append(problems, rkey("dest_dir")+" is required")
+		}
+		if len(r.Match) == 0 && len(r.Content) == 0 {
+			problems = append(problems, fmt.Sprintf("%s[%d] must set match or content", key("routes"), i))
+		}
+		for _, pattern := range r.Match {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				problems = append(problems, fmt.Sprintf("%s pattern %q is invalid: %v", rkey("match"), pattern, err))
+			}
+		}
+		problems = append(problems, validateContent(rkey, r.Content)...)
+	}
+	return problems
+}
@@ -0,0 +1,103 @@
+package foldermonitor
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// defaultRsync is the rsync executable looked up on the PATH.
+const defaultRsync = "rsync"
+
+// RsyncOptions push each copy with rsync into the same subfolder on a
+// server, over SSH or to an rsync daemon. A file copied again is sent as
+// a delta against the one already on the server, and an interrupted push
+// resumes from what arrived. The rule's destination folder is the local
+// spool.
+type RsyncOptions struct {
+	// Target is the server and folder, e.g. "archive@nas:/srv/swing" over
+	// SSH or "rsync://nas/swing" for an rsync daemon.
+	Target string `json:"target"`
+	// SSH is the remote shell used for SSH targets (default "ssh"), e.g.
+	// "ssh -i /etc/foldermonitor/id_ed25519 -p 2222". Authentication must
+	// not prompt, so use a key without a passphrase or an agent.
+	SSH string `json:"ssh,omitempty"`
+	// PasswordFile is passed as --password-file for rsync daemons.
+	PasswordFile string `json:"password_file,omitempty"`
+	// Rsync is the executable (default "rsync" on the PATH); Flags are
+	// added to the command line, e.g. ["--bwlimit=10m"].
+	Rsync string   `json:"rsync,omitempty"`
+	Flags []string `json:"flags,omitempty"`
+	// KeepLocal keeps the spooled copy, so that features reading the
+	// destination folder, such as checksums or cold tiering, work on it.
+	KeepLocal bool `json:"keep_local,omitempty"`
+}
+
+// executable returns the rsync executable to run.
+func (o *RsyncOptions) executable() string {
+	if o.Rsync != "" {
+		return o.Rsync
+	}
+	return defaultRsync
+}
+
+// daemon reports whether the target is an rsync daemon.
+func (o *RsyncOptions) daemon() bool {
+	return strings.HasPrefix(o.Target, "rsync://") || strings.Contains(o.Target, "::")
+}
+
+func (o *RsyncOptions) command(rule Rule, dest string) ([]string, string) {
+	rel := spoolPath(rule, dest)
+	// --relative recreates the part after "/./" below the target, so the
+	// subfolders exist on the server without a separate mkdir.
+	args := []string{o.executable(), "--times", "--partial", "--relative"}
+	if o.daemon() {
+		if o.PasswordFile != "" {
+			args = append(args, "--password-file="+rsyncPath(o.PasswordFile))
+		}
+	} else if o.SSH != "" {
+		args = append(args, "--rsh="+o.SSH)
+	}
+	args = append(args, o.Flags...)
+	target := strings.TrimRight(o.Target, "/")
+	args = append(args, rsyncPath(rule.DestDir)+"/./"+rel, target+"/")
+	return args, target + "/" + rel
+}
+
+func (o *RsyncOptions) keepLocal() bool { return o.KeepLocal }
+
+// rsyncPath converts a local path for rsync, which on Windows is a Cygwin
+// build that would read "C:" as a host name.
+func rsyncPath(p string) string {
+	if runtime.GOOS != "windows" {
+		return p
+	}
+	p = filepath.ToSlash(p)
+	if len(p) >= 2 && p[1] == ':' {
+		p = "/cygdrive/" + strings.ToLower(p[:1]) + p[2:]
+	}
+	return p
+}
+
+// validateRsync checks a rule's rsync settings.
+func validateRsync(key func(string) string, o RuleOptions) ConfigErrors {
+	var problems ConfigErrors
+	r := o.Rsync
+	if !r.daemon() && !strings.Contains(r.Target, ":") {
+		problems = append(problems, fmt.Sprintf("%s.target %q must be host:folder or rsync://host/module", key("rsync"), r.Target))
+	}
+	if r.PasswordFile != "" {
+		if !r.daemon() {
+			problems = append(problems, key("rsync")+".password_file is only used with rsync:// targets")
+		} else if _, err := os.Stat(r.PasswordFile); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.password_file: %v", key("rsync"), err))
+		}
+	}
+	if _, err := exec.LookPath(r.executable()); err != nil {
+		problems = append(problems, fmt.Sprintf("%s: rsync %q was not found: %v", key("rsync"), r.executable(), err))
+	}
+	return append(problems, validateSpool(key, o, "rsync", r.KeepLocal)...)
+}
@@ -0,0 +1,135 @@
+package foldermonitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// RuleInfo summarises one rule of any kind for listings such as the
+// web UI.
+type RuleInfo struct {
+	Name    string `json:"name"`
+	Kind    string `json:"kind"`
+	Enabled bool   `json:"enabled"`
+	Source  string `json:"source"`
+	DestDir string `json:"dest_dir"`
+}
+
+// ListRules returns every configured rule: folder rules, removable volumes,
+// devices and the upload receiver.
+func (c *Config) ListRules() []RuleInfo {
+	var rules []RuleInfo
+	for _, r := range c.ActiveRules() {
+		rules = append(rules, RuleInfo{Name: r.Name, Kind: "folder", Enabled: r.IsEnabled(), Source: r.SourceDir, DestDir: r.DestDir})
+	}
+	for _, v := range c.Volumes {
+		rules = append(rules, RuleInfo{Name: v.Name, Kind: "volume", Enabled: v.IsEnabled(), Source: strings.Join(v.Labels, ","), DestDir: v.DestDir})
+	}
+	for _, d := range c.Devices {
+		match := d.Match
+		if match == "" {
+			match = "*"
+		}
+		rules = append(rules, RuleInfo{Name: d.Name, Kind: "device", Enabled: d.IsEnabled(), Source: match, DestDir: d.DestDir})
+	}
+	if u := c.Upload; u != nil {
+		rules = append(rules, RuleInfo{Name: u.Name, Kind: "upload", Enabled: u.IsEnabled(), Source: u.Addr, DestDir: u.DestDir})
+	}
+	return rules
+}
+
+// ruleOptions returns the options of the rule called name, whatever its
+// kind, or nil if there is none.
+func (c *Config) ruleOptions(name string) *RuleOptions {
+	if name == DefaultRuleName && (c.SourceDir != "" || c.DestDir != "") {
+		return &c.RuleOptions
+	}
+	for i := range c.Rules {
+		if c.Rules[i].Name == name {
+			return &c.Rules[i].RuleOptions
+		}
+	}
+	for i := range c.Volumes {
+		if c.Volumes[i].Name == name {
+			return &c.Volumes[i].RuleOptions
+		}
+	}
+	for i := range c.Devices {
+		if c.Devices[i].Name == name {
+			return &c.Devices[i].RuleOptions
+		}
+	}
+	if c.Upload != nil && c.Upload.Name == name {
+		return &c.Upload.RuleOptions
+	}
+	return nil
+}
+
+// SetRuleEnabled switches the named rule on or off. Enabling removes the
+// flag so the config stays as it was before the rule was disabled.
+func (c *Config) SetRuleEnabled(name string, on bool) error {
+	o := c.ruleOptions(name)
+	if o == nil {
+		return fmt.Errorf("no rule named %q", name)
+	}
+	if on {
+		o.Enabled = nil
+	} else {
+		o.Enabled = &on
+	}
+	return nil
+}
+
+// cloneConfig returns a deep copy of cfg, so a running configuration can be
+// modified without racing the goroutines still reading it.
+func cloneConfig(cfg *Config) (*Config, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// ToggleRule enables or disables the named rule in the config file at path.
+// Use Monitor.SetRuleEnabled to change the rule of a running monitor too.
+func ToggleRule(path, name string, on bool) error {
+	cfg, err := ReadConfig(path)
+	if os.IsNotExist(err) {
+		cfg, err = &Config{}, nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := cfg.SetRuleEnabled(name, on); err != nil {
+		return err
+	}
+	if problems := ValidateConfig(cfg); len(problems) > 0 {
+		return problems
+	}
+	return WriteConfig(path, cfg)
+}
+
+// SetRuleEnabled enables or disables the named rule in the monitor's config
+// file and in its running configuration, which is then reloaded.
+func (m *Monitor) SetRuleEnabled(name string, on bool) error {
+	if err := ToggleRule(m.configPath, name, on); err != nil {
+		return err
+	}
+	// Apply the change to the running configuration rather than the file
+	// contents so environment and flag overrides are kept.
+	next, err := cloneConfig(m.Config())
+	if err != nil {
+		return err
+	}
+	if err := next.SetRuleEnabled(name, on); err != nil {
+		return err
+	}
+	m.Reload(next)
+	return nil
+}
@@ -0,0 +1,167 @@
+package foldermonitor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// errExpired marks queued files that were not copied before their rule's
+// schedule expired them.
+var errExpired = errors.New("expired before it could be copied")
+
+// What happens to expired files.
+const (
+	ExpiredDrop       = "drop"
+	ExpiredQuarantine = "quarantine"
+)
+
+// scheduleDays maps the day names a schedule may use to the days they
+// stand for.
+var scheduleDays = map[string][]time.Weekday{
+	"sun": {time.Sunday}, "mon": {time.Monday}, "tue": {time.Tuesday},
+	"wed": {time.Wednesday}, "thu": {time.Thursday}, "fri": {time.Friday},
+	"sat":      {time.Saturday},
+	"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekend":  {time.Saturday, time.Sunday},
+}
+
+// RuleSchedule limits a rule to the times its programs run, such as a
+// junior camp using a bay at weekends: files recorded outside every
+// period are not copied.
+type RuleSchedule struct {
+	// Active lists the periods in which the rule copies what is recorded.
+	Active []SchedulePeriod `json:"active"`
+	// Expire, when set, gives up on files still waiting to be copied, to
+	// be retried or deferred this long after they were recorded.
+	Expire Duration `json:"expire,omitempty"`
+	// Expired is what happens to them: "drop" (the default) forgets them,
+	// "quarantine" quarantines them so they can be released by hand.
+	Expired string `json:"expired,omitempty"`
+}
+
+// SchedulePeriod is a time of day on some days of the week, in local
+// time.
+type SchedulePeriod struct {
+	// Days are "mon" to "sun", "weekdays" or "weekend"; every day when
+	// empty.
+	Days []string `json:"days,omitempty"`
+	// From and Until are times of day such as "08:30"; the whole day when
+	// both are empty. A period whose Until is before its From ends the
+	// next morning.
+	From  string `json:"from,omitempty"`
+	Until string `json:"until,omitempty"`
+}
+
+// covers reports whether t falls in the period.
+func (p SchedulePeriod) covers(t time.Time) bool {
+	from, _ := parseClock(p.From, 0)
+	until, _ := parseClock(p.Until, 24*time.Hour)
+	day := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if from < until {
+		return p.onDay(t.Weekday()) && day >= from && day < until
+	}
+	// Overnight: the evening of a listed day and the morning after it.
+	return p.onDay(t.Weekday()) && day >= from || p.onDay((t.Weekday()+6)%7) && day < until
+}
+
+// onDay reports whether the period includes weekday d.
+func (p SchedulePeriod) onDay(d time.Weekday) bool {
+	if len(p.Days) == 0 {
+		return true
+	}
+	for _, name := range p.Days {
+		for _, w := range scheduleDays[strings.ToLower(name)] {
+			if w == d {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseClock parses a time of day such as "08:30" into the time since
+// midnight, returning def for "".
+func parseClock(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	if s == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time of day such as \"08:30\"", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// outsideSchedule returns why a file with info is skipped for having been
+// recorded outside the rule's schedule, or "" when it is copied.
+func (o RuleOptions) outsideSchedule(info os.FileInfo) string {
+	if o.Schedule == nil {
+		return ""
+	}
+	at := info.ModTime().Local()
+	for _, p := range o.Schedule.Active {
+		if p.covers(at) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("it was recorded on %s, outside the rule's schedule", at.Format("Mon 2006-01-02 15:04"))
+}
+
+// expire applies the rule's expiry to the accepted file of e, reporting
+// whether it is no longer copied.
+func (c *Copier) expire(e Event) bool {
+	s := e.Rule.Schedule
+	if s == nil || s.Expire <= 0 {
+		return false
+	}
+	info, err := os.Stat(e.Source)
+	if err != nil {
+		return false
+	}
+	age := time.Since(info.ModTime())
+	if age < time.Duration(s.Expire) {
+		return false
+	}
+	if s.Expired == ExpiredQuarantine {
+		c.quarantine(e, fmt.Errorf("%s %w: it was recorded %s ago", e.Source, errExpired, age.Round(time.Minute)))
+		return true
+	}
+	logEvent(CategoryCopy, LevelInfo, "Rule %s: dropped %s, recorded %s ago and not copied within %s", e.Rule.Name, e.Source, age.Round(time.Minute), time.Duration(s.Expire))
+	return true
+}
+
+// validateSchedule checks a rule's schedule.
+func validateSchedule(key func(string) string, s *RuleSchedule) ConfigErrors {
+	var problems ConfigErrors
+	if len(s.Active) == 0 {
+		problems = append(problems, key("schedule")+".active must list at least one period")
+	}
+	for i, p := range s.Active {
+		pkey := fmt.Sprintf("%s.active[%d]", key("schedule"), i)
+		for _, d := range p.Days {
+			if _, ok := scheduleDays[strings.ToLower(d)]; !ok {
+				problems = append(problems, fmt.Sprintf("%s.days %q must be mon to sun, weekdays or weekend", pkey, d))
+			}
+		}
+		for _, clock := range []string{p.From, p.Until} {
+			if _, err := parseClock(clock, 0); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", pkey, err))
+			}
+		}
+	}
+	if s.Expire < 0 {
+		problems = append(problems, key("schedule")+".expire must be a positive duration such as \"72h\"")
+	}
+	switch s.Expired {
+	case "", ExpiredDrop, ExpiredQuarantine:
+	default:
+		problems = append(problems, fmt.Sprintf("%s.expired %q must be %q or %q", key("schedule"), s.Expired, ExpiredDrop, ExpiredQuarantine))
+	}
+	return problems
+}
@@ -0,0 +1,64 @@
+package foldermonitor
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSchedulePeriodCovers(t *testing.T) {
+	// 12 October 2026 is a Monday.
+	at := func(day, hour, minute int) time.Time { return time.Date(2026, 10, day, hour, minute, 0, 0, time.Local) }
+	tests := []struct {
+		name   string
+		period SchedulePeriod
+		t      time.Time
+		want   bool
+	}{
+		{name: "every day, all day", period: SchedulePeriod{}, t: at(14, 3, 0), want: true},
+		{name: "inside the hours", period: SchedulePeriod{From: "08:30", Until: "17:00"}, t: at(14, 8, 30), want: true},
+		{name: "before the hours", period: SchedulePeriod{From: "08:30", Until: "17:00"}, t: at(14, 8, 29)},
+		{name: "until is exclusive", period: SchedulePeriod{From: "08:30", Until: "17:00"}, t: at(14, 17, 0)},
+		{name: "until midnight", period: SchedulePeriod{From: "18:00", Until: "24:00"}, t: at(14, 23, 59), want: true},
+		{name: "weekdays on a Friday", period: SchedulePeriod{Days: []string{"weekdays"}}, t: at(16, 12, 0), want: true},
+		{name: "weekdays on a Saturday", period: SchedulePeriod{Days: []string{"weekdays"}}, t: at(17, 12, 0)},
+		{name: "weekend on a Sunday", period: SchedulePeriod{Days: []string{"Weekend"}}, t: at(18, 12, 0), want: true},
+		{name: "listed days", period: SchedulePeriod{Days: []string{"tue", "thu"}}, t: at(15, 12, 0), want: true},
+		{name: "day not listed", period: SchedulePeriod{Days: []string{"tue", "thu"}}, t: at(14, 12, 0)},
+		{name: "overnight evening", period: SchedulePeriod{Days: []string{"fri"}, From: "22:00", Until: "02:00"}, t: at(16, 23, 0), want: true},
+		{name: "overnight next morning", period: SchedulePeriod{Days: []string{"fri"}, From: "22:00", Until: "02:00"}, t: at(17, 1, 59), want: true},
+		{name: "overnight morning of the listed day", period: SchedulePeriod{Days: []string{"fri"}, From: "22:00", Until: "02:00"}, t: at(16, 1, 0)},
+		{name: "overnight after it ends", period: SchedulePeriod{Days: []string{"fri"}, From: "22:00", Until: "02:00"}, t: at(17, 2, 0)},
+		{name: "overnight Sunday into Monday", period: SchedulePeriod{Days: []string{"sun"}, From: "20:00", Until: "06:00"}, t: at(12, 5, 0), want: true},
+	}
+	for _, tt := range tests {
+		if got := tt.period.covers(tt.t); got != tt.want {
+			t.Errorf("%s: covers(%s) = %v, want %v", tt.name, tt.t.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	key := func(field string) string { return field }
+	tests := []struct {
+		name     string
+		schedule RuleSchedule
+		want     string
+	}{
+		{name: "valid", schedule: RuleSchedule{Active: []SchedulePeriod{{Days: []string{"weekend"}, From: "08:00", Until: "12:00"}}, Expire: Duration(72 * time.Hour), Expired: ExpiredQuarantine}},
+		{name: "no periods", schedule: RuleSchedule{}, want: "at least one period"},
+		{name: "unknown day", schedule: RuleSchedule{Active: []SchedulePeriod{{Days: []string{"monday"}}}}, want: `"monday"`},
+		{name: "bad time", schedule: RuleSchedule{Active: []SchedulePeriod{{From: "8am"}}}, want: `"8am"`},
+		{name: "negative expiry", schedule: RuleSchedule{Active: []SchedulePeriod{{}}, Expire: -1}, want: "expire"},
+		{name: "unknown expired action", schedule: RuleSchedule{Active: []SchedulePeriod{{}}, Expired: "delete"}, want: `"delete"`},
+	}
+	for _, tt := range tests {
+		problems := validateSchedule(key, &tt.schedule)
+		switch {
+		case tt.want == "" && len(problems) > 0:
+			t.Errorf("%s: unexpected problems %v", tt.name, problems)
+		case tt.want != "" && (len(problems) == 0 || !strings.Contains(problems[0], tt.want)):
+			t.Errorf("%s: problems %v, want one mentioning %s", tt.name, problems, tt.want)
+		}
+	}
+}
@@ -0,0 +1,492 @@
+package foldermonitor
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// searchFile is the store file indexing every archived clip for search,
+// one JSON record per line. Like the history it is only appended to while
+// running and compacted when loaded; when missing it is seeded from the
+// history.
+const searchFile = "search.jsonl"
+
+// defaultSearchLimit bounds the results of a search without a limit.
+const defaultSearchLimit = 50
+
+// ClipRecord is an archived clip in the search index.
+type ClipRecord struct {
+	// Copied is when the clip was archived, Recorded when it was taken.
+	Copied   time.Time `json:"copied"`
+	Recorded time.Time `json:"recorded"`
+	Rule     string    `json:"rule"`
+	Bay      string    `json:"bay,omitempty"`
+	Student  string    `json:"student,omitempty"`
+	Name     string    `json:"name"`
+	Dest     string    `json:"dest"`
+	Size     int64     `json:"size"`
+	// Length is the duration of a video, when it could be read.
+	Length time.Duration     `json:"length,omitempty"`
+	Tags   map[string]string `json:"tags,omitempty"`
+	// MovedFrom is the earlier destination of the clip, which it replaces
+	// in the index.
+	MovedFrom string `json:"moved_from,omitempty"`
+}
+
+// text returns what the free words of a search are looked up in.
+func (r ClipRecord) text() string {
+	parts := []string{r.Name, r.Rule, r.Bay, r.Student, r.Dest}
+	for k, v := range r.Tags {
+		parts = append(parts, k, v)
+	}
+	return strings.ToLower(strings.Join(parts, "\n"))
+}
+
+// searchIndex is the loaded search index, keyed by destination.
+type searchIndex struct {
+	byDest map[string]ClipRecord
+	lines  int
+}
+
+func (x *searchIndex) apply(rec ClipRecord) {
+	if rec.MovedFrom != "" {
+		delete(x.byDest, rec.MovedFrom)
+	}
+	x.byDest[rec.Dest] = rec
+	x.lines++
+}
+
+// loadSearch reads the search index once, seeding it from the history
+// when it does not exist yet and compacting it when superseded records
+// make up most of it. s.mu must be held.
+func (s *Store) loadSearch() *searchIndex {
+	if s.search != nil {
+		return s.search
+	}
+	x := &searchIndex{byDest: make(map[string]ClipRecord)}
+	s.search = x
+	f, err := os.Open(s.path(searchFile))
+	if os.IsNotExist(err) {
+		for _, h := range s.loadHistory().byDest {
+			x.apply(clipFromHistory(h))
+		}
+		if len(x.byDest) > 0 {
+			s.compactSearch(x)
+		}
+		return x
+	} else if err != nil {
+		return x
+	}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var rec ClipRecord
+		if json.Unmarshal(sc.Bytes(), &rec) == nil && rec.Dest != "" {
+			x.apply(rec)
+		}
+	}
+	f.Close()
+	if x.lines > 2*len(x.byDest)+100 {
+		s.compactSearch(x)
+	}
+	return x
+}
+
+// clipFromHistory returns the search record of a copy in the history,
+// taking the bay and student from its lesson sidecar when it has one.
+func clipFromHistory(h HistoryRecord) ClipRecord {
+	rec := ClipRecord{
+		Copied:   h.Time,
+		Recorded: h.ModTime,
+		Rule:     h.Rule,
+		Bay:      h.Rule,
+		Name:     filepath.Base(h.Dest),
+		Dest:     h.Dest,
+		Size:     h.Size,
+		Length:   h.Length,
+	}
+	if data, err := os.ReadFile(h.Dest + ".json"); err == nil {
+		var side lessonSidecar
+		if json.Unmarshal(data, &side) == nil {
+			if side.Bay != "" {
+				rec.Bay = side.Bay
+			}
+			rec.Student = side.Student
+		}
+	}
+	return rec
+}
+
+// compactSearch rewrites the search index with the current records only.
+// s.mu must be held.
+func (s *Store) compactSearch(x *searchIndex) {
+	if err := os.MkdirAll(s.dir, os.ModePerm); err != nil {
+		return
+	}
+	tmp := s.path(searchFile + ".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, rec := range x.byDest {
+		rec.MovedFrom = ""
+		enc.Encode(rec)
+	}
+	err = w.Flush()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || os.Rename(tmp, s.path(searchFile)) != nil {
+		os.Remove(tmp)
+		return
+	}
+	x.lines = len(x.byDest)
+}
+
+// recordClip appends rec to the search index.
+func (s *Store) recordClip(rec ClipRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	x := s.loadSearch()
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path(searchFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	x.apply(rec)
+	return nil
+}
+
+// moveClip records that the clip at from is now at to.
+func (s *Store) moveClip(from, to string) error {
+	s.mu.Lock()
+	rec, ok := s.loadSearch().byDest[from]
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	rec.Dest, rec.MovedFrom = to, from
+	return s.recordClip(rec)
+}
+
+// indexClip is the bus consumer adding each copy to the search index.
+func (c *Copier) indexClip(e Event) {
+	info, err := os.Stat(e.Source)
+	if err != nil {
+		return
+	}
+	rule := e.Rule
+	rec := ClipRecord{
+		Copied:    e.Time,
+		Recorded:  clipTime(rule, e.Source, info),
+		Rule:      rule.Name,
+		Bay:       rule.Name,
+		Student:   e.lesson["student"],
+		Name:      filepath.Base(e.Dest),
+		Dest:      e.Dest,
+		Size:      info.Size(),
+		MovedFrom: e.RenamedFrom,
+	}
+	if rule.Metadata != nil {
+		if tags, err := metadataTags(rule.Metadata, rule, e.Source, info); err == nil && len(tags) > 0 {
+			rec.Tags = tags
+			if bay := tags["bay"]; bay != "" {
+				rec.Bay = bay
+			}
+		}
+	}
+	if s := rule.Students; s != nil {
+		bay := s.Bay
+		if bay == "" {
+			bay = defaultStudentBay
+		}
+		rec.Bay = expandMediaTemplate(bay, rule, e.Source, info)
+	}
+	if isMedia(e.Source) {
+		if d, err := videoDuration(e.Source); err == nil {
+			rec.Length = d
+		}
+	}
+	if err := c.store.recordClip(rec); err != nil {
+		logEvent(CategoryCopy, LevelWarning, "Error recording %s in the search index: %v", e.Dest, err)
+	}
+}
+
+// Search returns the archived clips matching query, most recently
+// recorded first, at most limit of them (50 when limit is 0). See
+// parseSearch for the query syntax.
+func (s *Store) Search(query string, now time.Time, limit int) ([]ClipRecord, error) {
+	q, err := parseSearch(query, now)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	s.mu.Lock()
+	var found []ClipRecord
+	for _, rec := range s.loadSearch().byDest {
+		if q.matches(rec) {
+			found = append(found, rec)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(found, func(i, j int) bool { return found[i].recorded().After(found[j].recorded()) })
+	if len(found) > limit {
+		found = found[:limit]
+	}
+	return found, nil
+}
+
+// recorded returns when the clip was taken, or copied if that is unknown.
+func (r ClipRecord) recorded() time.Time {
+	if r.Recorded.IsZero() {
+		return r.Copied
+	}
+	return r.Recorded
+}
+
+// searchQuery is a parsed search.
+type searchQuery struct {
+	words []string
+	// from and to bound the recording time; hours the hour of day, from
+	// inclusive to exclusive, wrapping past midnight.
+	from, to        time.Time
+	hours           *[2]int
+	bay, rule, stud string
+	ext             string
+	tags            map[string]string
+	longer, shorter time.Duration
+}
+
+// dayParts are the hours named by words like "afternoon".
+var dayParts = map[string][2]int{
+	"morning":   {5, 12},
+	"afternoon": {12, 17},
+	"evening":   {17, 22},
+	"night":     {22, 5},
+}
+
+// searchStopWords are left out of the free words of a search.
+var searchStopWords = map[string]bool{
+	"a": true, "an": true, "the": true, "of": true, "from": true, "in": true, "on": true,
+	"at": true, "for": true, "with": true, "and": true, "by": true,
+	"clip": true, "clips": true, "video": true, "videos": true, "file": true, "files": true,
+}
+
+// parseSearch parses a query such as "bay 2 clips from last Tuesday
+// afternoon" relative to now. Its words are
+//
+//   - today, yesterday, a weekday (the last one before today, optionally
+//     after "last"), this week, last week, this month and last month;
+//   - morning, afternoon, evening and night;
+//   - "bay" and the bay's name or number;
+//   - key:value filters: bay:, rule:, student:, ext:, tag:name=value,
+//     on:, after: and before: with a date such as 2026-10-13, and
+//     longer: and shorter: with a duration such as 30s;
+//
+// and any other word, which must appear in the clip's name, rule, bay,
+// student, tags or destination.
+func parseSearch(query string, now time.Time) (*searchQuery, error) {
+	q := &searchQuery{tags: make(map[string]string)}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	day := func(d time.Time) { q.from, q.to = d, d.AddDate(0, 0, 1) }
+	fields := strings.Fields(strings.ToLower(query))
+	for i := 0; i < len(fields); i++ {
+		w := fields[i]
+		next := ""
+		if i+1 < len(fields) {
+			next = fields[i+1]
+		}
+		if key, value, ok := strings.Cut(w, ":"); ok && value != "" {
+			if err := q.filter(key, value, now); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if wd, ok := weekday(w); ok {
+			back := (int(today.Weekday()) - int(wd) + 7) % 7
+			if back == 0 {
+				back = 7
+			}
+			day(today.AddDate(0, 0, -back))
+			continue
+		}
+		if hours, ok := dayParts[w]; ok {
+			q.hours = &hours
+			continue
+		}
+		switch {
+		case w == "today":
+			day(today)
+		case w == "yesterday":
+			day(today.AddDate(0, 0, -1))
+		case (w == "this" || w == "last") && next == "week":
+			monday := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+			if w == "last" {
+				monday = monday.AddDate(0, 0, -7)
+			}
+			q.from, q.to = monday, monday.AddDate(0, 0, 7)
+			i++
+		case (w == "this" || w == "last") && next == "month":
+			first := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location())
+			if w == "last" {
+				first = first.AddDate(0, -1, 0)
+			}
+			q.from, q.to = first, first.AddDate(0, 1, 0)
+			i++
+		case w == "last" || w == "this":
+			// "last tuesday" is the weekday on its own.
+		case w == "bay" && next != "":
+			q.bay = next
+			i++
+		case !searchStopWords[w]:
+			q.words = append(q.words, w)
+		}
+	}
+	return q, nil
+}
+
+// filter applies a key:value filter.
+func (q *searchQuery) filter(key, value string, now time.Time) error {
+	date := func() (time.Time, error) {
+		t, err := time.ParseInLocation(time.DateOnly, value, now.Location())
+		if err != nil {
+			return t, fmt.Errorf("%s:%s is not a date such as 2026-10-13", key, value)
+		}
+		return t, nil
+	}
+	length := func() (time.Duration, error) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("%s:%s is not a duration such as 30s", key, value)
+		}
+		return d, nil
+	}
+	var err error
+	switch key {
+	case "bay":
+		q.bay = value
+	case "rule":
+		q.rule = value
+	case "student":
+		q.stud = value
+	case "ext":
+		q.ext = "." + strings.TrimPrefix(value, ".")
+	case "tag":
+		k, v, _ := strings.Cut(value, "=")
+		q.tags[k] = v
+	case "on":
+		var t time.Time
+		if t, err = date(); err == nil {
+			q.from, q.to = t, t.AddDate(0, 0, 1)
+		}
+	case "after":
+		var t time.Time
+		if t, err = date(); err == nil {
+			q.from = t.AddDate(0, 0, 1)
+		}
+	case "before":
+		q.to, err = date()
+	case "longer":
+		q.longer, err = length()
+	case "shorter":
+		q.shorter, err = length()
+	default:
+		// A word with a colon, such as a time of day.
+		q.words = append(q.words, key+":"+value)
+	}
+	return err
+}
+
+// weekday returns the day named by w, such as "tue" or "tuesday".
+func weekday(w string) (time.Weekday, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if w == name || w == name[:3] || w == name+"s" {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// matches reports whether the clip rec satisfies the query.
+func (q *searchQuery) matches(rec ClipRecord) bool {
+	at := rec.recorded().In(time.Local)
+	if !q.from.IsZero() && at.Before(q.from) || !q.to.IsZero() && !at.Before(q.to) {
+		return false
+	}
+	if h := q.hours; h != nil {
+		hour := at.Hour()
+		if h[0] < h[1] && (hour < h[0] || hour >= h[1]) || h[0] > h[1] && hour < h[0] && hour >= h[1] {
+			return false
+		}
+	}
+	if q.bay != "" && !sameBay(rec.Bay, q.bay) && !sameBay(rec.Rule, q.bay) {
+		return false
+	}
+	if q.rule != "" && !strings.EqualFold(rec.Rule, q.rule) {
+		return false
+	}
+	if q.stud != "" && !strings.Contains(strings.ToLower(rec.Student), q.stud) {
+		return false
+	}
+	if q.ext != "" && !strings.EqualFold(filepath.Ext(rec.Name), q.ext) {
+		return false
+	}
+	for k, v := range q.tags {
+		found := false
+		for tk, tv := range rec.Tags {
+			if (v == "" && strings.EqualFold(tv, k)) || (strings.EqualFold(tk, k) && (v == "" || strings.EqualFold(tv, v))) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if q.longer > 0 && rec.Length <= q.longer || q.shorter > 0 && (rec.Length == 0 || rec.Length >= q.shorter) {
+		return false
+	}
+	text := rec.text()
+	for _, w := range q.words {
+		if !strings.Contains(text, w) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameBay reports whether bay is the one a search names as want, such as
+// "Bay 2", "bay2" or "2" for "2".
+func sameBay(bay, want string) bool {
+	norm := func(s string) string {
+		var b strings.Builder
+		for _, r := range strings.ToLower(s) {
+			if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+				b.WriteRune(r)
+			}
+		}
+		return b.String()
+	}
+	b, w := norm(bay), norm(want)
+	return b != "" && strings.TrimPrefix(b, "bay") == strings.TrimPrefix(w, "bay")
+}
@@ -0,0 +1,88 @@
+package foldermonitor
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// preserveSecuritySupported reports whether preserve_security works here.
+const preserveSecuritySupported = true
+
+// copySecurity gives dst the permission bits, owner and extended
+// attributes of src, including its POSIX ACLs, which are stored as the
+// system.posix_acl_* attributes. SELinux labels belong to the policy of
+// each machine and are left alone. The owner is only copied when running
+// as root.
+func copySecurity(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(dst, info.Mode().Perm()); err != nil {
+		return err
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && os.Geteuid() == 0 {
+		if err := os.Chown(dst, int(st.Uid), int(st.Gid)); err != nil {
+			return err
+		}
+	}
+	names, err := xattrNames(src)
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if name == "security.selinux" {
+			continue
+		}
+		value, err := xattr(src, name)
+		if err != nil {
+			return err
+		}
+		if err := unix.Setxattr(dst, name, value, 0); err != nil {
+			return &os.PathError{Op: "setxattr " + name, Path: dst, Err: err}
+		}
+	}
+	return nil
+}
+
+// xattrNames lists the extended attributes of path.
+func xattrNames(path string) ([]string, error) {
+	for {
+		size, err := unix.Listxattr(path, nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		n, err := unix.Listxattr(path, buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue // grew in between
+		} else if err != nil {
+			return nil, err
+		}
+		return strings.FieldsFunc(string(buf[:n]), func(r rune) bool { return r == 0 }), nil
+	}
+}
+
+// xattr reads one extended attribute of path.
+func xattr(path, name string) ([]byte, error) {
+	for {
+		size, err := unix.Getxattr(path, name, nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		n, err := unix.Getxattr(path, name, buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !windows && !linux
+
+package foldermonitor
+
+import "errors"
+
+// preserveSecuritySupported reports whether preserve_security works here.
+const preserveSecuritySupported = false
+
+// copySecurity is not available on this system; validation rejects
+// preserve_security.
+func copySecurity(src, dst string) error {
+	return errors.New("preserve_security is only supported on Windows and Linux")
+}
@@ -0,0 +1,37 @@
+package foldermonitor
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows"
+)
+
+// preserveSecuritySupported reports whether preserve_security works here.
+const preserveSecuritySupported = true
+
+// copySecurity gives dst the NTFS access control list of src, including
+// the entries src inherited, and protects it from inheriting those of the
+// destination folder. The owner and group are copied too when the service
+// may set them, which needs the restore privilege.
+func copySecurity(src, dst string) error {
+	sd, err := windows.GetNamedSecurityInfo(src, windows.SE_FILE_OBJECT,
+		windows.DACL_SECURITY_INFORMATION|windows.OWNER_SECURITY_INFORMATION|windows.GROUP_SECURITY_INFORMATION)
+	if err != nil {
+		return err
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		return err
+	}
+	info := windows.SECURITY_INFORMATION(windows.DACL_SECURITY_INFORMATION | windows.PROTECTED_DACL_SECURITY_INFORMATION)
+	owner, _, _ := sd.Owner()
+	group, _, _ := sd.Group()
+	if owner != nil && group != nil {
+		err = windows.SetNamedSecurityInfo(dst, windows.SE_FILE_OBJECT,
+			info|windows.OWNER_SECURITY_INFORMATION|windows.GROUP_SECURITY_INFORMATION, owner, group, dacl, nil)
+		if !errors.Is(err, windows.ERROR_INVALID_OWNER) && !errors.Is(err, windows.ERROR_PRIVILEGE_NOT_HELD) && !errors.Is(err, windows.ERROR_ACCESS_DENIED) {
+			return err
+		}
+	}
+	return windows.SetNamedSecurityInfo(dst, windows.SE_FILE_OBJECT, info, nil, nil, dacl, nil)
+}
@@ -0,0 +1,242 @@
+package foldermonitor
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Self-test defaults.
+const (
+	defaultSelfTestFiles   = 5
+	defaultSelfTestSize    = 8 << 20
+	defaultSelfTestChunk   = 256 << 10
+	defaultSelfTestDelay   = 20 * time.Millisecond
+	defaultSelfTestTimeout = 2 * time.Minute
+)
+
+// SelfTestOptions tune SelfTest. Zero values select the defaults.
+type SelfTestOptions struct {
+	// Files is how many videos the simulated camera writes (default 5).
+	Files int
+	// Size is the size of each file in bytes (default 8 MiB).
+	Size int64
+	// Chunk is how much the camera writes at a time (default 256 KiB),
+	// pausing WriteDelay between chunks (default 20ms) like a recorder
+	// flushing its output.
+	Chunk      int64
+	WriteDelay time.Duration
+	// QuietPeriod, StallTimeout and LockRetries are used as in the site's
+	// configuration, so the test exercises its timing.
+	QuietPeriod  Duration
+	StallTimeout Duration
+	LockRetries  int
+	// Dir is where the temporary source, destination and store are made
+	// (default the system temp folder). Keep leaves them for inspection.
+	Dir  string
+	Keep bool
+	// Timeout bounds the whole test (default 2m).
+	Timeout time.Duration
+	// Progress, when set, is called with a line for each step.
+	Progress func(msg string)
+}
+
+// SelfTestReport is the outcome of SelfTest.
+type SelfTestReport struct {
+	// Dir is the temporary folder of the test; it is gone unless kept.
+	Dir      string
+	Files    int
+	Verified int
+	// Failures describe each file that was not copied intact.
+	Failures []string
+	Duration time.Duration
+}
+
+// Passed reports whether every file arrived intact.
+func (r *SelfTestReport) Passed() bool {
+	return len(r.Failures) == 0 && r.Verified == r.Files
+}
+
+// SelfTest runs the whole pipeline of this build against temporary
+// folders: a simulated camera writes files incrementally into the source,
+// a monitor watches and copies them, and each copy is compared with what
+// was written. It validates a build on site before rollout without
+// touching the real configuration. The error is for a test that could not
+// run; a failed one is reported in the SelfTestReport.
+func SelfTest(ctx context.Context, opts SelfTestOptions) (*SelfTestReport, error) {
+	if opts.Files <= 0 {
+		opts.Files = defaultSelfTestFiles
+	}
+	if opts.Size <= 0 {
+		opts.Size = defaultSelfTestSize
+	}
+	if opts.Chunk <= 0 {
+		opts.Chunk = defaultSelfTestChunk
+	}
+	if opts.WriteDelay <= 0 {
+		opts.WriteDelay = defaultSelfTestDelay
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultSelfTestTimeout
+	}
+	progress := opts.Progress
+	if progress == nil {
+		progress = func(string) {}
+	}
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	dir, err := os.MkdirTemp(opts.Dir, "foldermonitor-selftest-")
+	if err != nil {
+		return nil, err
+	}
+	if !opts.Keep {
+		defer os.RemoveAll(dir)
+	}
+	src, dst := filepath.Join(dir, "source"), filepath.Join(dir, "dest")
+	for _, d := range []string{src, dst} {
+		if err := os.Mkdir(d, os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+	cfg := &Config{
+		SourceDir:    src,
+		DestDir:      dst,
+		QuietPeriod:  opts.QuietPeriod,
+		StallTimeout: opts.StallTimeout,
+		LockRetries:  opts.LockRetries,
+	}
+	if problems := ValidateConfig(cfg); len(problems) > 0 {
+		return nil, problems
+	}
+
+	// Collect the latest outcome of each file as the monitor reports it;
+	// transient failures are retried and reported again.
+	var mu sync.Mutex
+	outcomes := make(map[string]Event)
+	arrived := make(chan struct{}, 1)
+	m := New(cfg, Options{ConfigPath: filepath.Join(dir, "config.json")})
+	m.Subscribe(func(e Event) {
+		mu.Lock()
+		outcomes[filepath.Base(e.Source)] = e
+		mu.Unlock()
+		select {
+		case arrived <- struct{}{}:
+		default:
+		}
+	}, EventCopied, EventFailed)
+	settled := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		final := 0
+		for _, e := range outcomes {
+			if e.Err == nil || e.Class != ClassTransient {
+				final++
+			}
+		}
+		return final >= opts.Files
+	}
+	if err := m.Start(); err != nil {
+		return nil, err
+	}
+	defer m.Stop()
+
+	// The rules start in the background; files written before the watch
+	// is attached would never be seen.
+	for len(m.Status().Watching) == 0 {
+		select {
+		case <-time.After(50 * time.Millisecond):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("monitor did not start watching %s", src)
+		}
+	}
+
+	report := &SelfTestReport{Dir: dir, Files: opts.Files}
+	start := time.Now()
+	written := make(map[string]string, opts.Files)
+	for i := 1; i <= opts.Files; i++ {
+		name := fmt.Sprintf("swing_%03d.mp4", i)
+		progress(fmt.Sprintf("Writing %s (%s)", name, FormatBytes(opts.Size)))
+		sum, err := writeLikeCamera(ctx, filepath.Join(src, name), opts)
+		if err != nil {
+			return nil, fmt.Errorf("writing %s: %v", name, err)
+		}
+		written[name] = sum
+	}
+
+	progress("Waiting for the copies")
+wait:
+	for !settled() {
+		select {
+		case <-arrived:
+		case <-ctx.Done():
+			break wait
+		}
+	}
+	report.Duration = time.Since(start)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i := 1; i <= opts.Files; i++ {
+		name := fmt.Sprintf("swing_%03d.mp4", i)
+		e, ok := outcomes[name]
+		switch {
+		case !ok:
+			report.Failures = append(report.Failures, fmt.Sprintf("%s: not copied within %s", name, opts.Timeout))
+		case e.Err != nil:
+			report.Failures = append(report.Failures, fmt.Sprintf("%s: %v", name, e.Err))
+		default:
+			sum, err := hashFile(e.Dest)
+			switch {
+			case err != nil:
+				report.Failures = append(report.Failures, fmt.Sprintf("%s: %v", name, err))
+			case sum != written[name]:
+				report.Failures = append(report.Failures, fmt.Sprintf("%s: copy %s differs from what the camera wrote", name, e.Dest))
+			case e.SHA256 != "" && e.SHA256 != sum:
+				report.Failures = append(report.Failures, fmt.Sprintf("%s: reported SHA-256 %s does not match the copy", name, e.SHA256))
+			default:
+				report.Verified++
+				progress(fmt.Sprintf("Verified %s", e.Dest))
+			}
+		}
+	}
+	return report, nil
+}
+
+// writeLikeCamera writes opts.Size random bytes to path in chunks, keeping
+// the file open between them as a recorder does, and returns their SHA-256.
+func writeLikeCamera(ctx context.Context, path string, opts SelfTestOptions) (string, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	w := io.MultiWriter(f, h)
+	buf := make([]byte, opts.Chunk)
+	for left := opts.Size; left > 0; left -= int64(len(buf)) {
+		if left < int64(len(buf)) {
+			buf = buf[:left]
+		}
+		rand.Read(buf)
+		if _, err := w.Write(buf); err != nil {
+			return "", err
+		}
+		select {
+		case <-time.After(opts.WriteDelay):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,26 @@
+package foldermonitor
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSelfTest(t *testing.T) {
+	report, err := SelfTest(context.Background(), SelfTestOptions{
+		Files:       3,
+		Size:        256 << 10,
+		Chunk:       64 << 10,
+		WriteDelay:  5 * time.Millisecond,
+		QuietPeriod: Duration(200 * time.Millisecond),
+		Dir:         t.TempDir(),
+		Timeout:     time.Minute,
+		Progress:    func(msg string) { t.Log(msg) },
+	})
+	if err != nil {
+		t.Fatalf("SelfTest: %v", err)
+	}
+	if !report.Passed() {
+		t.Fatalf("%d of %d files verified: %v", report.Verified, report.Files, report.Failures)
+	}
+}
@@ -0,0 +1,61 @@
+package foldermonitor
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// ServiceConfig controls how "monitor install" registers the service with
+// the Windows service manager, systemd or launchd. Changes take effect when
+// the service is installed again.
+type ServiceConfig struct {
+	// UserName runs the service under this account, e.g. "CLUB\svc-video"
+	// for a domain account that can reach the NAS. The default is
+	// LocalSystem on Windows and root elsewhere.
+	UserName string `json:"user_name,omitempty"`
+	// Password of UserName on Windows. Prefer "install -password" or
+	// FM_SERVICE_PASSWORD so it is not kept in the file.
+	Password string `json:"password,omitempty"`
+	// Dependencies are services that must be running first, such as
+	// "LanmanWorkstation" on Windows or "After=network-online.target" for
+	// systemd.
+	Dependencies []string `json:"dependencies,omitempty"`
+	// StartType is "automatic" (default), "delayed" (automatic, after the
+	// other automatic services), "manual" or "disabled". Windows only.
+	StartType string `json:"start_type,omitempty"`
+	// OnFailure is what happens when the service exits unexpectedly:
+	// "restart" (default), "reboot" (Windows only) or "none".
+	OnFailure string `json:"on_failure,omitempty"`
+	// RestartDelay is how long to wait before restarting (default 5s).
+	// Windows only; systemd waits two minutes.
+	RestartDelay Duration `json:"restart_delay,omitempty"`
+	// FailureResetPeriod is how long the service must run without failing
+	// before Windows forgets earlier failures (default 1h).
+	FailureResetPeriod Duration `json:"failure_reset_period,omitempty"`
+}
+
+// validateService checks the service installation settings.
+func validateService(s *ServiceConfig) ConfigErrors {
+	var problems ConfigErrors
+	switch s.StartType {
+	case "", "automatic", "delayed", "manual", "disabled":
+	default:
+		problems = append(problems, fmt.Sprintf("service.start_type %q is not supported (want automatic, delayed, manual or disabled)", s.StartType))
+	}
+	switch s.OnFailure {
+	case "", "restart", "none":
+	case "reboot":
+		if runtime.GOOS != "windows" {
+			problems = append(problems, "service.on_failure \"reboot\" is only supported on Windows")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("service.on_failure %q is not supported (want restart, reboot or none)", s.OnFailure))
+	}
+	if s.Password != "" && s.UserName == "" {
+		problems = append(problems, "service.password is set without service.user_name")
+	}
+	if s.RestartDelay < 0 || s.FailureResetPeriod < 0 {
+		problems = append(problems, "service.restart_delay and service.failure_reset_period must not be negative")
+	}
+	return problems
+}
@@ -0,0 +1,90 @@
+package foldermonitor
+
+import (
+	"runtime"
+	"time"
+)
+
+// Session defaults.
+const (
+	defaultHeavySize       = 100 << 20
+	defaultSessionMaxDefer = 4 * time.Hour
+)
+
+// SessionEvent is a change of the interactive session on the console, as
+// reported by the Windows service manager.
+type SessionEvent int
+
+const (
+	SessionLock SessionEvent = iota + 1
+	SessionUnlock
+	SessionLogon
+	SessionLogoff
+)
+
+func (e SessionEvent) String() string {
+	switch e {
+	case SessionLock:
+		return "locked"
+	case SessionUnlock:
+		return "unlocked"
+	case SessionLogon:
+		return "logged on"
+	case SessionLogoff:
+		return "logged off"
+	}
+	return "changed"
+}
+
+// Console session states; consoleUnknown until the first session change
+// is reported.
+const (
+	consoleUnknown int32 = iota
+	consoleInUse
+	consoleIdle
+)
+
+// SessionConfig makes the monitor aware of the console session, so that
+// archiving never competes with an active lesson. Windows only, and only
+// when running as a service: the state comes from the service manager's
+// session-change notifications, and nothing is deferred until the first
+// lock, unlock, logon or logoff after the service started.
+type SessionConfig struct {
+	// DeferHeavy holds back copies of files of at least HeavySize bytes
+	// (default 100 MiB) from watched folders while the console session is
+	// logged on and unlocked. They are copied once it is locked or logged
+	// off, or after MaxDefer (default 4h) at the latest.
+	DeferHeavy bool     `json:"defer_heavy,omitempty"`
+	HeavySize  int64    `json:"heavy_size,omitempty"`
+	MaxDefer   Duration `json:"max_defer,omitempty"`
+}
+
+// SessionChanged records a change of the console session, logging it and
+// starting deferred copies once the console is no longer in use.
+func (m *Monitor) SessionChanged(e SessionEvent) {
+	state := consoleIdle
+	if e == SessionUnlock || e == SessionLogon {
+		state = consoleInUse
+	}
+	m.console.Store(state)
+	logEvent(CategoryService, LevelInfo, "Console session %s", e)
+	if c := m.copier.Load(); c != nil && state == consoleIdle {
+		// Copying must not hold up the service manager's notifications.
+		go c.releaseDeferred()
+	}
+}
+
+// validateSession checks the session settings.
+func validateSession(s *SessionConfig) ConfigErrors {
+	var problems ConfigErrors
+	if s.HeavySize < 0 {
+		problems = append(problems, "session.heavy_size must not be negative")
+	}
+	if s.DeferHeavy && runtime.GOOS != "windows" {
+		problems = append(problems, "session.defer_heavy is only supported on Windows")
+	}
+	if s.MaxDefer < 0 {
+		problems = append(problems, "session.max_defer must be a positive duration such as \"4h\"")
+	}
+	return problems
+}
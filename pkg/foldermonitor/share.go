@@ -0,0 +1,181 @@
+package foldermonitor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Share defaults.
+const (
+	defaultLogoPosition = "bottom-right"
+	defaultTextPosition = "bottom-left"
+	defaultFontSize     = 28
+	// watermarkMargin is the distance in pixels from the frame's edge.
+	watermarkMargin = 20
+)
+
+// ShareOptions write a second, branded copy of each video to a folder that
+// students can see. The archive copy is not changed; the share copy gets
+// the rule's trim and metadata as well as the overlay, and is never
+// compressed or encrypted.
+type ShareOptions struct {
+	// DestDir is the share folder. The source's subfolders are kept below
+	// it.
+	DestDir string `json:"dest_dir"`
+	// Logo is a PNG image, typically with transparency, laid over the
+	// video at LogoPosition: top-left, top-right, bottom-left or
+	// bottom-right (default bottom-right).
+	Logo         string `json:"logo,omitempty"`
+	LogoPosition string `json:"logo_position,omitempty"`
+	// Text is written over the video at TextPosition (default bottom-left).
+	// It may contain the placeholders of metadata tags, e.g.
+	// "Studio 1 · {date} · {rule}".
+	Text         string `json:"text,omitempty"`
+	TextPosition string `json:"text_position,omitempty"`
+	// Font is the TrueType font file for Text; Arial is used on Windows
+	// and the fontconfig default elsewhere. FontSize is in pixels
+	// (default 28).
+	Font     string `json:"font,omitempty"`
+	FontSize int    `json:"font_size,omitempty"`
+}
+
+// overlayPositions map a position name to the overlay filter's x:y for a
+// logo and the drawtext filter's x and y for text.
+var overlayPositions = map[string][2]string{
+	"top-left":     {"%[1]d:%[1]d", "x=%[1]d:y=%[1]d"},
+	"top-right":    {"W-w-%[1]d:%[1]d", "x=w-tw-%[1]d:y=%[1]d"},
+	"bottom-left":  {"%[1]d:H-h-%[1]d", "x=%[1]d:y=h-th-%[1]d"},
+	"bottom-right": {"W-w-%[1]d:H-h-%[1]d", "x=w-tw-%[1]d:y=h-th-%[1]d"},
+}
+
+// escapeFilterValue quotes s for use as an option value in an ffmpeg
+// filtergraph, which is parsed twice: once as the filter's options and once
+// as the graph.
+func escapeFilterValue(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(s)
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`).Replace(s)
+}
+
+// watermark adds the share overlay to j, which re-encodes the video.
+func (j *ffmpegJob) watermark(s *ShareOptions, rule Rule, path string, info os.FileInfo) {
+	var chain []string
+	in := "[0:v]"
+	if s.Logo != "" {
+		j.inputs = append(j.inputs, s.Logo)
+		pos := overlayPositions[orDefault(s.LogoPosition, defaultLogoPosition)][0]
+		chain = append(chain, in+"[1:v]overlay="+fmt.Sprintf(pos, watermarkMargin))
+		in = "[logo]"
+	}
+	if s.Text != "" {
+		size := s.FontSize
+		if size <= 0 {
+			size = defaultFontSize
+		}
+		text := "drawtext=expansion=none:text=" + escapeFilterValue(expandMediaTemplate(s.Text, rule, path, info))
+		if font := shareFont(s); font != "" {
+			text += ":fontfile=" + escapeFilterValue(filepath.ToSlash(font))
+		}
+		pos := overlayPositions[orDefault(s.TextPosition, defaultTextPosition)][1]
+		text += fmt.Sprintf(":fontsize=%d:fontcolor=white:box=1:boxcolor=black@0.4:boxborderw=8:", size) +
+			fmt.Sprintf(pos, watermarkMargin)
+		if len(chain) > 0 {
+			chain[len(chain)-1] += in
+		}
+		chain = append(chain, in+text)
+	}
+	if len(chain) == 0 {
+		return
+	}
+	j.filter = strings.Join(chain, ";") + "[v]"
+	j.encode = true
+}
+
+// orDefault returns s, or def when s is empty.
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// shareFont returns the font file for the overlay text, or "" to leave the
+// choice to ffmpeg.
+func shareFont(s *ShareOptions) string {
+	if s.Font != "" {
+		return s.Font
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("WINDIR"), "Fonts", "arial.ttf")
+	}
+	return ""
+}
+
+// shareCopy is the bus consumer writing the share copy of a copied video.
+// A failure is logged but does not fail the archive copy.
+func (c *Copier) shareCopy(e Event) {
+	s := e.Rule.Share
+	if s == nil || e.RenamedFrom != "" || !isMedia(e.Source) {
+		return
+	}
+	ctx := e.Context()
+	info, err := os.Stat(e.Source)
+	if err != nil {
+		return
+	}
+	relDir, _ := relInside(e.Rule.SourceDir, filepath.Dir(e.Source))
+	destDir := filepath.Join(s.DestDir, relDir)
+	if err := os.MkdirAll(destDir, os.ModePerm); err != nil {
+		logEvent(CategoryDestination, LevelError, "Rule %s: cannot create share folder: %v", e.Rule.Name, err)
+		return
+	}
+	dest := filepath.Join(destDir, filepath.Base(e.Source))
+	// ffmpeg picks the container by extension, so the temporary name keeps it.
+	tmp := filepath.Join(destDir, ".share-"+filepath.Base(e.Source))
+	job, err := mediaJob(e.Rule, e.Source, info)
+	if err == nil {
+		job.watermark(s, e.Rule, e.Source, info)
+		err = c.runFFmpeg(ctx, e.Rule, job, e.Source, tmp)
+	}
+	if err == nil {
+		err = os.Rename(tmp, dest)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		if ctx.Err() == nil {
+			logEvent(CategoryCopyFailure, LevelWarning, "Rule %s: share copy of %s failed: %v", e.Rule.Name, e.Source, err)
+		}
+		return
+	}
+	logEvent(CategoryCopy, LevelInfo, "Rule %s: shared %s to %s", e.Rule.Name, e.Source, dest)
+}
+
+// validateShare checks a rule's share settings.
+func validateShare(key func(string) string, s *ShareOptions) ConfigErrors {
+	var problems ConfigErrors
+	if s.DestDir == "" {
+		problems = append(problems, key("share")+".dest_dir is required")
+	}
+	if s.Logo == "" && s.Text == "" {
+		problems = append(problems, key("share")+" needs a logo or text")
+	}
+	for _, f := range []struct{ name, path string }{{"logo", s.Logo}, {"font", s.Font}} {
+		if f.path == "" {
+			continue
+		}
+		if _, err := os.Stat(f.path); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.%s: %v", key("share"), f.name, err))
+		}
+	}
+	for _, p := range []struct{ name, value string }{{"logo_position", s.LogoPosition}, {"text_position", s.TextPosition}} {
+		if _, ok := overlayPositions[p.value]; p.value != "" && !ok {
+			problems = append(problems, fmt.Sprintf("%s.%s %q is not supported (want top-left, top-right, bottom-left or bottom-right)", key("share"), p.name, p.value))
+		}
+	}
+	if s.FontSize < 0 {
+		problems = append(problems, key("share")+".font_size must not be negative")
+	}
+	return problems
+}
@@ -0,0 +1,489 @@
+package foldermonitor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Shot data defaults.
+const (
+	defaultShotWindow = 30 * time.Second
+	// maxShotFiles bounds the parsed exports kept in memory.
+	maxShotFiles = 64
+	// maxShotHeaderRows is how far into an export its header is looked for.
+	maxShotHeaderRows = 10
+)
+
+// ShotDataOptions attach the launch monitor shot a clip recorded, read
+// from the CSV exports that land next to the videos, to its sidecar file
+// and a webhook. TrackMan and Foresight GCQuad exports are recognised, as
+// are other CSVs using the same column names.
+type ShotDataOptions struct {
+	// Dir is the folder of the exports (default the clip's source folder).
+	Dir string `json:"dir,omitempty"`
+	// Window is how far apart a shot's time and the clip's time may be
+	// (default 30s). A clip and an export with the same base name belong
+	// together whatever the times; the export's last shot is used when
+	// none is in the window.
+	Window Duration `json:"window,omitempty"`
+	// Sidecar adds the shot to the clip's <name>.json, next to the lesson
+	// of the students option when both are set.
+	Sidecar bool `json:"sidecar,omitempty"`
+	// Webhook receives a JSON POST for every clip copied with a shot.
+	Webhook string `json:"webhook,omitempty"`
+}
+
+// Shot is one row of a launch monitor export.
+type Shot struct {
+	// Format is "trackman", "gcquad" or "csv" for other exports.
+	Format string `json:"format"`
+	// File is the export the shot was read from, Row its line in it.
+	File   string     `json:"file"`
+	Row    int        `json:"row"`
+	Time   *time.Time `json:"time,omitempty"`
+	Player string     `json:"player,omitempty"`
+	Club   string     `json:"club,omitempty"`
+	// Metrics are the recognised values under the names of shotColumns,
+	// in the units of the export, which Units gives where it says.
+	// Directions are negative to the left.
+	Metrics map[string]float64 `json:"metrics"`
+	Units   map[string]string  `json:"units,omitempty"`
+}
+
+// ShotWebhook is the body of a shot data webhook request.
+type ShotWebhook struct {
+	Rule     string    `json:"rule"`
+	Source   string    `json:"source"`
+	Dest     string    `json:"dest"`
+	SHA256   string    `json:"sha256,omitempty"`
+	Bytes    int64     `json:"bytes"`
+	Recorded time.Time `json:"recorded"`
+	Shot     *Shot     `json:"shot"`
+}
+
+// shotColumns maps each metric to the header names exports use for it,
+// lower case without spaces, punctuation or units.
+var shotColumns = map[string][]string{
+	"club_speed":       {"clubspeed"},
+	"ball_speed":       {"ballspeed"},
+	"smash_factor":     {"smashfactor", "smash"},
+	"launch_angle":     {"launchangle", "vla", "verticallaunchangle"},
+	"launch_direction": {"launchdirection", "hla", "horizontallaunchangle", "sideangle", "azimuth"},
+	"spin_rate":        {"spinrate", "totalspin"},
+	"spin_axis":        {"spinaxis", "tilt"},
+	"back_spin":        {"backspin"},
+	"side_spin":        {"sidespin"},
+	"carry":            {"carry", "carrydistance", "carryflat"},
+	"total":            {"total", "totaldistance", "totalflat"},
+	"side":             {"side", "offline", "carryside", "lateral"},
+	"height":           {"height", "peakheight", "maxheight", "apex"},
+	"descent_angle":    {"landingangle", "descentangle"},
+	"attack_angle":     {"attackangle", "angleofattack", "aoa"},
+	"club_path":        {"clubpath"},
+	"face_angle":       {"faceangle", "facetotarget"},
+	"face_to_path":     {"facetopath"},
+	"dynamic_loft":     {"dynamicloft"},
+}
+
+// The header names of the time, club and player columns.
+var (
+	shotTimeColumns   = []string{"datetime", "timestamp", "shottime", "date"}
+	shotClockColumns  = []string{"time"}
+	shotClubColumns   = []string{"club", "clubtype", "clubname"}
+	shotPlayerColumns = []string{"player", "playername", "golfer", "name"}
+)
+
+// shotTimeLayouts are the accepted forms of shot times, in local time
+// unless they carry a zone.
+var shotTimeLayouts = []string{
+	time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04",
+	"1/2/2006 3:04:05 PM", "1/2/2006 15:04:05", "1/2/2006 3:04 PM", "1/2/2006 15:04",
+	"2006/01/02 15:04:05", "02.01.2006 15:04:05", "02.01.2006 15:04",
+}
+
+// shotFiles caches parsed exports by path until they change.
+var shotFiles = struct {
+	sync.Mutex
+	entries map[string]parsedShots
+}{entries: make(map[string]parsedShots)}
+
+type parsedShots struct {
+	size  int64
+	mod   time.Time
+	shots []Shot
+}
+
+// find returns the shot the clip at path recorded, or nil if no export
+// has one.
+func (o *ShotDataOptions) find(rule Rule, path string, info os.FileInfo) *Shot {
+	dir := o.Dir
+	if dir == "" {
+		dir = filepath.Dir(path)
+	}
+	exports, _ := filepath.Glob(filepath.Join(dir, "*.[cC][sS][vV]"))
+	at := clipTime(rule, path, info)
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	window := o.Window.or(defaultShotWindow)
+
+	var best *Shot
+	var bestGap time.Duration
+	for _, export := range exports {
+		shots, err := readShotFile(export)
+		if err != nil {
+			logDebugf("Rule %s: cannot read shot data from %s: %v", rule.Name, export, err)
+			continue
+		}
+		if len(shots) == 0 {
+			continue
+		}
+		named := strings.EqualFold(strings.TrimSuffix(filepath.Base(export), filepath.Ext(export)), base)
+		match := -1
+		for i, s := range shots {
+			if s.Time == nil {
+				continue
+			}
+			if gap := s.Time.Sub(at).Abs(); gap <= window && (match < 0 || gap < shots[match].Time.Sub(at).Abs()) {
+				match = i
+			}
+		}
+		if match < 0 && named {
+			match = len(shots) - 1
+		}
+		if match < 0 {
+			continue
+		}
+		s := shots[match]
+		gap := time.Duration(0)
+		if s.Time != nil && !named {
+			gap = s.Time.Sub(at).Abs()
+		}
+		if best == nil || gap < bestGap {
+			best, bestGap = &s, gap
+		}
+	}
+	return best
+}
+
+// readShotFile returns the shots of the export at path, parsing it again
+// only when it changed.
+func readShotFile(path string) ([]Shot, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	shotFiles.Lock()
+	c, ok := shotFiles.entries[path]
+	shotFiles.Unlock()
+	if ok && c.size == info.Size() && c.mod.Equal(info.ModTime()) {
+		return c.shots, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	shots, err := parseShots(f, filepath.Base(path))
+	if err != nil {
+		return nil, err
+	}
+	shotFiles.Lock()
+	if len(shotFiles.entries) >= maxShotFiles {
+		shotFiles.entries = make(map[string]parsedShots)
+	}
+	shotFiles.entries[path] = parsedShots{size: info.Size(), mod: info.ModTime(), shots: shots}
+	shotFiles.Unlock()
+	return shots, nil
+}
+
+// parseShots reads the shots of a launch monitor export named name. Rows
+// without any metric, such as a row of units or a blank one, are skipped;
+// a row of units below the header sets the units of the metrics.
+func parseShots(r io.Reader, name string) ([]Shot, error) {
+	br := bufio.NewReader(r)
+	// Exports written for Excel may start with a byte order mark and a
+	// "sep=;" line; European ones separate with semicolons and use decimal
+	// commas.
+	if b, _ := br.Peek(3); bytes.Equal(b, []byte{0xEF, 0xBB, 0xBF}) {
+		br.Discard(3)
+	}
+	sep := ','
+	if b, _ := br.Peek(4); strings.EqualFold(string(b), "sep=") {
+		line, _ := br.ReadString('\n')
+		if s := strings.TrimSpace(line[4:]); s != "" {
+			sep = rune(s[0])
+		}
+	} else if b, _ := br.Peek(4096); bytes.Count(firstLine(b), []byte{';'}) > bytes.Count(firstLine(b), []byte{','}) {
+		sep = ';'
+	}
+	cr := csv.NewReader(br)
+	cr.Comma = sep
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	// Some exports put a title or the player above the header.
+	var keys []string
+	columns := make(map[string]int)
+	units := make(map[string]string)
+	for tries := 0; len(columns) == 0; tries++ {
+		header, err := cr.Read()
+		if err == io.EOF || tries == maxShotHeaderRows {
+			return nil, fmt.Errorf("no launch monitor columns in %s", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = make([]string, len(header))
+		for i, h := range header {
+			key, unit := shotHeader(h)
+			keys[i] = key
+			if metric := shotMetric(key); metric != "" {
+				if _, dup := columns[metric]; !dup {
+					columns[metric] = i
+					if unit != "" {
+						units[metric] = unit
+					}
+				}
+			}
+		}
+	}
+	format := shotFormat(keys)
+	text := func(rec []string, names []string) string {
+		for _, n := range names {
+			for i, k := range keys {
+				if k == n && i < len(rec) && strings.TrimSpace(rec[i]) != "" {
+					return strings.TrimSpace(rec[i])
+				}
+			}
+		}
+		return ""
+	}
+
+	var shots []Shot
+	for {
+		// An export still being written may end in half a row.
+		rec, err := cr.Read()
+		if err != nil {
+			break
+		}
+		row, _ := cr.FieldPos(0)
+		metrics := make(map[string]float64)
+		for metric, i := range columns {
+			if i < len(rec) {
+				if v, ok := parseShotValue(rec[i], sep == ';'); ok {
+					metrics[metric] = v
+				}
+			}
+		}
+		if len(metrics) == 0 {
+			if len(shots) == 0 {
+				for metric, i := range columns {
+					if i < len(rec) && strings.HasPrefix(strings.TrimSpace(rec[i]), "[") {
+						units[metric] = strings.Trim(strings.TrimSpace(rec[i]), "[]")
+					}
+				}
+			}
+			continue
+		}
+		s := Shot{Format: format, File: name, Row: row, Player: text(rec, shotPlayerColumns), Club: text(rec, shotClubColumns), Metrics: metrics}
+		if t, ok := shotTime(text(rec, shotTimeColumns), text(rec, shotClockColumns)); ok {
+			s.Time = &t
+		}
+		shots = append(shots, s)
+	}
+	for i := range shots {
+		if len(units) > 0 {
+			shots[i].Units = units
+		}
+	}
+	return shots, nil
+}
+
+// firstLine returns the first line of b.
+func firstLine(b []byte) []byte {
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		return b[:i]
+	}
+	return b
+}
+
+// shotHeader returns the key of a column header, e.g. "ballspeed" for
+// "Ball Speed (mph)", and the unit it names.
+func shotHeader(h string) (key, unit string) {
+	for _, p := range [][2]string{{"(", ")"}, {"[", "]"}} {
+		if i := strings.Index(h, p[0]); i >= 0 {
+			if j := strings.Index(h[i:], p[1]); j > 0 {
+				unit = strings.TrimSpace(h[i+1 : i+j])
+				h = h[:i] + h[i+j+1:]
+			}
+		}
+	}
+	var b strings.Builder
+	for _, r := range strings.ToLower(h) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String(), unit
+}
+
+// shotMetric returns the metric a column key holds, or "".
+func shotMetric(key string) string {
+	for metric, names := range shotColumns {
+		for _, n := range names {
+			if key == n {
+				return metric
+			}
+		}
+	}
+	return ""
+}
+
+// shotFormat tells which launch monitor wrote an export from its columns.
+func shotFormat(keys []string) string {
+	has := make(map[string]bool)
+	for _, k := range keys {
+		has[k] = true
+	}
+	switch {
+	case has["facetopath"] || has["launchdirection"] || has["spinrate"] && has["swingplane"]:
+		return "trackman"
+	case has["backspin"] && has["sidespin"] || has["hla"] || has["offline"]:
+		return "gcquad"
+	}
+	return "csv"
+}
+
+// parseShotValue parses a metric such as "152.3", "152.3 mph" or "3.2 L",
+// where a trailing L or R gives the side, left being negative.
+func parseShotValue(s string, decimalComma bool) (float64, bool) {
+	s = strings.TrimSpace(s)
+	sign := 1.0
+	if n := len(s); n > 1 {
+		switch s[n-1] {
+		case 'L', 'l':
+			sign, s = -1, strings.TrimSpace(s[:n-1])
+		case 'R', 'r':
+			s = strings.TrimSpace(s[:n-1])
+		}
+	}
+	end := strings.IndexFunc(s, func(r rune) bool {
+		return !unicode.IsDigit(r) && r != '.' && r != ',' && r != '-' && r != '+'
+	})
+	if end >= 0 {
+		s = strings.TrimSpace(s[:end])
+	}
+	if decimalComma {
+		s = strings.ReplaceAll(s, ",", ".")
+	} else {
+		s = strings.ReplaceAll(s, ",", "")
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	return sign * v, true
+}
+
+// shotTime parses the time of a shot, given in one column or as a date
+// and a separate clock time.
+func shotTime(date, clock string) (time.Time, bool) {
+	values := []string{date}
+	if clock != "" {
+		values = []string{date + " " + clock, clock}
+	}
+	for _, v := range values {
+		for _, layout := range shotTimeLayouts {
+			if t, err := time.ParseInLocation(layout, strings.TrimSpace(v), time.Local); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// attachShot adds the shot of the copied clip e to its sidecar and posts
+// it to the webhook. Clips without a shot are left alone.
+func (o *ShotDataOptions) attachShot(e Event, info os.FileInfo) {
+	if !isMedia(e.Source) {
+		return
+	}
+	shot := o.find(e.Rule, e.Source, info)
+	if shot == nil {
+		logDebugf("Rule %s: no launch monitor shot for %s", e.Rule.Name, e.Source)
+		return
+	}
+	if o.Sidecar {
+		if err := mergeSidecar(e.Dest+".json", "shot", shot); err != nil {
+			logEvent(CategoryCopy, LevelWarning, "Rule %s: cannot add the shot to the sidecar of %s: %v", e.Rule.Name, e.Dest, err)
+		}
+	}
+	if o.Webhook == "" {
+		return
+	}
+	body := ShotWebhook{
+		Rule:     e.Rule.Name,
+		Source:   e.Source,
+		Dest:     e.Dest,
+		SHA256:   e.SHA256,
+		Bytes:    e.Bytes,
+		Recorded: clipTime(e.Rule, e.Source, info),
+		Shot:     shot,
+	}
+	go func() {
+		if err := postWebhook(o.Webhook, body); err != nil {
+			logEvent(CategoryCopy, LevelWarning, "Rule %s: shot data webhook for %s: %v", e.Rule.Name, e.Source, err)
+		}
+	}()
+}
+
+// mergeSidecar sets key to v in the JSON object of the sidecar at path,
+// creating it when missing.
+func mergeSidecar(path, key string, v interface{}) error {
+	doc := make(map[string]interface{})
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("%s is not a JSON object: %v", filepath.Base(path), err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	doc[key] = v
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// validateShotData checks a rule's shot data settings.
+func validateShotData(key func(string) string, o *ShotDataOptions) ConfigErrors {
+	var problems ConfigErrors
+	if o.Dir != "" {
+		if info, err := os.Stat(o.Dir); err != nil || !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("%s.dir %q is not a folder", key("shot_data"), o.Dir))
+		}
+	}
+	if o.Window < 0 {
+		problems = append(problems, key("shot_data")+".window must be a positive duration such as \"30s\"")
+	}
+	if !o.Sidecar && o.Webhook == "" {
+		problems = append(problems, key("shot_data")+" needs sidecar or a webhook")
+	}
+	if o.Webhook != "" {
+		if u, err := url.Parse(o.Webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("%s.webhook %q must be an http(s) URL", key("shot_data"), o.Webhook))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,217 @@
+package foldermonitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultSLOAlertInterval limits alerts per rule while files are late.
+	defaultSLOAlertInterval = 5 * time.Minute
+	// sloWebhookTimeout bounds one webhook request.
+	sloWebhookTimeout = 10 * time.Second
+	// sloForgetAfter drops files that were detected but never copied.
+	sloForgetAfter = 24 * time.Hour
+)
+
+// SLOConfig sets how soon files from watched folders must be archived
+// after they first appear, counting the quiet period, retries and the copy
+// itself.
+type SLOConfig struct {
+	// MaxLatency is the longest acceptable time from detection to a
+	// verified copy, e.g. "2m".
+	MaxLatency Duration `json:"max_latency"`
+	// Webhook receives a JSON POST for each alert.
+	Webhook string `json:"webhook,omitempty"`
+	// AlertInterval is the least time between two alerts for the same rule
+	// (default 5m); late files in between are counted in the next alert.
+	AlertInterval Duration `json:"alert_interval,omitempty"`
+}
+
+// SLOAlert is the body of a webhook request and describes the latest late
+// file of a rule.
+type SLOAlert struct {
+	Rule       string    `json:"rule"`
+	Source     string    `json:"source"`
+	Dest       string    `json:"dest"`
+	Detected   time.Time `json:"detected"`
+	Archived   time.Time `json:"archived"`
+	Latency    float64   `json:"latency_seconds"`
+	MaxLatency float64   `json:"max_latency_seconds"`
+	// Late counts the late files since the previous alert, this one
+	// included.
+	Late int `json:"late"`
+}
+
+// sloTracker measures detection-to-archive latency on a copier's bus for
+// the stats and, with an SLO configured, publishes an EventLate for every
+// file over the limit.
+type sloTracker struct {
+	cfg    *SLOConfig // nil when no SLO is configured
+	bus    *Bus
+	status *statusTracker
+	stats  *statsRecorder
+
+	mu       sync.Mutex
+	seen     map[string]time.Time // detected files not yet copied
+	pruned   time.Time
+	alerted  map[string]time.Time // last alert per rule
+	withheld map[string]int       // late files since the last alert per rule
+}
+
+func newSLOTracker(cfg *SLOConfig, bus *Bus, status *statusTracker, stats *statsRecorder) *sloTracker {
+	return &sloTracker{
+		cfg:      cfg,
+		bus:      bus,
+		status:   status,
+		stats:    stats,
+		seen:     make(map[string]time.Time),
+		alerted:  make(map[string]time.Time),
+		withheld: make(map[string]int),
+	}
+}
+
+// detected records when the file of a detected event first appeared. It
+// must run before the copy stage, which handles the file synchronously.
+func (s *sloTracker) detected(e Event) {
+	seen := e.Seen
+	if seen.IsZero() {
+		seen = e.Time
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seen[cleanPath(e.Source)] = seen
+	if time.Since(s.pruned) > time.Hour {
+		for path, t := range s.seen {
+			if time.Since(t) > sloForgetAfter {
+				delete(s.seen, path)
+			}
+		}
+		s.pruned = time.Now()
+	}
+}
+
+// copied measures the latency of a copied file. Files copied as part of a
+// new directory are measured from the directory's detection.
+func (s *sloTracker) copied(e Event) {
+	s.mu.Lock()
+	seen, ok := s.lookup(e.Rule, e.Source)
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	latency := e.Time.Sub(seen)
+	s.stats.latency(e, latency)
+	if s.cfg == nil {
+		return
+	}
+	max := time.Duration(s.cfg.MaxLatency)
+	late := latency > max
+	s.status.recordLatency(latency, late)
+	if !late {
+		return
+	}
+	logEvent(CategoryLatency, LevelWarning, "Rule %s: %s took %s from detection to archive (SLO %s)",
+		e.Rule.Name, e.Source, latency.Round(time.Second), max)
+	e.Kind, e.Seen, e.Latency = EventLate, seen, latency
+	s.bus.Publish(e)
+}
+
+// lookup finds and forgets the detection time of path or, for files of a
+// copied directory, of its nearest detected parent. s.mu must be held.
+func (s *sloTracker) lookup(rule Rule, path string) (time.Time, bool) {
+	root := cleanPath(rule.SourceDir)
+	for p := cleanPath(path); ; p = filepath.Dir(p) {
+		if seen, ok := s.seen[p]; ok {
+			if p == cleanPath(path) {
+				delete(s.seen, p)
+			}
+			return seen, true
+		}
+		if p == root || filepath.Dir(p) == p {
+			return time.Time{}, false
+		}
+	}
+}
+
+// alert posts a late file to the webhook, at most once per AlertInterval
+// and rule.
+func (s *sloTracker) alert(e Event) {
+	if s.cfg.Webhook == "" {
+		return
+	}
+	s.mu.Lock()
+	s.withheld[e.Rule.Name]++
+	if time.Since(s.alerted[e.Rule.Name]) < s.cfg.AlertInterval.or(defaultSLOAlertInterval) {
+		s.mu.Unlock()
+		return
+	}
+	late := s.withheld[e.Rule.Name]
+	s.withheld[e.Rule.Name] = 0
+	s.alerted[e.Rule.Name] = time.Now()
+	s.mu.Unlock()
+
+	a := SLOAlert{
+		Rule:       e.Rule.Name,
+		Source:     e.Source,
+		Dest:       e.Dest,
+		Detected:   e.Seen,
+		Archived:   e.Time,
+		Latency:    e.Latency.Seconds(),
+		MaxLatency: time.Duration(s.cfg.MaxLatency).Seconds(),
+		Late:       late,
+	}
+	go func() {
+		if err := postWebhook(s.cfg.Webhook, a); err != nil {
+			logEvent(CategoryLatency, LevelError, "SLO webhook: %v", err)
+		}
+	}()
+}
+
+// postWebhook sends v as JSON to target.
+func postWebhook(target string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sloWebhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: %s", target, resp.Status)
+	}
+	return nil
+}
+
+// validateSLO checks the latency SLO settings.
+func validateSLO(s *SLOConfig) ConfigErrors {
+	var problems ConfigErrors
+	if s.MaxLatency <= 0 {
+		problems = append(problems, "slo.max_latency must be a positive duration such as \"2m\"")
+	}
+	if s.AlertInterval < 0 {
+		problems = append(problems, "slo.alert_interval must not be negative")
+	}
+	if s.Webhook != "" {
+		if u, err := url.Parse(s.Webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("slo.webhook %q must be an http(s) URL", s.Webhook))
+		}
+	}
+	return problems
+}
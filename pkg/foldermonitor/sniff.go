@@ -0,0 +1,150 @@
+package foldermonitor
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Content kinds told apart by sniffContent.
+const (
+	ContentVideo = "video"
+	ContentImage = "image"
+	ContentAudio = "audio"
+	// ContentData is everything else, including empty files.
+	ContentData = "data"
+)
+
+// contentKinds lists the kinds a rule's content filter may name.
+var contentKinds = []string{ContentVideo, ContentImage, ContentAudio, ContentData}
+
+// sniffSize is how much of a file is read to classify it; MPEG transport
+// streams are recognised by sync bytes in several packets.
+const sniffSize = 1024
+
+// ftypKinds classify ISO base media files (MP4, MOV, HEIC, ...) by the
+// major brand of their ftyp box. Brands not listed are video.
+var ftypKinds = map[string]string{
+	"heic": ContentImage, "heix": ContentImage, "heim": ContentImage, "heis": ContentImage,
+	"hevc": ContentImage, "mif1": ContentImage, "msf1": ContentImage, "avif": ContentImage,
+	"avis": ContentImage, "crx ": ContentImage, "jp2 ": ContentImage,
+	"M4A ": ContentAudio, "M4B ": ContentAudio, "M4P ": ContentAudio, "F4A ": ContentAudio,
+}
+
+// quickTimeAtoms start QuickTime movies written without an ftyp box.
+var quickTimeAtoms = []string{"moov", "mdat", "wide", "free", "skip", "pnot"}
+
+// sniffContent classifies the file at path by its first bytes, whatever
+// its extension.
+func sniffContent(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	head := make([]byte, sniffSize)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return sniffBytes(head[:n]), nil
+}
+
+// sniffBytes classifies a file from its first bytes.
+func sniffBytes(b []byte) string {
+	prefix := func(offset int, sig string) bool {
+		return len(b) >= offset+len(sig) && string(b[offset:offset+len(sig)]) == sig
+	}
+	switch {
+	case prefix(4, "ftyp"):
+		if len(b) >= 12 {
+			if kind, ok := ftypKinds[string(b[8:12])]; ok {
+				return kind
+			}
+		}
+		return ContentVideo
+	case prefix(0, "RIFF") && len(b) >= 12:
+		switch string(b[8:12]) {
+		case "AVI ":
+			return ContentVideo
+		case "WEBP":
+			return ContentImage
+		case "WAVE":
+			return ContentAudio
+		}
+	case prefix(0, "\x1a\x45\xdf\xa3"), // Matroska and WebM
+		prefix(0, "\x30\x26\xb2\x75\x8e\x66\xcf\x11"), // ASF (WMV)
+		prefix(0, "\x06\x0e\x2b\x34\x02\x05\x01\x01"), // MXF
+		prefix(0, "\x00\x00\x01\xba"),                 // MPEG program stream
+		prefix(0, "FLV\x01"),
+		mpegTransportStream(b, 0, 188), mpegTransportStream(b, 4, 192): // TS and AVCHD .mts
+		return ContentVideo
+	case prefix(0, "\xff\xd8\xff"),
+		prefix(0, "\x89PNG\r\n\x1a\n"),
+		prefix(0, "GIF87a"), prefix(0, "GIF89a"),
+		prefix(0, "BM") && len(b) >= 14,
+		// TIFF, and the raw formats built on it such as DNG, CR2 and NEF.
+		prefix(0, "II*\x00"), prefix(0, "MM\x00*"),
+		prefix(0, "IIRO"), prefix(0, "IIU\x00"), // Olympus and Panasonic raw
+		prefix(0, "FUJIFILMCCD-RAW"):
+		return ContentImage
+	case prefix(0, "ID3"), prefix(0, "fLaC"), prefix(0, "OggS"),
+		len(b) >= 2 && b[0] == 0xff && b[1]&0xe0 == 0xe0: // MPEG audio frame
+		return ContentAudio
+	}
+	for _, atom := range quickTimeAtoms {
+		if prefix(4, atom) {
+			return ContentVideo
+		}
+	}
+	return ContentData
+}
+
+// mpegTransportStream reports whether b holds packets of the given size
+// whose sync byte 0x47 is at offset, checking every packet of b.
+func mpegTransportStream(b []byte, offset, size int) bool {
+	packets := 0
+	for i := offset; i < len(b); i += size {
+		if b[i] != 0x47 {
+			return false
+		}
+		packets++
+	}
+	return packets >= 3
+}
+
+// outsideContent returns why the file at path is skipped for its content,
+// or "" when it is copied. Files that cannot be read are copied, so that
+// the copy reports the error.
+func (o RuleOptions) outsideContent(path string) string {
+	if len(o.Content) == 0 {
+		return ""
+	}
+	kind, err := sniffContent(path)
+	if err != nil {
+		logDebugf("Cannot read the content of %s, copying it: %v", path, err)
+		return ""
+	}
+	for _, k := range o.Content {
+		if k == kind {
+			return ""
+		}
+	}
+	return fmt.Sprintf("its content is %s, not %s", kind, strings.Join(o.Content, " or "))
+}
+
+// validateContent checks a rule's content filter.
+func validateContent(key func(string) string, kinds []string) ConfigErrors {
+	var problems ConfigErrors
+	for _, k := range kinds {
+		valid := false
+		for _, c := range contentKinds {
+			valid = valid || k == c
+		}
+		if !valid {
+			problems = append(problems, fmt.Sprintf("%s %q must be one of %s", key("content"), k, strings.Join(contentKinds, ", ")))
+		}
+	}
+	return problems
+}
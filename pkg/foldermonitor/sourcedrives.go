@@ -0,0 +1,341 @@
+package foldermonitor
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Source drive defaults.
+const (
+	defaultDriveInterval = time.Minute
+	defaultDriveMinFree  = 1 << 30
+	defaultSMARTInterval = time.Hour
+	defaultSmartctl      = "smartctl"
+	// driveProbeTimeout bounds the check of one source folder, so that a
+	// hung network share is reported instead of stalling the others.
+	driveProbeTimeout = 5 * time.Second
+)
+
+// SourceDrivesConfig tunes the checks of the drives holding the watched
+// source folders. The checks run every minute without it.
+type SourceDrivesConfig struct {
+	// Interval is how often the drives are checked (default 1m).
+	Interval Duration `json:"interval,omitempty"`
+	// MinFree is the least free space in bytes before a drive is reported,
+	// since cameras recording to it stop when it fills (default 1 GiB; -1
+	// disables the check).
+	MinFree int64 `json:"min_free,omitempty"`
+	// SMART asks smartctl from smartmontools, or the Smartctl executable,
+	// for each drive's health verdict every SMARTInterval (default 1h).
+	SMART         bool     `json:"smart,omitempty"`
+	SMARTInterval Duration `json:"smart_interval,omitempty"`
+	Smartctl      string   `json:"smartctl,omitempty"`
+	// Webhook receives a JSON POST whenever a drive develops a problem or
+	// recovers.
+	Webhook string `json:"webhook,omitempty"`
+}
+
+// minFree returns the free space below which a drive is reported, or 0.
+func (c *SourceDrivesConfig) minFree() uint64 {
+	switch {
+	case c.MinFree < 0:
+		return 0
+	case c.MinFree == 0:
+		return defaultDriveMinFree
+	}
+	return uint64(c.MinFree)
+}
+
+// smartctl returns the smartctl executable to run.
+func (c *SourceDrivesConfig) smartctl() string {
+	if c.Smartctl != "" {
+		return c.Smartctl
+	}
+	return defaultSmartctl
+}
+
+// DriveHealth describes the drive holding the source folders of one or
+// more rules.
+type DriveHealth struct {
+	// Mount is where the drive is mounted, e.g. "/mnt/bay1" or "E:\".
+	Mount  string   `json:"mount"`
+	Device string   `json:"device,omitempty"`
+	Rules  []string `json:"rules"`
+	// Mounted is false when the drive went away or does not respond.
+	Mounted    bool   `json:"mounted"`
+	FreeBytes  uint64 `json:"free_bytes,omitempty"`
+	TotalBytes uint64 `json:"total_bytes,omitempty"`
+	// SMART is smartctl's verdict, e.g. "PASSED" or "FAILED", once checked.
+	SMART   string `json:"smart,omitempty"`
+	Problem string `json:"problem,omitempty"`
+}
+
+// DriveAlert is the body of a source drive webhook request.
+type DriveAlert struct {
+	Host    string    `json:"host"`
+	Mount   string    `json:"mount"`
+	Rules   []string  `json:"rules"`
+	OK      bool      `json:"ok"`
+	Problem string    `json:"problem,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// smartResult is the last SMART verdict of a device.
+type smartResult struct {
+	verdict string
+	checked time.Time
+}
+
+// sourceDrives checks the drives of the watched rules and reports them to
+// the status tracker, alerting when one disappears, fills or fails.
+type sourceDrives struct {
+	cfg    *SourceDrivesConfig
+	rules  []Rule
+	status *statusTracker
+
+	// drive is the mount and device each rule's source folder was last
+	// found on, so that a drive that went away is still named.
+	drive    map[string][2]string
+	problems map[string]string // last reported problem by mount
+	smart    map[string]smartResult
+}
+
+func newSourceDrives(cfg *SourceDrivesConfig, rules []Rule, status *statusTracker) *sourceDrives {
+	if cfg == nil {
+		cfg = &SourceDrivesConfig{}
+	}
+	return &sourceDrives{
+		cfg:      cfg,
+		rules:    rules,
+		status:   status,
+		drive:    make(map[string][2]string),
+		problems: make(map[string]string),
+		smart:    make(map[string]smartResult),
+	}
+}
+
+// run checks the drives now and on every interval until ctx is cancelled.
+func (d *sourceDrives) run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.Interval.or(defaultDriveInterval))
+	defer ticker.Stop()
+	defer d.status.setDrives(nil)
+	for {
+		d.alert(d.check(ctx))
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// driveProbe is what was found out about one rule's source folder.
+type driveProbe struct {
+	mount, device string
+	present       bool
+	// parent is the mount of the nearest existing parent of a missing
+	// folder.
+	parent string
+	err    error
+}
+
+// check probes every source folder and returns the drives they are on.
+func (d *sourceDrives) check(ctx context.Context) []DriveHealth {
+	byMount := make(map[string]*DriveHealth)
+	var mounts []string
+	for _, r := range d.rules {
+		p := probeSourceDrive(ctx, r.SourceDir)
+		known, seen := d.drive[r.Name]
+		if p.present && p.mount != "" {
+			d.drive[r.Name] = [2]string{p.mount, p.device}
+		} else if !p.present && seen {
+			p.mount, p.device = known[0], known[1]
+		}
+		if p.mount == "" {
+			p.mount = r.SourceDir
+		}
+		h := byMount[p.mount]
+		if h == nil {
+			h = &DriveHealth{Mount: p.mount, Device: p.device, Mounted: true}
+			byMount[p.mount] = h
+			mounts = append(mounts, p.mount)
+		}
+		h.Rules = append(h.Rules, r.Name)
+		switch {
+		case p.err != nil:
+			h.Mounted, h.Problem = false, p.err.Error()
+		case p.present:
+		case seen && p.parent != p.mount:
+			h.Mounted, h.Problem = false, "drive is not mounted"
+		default:
+			// A folder never found is not known to be on any drive.
+			h.Mounted = h.Mounted && seen
+			if h.Problem == "" {
+				h.Problem = fmt.Sprintf("source folder %s is missing", r.SourceDir)
+			}
+		}
+	}
+	sort.Strings(mounts)
+
+	drives := make([]DriveHealth, 0, len(mounts))
+	for _, mount := range mounts {
+		h := byMount[mount]
+		if h.Mounted {
+			d.inspect(ctx, h)
+		}
+		drives = append(drives, *h)
+	}
+	d.status.setDrives(drives)
+	return drives
+}
+
+// probeSourceDrive finds the drive of the source folder dir, or of its
+// nearest existing parent when dir is missing, giving up after
+// driveProbeTimeout.
+func probeSourceDrive(ctx context.Context, dir string) driveProbe {
+	ctx, cancel := context.WithTimeout(ctx, driveProbeTimeout)
+	defer cancel()
+	done := make(chan driveProbe, 1)
+	go func() {
+		var p driveProbe
+		if _, err := os.Stat(dir); err == nil {
+			p.present = true
+			p.mount, p.device, _ = driveOf(dir)
+			done <- p
+			return
+		}
+		for path := filepath.Dir(dir); ; path = filepath.Dir(path) {
+			if _, err := os.Stat(path); err == nil {
+				p.parent, _, _ = driveOf(path)
+				break
+			}
+			if filepath.Dir(path) == path {
+				break
+			}
+		}
+		done <- p
+	}()
+	select {
+	case p := <-done:
+		return p
+	case <-ctx.Done():
+		return driveProbe{err: fmt.Errorf("source folder %s gave no response within %s", dir, driveProbeTimeout)}
+	}
+}
+
+// inspect adds the free space and SMART verdict of a mounted drive.
+func (d *sourceDrives) inspect(ctx context.Context, h *DriveHealth) {
+	free, total, err := driveSpace(h.Mount)
+	switch {
+	case err != nil:
+		if h.Problem == "" {
+			h.Problem = fmt.Sprintf("free space unknown: %v", err)
+		}
+	case free < d.cfg.minFree() && h.Problem == "":
+		h.Problem = fmt.Sprintf("only %s free of %s", formatGiB(free), formatGiB(total))
+	}
+	h.FreeBytes, h.TotalBytes = free, total
+	if !d.cfg.SMART || h.Device == "" {
+		return
+	}
+	res, ok := d.smart[h.Device]
+	if !ok || time.Since(res.checked) >= d.cfg.SMARTInterval.or(defaultSMARTInterval) {
+		res = smartResult{verdict: d.smartVerdict(ctx, h.Device), checked: time.Now()}
+		d.smart[h.Device] = res
+	}
+	h.SMART = res.verdict
+	if res.verdict == "FAILED" && h.Problem == "" {
+		h.Problem = "SMART reports the drive is failing"
+	}
+}
+
+// smartVerdict runs "smartctl -H" for device, returning "PASSED", "FAILED"
+// or "" when the drive does not report SMART health.
+func (d *sourceDrives) smartVerdict(ctx context.Context, device string) string {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, d.cfg.smartctl(), "-H", smartDevice(device)).CombinedOutput()
+	// smartctl sets bit 3 of its exit status when the disk is failing.
+	if exit, ok := err.(*exec.ExitError); ok && exit.ExitCode()&8 != 0 {
+		return "FAILED"
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		// ATA drives report "...test result: PASSED", SCSI and NVMe ones
+		// "SMART Health Status: OK".
+		if i := strings.Index(line, "test result:"); i >= 0 {
+			return strings.TrimSpace(line[i+len("test result:"):])
+		}
+		if strings.HasPrefix(line, "SMART Health Status:") {
+			if strings.TrimSpace(strings.TrimPrefix(line, "SMART Health Status:")) == "OK" {
+				return "PASSED"
+			}
+			return "FAILED"
+		}
+	}
+	logDebugf("Source drive %s: no SMART health from %s: %v", device, d.cfg.smartctl(), err)
+	return ""
+}
+
+// alert logs drives whose problem changed since the last check and posts
+// them to the webhook.
+func (d *sourceDrives) alert(drives []DriveHealth) {
+	host, _ := os.Hostname()
+	var alerts []DriveAlert
+	for _, h := range drives {
+		if h.Problem == d.problems[h.Mount] {
+			continue
+		}
+		d.problems[h.Mount] = h.Problem
+		rules := strings.Join(h.Rules, ", ")
+		if h.Problem == "" {
+			logEvent(CategoryHealth, LevelInfo, "Source drive %s (rules %s) is healthy again", h.Mount, rules)
+		} else {
+			logEvent(CategoryHealth, LevelError, "Source drive %s (rules %s): %s", h.Mount, rules, h.Problem)
+		}
+		alerts = append(alerts, DriveAlert{Host: host, Mount: h.Mount, Rules: h.Rules, OK: h.Problem == "", Problem: h.Problem, Time: time.Now()})
+	}
+	if d.cfg.Webhook == "" {
+		return
+	}
+	for _, a := range alerts {
+		go func() {
+			if err := postWebhook(d.cfg.Webhook, a); err != nil {
+				logEvent(CategoryHealth, LevelError, "Source drive webhook: %v", err)
+			}
+		}()
+	}
+}
+
+// formatGiB formats n bytes in GiB.
+func formatGiB(n uint64) string {
+	return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+}
+
+// validateSourceDrives checks the source_drives settings.
+func validateSourceDrives(c *SourceDrivesConfig) ConfigErrors {
+	var problems ConfigErrors
+	if c.Interval < 0 || c.SMARTInterval < 0 {
+		problems = append(problems, "source_drives.interval and smart_interval must be positive durations such as \"1m\"")
+	}
+	if c.MinFree < -1 {
+		problems = append(problems, "source_drives.min_free must be a size in bytes, or -1 to disable the check")
+	}
+	if c.SMART {
+		if _, err := exec.LookPath(c.smartctl()); err != nil {
+			problems = append(problems, fmt.Sprintf("source_drives.smart: smartctl %q was not found; install smartmontools: %v", c.smartctl(), err))
+		}
+	}
+	if c.Webhook != "" {
+		if u, err := url.Parse(c.Webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("source_drives.webhook %q must be an http(s) URL", c.Webhook))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,106 @@
+package foldermonitor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// errPush marks copies that could not be pushed to a remote destination.
+var errPush = errors.New("remote push")
+
+// A rule with a remote destination, such as an rclone remote or an rsync
+// server, uses its destination folder as a local spool: each file is
+// copied there as usual, then pushed by running a command, and the local
+// copy is removed unless it is kept. A failed push fails the copy, which
+// is retried like one to a destination that needs attention.
+
+// remotePush is a command pushing spooled copies to a remote destination.
+type remotePush interface {
+	// command returns the command line pushing the spooled copy dest of
+	// the rule and where it goes.
+	command(rule Rule, dest string) (args []string, target string)
+	// keepLocal reports whether the spooled copy is kept after the push.
+	keepLocal() bool
+}
+
+// remotePush returns the rule's remote destination, or nil.
+func (o RuleOptions) remotePush() remotePush {
+	switch {
+	case o.Rclone != nil:
+		return o.Rclone
+	case o.Rsync != nil:
+		return o.Rsync
+	}
+	return nil
+}
+
+// spoolPath returns the path of the spooled copy dest below the rule's
+// destination folder, in slash form.
+func spoolPath(rule Rule, dest string) string {
+	rel, ok := relInside(rule.DestDir, dest)
+	if !ok {
+		rel = filepath.Base(dest)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// pushRemote pushes the copy of e to the rule's remote destination,
+// returning e updated with the outcome. The copy's destination becomes the
+// remote one unless the local copy is kept.
+func (c *Copier) pushRemote(ctx context.Context, rule Rule, e Event) Event {
+	p := rule.remotePush()
+	args, target := p.command(rule, e.Dest)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			e.Err = context.Cause(ctx)
+		} else {
+			e.Err = fmt.Errorf("%w: %s to %s: %v: %s", errPush, filepath.Base(e.Dest), target, err, strings.TrimSpace(stderr.String()))
+		}
+		e.Kind, e.SHA256, e.Class = EventFailed, "", ClassifyError(e.Err)
+		return e
+	}
+	logDebugf("Rule %s: pushed %s to %s", rule.Name, e.Dest, target)
+	if !p.keepLocal() {
+		e.Dest = target
+	}
+	return e
+}
+
+// validateSpool checks the options of a rule with a remote destination,
+// named name, that need the local copy the push removes.
+func validateSpool(key func(string) string, o RuleOptions, name string, keep bool) ConfigErrors {
+	var problems ConfigErrors
+	if len(o.Group) > 0 {
+		problems = append(problems, fmt.Sprintf("%s cannot be combined with group", key(name)))
+	}
+	if keep {
+		return problems
+	}
+	for _, opt := range []struct {
+		name string
+		set  bool
+	}{
+		{"archive", o.Archive != ""},
+		{"checksums", o.Checksums != ""},
+		{"skip_identical", o.SkipIdentical != ""},
+		{"detect_renames", o.DetectRenames},
+		{"visual_duplicates", o.VisualDuplicates != nil},
+		{"cold", o.Cold != nil},
+		{"library", o.Library != nil},
+		{"students.sidecar", o.Students != nil && o.Students.Sidecar},
+		{"shot_data.sidecar", o.ShotData != nil && o.ShotData.Sidecar},
+	} {
+		if opt.set {
+			problems = append(problems, fmt.Sprintf("%s needs keep_local to be combined with %s", key(name), opt.name))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,203 @@
+package foldermonitor
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// statsFile is the store file holding the copy statistics of every day,
+// for utilization reports with "monitor stats".
+const statsFile = "stats.json"
+
+// statsFlushDelay is how long new counts are collected in memory before
+// they are added to the store file.
+const statsFlushDelay = time.Minute
+
+// statsDay is the layout of the day of a DayStats.
+const statsDay = "2006-01-02"
+
+// DayStats are the copy statistics of one rule on one day, in local time.
+type DayStats struct {
+	Day      string `json:"day"`
+	Rule     string `json:"rule"`
+	Files    int    `json:"files"`
+	Bytes    int64  `json:"bytes"`
+	Failures int    `json:"failures"`
+	// MaxLatency is the longest time from detection to archive of a file
+	// from a watched folder, in seconds.
+	MaxLatency float64 `json:"max_latency_seconds,omitempty"`
+}
+
+// add merges the counts of o into d.
+func (d *DayStats) add(o DayStats) {
+	d.Files += o.Files
+	d.Bytes += o.Bytes
+	d.Failures += o.Failures
+	d.MaxLatency = max(d.MaxLatency, o.MaxLatency)
+}
+
+// Stats returns the statistics of the days from from to to, both
+// included, sorted by day and rule. A running monitor adds its counts
+// about once a minute.
+func (s *Store) Stats(from, to time.Time) ([]DayStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	days, err := s.readStats()
+	if err != nil {
+		return nil, err
+	}
+	first, last := from.Format(statsDay), to.Format(statsDay)
+	var list []DayStats
+	for _, d := range days {
+		if d.Day >= first && d.Day <= last {
+			list = append(list, d)
+		}
+	}
+	return list, nil
+}
+
+// addStats merges counts into the store file.
+func (s *Store) addStats(counts []DayStats) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	days, err := s.readStats()
+	if err != nil {
+		return err
+	}
+	index := make(map[[2]string]int, len(days))
+	for i, d := range days {
+		index[[2]string{d.Day, d.Rule}] = i
+	}
+	for _, c := range counts {
+		if i, ok := index[[2]string{c.Day, c.Rule}]; ok {
+			days[i].add(c)
+		} else {
+			index[[2]string{c.Day, c.Rule}] = len(days)
+			days = append(days, c)
+		}
+	}
+	sort.Slice(days, func(i, j int) bool {
+		if days[i].Day != days[j].Day {
+			return days[i].Day < days[j].Day
+		}
+		return days[i].Rule < days[j].Rule
+	})
+	data, err := json.MarshalIndent(days, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, os.ModePerm); err != nil {
+		return err
+	}
+	tmp := s.path(statsFile + ".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(statsFile))
+}
+
+// readStats loads the statistics; a missing file has none. s.mu must be
+// held.
+func (s *Store) readStats() ([]DayStats, error) {
+	data, err := os.ReadFile(s.path(statsFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var days []DayStats
+	if err := json.Unmarshal(data, &days); err != nil {
+		return nil, err
+	}
+	return days, nil
+}
+
+// statsRecorder counts a copier's copies and failures by day and rule and
+// adds them to the store in batches.
+type statsRecorder struct {
+	store   *Store
+	mu      sync.Mutex
+	pending map[[2]string]*DayStats
+	flush   *time.Timer
+}
+
+func newStatsRecorder(store *Store) *statsRecorder {
+	return &statsRecorder{store: store, pending: make(map[[2]string]*DayStats)}
+}
+
+// record is the bus consumer counting copied and failed files.
+func (r *statsRecorder) record(e Event) {
+	r.update(e, func(d *DayStats) {
+		if e.Kind == EventFailed {
+			d.Failures++
+		} else {
+			d.Files++
+			d.Bytes += e.Bytes
+		}
+	})
+}
+
+// latency records the detection-to-archive time of a copied file.
+func (r *statsRecorder) latency(e Event, latency time.Duration) {
+	r.update(e, func(d *DayStats) {
+		d.MaxLatency = max(d.MaxLatency, latency.Seconds())
+	})
+}
+
+// update applies fn to the pending counts of the day and rule of e.
+func (r *statsRecorder) update(e Event, fn func(*DayStats)) {
+	t := e.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	key := [2]string{t.Format(statsDay), e.Rule.Name}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := r.pending[key]
+	if d == nil {
+		d = &DayStats{Day: key[0], Rule: key[1]}
+		r.pending[key] = d
+	}
+	fn(d)
+	if r.flush == nil {
+		r.flush = time.AfterFunc(statsFlushDelay, r.save)
+	}
+}
+
+// save adds the pending counts to the store. Counts that cannot be saved
+// are kept for the next attempt.
+func (r *statsRecorder) save() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.flush != nil {
+		r.flush.Stop()
+		r.flush = nil
+	}
+	if len(r.pending) == 0 {
+		return
+	}
+	counts := make([]DayStats, 0, len(r.pending))
+	for _, d := range r.pending {
+		counts = append(counts, *d)
+	}
+	if err := r.store.addStats(counts); err != nil {
+		logEvent(CategoryGeneral, LevelWarning, "Cannot save copy statistics: %v", err)
+		r.flush = time.AfterFunc(statsFlushDelay, r.save)
+		return
+	}
+	clear(r.pending)
+}
+
+// close saves the pending counts.
+func (r *statsRecorder) close() {
+	r.save()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.flush != nil {
+		r.flush.Stop()
+		r.flush = nil
+	}
+}
@@ -0,0 +1,330 @@
+package foldermonitor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// historySize and errorHistorySize bound the in-memory history kept for the
+// status UI.
+const (
+	historySize      = 100
+	errorHistorySize = 20
+)
+
+// Transfer is a copy in progress. It implements io.Writer so it can be fed
+// the bytes being copied to track progress.
+type Transfer struct {
+	ID      int       `json:"id"`
+	Source  string    `json:"source"`
+	Dest    string    `json:"dest"`
+	Size    int64     `json:"size"`
+	Copied  int64     `json:"copied"`
+	Started time.Time `json:"started"`
+	cancel  context.CancelFunc
+}
+
+func (t *Transfer) Write(p []byte) (int, error) {
+	atomic.AddInt64(&t.Copied, int64(len(p)))
+	return len(p), nil
+}
+
+// CopyRecord is the outcome of a finished copy.
+type CopyRecord struct {
+	Time     time.Time `json:"time"`
+	Source   string    `json:"source"`
+	Dest     string    `json:"dest"`
+	Bytes    int64     `json:"bytes"`
+	Duration float64   `json:"duration_seconds"`
+	Error    string    `json:"error,omitempty"`
+	// ErrorClass tells how the error is handled, e.g. "transient".
+	ErrorClass ErrorClass `json:"error_class,omitempty"`
+}
+
+// ErrorRecord is a logged error kept for display.
+type ErrorRecord struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// StatusSnapshot is a point-in-time copy of the monitor state, served as
+// JSON by the UI.
+type StatusSnapshot struct {
+	Started     time.Time     `json:"started"`
+	Watching    []string      `json:"watching"`
+	InFlight    []Transfer    `json:"in_flight"`
+	History     []CopyRecord  `json:"history"`
+	Errors      []ErrorRecord `json:"errors"`
+	FilesCopied int           `json:"files_copied"`
+	FilesFailed int           `json:"files_failed"`
+	BytesCopied int64         `json:"bytes_copied"`
+	LastCopy    time.Time     `json:"last_copy"`
+	// PausedSince is set while copying is paused.
+	PausedSince *time.Time `json:"paused_since,omitempty"`
+	// Heartbeat is when the watcher last reported in. Settling files are
+	// waiting for writes to them to stop, Retrying ones for another attempt.
+	Heartbeat   time.Time `json:"heartbeat"`
+	Settling    int       `json:"settling"`
+	Retrying    int       `json:"retrying"`
+	SourcesDown []string  `json:"sources_down,omitempty"`
+	// Drives are the drives holding the source folders.
+	Drives []DriveHealth `json:"drives,omitempty"`
+	// LastLatency is the detection-to-archive time of the last file
+	// measured against the latency SLO; LateFiles counts those over it.
+	LastLatency float64 `json:"last_latency_seconds,omitempty"`
+	LateFiles   int     `json:"late_files,omitempty"`
+	// Degraded says why the monitoring loop is down while the watchdog
+	// waits to restart it; Restarts counts its restarts.
+	Degraded string `json:"degraded,omitempty"`
+	Restarts int    `json:"restarts,omitempty"`
+}
+
+// statusTracker records what the monitor is doing for the status UI.
+type statusTracker struct {
+	mu       sync.Mutex
+	started  time.Time
+	watching []string
+	nextID   int
+	inFlight map[int]*Transfer
+	history  []CopyRecord
+	errors   []ErrorRecord
+	copied   int
+	failed   int
+	bytes    int64
+	lastCopy time.Time
+	paused   time.Time
+	// Reported by the watcher and the retry queue.
+	beat     time.Time
+	settling int
+	retrying int
+	down     []string
+	// Reported by the source drive checks.
+	drives []DriveHealth
+	// Reported by the latency SLO.
+	latency time.Duration
+	late    int
+	// Reported by the watchdog.
+	degraded string
+	restarts int
+}
+
+// newStatusTracker creates an empty tracker.
+func newStatusTracker() *statusTracker {
+	return &statusTracker{started: time.Now(), inFlight: make(map[int]*Transfer)}
+}
+
+// setWatching records the folders currently being watched.
+func (s *statusTracker) setWatching(dirs []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watching = append([]string(nil), dirs...)
+}
+
+// setPaused records when copying was paused; the zero time means running.
+func (s *statusTracker) setPaused(since time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = since
+}
+
+// loopFailed records that the monitoring loop ended with reason and is
+// about to be restarted.
+func (s *statusTracker) loopFailed(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.degraded, s.beat = reason, time.Time{}
+	s.restarts++
+}
+
+// loopRunning records that the monitoring loop is up again.
+func (s *statusTracker) loopRunning() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.degraded = ""
+}
+
+// heartbeat records that the watcher is alive, with its queue and the rules
+// whose source is unavailable.
+func (s *statusTracker) heartbeat(settling int, down []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.beat, s.settling, s.down = time.Now(), settling, down
+}
+
+// setDrives records the last check of the source drives.
+func (s *statusTracker) setDrives(drives []DriveHealth) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.drives = drives
+}
+
+// addRetrying adjusts the number of copies waiting to be retried.
+func (s *statusTracker) addRetrying(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retrying += n
+}
+
+// recordLatency records the detection-to-archive time of a file.
+func (s *statusTracker) recordLatency(d time.Duration, late bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+	if late {
+		s.late++
+	}
+}
+
+// begin registers a new transfer; cancel aborts it.
+func (s *statusTracker) begin(src, dst string, size int64, cancel context.CancelFunc) *Transfer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	t := &Transfer{ID: s.nextID, Source: src, Dest: dst, Size: size, Started: time.Now(), cancel: cancel}
+	s.inFlight[t.ID] = t
+	return t
+}
+
+// cancel aborts the in-flight transfer with the given ID and reports
+// whether there was one.
+func (s *statusTracker) cancel(id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.inFlight[id]
+	if ok {
+		t.cancel()
+	}
+	return ok
+}
+
+// finish removes t from the in-flight set and records its outcome.
+func (s *statusTracker) finish(t *Transfer, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.inFlight, t.ID)
+	rec := CopyRecord{
+		Time:     time.Now(),
+		Source:   t.Source,
+		Dest:     t.Dest,
+		Bytes:    atomic.LoadInt64(&t.Copied),
+		Duration: time.Since(t.Started).Seconds(),
+	}
+	if err != nil {
+		rec.Error = err.Error()
+		rec.ErrorClass = ClassifyError(err)
+		s.failed++
+	} else {
+		s.copied++
+		s.bytes += rec.Bytes
+		s.lastCopy = rec.Time
+	}
+	s.history = append(s.history, rec)
+	if len(s.history) > historySize {
+		s.history = s.history[len(s.history)-historySize:]
+	}
+}
+
+// recordError keeps msg in the recent-errors list.
+func (s *statusTracker) recordError(msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors = append(s.errors, ErrorRecord{Time: time.Now(), Message: msg})
+	if len(s.errors) > errorHistorySize {
+		s.errors = s.errors[len(s.errors)-errorHistorySize:]
+	}
+}
+
+// snapshot returns a consistent copy of the current state. History and
+// errors are returned newest first.
+func (s *statusTracker) snapshot() StatusSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := StatusSnapshot{
+		Started:     s.started,
+		Watching:    append([]string(nil), s.watching...),
+		FilesCopied: s.copied,
+		FilesFailed: s.failed,
+		BytesCopied: s.bytes,
+		LastCopy:    s.lastCopy,
+		Heartbeat:   s.beat,
+		Settling:    s.settling,
+		Retrying:    s.retrying,
+		SourcesDown: append([]string(nil), s.down...),
+		Drives:      append([]DriveHealth(nil), s.drives...),
+		LastLatency: s.latency.Seconds(),
+		LateFiles:   s.late,
+		Degraded:    s.degraded,
+		Restarts:    s.restarts,
+	}
+	if !s.paused.IsZero() {
+		since := s.paused
+		snap.PausedSince = &since
+	}
+	for _, t := range s.inFlight {
+		cp := *t
+		cp.Copied = atomic.LoadInt64(&t.Copied)
+		snap.InFlight = append(snap.InFlight, cp)
+	}
+	sort.Slice(snap.InFlight, func(i, j int) bool { return snap.InFlight[i].ID < snap.InFlight[j].ID })
+	for i := len(s.history) - 1; i >= 0; i-- {
+		snap.History = append(snap.History, s.history[i])
+	}
+	for i := len(s.errors) - 1; i >= 0; i-- {
+		snap.Errors = append(snap.Errors, s.errors[i])
+	}
+	return snap
+}
+
+// statusLogger wraps a Logger so that errors are also kept in the
+// tracker's recent-errors list.
+type statusLogger struct {
+	Logger
+	status *statusTracker
+}
+
+func (l statusLogger) Error(v ...interface{}) error {
+	l.status.recordError(fmt.Sprint(v...))
+	return l.Logger.Error(v...)
+}
+
+func (l statusLogger) Errorf(format string, a ...interface{}) error {
+	l.status.recordError(fmt.Sprintf(format, a...))
+	return l.Logger.Errorf(format, a...)
+}
+
+func (l statusLogger) LogEvent(cat LogCategory, level LogLevel, msg string) error {
+	if level == LevelError {
+		l.status.recordError(msg)
+	}
+	if e, ok := l.Logger.(EventLogger); ok {
+		return e.LogEvent(cat, level, msg)
+	}
+	return logAt(l.Logger, level, msg)
+}
+
+func (l statusLogger) Debugf(format string, a ...interface{}) error {
+	if d, ok := l.Logger.(interface {
+		Debugf(string, ...interface{}) error
+	}); ok {
+		return d.Debugf(format, a...)
+	}
+	return nil
+}
+
+// FormatBytes renders n using binary unit prefixes.
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,177 @@
+package foldermonitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// defaultStatusFileInterval is how often the status file is rewritten.
+const defaultStatusFileInterval = 30 * time.Second
+
+// StatusFileConfig writes the monitor's state as JSON to a file on an
+// interval, for dashboards and file-based monitoring that cannot query the
+// service. The file is replaced atomically, so readers never see it half
+// written.
+type StatusFileConfig struct {
+	// Path is the file written, e.g. "/var/lib/foldermonitor/status.json".
+	Path string `json:"path"`
+	// Interval is how often it is rewritten (default 30s).
+	Interval Duration `json:"interval,omitempty"`
+}
+
+// StatusFile is the document written to the status file.
+type StatusFile struct {
+	Time    time.Time `json:"time"`
+	Host    string    `json:"host"`
+	Version string    `json:"version"`
+	Started time.Time `json:"started"`
+	Paused  bool      `json:"paused"`
+	// Degraded says why the monitoring loop is down; SourcesDown lists
+	// source folders that cannot be watched.
+	Degraded    string   `json:"degraded,omitempty"`
+	SourcesDown []string `json:"sources_down,omitempty"`
+	// Drives are the drives holding the source folders.
+	Drives []DriveHealth `json:"drives,omitempty"`
+	// QueueDepth counts copies waiting to be retried; InFlight, Settling
+	// and Retrying are the files being worked on right now.
+	QueueDepth int `json:"queue_depth"`
+	InFlight   int `json:"in_flight"`
+	Settling   int `json:"settling"`
+	Retrying   int `json:"retrying"`
+	// LastCopy is null until a file has been copied.
+	LastCopy    *time.Time `json:"last_copy"`
+	FilesCopied int        `json:"files_copied"`
+	FilesFailed int        `json:"files_failed"`
+	BytesCopied int64      `json:"bytes_copied"`
+	LateFiles   int        `json:"late_files"`
+	LastLatency float64    `json:"last_latency_seconds"`
+	// Rules are the counters of each rule that has copied or failed a
+	// file since the service started, sorted by name.
+	Rules []RuleCounters `json:"rules"`
+}
+
+// RuleCounters are a rule's copy outcomes since the service started.
+type RuleCounters struct {
+	Rule        string  `json:"rule"`
+	FilesCopied int64   `json:"files_copied"`
+	FilesFailed int64   `json:"files_failed"`
+	BytesCopied int64   `json:"bytes_copied"`
+	CopySeconds float64 `json:"copy_seconds"`
+}
+
+// perRule returns the counters of every rule, sorted by name.
+func (m *metricCounters) perRule() []RuleCounters {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]RuleCounters, 0, len(m.rules))
+	for name, r := range m.rules {
+		list = append(list, RuleCounters{
+			Rule:        name,
+			FilesCopied: r.copied,
+			FilesFailed: r.failed,
+			BytesCopied: r.bytes,
+			CopySeconds: r.seconds,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Rule < list[j].Rule })
+	return list
+}
+
+// statusFileWriter writes the status file of a monitor on an interval.
+type statusFileWriter struct {
+	cfg      *StatusFileConfig
+	counters *metricCounters
+	status   *statusTracker
+	store    *Store
+	failing  bool
+}
+
+// run writes the status file at once and then on every interval until ctx
+// is cancelled. Failures are logged when writing starts and stops
+// failing, not on every interval.
+func (w *statusFileWriter) run(ctx context.Context) {
+	ticker := time.NewTicker(w.cfg.Interval.or(defaultStatusFileInterval))
+	defer ticker.Stop()
+	for {
+		err := w.write()
+		switch {
+		case err != nil && !w.failing:
+			logEvent(CategoryGeneral, LevelWarning, "Status file: writing %s failed: %v", w.cfg.Path, err)
+		case err == nil && w.failing:
+			logEvent(CategoryGeneral, LevelInfo, "Status file: writing %s again", w.cfg.Path)
+		}
+		w.failing = err != nil
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// statusDocument builds the current status file from the tracker, rule
+// counters and retry queue of a monitor.
+func statusDocument(status *statusTracker, counters *metricCounters, store *Store) StatusFile {
+	s := status.snapshot()
+	host, _ := os.Hostname()
+	queued, _ := store.Queued()
+	doc := StatusFile{
+		Time:        time.Now(),
+		Host:        host,
+		Version:     Version,
+		Started:     s.Started,
+		Paused:      s.PausedSince != nil,
+		Degraded:    s.Degraded,
+		SourcesDown: s.SourcesDown,
+		Drives:      s.Drives,
+		QueueDepth:  len(queued),
+		InFlight:    len(s.InFlight),
+		Settling:    s.Settling,
+		Retrying:    s.Retrying,
+		FilesCopied: s.FilesCopied,
+		FilesFailed: s.FilesFailed,
+		BytesCopied: s.BytesCopied,
+		LateFiles:   s.LateFiles,
+		LastLatency: s.LastLatency,
+		Rules:       counters.perRule(),
+	}
+	if !s.LastCopy.IsZero() {
+		doc.LastCopy = &s.LastCopy
+	}
+	return doc
+}
+
+// write replaces the status file with the current state.
+func (w *statusFileWriter) write() error {
+	data, err := json.MarshalIndent(statusDocument(w.status, w.counters, w.store), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(w.cfg.Path), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := w.cfg.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, w.cfg.Path)
+}
+
+// validateStatusFile checks the status file settings.
+func validateStatusFile(c *StatusFileConfig) ConfigErrors {
+	var problems ConfigErrors
+	if c.Path == "" {
+		problems = append(problems, "status_file.path must name the file to write")
+	} else if info, err := os.Stat(c.Path); err == nil && info.IsDir() {
+		problems = append(problems, fmt.Sprintf("status_file.path %q is a folder", c.Path))
+	}
+	if c.Interval < 0 {
+		problems = append(problems, "status_file.interval must be a positive duration such as \"30s\"")
+	}
+	return problems
+}
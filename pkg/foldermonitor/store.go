@@ -0,0 +1,34 @@
+package foldermonitor
+
+import (
+	"path/filepath"
+	"sync"
+)
+
+// Store keeps state that must survive restarts, such as the pause marker
+// and the index of files imported from each device, in one folder.
+type Store struct {
+	mu  sync.Mutex // serialises updates to the store files
+	dir string
+	// history is the copy history, loaded when first needed.
+	history *copyHistory
+	// search is the search index of archived clips, loaded when first
+	// needed.
+	search *searchIndex
+}
+
+// NewStore returns a store keeping its files in dir, which is created when
+// first written to.
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// Dir returns the store's folder.
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// path returns the location of a file in the store.
+func (s *Store) path(elem ...string) string {
+	return filepath.Join(append([]string{s.dir}, elem...)...)
+}
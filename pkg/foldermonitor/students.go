@@ -0,0 +1,362 @@
+package foldermonitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Student lookup defaults.
+const (
+	defaultStudentBay        = "{rule}"
+	defaultStudentFolder     = "{student}"
+	defaultStudentUnassigned = "unassigned"
+	studentLookupTimeout     = 10 * time.Second
+	lessonCacheTTL           = time.Minute
+)
+
+// unknownField matches the placeholders left in a folder template.
+var unknownField = regexp.MustCompile(`\{[^{}/\\]*\}`)
+
+// scheduleLayouts are the accepted forms of the start and end columns of
+// a lesson schedule, in local time unless they carry a zone.
+var scheduleLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04"}
+
+// StudentOptions resolve which student a clip belongs to from the bay it
+// was filmed in and when, and file it under a folder per student. Either
+// Schedule or URL is set.
+type StudentOptions struct {
+	// Schedule is a CSV file of lessons with a header row naming at least
+	// the columns bay, start, end and student, e.g.
+	// "3,2026-10-14 15:00,2026-10-14 16:00,Ana". Start and end are local
+	// times; other columns such as coach become fields of the lesson. It
+	// is read for every file, so bookings can be updated while running.
+	Schedule string `json:"schedule,omitempty"`
+	// URL is queried with GET URL?bay=...&time=... (RFC 3339) and answers
+	// the lesson as a JSON object such as {"student": "Ana", "coach":
+	// "Sam"}, or 404 when there is none. Token, when set, is sent as a
+	// bearer token.
+	URL   string `json:"url,omitempty"`
+	Token string `json:"token,omitempty"`
+	// Bay is the template of the bay looked up (default "{rule}"). The
+	// time looked up is the clip's modification time.
+	Bay string `json:"bay,omitempty"`
+	// Folder is the template of the destination subfolder of each clip
+	// (default "{student}"), e.g. "{student}/{date}". It may contain the
+	// placeholders of metadata tags and the fields of the lesson, such as
+	// {student} and {coach}, which are also available to metadata tags.
+	Folder string `json:"folder,omitempty"`
+	// Unassigned is the student of clips outside any lesson, or when the
+	// lookup fails (default "unassigned").
+	Unassigned string `json:"unassigned,omitempty"`
+	// Sidecar writes the lesson next to each copy as <name>.json, for
+	// analysis software that reads it instead of metadata tags.
+	Sidecar bool `json:"sidecar,omitempty"`
+}
+
+// lessonCache keeps recent lookups, so the metadata stage and the
+// destination folder of a clip use one answer from the endpoint.
+var lessonCache = struct {
+	sync.Mutex
+	entries map[string]cachedLesson
+}{entries: make(map[string]cachedLesson)}
+
+type cachedLesson struct {
+	fields  map[string]string
+	fetched time.Time
+}
+
+// lesson returns the fields of the lesson the clip at path belongs to,
+// always including its student.
+func (s *StudentOptions) lesson(ctx context.Context, rule Rule, path string, info os.FileInfo) map[string]string {
+	bay := s.Bay
+	if bay == "" {
+		bay = defaultStudentBay
+	}
+	bay = expandMediaTemplate(bay, rule, path, info)
+	at := clipTime(rule, path, info)
+	key := s.Schedule + s.URL + "\x00" + bay + "\x00" + at.UTC().Format(time.RFC3339Nano)
+
+	lessonCache.Lock()
+	now := time.Now()
+	for k, c := range lessonCache.entries {
+		if now.Sub(c.fetched) > lessonCacheTTL {
+			delete(lessonCache.entries, k)
+		}
+	}
+	c, ok := lessonCache.entries[key]
+	lessonCache.Unlock()
+	if ok {
+		return c.fields
+	}
+
+	var fields map[string]string
+	var err error
+	if s.URL != "" {
+		fields, err = s.fetchLesson(ctx, bay, at)
+	} else {
+		fields, err = scheduledLesson(s.Schedule, bay, at)
+	}
+	if err != nil {
+		logEvent(CategoryCopy, LevelWarning, "Rule %s: cannot find the student of %s, filing it as %s: %v", rule.Name, path, s.unassigned(), err)
+		fields = nil
+	}
+	if fields == nil {
+		fields = make(map[string]string)
+	}
+	if strings.TrimSpace(fields["student"]) == "" {
+		fields["student"] = s.unassigned()
+	}
+	lessonCache.Lock()
+	lessonCache.entries[key] = cachedLesson{fields: fields, fetched: now}
+	lessonCache.Unlock()
+	return fields
+}
+
+// studentDir returns the destination subfolder of the clip at path under
+// the rule's student lookup and the lesson it belongs to, or "" and nil
+// when the rule does not look students up.
+func studentDir(ctx context.Context, rule Rule, path string, info os.FileInfo) (string, map[string]string) {
+	if rule.Students == nil {
+		return "", nil
+	}
+	lesson := rule.Students.lesson(ctx, rule, path, info)
+	return rule.Students.folder(rule, path, info, lesson), lesson
+}
+
+// unassigned returns the student of clips outside any lesson.
+func (s *StudentOptions) unassigned() string {
+	if s.Unassigned != "" {
+		return s.Unassigned
+	}
+	return defaultStudentUnassigned
+}
+
+// folder returns the destination subfolder of a clip of the lesson.
+func (s *StudentOptions) folder(rule Rule, path string, info os.FileInfo, lesson map[string]string) string {
+	t := s.Folder
+	if t == "" {
+		t = defaultStudentFolder
+	}
+	pairs := make([]string, 0, 2*len(lesson))
+	for k, v := range lesson {
+		pairs = append(pairs, "{"+k+"}", folderName(v, s.unassigned()))
+	}
+	t = expandMediaTemplate(strings.NewReplacer(pairs...).Replace(t), rule, path, info)
+	// Fields the lesson does not have leave no trace.
+	t = unknownField.ReplaceAllString(t, "")
+	return filepath.Clean(filepath.FromSlash(t))
+}
+
+// folderName makes v usable as a single folder name on every system.
+func folderName(v, empty string) string {
+	v = strings.Map(func(r rune) rune {
+		if r < ' ' || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, v)
+	v = strings.Trim(v, " .")
+	if v == "" {
+		return empty
+	}
+	return v
+}
+
+// expandLessonTemplate replaces the fields of the lesson of the clip at
+// path in t, for metadata tags. Clips of rules without a student lookup
+// are returned unchanged.
+func expandLessonTemplate(t string, rule Rule, path string, info os.FileInfo) string {
+	if rule.Students == nil || !strings.Contains(t, "{") {
+		return t
+	}
+	lesson := rule.Students.lesson(context.Background(), rule, path, info)
+	pairs := make([]string, 0, 2*len(lesson))
+	for k, v := range lesson {
+		pairs = append(pairs, "{"+k+"}", v)
+	}
+	return strings.NewReplacer(pairs...).Replace(t)
+}
+
+// fetchLesson asks the endpoint which lesson was in the bay at t.
+func (s *StudentOptions) fetchLesson(ctx context.Context, bay string, t time.Time) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, studentLookupTimeout)
+	defer cancel()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("bay", bay)
+	q.Set("time", t.Format(time.RFC3339))
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotFound, http.StatusNoContent:
+		return nil, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("student lookup: %s", resp.Status)
+	}
+	var body map[string]any
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("student lookup: %v", err)
+	}
+	fields := make(map[string]string, len(body))
+	for k, v := range body {
+		switch v := v.(type) {
+		case nil:
+		case string:
+			fields[k] = v
+		default:
+			fields[k] = fmt.Sprint(v)
+		}
+	}
+	return fields, nil
+}
+
+// scheduledLesson returns the row of the schedule covering bay at t, as a
+// map from column names to values, or nil when no lesson does.
+func scheduledLesson(file, bay string, t time.Time) (map[string]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("lesson schedule: %v", err)
+	}
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("lesson schedule %s: %v", file, err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	header := make([]string, len(records[0]))
+	col := make(map[string]int)
+	for i, name := range records[0] {
+		header[i] = strings.ToLower(strings.TrimSpace(name))
+		col[header[i]] = i
+	}
+	for _, name := range []string{"bay", "start", "end", "student"} {
+		if _, ok := col[name]; !ok {
+			return nil, fmt.Errorf("lesson schedule %s has no %s column", file, name)
+		}
+	}
+	for n, rec := range records[1:] {
+		get := func(name string) string {
+			if i := col[name]; i < len(rec) {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		}
+		if get("bay") != bay {
+			continue
+		}
+		start, err := parseScheduleTime(get("start"))
+		if err != nil {
+			return nil, fmt.Errorf("lesson schedule %s line %d: %v", file, n+2, err)
+		}
+		end, err := parseScheduleTime(get("end"))
+		if err != nil {
+			return nil, fmt.Errorf("lesson schedule %s line %d: %v", file, n+2, err)
+		}
+		if t.Before(start) || !t.Before(end) {
+			continue
+		}
+		row := make(map[string]string)
+		for i, name := range header {
+			if name != "" && name != "start" && name != "end" && i < len(rec) {
+				row[name] = strings.TrimSpace(rec[i])
+			}
+		}
+		return row, nil
+	}
+	return nil, nil
+}
+
+// parseScheduleTime parses the start or end of a lesson.
+func parseScheduleTime(v string) (time.Time, error) {
+	for _, layout := range scheduleLayouts {
+		if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("time %q is not like \"2006-01-02 15:04\"", v)
+}
+
+// lessonSidecar is the content of a copy's sidecar file.
+type lessonSidecar struct {
+	Rule     string            `json:"rule"`
+	Source   string            `json:"source"`
+	Modified time.Time         `json:"modified"`
+	Bay      string            `json:"bay"`
+	Student  string            `json:"student"`
+	Lesson   map[string]string `json:"lesson"`
+}
+
+// writeSidecar stores the lesson of the copy e next to it.
+func (s *StudentOptions) writeSidecar(e Event, info os.FileInfo, lesson map[string]string) error {
+	bay := s.Bay
+	if bay == "" {
+		bay = defaultStudentBay
+	}
+	data, err := json.MarshalIndent(lessonSidecar{
+		Rule:     e.Rule.Name,
+		Source:   e.Source,
+		Modified: info.ModTime(),
+		Bay:      expandMediaTemplate(bay, e.Rule, e.Source, info),
+		Student:  lesson["student"],
+		Lesson:   lesson,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(e.Dest+".json", append(data, '\n'), 0o644)
+}
+
+// validateStudents checks a rule's student lookup settings.
+func validateStudents(key func(string) string, s *StudentOptions) ConfigErrors {
+	var problems ConfigErrors
+	switch {
+	case (s.Schedule == "") == (s.URL == ""):
+		problems = append(problems, key("students")+" needs either a schedule file or a url")
+	case s.Schedule != "":
+		if _, err := os.Stat(s.Schedule); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.schedule: %v", key("students"), err))
+		}
+	default:
+		if !strings.HasPrefix(s.URL, "http://") && !strings.HasPrefix(s.URL, "https://") {
+			problems = append(problems, key("students")+".url must be an http:// or https:// URL")
+		}
+	}
+	if filepath.IsAbs(s.Folder) || strings.Contains(s.Folder, "..") {
+		problems = append(problems, fmt.Sprintf("%s.folder %q must be a relative path inside the destination", key("students"), s.Folder))
+	}
+	if s.Unassigned != "" && folderName(s.Unassigned, "") != s.Unassigned {
+		problems = append(problems, fmt.Sprintf("%s.unassigned %q must be a valid folder name", key("students"), s.Unassigned))
+	}
+	return problems
+}
@@ -0,0 +1,279 @@
+package foldermonitor
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Syslog transport limits. Messages are queued so a slow or absent
+// receiver never holds up copying; they are dropped when the queue is full.
+const (
+	syslogDialTimeout  = 5 * time.Second
+	syslogWriteTimeout = 5 * time.Second
+	syslogQueueSize    = 256
+	// syslogSDID names the structured data element carrying the category.
+	syslogSDID = "foldermonitor@32473"
+)
+
+// SyslogConfig sends the service log to a syslog receiver in RFC 5424
+// format, with the category as MSGID and the event ID in structured data.
+type SyslogConfig struct {
+	// Address is the receiver's host:port.
+	Address string `json:"address"`
+	// Protocol is "udp" (default), "tcp" or "tls". TCP and TLS use
+	// octet-counted framing (RFC 6587).
+	Protocol string `json:"protocol,omitempty"`
+	// Facility is e.g. "daemon" or "local0" (default "local0").
+	Facility string `json:"facility,omitempty"`
+	// AppName identifies the monitor (default "foldermonitor").
+	AppName string `json:"app_name,omitempty"`
+	// Level is the least severe level sent: error, warning, info (default)
+	// or debug.
+	Level string `json:"level,omitempty"`
+	// CAFile verifies a TLS receiver against this PEM bundle instead of the
+	// system roots; InsecureSkipVerify accepts any certificate.
+	CAFile             string `json:"ca_file,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+	// RateLimit collapses repeated warnings and errors.
+	RateLimit *RateLimit `json:"rate_limit,omitempty"`
+}
+
+// syslogFacilities maps facility names to their codes.
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSeverity maps log levels to syslog severities.
+var syslogSeverity = map[LogLevel]int{LevelError: 3, LevelWarning: 4, LevelInfo: 6, LevelDebug: 7}
+
+// SyslogLogger is an EventLogger sending messages to a syslog receiver.
+type SyslogLogger struct {
+	cfg      *SyslogConfig
+	level    LogLevel
+	facility int
+	host     string
+	app      string
+	queue    chan []byte
+	done     chan struct{}
+	once     sync.Once
+	conn     net.Conn     // owned by the sending goroutine
+	limit    *rateLimiter // nil unless repeats are collapsed
+}
+
+// syslogSample is a held back message.
+type syslogSample struct {
+	cat   LogCategory
+	level LogLevel
+	msg   string
+}
+
+// NewSyslogLogger starts sending to the receiver of cfg. The connection is
+// made with the first message and re-established after errors. Close the
+// logger to stop.
+func NewSyslogLogger(cfg *SyslogConfig) (*SyslogLogger, error) {
+	level, err := ParseLogLevel(cfg.Level)
+	if err != nil {
+		return nil, fmt.Errorf("syslog.level: %v", err)
+	}
+	facility := "local0"
+	if cfg.Facility != "" {
+		facility = strings.ToLower(cfg.Facility)
+	}
+	code, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("syslog.facility %q is unknown", cfg.Facility)
+	}
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "-"
+	}
+	app := cfg.AppName
+	if app == "" {
+		app = "foldermonitor"
+	}
+	l := &SyslogLogger{
+		cfg:      cfg,
+		level:    level,
+		facility: code,
+		host:     host,
+		app:      app,
+		queue:    make(chan []byte, syslogQueueSize),
+		done:     make(chan struct{}),
+	}
+	l.limit = newRateLimiter(cfg.RateLimit, func(sample interface{}, count int, window time.Duration) {
+		m := sample.(syslogSample)
+		l.enqueue(m.cat, m.level, m.msg+repeatedSuffix(count, window))
+	})
+	go l.send()
+	return l, nil
+}
+
+// Close stops sending; queued messages are discarded.
+func (l *SyslogLogger) Close() {
+	l.limit.close()
+	l.once.Do(func() { close(l.done) })
+}
+
+// LogEvent queues msg with its category and event ID.
+func (l *SyslogLogger) LogEvent(cat LogCategory, level LogLevel, msg string) error {
+	if level > logThreshold(l.level, cat, msg) {
+		return nil
+	}
+	if level <= LevelWarning && !l.limit.allow(fmt.Sprintf("%d|%s", cat.EventID(level), notificationKey(msg)), syslogSample{cat, level, msg}) {
+		return nil
+	}
+	l.enqueue(cat, level, msg)
+	return nil
+}
+
+// enqueue queues msg for sending.
+func (l *SyslogLogger) enqueue(cat LogCategory, level LogLevel, msg string) {
+	select {
+	case <-l.done:
+		return
+	default:
+	}
+	select {
+	case l.queue <- l.format(cat, level, msg, time.Now()):
+	default:
+		// Drop rather than block; the receiver is not keeping up.
+	}
+}
+
+// format renders one RFC 5424 message.
+func (l *SyslogLogger) format(cat LogCategory, level LogLevel, msg string, t time.Time) []byte {
+	pri := l.facility*8 + syslogSeverity[level]
+	sd := fmt.Sprintf(`[%s eventID="%d" category="%s"]`, syslogSDID, cat.EventID(level), sdEscape(cat.String()))
+	line := fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		pri, t.Format(time.RFC3339Nano), l.host, l.app, os.Getpid(), cat, sd, msg)
+	if l.cfg.Protocol == "tcp" || l.cfg.Protocol == "tls" {
+		line = fmt.Sprintf("%d %s", len(line), line)
+	}
+	return []byte(line)
+}
+
+// sdEscape escapes a structured data parameter value.
+func sdEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(s)
+}
+
+// send writes queued messages until the logger is closed, reconnecting
+// once per message after a failed write.
+func (l *SyslogLogger) send() {
+	defer func() {
+		if l.conn != nil {
+			l.conn.Close()
+		}
+	}()
+	for {
+		select {
+		case msg := <-l.queue:
+			for attempt := 0; attempt < 2; attempt++ {
+				if err := l.write(msg); err == nil {
+					break
+				}
+			}
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// write sends msg on the current connection, dialling first if needed.
+func (l *SyslogLogger) write(msg []byte) error {
+	if l.conn == nil {
+		conn, err := l.dial()
+		if err != nil {
+			return err
+		}
+		l.conn = conn
+	}
+	l.conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+	if _, err := l.conn.Write(msg); err != nil {
+		l.conn.Close()
+		l.conn = nil
+		return err
+	}
+	return nil
+}
+
+// dial connects to the receiver.
+func (l *SyslogLogger) dial() (net.Conn, error) {
+	d := &net.Dialer{Timeout: syslogDialTimeout}
+	switch l.cfg.Protocol {
+	case "tcp":
+		return d.Dial("tcp", l.cfg.Address)
+	case "tls":
+		tc := &tls.Config{InsecureSkipVerify: l.cfg.InsecureSkipVerify}
+		if l.cfg.CAFile != "" {
+			pem, err := os.ReadFile(l.cfg.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			tc.RootCAs = x509.NewCertPool()
+			tc.RootCAs.AppendCertsFromPEM(pem)
+		}
+		return tls.DialWithDialer(d, "tcp", l.cfg.Address, tc)
+	}
+	return d.Dial("udp", l.cfg.Address)
+}
+
+func (l *SyslogLogger) Error(v ...interface{}) error {
+	return l.LogEvent(CategoryGeneral, LevelError, fmt.Sprint(v...))
+}
+
+func (l *SyslogLogger) Warning(v ...interface{}) error {
+	return l.LogEvent(CategoryGeneral, LevelWarning, fmt.Sprint(v...))
+}
+
+func (l *SyslogLogger) Info(v ...interface{}) error {
+	return l.LogEvent(CategoryGeneral, LevelInfo, fmt.Sprint(v...))
+}
+
+func (l *SyslogLogger) Errorf(format string, a ...interface{}) error {
+	return l.LogEvent(CategoryGeneral, LevelError, fmt.Sprintf(format, a...))
+}
+
+func (l *SyslogLogger) Warningf(format string, a ...interface{}) error {
+	return l.LogEvent(CategoryGeneral, LevelWarning, fmt.Sprintf(format, a...))
+}
+
+func (l *SyslogLogger) Infof(format string, a ...interface{}) error {
+	return l.LogEvent(CategoryGeneral, LevelInfo, fmt.Sprintf(format, a...))
+}
+
+func (l *SyslogLogger) Debugf(format string, a ...interface{}) error {
+	return l.LogEvent(CategoryGeneral, LevelDebug, fmt.Sprintf(format, a...))
+}
+
+// validateSyslog checks the syslog settings.
+func validateSyslog(s *SyslogConfig) ConfigErrors {
+	var problems ConfigErrors
+	if _, _, err := net.SplitHostPort(s.Address); err != nil {
+		problems = append(problems, fmt.Sprintf("syslog.address %q must be host:port", s.Address))
+	}
+	switch s.Protocol {
+	case "", "udp", "tcp", "tls":
+	default:
+		problems = append(problems, fmt.Sprintf("syslog.protocol %q is not supported (want udp, tcp or tls)", s.Protocol))
+	}
+	if _, ok := syslogFacilities[strings.ToLower(s.Facility)]; s.Facility != "" && !ok {
+		problems = append(problems, fmt.Sprintf("syslog.facility %q is unknown (e.g. daemon or local0)", s.Facility))
+	}
+	if _, err := ParseLogLevel(s.Level); err != nil {
+		problems = append(problems, "syslog.level: "+err.Error())
+	}
+	if s.RateLimit != nil {
+		problems = append(problems, validateRateLimit("syslog.rate_limit", s.RateLimit)...)
+	}
+	return problems
+}
@@ -0,0 +1,162 @@
+package foldermonitor
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Defaults for resource-aware throttling.
+const (
+	defaultThrottleInterval = 2 * time.Second
+	defaultThrottleMaxDefer = 10 * time.Minute
+	defaultThrottleSlowRate = 2 << 20 // bytes per second
+)
+
+// ThrottleConfig defers or slows copies while the machine is busy, so that
+// archiving does not disturb live capture software. A threshold of zero is
+// not checked.
+type ThrottleConfig struct {
+	// MaxCPU is the total CPU usage in percent above which the machine is
+	// considered busy.
+	MaxCPU float64 `json:"max_cpu,omitempty"`
+	// MaxDiskQueue is the number of outstanding disk I/O requests above
+	// which the machine is considered busy.
+	MaxDiskQueue float64 `json:"max_disk_queue,omitempty"`
+	// Mode is "defer" (pause copying while busy, the default) or "slow"
+	// (continue at SlowRate bytes per second).
+	Mode     string `json:"mode,omitempty"`
+	SlowRate int64  `json:"slow_rate,omitempty"`
+	// MaxDefer bounds how long one copy is paused (default 10m) so that a
+	// permanently busy machine still archives eventually.
+	MaxDefer Duration `json:"max_defer,omitempty"`
+	// Interval is how often the load is sampled (default 2s).
+	Interval Duration `json:"interval,omitempty"`
+}
+
+// loadProbe reads the current CPU usage (percent) and disk queue length.
+// Implementations are platform specific.
+type loadProbe interface {
+	sample() (cpu, queue float64, err error)
+}
+
+// loadMonitor samples the machine load in the background.
+type loadMonitor struct {
+	cfg  *ThrottleConfig
+	mu   sync.Mutex
+	busy bool
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startLoadMonitor begins sampling; it returns nil if load cannot be
+// measured on this platform.
+func startLoadMonitor(cfg *ThrottleConfig) *loadMonitor {
+	probe, err := newLoadProbe()
+	if err != nil {
+		if svcLogger != nil {
+			svcLogger.Warningf("Throttling disabled, cannot measure load: %v", err)
+		}
+		return nil
+	}
+	m := &loadMonitor{cfg: cfg, stop: make(chan struct{}), done: make(chan struct{})}
+	go m.loop(probe)
+	return m
+}
+
+func (m *loadMonitor) loop(probe loadProbe) {
+	defer close(m.done)
+	ticker := time.NewTicker(m.cfg.Interval.or(defaultThrottleInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+		cpu, queue, err := probe.sample()
+		if err != nil {
+			logDebugf("Load sample failed: %v", err)
+			continue
+		}
+		busy := (m.cfg.MaxCPU > 0 && cpu > m.cfg.MaxCPU) || (m.cfg.MaxDiskQueue > 0 && queue > m.cfg.MaxDiskQueue)
+		m.mu.Lock()
+		changed := busy != m.busy
+		m.busy = busy
+		m.mu.Unlock()
+		if changed && svcLogger != nil {
+			if busy {
+				svcLogger.Infof("Machine busy (CPU %.0f%%, disk queue %.1f), throttling copies", cpu, queue)
+			} else {
+				svcLogger.Infof("Machine idle again (CPU %.0f%%, disk queue %.1f), copying at full speed", cpu, queue)
+			}
+		}
+	}
+}
+
+// isBusy reports the result of the latest sample.
+func (m *loadMonitor) isBusy() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.busy
+}
+
+// Stop ends sampling.
+func (m *loadMonitor) Stop() {
+	close(m.stop)
+	<-m.done
+}
+
+// wrapper returns a writerWrapper applying the throttling policy. Waiting
+// ends early when ctx is cancelled.
+func (m *loadMonitor) wrapper(ctx context.Context) writerWrapper {
+	return func(w io.Writer) (io.WriteCloser, error) {
+		return &throttledWriter{ctx: ctx, w: w, m: m}, nil
+	}
+}
+
+// throttledWriter pauses or slows writes while the machine is busy.
+type throttledWriter struct {
+	ctx    context.Context
+	w      io.Writer
+	m      *loadMonitor
+	paused time.Duration
+}
+
+// sleep waits for d or until the copy is cancelled.
+func (t *throttledWriter) sleep(d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-t.ctx.Done():
+		return t.ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	if t.m.isBusy() {
+		if t.m.cfg.Mode == "slow" {
+			rate := t.m.cfg.SlowRate
+			if rate <= 0 {
+				rate = defaultThrottleSlowRate
+			}
+			if err := t.sleep(time.Duration(float64(len(p)) / float64(rate) * float64(time.Second))); err != nil {
+				return 0, err
+			}
+		} else {
+			max := t.m.cfg.MaxDefer.or(defaultThrottleMaxDefer)
+			for t.m.isBusy() && t.paused < max {
+				if err := t.sleep(250 * time.Millisecond); err != nil {
+					return 0, err
+				}
+				t.paused += 250 * time.Millisecond
+			}
+		}
+	}
+	return t.w.Write(p)
+}
+
+func (t *throttledWriter) Close() error { return nil }
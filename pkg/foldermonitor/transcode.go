@@ -0,0 +1,149 @@
+package foldermonitor
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Videos are re-encoded for precise trims, share copies and merged pairs.
+// The encoder is chosen once per copier: NVIDIA NVENC or Intel Quick Sync
+// when ffmpeg can open one on this machine, libx264 otherwise.
+
+// Encoder names accepted by TranscodeConfig.Encoder.
+const (
+	EncoderAuto     = "auto"
+	EncoderNVENC    = "nvenc"
+	EncoderQSV      = "qsv"
+	EncoderSoftware = "software"
+)
+
+// defaultMaxTranscodes is how many re-encodes run at once by default.
+const defaultMaxTranscodes = 1
+
+// encoderProbeTimeout bounds the test encode that checks a hardware encoder.
+const encoderProbeTimeout = 15 * time.Second
+
+// ffmpegEncoders are the ffmpeg video encoders by name, with their options.
+var ffmpegEncoders = map[string][]string{
+	EncoderNVENC:    {"-c:v", "h264_nvenc", "-preset", "p4", "-rc", "vbr", "-cq", "19", "-b:v", "0"},
+	EncoderQSV:      {"-c:v", "h264_qsv", "-preset", "veryfast", "-global_quality", "18", "-pix_fmt", "nv12"},
+	EncoderSoftware: {"-c:v", "libx264", "-preset", "veryfast", "-crf", "18"},
+}
+
+// hardwareEncoders are tried in this order by the auto encoder.
+var hardwareEncoders = []string{EncoderNVENC, EncoderQSV}
+
+// TranscodeConfig tunes how videos are re-encoded.
+type TranscodeConfig struct {
+	// Encoder is "auto" (the default) to prefer a hardware encoder found on
+	// this machine, or "nvenc", "qsv" or "software" to always use one.
+	Encoder string `json:"encoder,omitempty"`
+	// MaxConcurrent caps the re-encodes running at once (default 1), so
+	// that archiving leaves the GPU to the capture software during lessons.
+	MaxConcurrent int `json:"max_concurrent,omitempty"`
+}
+
+// transcoder picks the encoder for re-encoded videos and limits how many
+// run at once.
+type transcoder struct {
+	ffmpeg string
+	want   string
+	slots  chan struct{}
+
+	once    sync.Once
+	encoder string
+}
+
+func newTranscoder(cfg *TranscodeConfig, ffmpeg string) *transcoder {
+	if cfg == nil {
+		cfg = &TranscodeConfig{}
+	}
+	want := cfg.Encoder
+	if want == "" {
+		want = EncoderAuto
+	}
+	n := cfg.MaxConcurrent
+	if n <= 0 {
+		n = defaultMaxTranscodes
+	}
+	return &transcoder{ffmpeg: ffmpeg, want: want, slots: make(chan struct{}, n)}
+}
+
+// acquire waits for a free transcode slot; release returns it.
+func (t *transcoder) acquire(ctx context.Context) error {
+	select {
+	case t.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+func (t *transcoder) release() {
+	<-t.slots
+}
+
+// choose returns the encoder to use, probing the hardware encoders the
+// first time when the encoder is auto.
+func (t *transcoder) choose(ctx context.Context) string {
+	t.once.Do(func() {
+		t.encoder = t.want
+		if t.want != EncoderAuto {
+			return
+		}
+		t.encoder = EncoderSoftware
+		for _, name := range hardwareEncoders {
+			if err := t.probe(ctx, name); err != nil {
+				logDebugf("Hardware encoder %s is not available: %v", name, err)
+				continue
+			}
+			t.encoder = name
+			break
+		}
+		logEvent(CategoryCopy, LevelInfo, "Re-encoding videos with %s", encoderName(t.encoder))
+	})
+	return t.encoder
+}
+
+// probe encodes a few frames with the named encoder, which fails when ffmpeg
+// was built without it or the machine has no such GPU.
+func (t *transcoder) probe(ctx context.Context, name string) error {
+	ctx, cancel := context.WithTimeout(ctx, encoderProbeTimeout)
+	defer cancel()
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin",
+		"-f", "lavfi", "-i", "color=c=black:s=256x256:d=0.2"}
+	args = append(args, ffmpegEncoders[name]...)
+	args = append(args, "-f", "null", "-")
+	out, err := exec.CommandContext(ctx, t.ffmpeg, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// encoderName returns the ffmpeg encoder behind name, e.g. "h264_nvenc".
+func encoderName(name string) string {
+	args := ffmpegEncoders[name]
+	if len(args) < 2 {
+		return name
+	}
+	return args[1]
+}
+
+// validateTranscode checks the transcode settings.
+func validateTranscode(c *TranscodeConfig) ConfigErrors {
+	var problems ConfigErrors
+	switch c.Encoder {
+	case "", EncoderAuto, EncoderNVENC, EncoderQSV, EncoderSoftware:
+	default:
+		problems = append(problems, fmt.Sprintf("transcode.encoder %q is not supported (want auto, nvenc, qsv or software)", c.Encoder))
+	}
+	if c.MaxConcurrent < 0 {
+		problems = append(problems, "transcode.max_concurrent must be a positive number")
+	}
+	return problems
+}
@@ -0,0 +1,365 @@
+package foldermonitor
+
+import (
+	"bytes"
+	"crypto/tls"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+//go:embed web
+var webFiles embed.FS
+
+// uiServer serves the configuration and status web UI. prg is nil when the
+// UI runs standalone, in which case only configuration editing is
+// available. Saved changes go to configPath.
+type uiServer struct {
+	prg        *Monitor
+	configPath string
+}
+
+// UIHandler returns the HTTP handler of the web UI for m, which may be nil
+// to edit the config file at configPath without a running monitor.
+func UIHandler(m *Monitor, configPath string) http.Handler {
+	u := &uiServer{prg: m, configPath: configPath}
+	static, _ := fs.Sub(webFiles, "web")
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.FS(static)))
+	mux.HandleFunc("GET /healthz", u.handleHealth)
+	mux.HandleFunc("/api/status", u.handleStatus)
+	mux.HandleFunc("/api/config", u.handleConfig)
+	mux.HandleFunc("GET /api/rules", u.handleRules)
+	mux.HandleFunc("POST /api/pause", u.handlePause)
+	mux.HandleFunc("POST /api/resume", u.handlePause)
+	mux.HandleFunc("POST /api/rescan", u.handleRescan)
+	mux.HandleFunc("POST /api/rules/{name}/{action}", u.handleRuleAction)
+	mux.HandleFunc("POST /api/transfers/{id}/cancel", u.handleCancel)
+	mux.HandleFunc("GET /api/quarantine", u.handleQuarantine)
+	mux.HandleFunc("POST /api/quarantine/release", u.handleRelease)
+	mux.HandleFunc("GET /api/queue", u.handleQueue)
+	mux.HandleFunc("GET /api/search", u.handleSearch)
+	mux.HandleFunc("POST /api/queue/{id}/{action}", u.handleQueueAction)
+	mux.HandleFunc("GET /api/fleet", u.handleFleet)
+	mux.HandleFunc("POST "+fleetReportPath, u.handleFleetReport)
+	return mux
+}
+
+func (u *uiServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if u.prg == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "monitor is not running in this process"})
+		return
+	}
+	writeJSON(w, http.StatusOK, u.prg.Status())
+}
+
+// handleHealth answers 200 when the monitor is healthy and 503 otherwise,
+// with the details as JSON, for uptime monitors. The optional max_idle
+// query parameter, e.g. "?max_idle=2h", also fails the check when nothing
+// was copied for that long.
+func (u *uiServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if u.prg == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "monitor is not running in this process"})
+		return
+	}
+	var maxIdle time.Duration
+	if s := r.URL.Query().Get("max_idle"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "max_idle: " + err.Error()})
+			return
+		}
+		maxIdle = d
+	}
+	h := u.prg.Health(r.Context(), maxIdle)
+	code := http.StatusOK
+	if !h.OK {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, h)
+}
+
+func (u *uiServer) handleConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		cfg, err := u.currentConfig()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, cfg)
+	case http.MethodPut, http.MethodPost:
+		if !jsonRequest(w, r) {
+			return
+		}
+		var cfg Config
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&cfg); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"errors": []string{err.Error()}})
+			return
+		}
+		if problems := ValidateConfig(&cfg); len(problems) > 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"errors": problems})
+			return
+		}
+		if err := WriteConfig(u.configPath, &cfg); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"errors": []string{err.Error()}})
+			return
+		}
+		if u.prg != nil {
+			u.prg.Reload(&cfg)
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"saved": u.configPath})
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (u *uiServer) handleRules(w http.ResponseWriter, r *http.Request) {
+	cfg, err := u.currentConfig()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, cfg.ListRules())
+}
+
+// handleRuleAction enables or disables a rule, saving the config file and
+// reloading the running monitor.
+func (u *uiServer) handleRuleAction(w http.ResponseWriter, r *http.Request) {
+	name, action := r.PathValue("name"), r.PathValue("action")
+	if action != "enable" && action != "disable" {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown action " + action})
+		return
+	}
+	cfg, err := u.currentConfig()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	if cfg.ruleOptions(name) == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("no rule named %q", name)})
+		return
+	}
+	on := action == "enable"
+	if u.prg != nil {
+		err = u.prg.SetRuleEnabled(name, on)
+	} else {
+		err = ToggleRule(u.configPath, name, on)
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"saved": u.configPath, "running": u.prg != nil})
+}
+
+// handlePause pauses or resumes copying. Without a running monitor only the
+// persisted state is changed.
+func (u *uiServer) handlePause(w http.ResponseWriter, r *http.Request) {
+	paused := r.URL.Path == "/api/pause"
+	var err error
+	if u.prg != nil {
+		err = u.prg.SetPaused(paused)
+	} else {
+		err = u.store().SetPaused(paused)
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"paused": paused, "running": u.prg != nil})
+}
+
+// handleCancel aborts an in-flight copy.
+func (u *uiServer) handleCancel(w http.ResponseWriter, r *http.Request) {
+	if u.prg == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "monitor is not running in this process"})
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || !u.prg.CancelTransfer(id) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no copy in progress with id " + r.PathValue("id")})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"cancelled": id})
+}
+
+func (u *uiServer) handleQuarantine(w http.ResponseWriter, r *http.Request) {
+	list, err := u.store().Quarantined()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleRelease takes the files listed in the request body out of
+// quarantine; an empty list releases every file.
+func (u *uiServer) handleRelease(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Sources []string `json:"sources"`
+	}
+	if r.ContentLength != 0 {
+		if !jsonRequest(w, r) {
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+	n, err := u.store().Release(req.Sources...)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"released": n})
+}
+
+func (u *uiServer) handleQueue(w http.ResponseWriter, r *http.Request) {
+	list, err := u.store().Queued()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleSearch returns the archived clips matching the query q, at most
+// limit of them.
+func (u *uiServer) handleSearch(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	found, err := u.store().Search(r.URL.Query().Get("q"), time.Now(), limit)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if found == nil {
+		found = []ClipRecord{}
+	}
+	writeJSON(w, http.StatusOK, found)
+}
+
+// handleQueueAction retries a queued copy now or drops it. Without a
+// running monitor only the persisted queue is changed.
+func (u *uiServer) handleQueueAction(w http.ResponseWriter, r *http.Request) {
+	id, action := r.PathValue("id"), r.PathValue("action")
+	var ok bool
+	var err error
+	switch {
+	case action == "retry" && u.prg != nil:
+		ok, err = u.prg.RetryQueued(id)
+	case action == "retry":
+		ok, err = u.store().RetryQueued(id)
+	case action == "drop" && u.prg != nil:
+		ok, err = u.prg.DropQueued(id)
+	case action == "drop":
+		ok, err = u.store().DropQueued(id)
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown action " + action})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no queued copy with id " + id})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{action: id, "running": u.prg != nil})
+}
+
+// store returns the running monitor's store, or the one next to the config
+// file when no monitor is running.
+func (u *uiServer) store() *Store {
+	if u.prg != nil {
+		return u.prg.Store()
+	}
+	return NewStore(filepath.Dir(u.configPath))
+}
+
+// currentConfig returns the running configuration, or the file contents
+// when no monitor is running.
+func (u *uiServer) currentConfig() (*Config, error) {
+	if u.prg != nil {
+		return u.prg.Config(), nil
+	}
+	cfg, err := ReadConfig(u.configPath)
+	if os.IsNotExist(err) {
+		return &Config{}, nil
+	}
+	return cfg, err
+}
+
+// jsonRequest reports whether the body of r is JSON, and otherwise
+// answers 415. Forms that other sites post cannot send JSON.
+func jsonRequest(w http.ResponseWriter, r *http.Request) bool {
+	if t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && t == "application/json" {
+		return true
+	}
+	writeJSON(w, http.StatusUnsupportedMediaType, map[string]interface{}{"errors": []string{"the request body must be application/json"}})
+	return false
+}
+
+// writeJSON sends v as an indented JSON response.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(buf.Bytes())
+}
+
+// UIListenAddr defaults the host of addr to the loopback interface so the
+// UI is only reachable locally unless a host is given explicitly.
+func UIListenAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != "" {
+		return addr
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+// StartUI listens on addr and serves h in the background, behind the
+// token and over HTTPS as auth, which may be nil, requires. It returns the
+// server and the base URL of the address actually bound.
+func StartUI(addr string, auth *UIAuthConfig, h http.Handler) (*http.Server, string, error) {
+	var tc *tls.Config
+	if auth.https() {
+		var err error
+		if tc, err = auth.serverTLS(); err != nil {
+			return nil, "", err
+		}
+	}
+	ln, err := net.Listen("tcp", UIListenAddr(addr))
+	if err != nil {
+		return nil, "", err
+	}
+	if tc != nil {
+		ln = tls.NewListener(ln, tc)
+	}
+	srv := &http.Server{Handler: auth.protect(h, ln.Addr().String()), ReadHeaderTimeout: 10 * time.Second, TLSConfig: tc}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed && svcLogger != nil {
+			svcLogger.Errorf("Web UI stopped: %v", err)
+		}
+	}()
+	return srv, auth.scheme() + "://" + ln.Addr().String(), nil
+}
@@ -0,0 +1,433 @@
+package foldermonitor
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Web UI authentication defaults.
+const (
+	uiTokenCookie   = "foldermonitor_token"
+	uiSessionCookie = "foldermonitor_session"
+	minUITokenSize  = 16
+)
+
+// UIAuthConfig protects the web UI and its API, for machines on networks
+// shared with others. Token and client certificates may be used alone or
+// together; both are then required.
+type UIAuthConfig struct {
+	// Token must be sent as "Authorization: Bearer <token>". Browsers open
+	// the UI once as /?token=<token>, which keeps it in a cookie.
+	Token string `json:"token,omitempty"`
+	// ReadToken is accepted like Token but only reads status, rules and
+	// queues, for dashboards that must not change anything. The
+	// configuration, which holds the tokens, is not readable with it.
+	ReadToken string `json:"read_token,omitempty"`
+	// CertFile and KeyFile serve the UI over HTTPS.
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	// ClientCAFile requires clients to present a certificate signed by a CA
+	// in this PEM bundle; it needs HTTPS.
+	ClientCAFile string `json:"client_ca_file,omitempty"`
+	// ClientCertFile and ClientKeyFile are the certificate that monitor
+	// commands such as pause and top present to the running service.
+	ClientCertFile string `json:"client_cert_file,omitempty"`
+	ClientKeyFile  string `json:"client_key_file,omitempty"`
+}
+
+// https reports whether the UI is served over HTTPS.
+func (a *UIAuthConfig) https() bool { return a != nil && a.CertFile != "" }
+
+// scheme returns the URL scheme of the UI.
+func (a *UIAuthConfig) scheme() string {
+	if a.https() {
+		return "https"
+	}
+	return "http"
+}
+
+// serverTLS returns the TLS settings of the UI server.
+func (a *UIAuthConfig) serverTLS() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(a.CertFile, a.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tc := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if a.ClientCAFile != "" {
+		pool, err := loadCertPool(a.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tc, nil
+}
+
+// loadCertPool reads a PEM bundle of certificates.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s contains no certificates", path)
+	}
+	return pool, nil
+}
+
+// uiRole is what a request may do.
+type uiRole int
+
+const (
+	uiDenied uiRole = iota
+	uiReader
+	uiAdmin
+)
+
+// protect wraps h to require the token, or the read token for requests
+// that change nothing. A request carrying a token in the query sets the
+// cookie and is redirected without it, so the token does not stay in the
+// address bar or the browser history.
+//
+// Without a token, requests must name the listen address addr as their
+// Host, which keeps pages on other sites from reaching the UI through DNS
+// rebinding, and changes must carry a per-process session cookie that the
+// UI sets on reads. Changes from a browser must also come from the UI's
+// own origin.
+func (a *UIAuthConfig) protect(h http.Handler, addr string) http.Handler {
+	token := a != nil && a.Token != ""
+	var session, cookie string
+	if !token {
+		b := make([]byte, 32)
+		rand.Read(b)
+		session = hex.EncodeToString(b)
+		_, port, _ := net.SplitHostPort(addr)
+		cookie = uiSessionCookie + "_" + port
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fleet reports carry the collector's report token instead.
+		if r.URL.Path == fleetReportPath {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if t := r.URL.Query().Get("token"); token && t != "" && a.role(t) != uiDenied {
+			http.SetCookie(w, &http.Cookie{
+				Name:     uiTokenCookie,
+				Value:    t,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteStrictMode,
+			})
+			q := r.URL.Query()
+			q.Del("token")
+			u := *r.URL
+			u.RawQuery = q.Encode()
+			http.Redirect(w, r, u.RequestURI(), http.StatusSeeOther)
+			return
+		}
+		role := uiAdmin
+		if token {
+			role = a.role(requestToken(r))
+		} else if !listenHost(r.Host, addr) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "the request is not addressed to " + addr})
+			return
+		}
+		if role == uiDenied {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="foldermonitor"`)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "a valid token is required"})
+			return
+		}
+		if safeMethod(r) {
+			if !token {
+				if c, err := r.Cookie(cookie); err != nil || c.Value != session {
+					http.SetCookie(w, &http.Cookie{
+						Name:     cookie,
+						Value:    session,
+						Path:     "/",
+						HttpOnly: true,
+						Secure:   r.TLS != nil,
+						SameSite: http.SameSiteStrictMode,
+					})
+				}
+			}
+		} else {
+			if !sameOrigin(r) {
+				writeJSON(w, http.StatusForbidden, map[string]string{"error": "changes must come from the web UI's own pages"})
+				return
+			}
+			if !token {
+				c, err := r.Cookie(cookie)
+				if err != nil || subtle.ConstantTimeCompare([]byte(c.Value), []byte(session)) != 1 {
+					writeJSON(w, http.StatusForbidden, map[string]string{"error": "the session has expired; reload the page"})
+					return
+				}
+			}
+		}
+		if role == uiReader && !readOnly(r) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "the read-only token cannot change the monitor or read its configuration"})
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// safeMethod reports whether r only reads.
+func safeMethod(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead
+}
+
+// readOnly reports whether r is allowed with the read token.
+func readOnly(r *http.Request) bool {
+	return safeMethod(r) && r.URL.Path != "/api/config"
+}
+
+// sameOrigin reports whether r, when it names an origin, comes from the
+// origin it is addressed to.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// Not sent by a browser.
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return u.Scheme == scheme && strings.EqualFold(u.Host, r.Host)
+}
+
+// listenHost reports whether host, the Host of a request, names the
+// listen address addr: its port and the same IP address, or when addr is
+// a loopback or unspecified address, localhost or this machine's name.
+// An unspecified address accepts any IP address.
+func listenHost(host, addr string) bool {
+	lhost, lport, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		// No port names the default one of the scheme.
+		name = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		if lport != "80" && lport != "443" {
+			return false
+		}
+	} else if port != lport {
+		return false
+	}
+	lip, ip := net.ParseIP(lhost), net.ParseIP(name)
+	switch {
+	case ip != nil:
+		return ip.Equal(lip) || lip.IsUnspecified() || (lip.IsLoopback() && ip.IsLoopback())
+	case strings.EqualFold(name, "localhost"):
+		return lip.IsLoopback() || lip.IsUnspecified()
+	case lip.IsUnspecified():
+		hostname, err := os.Hostname()
+		name = strings.ToLower(name)
+		hostname = strings.ToLower(hostname)
+		return err == nil && hostname != "" && (name == hostname || strings.HasPrefix(name, hostname+"."))
+	}
+	return false
+}
+
+// requestToken returns the token r carries in its Authorization header or
+// cookie.
+func requestToken(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimPrefix(h, "Bearer ")
+	}
+	if c, err := r.Cookie(uiTokenCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// role returns what a request with token t may do.
+func (a *UIAuthConfig) role(t string) uiRole {
+	switch {
+	case t == "":
+		return uiDenied
+	case subtle.ConstantTimeCompare([]byte(t), []byte(a.Token)) == 1:
+		return uiAdmin
+	case a.ReadToken != "" && subtle.ConstantTimeCompare([]byte(t), []byte(a.ReadToken)) == 1:
+		return uiReader
+	}
+	return uiDenied
+}
+
+// tokenTransport adds the bearer token to every request.
+type tokenTransport struct {
+	token string
+	next  http.RoundTripper
+}
+
+func (t *tokenTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+t.token)
+	return t.next.RoundTrip(r)
+}
+
+// sessionTransport reads the session cookie of a web UI without a token
+// before the first change and sends it with every change.
+type sessionTransport struct {
+	base string
+	next http.RoundTripper
+
+	mu     sync.Mutex
+	cookie *http.Cookie
+}
+
+func (t *sessionTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if safeMethod(r) {
+		return t.next.RoundTrip(r)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cookie == nil {
+		read, err := http.NewRequestWithContext(r.Context(), http.MethodHead, t.base+"/healthz", nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := t.next.RoundTrip(read)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		for _, c := range resp.Cookies() {
+			if strings.HasPrefix(c.Name, uiSessionCookie) {
+				t.cookie = c
+			}
+		}
+	}
+	if t.cookie != nil {
+		r = r.Clone(r.Context())
+		r.AddCookie(t.cookie)
+	}
+	return t.next.RoundTrip(r)
+}
+
+// UIClient returns an HTTP client for the web UI of cfg and the UI's base
+// URL, such as "https://127.0.0.1:8080". The client sends the token and
+// client certificate of the ui_auth section. Over HTTPS it trusts exactly
+// the configured server certificate rather than checking the host name,
+// since commands connect by address to the service on the same machine.
+func UIClient(cfg *Config, timeout time.Duration) (*http.Client, string, error) {
+	a := cfg.UIAuth
+	base := a.scheme() + "://" + UIListenAddr(cfg.UIAddr)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if a == nil {
+		return &http.Client{Timeout: timeout, Transport: &sessionTransport{base: base, next: transport}}, base, nil
+	}
+	if a.https() {
+		want, err := leafCertificate(a.CertFile)
+		if err != nil {
+			return nil, "", err
+		}
+		tc := &tls.Config{
+			// The certificate is verified below against the configured one.
+			InsecureSkipVerify: true,
+			VerifyPeerCertificate: func(raw [][]byte, _ [][]*x509.Certificate) error {
+				if len(raw) == 0 || !bytes.Equal(raw[0], want) {
+					return errors.New("the web UI presented a different certificate than ui_auth.cert_file")
+				}
+				return nil
+			},
+		}
+		if a.ClientCertFile != "" {
+			cert, err := tls.LoadX509KeyPair(a.ClientCertFile, a.ClientKeyFile)
+			if err != nil {
+				return nil, "", err
+			}
+			tc.Certificates = []tls.Certificate{cert}
+		}
+		transport.TLSClientConfig = tc
+	}
+	var rt http.RoundTripper = &sessionTransport{base: base, next: transport}
+	if a.Token != "" {
+		rt = &tokenTransport{token: a.Token, next: transport}
+	}
+	return &http.Client{Timeout: timeout, Transport: rt}, base, nil
+}
+
+// leafCertificate returns the DER bytes of the first certificate in the
+// PEM file at path.
+func leafCertificate(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s contains no certificates", path)
+		}
+		if block.Type == "CERTIFICATE" {
+			return block.Bytes, nil
+		}
+	}
+}
+
+// validateUIAuth checks the web UI authentication settings.
+func validateUIAuth(a *UIAuthConfig) ConfigErrors {
+	var problems ConfigErrors
+	if a.Token == "" && a.ClientCAFile == "" && a.CertFile == "" {
+		problems = append(problems, "ui_auth needs a token, a client_ca_file or a cert_file")
+	}
+	if a.Token != "" && len(a.Token) < minUITokenSize {
+		problems = append(problems, fmt.Sprintf("ui_auth.token must be at least %d characters", minUITokenSize))
+	}
+	switch {
+	case a.ReadToken == "":
+	case a.Token == "":
+		problems = append(problems, "ui_auth.read_token needs a token for changes")
+	case a.ReadToken == a.Token:
+		problems = append(problems, "ui_auth.read_token must differ from token")
+	case len(a.ReadToken) < minUITokenSize:
+		problems = append(problems, fmt.Sprintf("ui_auth.read_token must be at least %d characters", minUITokenSize))
+	}
+	if (a.CertFile == "") != (a.KeyFile == "") {
+		problems = append(problems, "ui_auth.cert_file and ui_auth.key_file must be set together")
+	} else if a.CertFile != "" {
+		if _, err := tls.LoadX509KeyPair(a.CertFile, a.KeyFile); err != nil {
+			problems = append(problems, fmt.Sprintf("ui_auth.cert_file: %v", err))
+		}
+	}
+	if a.ClientCAFile != "" {
+		if a.CertFile == "" {
+			problems = append(problems, "ui_auth.client_ca_file needs HTTPS; set cert_file and key_file")
+		}
+		if _, err := loadCertPool(a.ClientCAFile); err != nil {
+			problems = append(problems, fmt.Sprintf("ui_auth.client_ca_file: %v", err))
+		}
+	}
+	if (a.ClientCertFile == "") != (a.ClientKeyFile == "") {
+		problems = append(problems, "ui_auth.client_cert_file and ui_auth.client_key_file must be set together")
+	} else if a.ClientCertFile != "" {
+		if _, err := tls.LoadX509KeyPair(a.ClientCertFile, a.ClientKeyFile); err != nil {
+			problems = append(problems, fmt.Sprintf("ui_auth.client_cert_file: %v", err))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,104 @@
+package foldermonitor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestListenHost(t *testing.T) {
+	tests := []struct {
+		host, addr string
+		want       bool
+	}{
+		{"127.0.0.1:8080", "127.0.0.1:8080", true},
+		{"localhost:8080", "127.0.0.1:8080", true},
+		{"[::1]:8080", "127.0.0.1:8080", true},
+		{"127.0.0.1:9090", "127.0.0.1:8080", false},
+		{"evil.example:8080", "127.0.0.1:8080", false},
+		{"192.168.1.20:8080", "0.0.0.0:8080", true},
+		{"evil.example:8080", "0.0.0.0:8080", false},
+		{"192.168.1.21:8080", "192.168.1.20:8080", false},
+		{"localhost:8080", "192.168.1.20:8080", false},
+		{"127.0.0.1", "127.0.0.1:80", true},
+		{"127.0.0.1", "127.0.0.1:8080", false},
+	}
+	for _, tt := range tests {
+		if got := listenHost(tt.host, tt.addr); got != tt.want {
+			t.Errorf("listenHost(%q, %q) = %v, want %v", tt.host, tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestProtect(t *testing.T) {
+	const addr = "127.0.0.1:8080"
+	token := strings.Repeat("t", minUITokenSize)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	open := (*UIAuthConfig)(nil).protect(ok, addr)
+	// The session cookie the open UI hands to its pages.
+	rec := httptest.NewRecorder()
+	open.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://"+addr+"/", nil))
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || !strings.HasPrefix(cookies[0].Name, uiSessionCookie) {
+		t.Fatalf("session cookies %v", cookies)
+	}
+	session := cookies[0]
+
+	tests := []struct {
+		name    string
+		h       http.Handler
+		method  string
+		url     string
+		header  map[string]string
+		session bool
+		want    int
+	}{
+		{name: "reads need no session", h: open, method: "GET", url: "http://" + addr + "/api/status", want: 200},
+		{name: "changes need the session", h: open, method: "POST", url: "http://" + addr + "/api/pause", want: 403},
+		{name: "changes with the session", h: open, method: "POST", url: "http://" + addr + "/api/pause", session: true, want: 200},
+		{name: "changes from the UI's origin", h: open, method: "POST", url: "http://" + addr + "/api/pause", session: true,
+			header: map[string]string{"Origin": "http://" + addr}, want: 200},
+		{name: "changes from another origin", h: open, method: "POST", url: "http://" + addr + "/api/pause", session: true,
+			header: map[string]string{"Origin": "http://evil.example"}, want: 403},
+		{name: "rebound host name", h: open, method: "GET", url: "http://evil.example:8080/api/config", want: 403},
+		{name: "fleet reports pass", h: open, method: "POST", url: "http://collector.example:8080" + fleetReportPath, want: 200},
+		{name: "token required", h: (&UIAuthConfig{Token: token}).protect(ok, addr), method: "POST", url: "http://" + addr + "/api/pause", want: 401},
+		{name: "token on any host name", h: (&UIAuthConfig{Token: token}).protect(ok, addr), method: "POST", url: "http://monitor.example:8080/api/pause",
+			header: map[string]string{"Authorization": "Bearer " + token}, want: 200},
+		{name: "token from another origin", h: (&UIAuthConfig{Token: token}).protect(ok, addr), method: "POST", url: "http://" + addr + "/api/pause",
+			header: map[string]string{"Authorization": "Bearer " + token, "Origin": "http://evil.example"}, want: 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.url, nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			if tt.session {
+				r.AddCookie(session)
+			}
+			rec := httptest.NewRecorder()
+			tt.h.ServeHTTP(rec, r)
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestConfigNeedsJSON(t *testing.T) {
+	h := UIHandler(nil, filepath.Join(t.TempDir(), "config.json"))
+	for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded"} {
+		r := httptest.NewRequest(http.MethodPut, "/api/config", strings.NewReader(`{}`))
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("Content-Type %q: status %d, want 415", contentType, rec.Code)
+		}
+	}
+}
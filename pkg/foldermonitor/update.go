@@ -0,0 +1,318 @@
+package foldermonitor
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version is the release of this build, set with
+// -ldflags "-X vx-module/pkg/foldermonitor.Version=1.4.0".
+var Version = "dev"
+
+// defaultUpdateInterval is how often auto-update checks for a release.
+const defaultUpdateInterval = 6 * time.Hour
+
+// UpdateConfig says where releases are published and how to verify them.
+type UpdateConfig struct {
+	// URL is the release manifest, a JSON document of the form
+	//	{"channels": {"stable": {"version": "1.4.0", "files": {
+	//		"windows/amd64": {"url": "...", "sha256": "...", "signature": "..."}}}}}
+	// where signature is the base64 Ed25519 signature of the release's
+	// version, platform and SHA-256 together, so that an old build cannot
+	// be offered as a newer one. It must be https unless AllowHTTP is set.
+	URL string `json:"url"`
+	// AllowHTTP permits a manifest and downloads over plain http, such as
+	// from a mirror on the site's own network.
+	AllowHTTP bool `json:"allow_http,omitempty"`
+	// Channel selects the release line, e.g. "beta" (default "stable").
+	Channel string `json:"channel,omitempty"`
+	// PublicKey is the base64 Ed25519 key releases must be signed with,
+	// either raw or PKIX DER (the body of a PEM file).
+	PublicKey string `json:"public_key"`
+	// Auto installs new releases in the background and restarts.
+	Auto bool `json:"auto,omitempty"`
+	// Interval is how often auto-update checks (default 6h).
+	Interval Duration `json:"interval,omitempty"`
+}
+
+// CheckInterval returns how often auto-update checks for a release.
+func (u *UpdateConfig) CheckInterval() time.Duration {
+	return u.Interval.or(defaultUpdateInterval)
+}
+
+// Release is a build offered by the update manifest for this platform.
+type Release struct {
+	Version   string `json:"version"`
+	URL       string `json:"url"`
+	SHA256    string `json:"sha256"`
+	Signature string `json:"signature"`
+}
+
+// updateManifest is the document served at UpdateConfig.URL.
+type updateManifest struct {
+	Channels map[string]struct {
+		Version string             `json:"version"`
+		Files   map[string]Release `json:"files"`
+	} `json:"channels"`
+}
+
+// CheckUpdate fetches the manifest and returns the release of the
+// configured channel for this platform, or nil if it is not newer than
+// Version.
+func CheckUpdate(ctx context.Context, u *UpdateConfig) (*Release, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("update manifest: %s", resp.Status)
+	}
+	var m updateManifest
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, fmt.Errorf("update manifest: %v", err)
+	}
+	channel := u.Channel
+	if channel == "" {
+		channel = "stable"
+	}
+	ch, ok := m.Channels[channel]
+	if !ok {
+		return nil, fmt.Errorf("update manifest has no %q channel", channel)
+	}
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	rel, ok := ch.Files[platform]
+	if !ok {
+		return nil, fmt.Errorf("release %s has no build for %s", ch.Version, platform)
+	}
+	if compareVersions(ch.Version, Version) <= 0 {
+		return nil, nil
+	}
+	rel.Version = ch.Version
+	return &rel, nil
+}
+
+// releaseMessage is what the signature of a release covers: its version,
+// platform and the hex SHA-256 of the binary.
+func releaseMessage(version, platform, sum string) []byte {
+	return []byte("foldermonitor release\n" + strings.TrimPrefix(version, "v") + "\n" + platform + "\n" + strings.ToLower(sum) + "\n")
+}
+
+// ApplyUpdate downloads rel next to the running executable, verifies its
+// checksum and its signature of the version and platform claimed by the
+// manifest, and swaps it in. The new binary is used from the
+// next start; the caller arranges the restart.
+func ApplyUpdate(ctx context.Context, u *UpdateConfig, rel *Release) error {
+	pub, err := parseUpdateKey(u.PublicKey)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(rel.Signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return fmt.Errorf("release %s: invalid signature", rel.Version)
+	}
+	if rel.SHA256 == "" {
+		return fmt.Errorf("release %s: the manifest gives no sha256", rel.Version)
+	}
+	if !u.AllowHTTP && !strings.HasPrefix(rel.URL, "https://") {
+		return fmt.Errorf("release %s: %q is not an https URL", rel.Version, rel.URL)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rel.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("downloading release %s: %s", rel.Version, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("downloading release %s: %v", rel.Version, err)
+	}
+	if sum := sha256.Sum256(data); !strings.EqualFold(hex.EncodeToString(sum[:]), rel.SHA256) {
+		return fmt.Errorf("release %s: checksum mismatch", rel.Version)
+	}
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	if !ed25519.Verify(pub, releaseMessage(rel.Version, platform, rel.SHA256), sig) {
+		return fmt.Errorf("release %s: signature does not match the configured public key", rel.Version)
+	}
+
+	// A running executable cannot be replaced on Windows, but it can be
+	// renamed, so move it aside and put the new one in its place.
+	tmp, old := exe+".new", exe+".old"
+	if err := os.WriteFile(tmp, data, 0755); err != nil {
+		return err
+	}
+	os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, exe); err != nil {
+		os.Rename(old, exe)
+		os.Remove(tmp)
+		return err
+	}
+	os.Remove(old)
+	logEvent(CategoryUpdate, LevelInfo, "Installed release %s (was %s)", rel.Version, Version)
+	return nil
+}
+
+// AutoUpdate checks for a release every u.CheckInterval until one is
+// installed, which it returns, or ctx is cancelled. Failed checks are
+// logged and retried at the next interval.
+func AutoUpdate(ctx context.Context, u *UpdateConfig) (*Release, error) {
+	ticker := time.NewTicker(u.CheckInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		rel, err := CheckUpdate(ctx, u)
+		if err == nil && rel != nil {
+			err = ApplyUpdate(ctx, u, rel)
+		}
+		if err != nil {
+			logEvent(CategoryUpdate, LevelWarning, "Auto-update failed: %v", err)
+			continue
+		}
+		if rel != nil {
+			return rel, nil
+		}
+	}
+}
+
+// CleanupUpdate removes the executable replaced by the last update, which
+// Windows keeps locked until the old process has exited.
+func CleanupUpdate() {
+	if exe, err := os.Executable(); err == nil {
+		os.Remove(exe + ".old")
+	}
+}
+
+// GenerateUpdateKey returns a new base64 Ed25519 key pair for signing
+// releases.
+func GenerateUpdateKey() (public, private string, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(pub), base64.StdEncoding.EncodeToString(priv.Seed()), nil
+}
+
+// SignRelease returns the hex SHA-256 of the binary at path and the base64
+// signature of it with version and platform, such as "windows/amd64", for
+// the manifest, signed with the base64 private key from GenerateUpdateKey.
+func SignRelease(privateKey, path, version, platform string) (signature, sum string, err error) {
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(privateKey))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return "", "", errors.New("private key must be a base64 Ed25519 seed")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	digest := sha256.Sum256(data)
+	sum = hex.EncodeToString(digest[:])
+	sig := ed25519.Sign(ed25519.NewKeyFromSeed(seed), releaseMessage(version, platform, sum))
+	return base64.StdEncoding.EncodeToString(sig), sum, nil
+}
+
+// parseUpdateKey decodes a raw or PKIX DER Ed25519 public key.
+func parseUpdateKey(s string) (ed25519.PublicKey, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("update.public_key: %v", err)
+	}
+	if len(data) == ed25519.PublicKeySize {
+		return ed25519.PublicKey(data), nil
+	}
+	key, err := x509.ParsePKIXPublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("update.public_key: %v", err)
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("update.public_key is not an Ed25519 key")
+	}
+	return pub, nil
+}
+
+// compareVersions compares dotted version numbers such as "v1.4.10",
+// returning -1, 0 or 1. Parts that are not numbers, as in "dev", count as
+// zero.
+func compareVersions(a, b string) int {
+	pa := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	pb := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x, _ = strconv.Atoi(pa[i])
+		}
+		if i < len(pb) {
+			y, _ = strconv.Atoi(pb[i])
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+// validateUpdate checks the update settings.
+func validateUpdate(u *UpdateConfig) ConfigErrors {
+	var problems ConfigErrors
+	switch {
+	case strings.HasPrefix(u.URL, "https://"):
+	case strings.HasPrefix(u.URL, "http://"):
+		if !u.AllowHTTP {
+			problems = append(problems, fmt.Sprintf("update.url %q must be an https URL; set update.allow_http to accept http", u.URL))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("update.url %q must be an https URL", u.URL))
+	}
+	if _, err := parseUpdateKey(u.PublicKey); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if u.Interval < 0 {
+		problems = append(problems, "update.interval must be a positive duration such as \"6h\"")
+	}
+	return problems
+}
@@ -0,0 +1,130 @@
+package foldermonitor
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSignRelease(t *testing.T) {
+	public, private, err := GenerateUpdateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := parseUpdateKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "monitor")
+	if err := os.WriteFile(path, []byte("release 1.4.0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	signature, sum, err := SignRelease(private, path, "1.4.0", "windows/amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, _ := base64.StdEncoding.DecodeString(signature)
+	tests := []struct {
+		name              string
+		version, platform string
+		sum               string
+		ok                bool
+	}{
+		{name: "as signed", version: "1.4.0", platform: "windows/amd64", sum: sum, ok: true},
+		{name: "with a v prefix", version: "v1.4.0", platform: "windows/amd64", sum: sum, ok: true},
+		{name: "upper-case checksum", version: "1.4.0", platform: "windows/amd64", sum: strings.ToUpper(sum), ok: true},
+		{name: "offered as another version", version: "1.5.0", platform: "windows/amd64", sum: sum},
+		{name: "offered for another platform", version: "1.4.0", platform: "linux/amd64", sum: sum},
+		{name: "another binary", version: "1.4.0", platform: "windows/amd64", sum: strings.Repeat("0", 64)},
+	}
+	for _, tt := range tests {
+		if got := ed25519.Verify(pub, releaseMessage(tt.version, tt.platform, tt.sum), sig); got != tt.ok {
+			t.Errorf("%s: verified %v, want %v", tt.name, got, tt.ok)
+		}
+	}
+}
+
+// TestApplyUpdateRejects only covers releases that must be refused, since
+// an accepted one would replace the test binary.
+func TestApplyUpdateRejects(t *testing.T) {
+	public, private, err := GenerateUpdateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary := []byte("release 1.4.0")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write(binary) }))
+	defer srv.Close()
+	path := filepath.Join(t.TempDir(), "monitor")
+	if err := os.WriteFile(path, binary, 0644); err != nil {
+		t.Fatal(err)
+	}
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	sign := func(version, platform string) (string, string) {
+		sig, sum, err := SignRelease(private, path, version, platform)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig, sum
+	}
+	oldSig, oldSum := sign("1.3.0", platform)
+	otherSig, otherSum := sign("1.4.0", "plan9/386")
+	sig, sum := sign("1.4.0", platform)
+	tests := []struct {
+		name      string
+		allowHTTP bool
+		rel       Release
+		want      string
+	}{
+		{name: "an old release offered as new", allowHTTP: true,
+			rel: Release{Version: "1.4.0", URL: srv.URL, SHA256: oldSum, Signature: oldSig}, want: "signature does not match"},
+		{name: "a build for another platform", allowHTTP: true,
+			rel: Release{Version: "1.4.0", URL: srv.URL, SHA256: otherSum, Signature: otherSig}, want: "signature does not match"},
+		{name: "a different binary", allowHTTP: true,
+			rel: Release{Version: "1.4.0", URL: srv.URL, SHA256: strings.Repeat("0", 64), Signature: sig}, want: "checksum mismatch"},
+		{name: "no checksum", allowHTTP: true,
+			rel: Release{Version: "1.4.0", URL: srv.URL, Signature: sig}, want: "no sha256"},
+		{name: "http without allow_http",
+			rel: Release{Version: "1.4.0", URL: srv.URL, SHA256: sum, Signature: sig}, want: "not an https URL"},
+		{name: "no signature", allowHTTP: true,
+			rel: Release{Version: "1.4.0", URL: srv.URL, SHA256: sum}, want: "invalid signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &UpdateConfig{URL: srv.URL, AllowHTTP: tt.allowHTTP, PublicKey: public}
+			err := ApplyUpdate(context.Background(), u, &tt.rel)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("ApplyUpdate: %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateUpdate(t *testing.T) {
+	public, _, err := GenerateUpdateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		url       string
+		allowHTTP bool
+		ok        bool
+	}{
+		{url: "https://releases.example/manifest.json", ok: true},
+		{url: "http://releases.example/manifest.json"},
+		{url: "http://mirror.local/manifest.json", allowHTTP: true, ok: true},
+		{url: "ftp://releases.example/manifest.json", allowHTTP: true},
+	}
+	for _, tt := range tests {
+		problems := validateUpdate(&UpdateConfig{URL: tt.url, AllowHTTP: tt.allowHTTP, PublicKey: public})
+		if (len(problems) == 0) != tt.ok {
+			t.Errorf("url %q allow_http %v: problems %v, want ok=%v", tt.url, tt.allowHTTP, problems, tt.ok)
+		}
+	}
+}
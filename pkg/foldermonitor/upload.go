@@ -0,0 +1,220 @@
+package foldermonitor
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultMaxUploadSize caps a single upload unless configured otherwise.
+const defaultMaxUploadSize = 8 << 30
+
+// UploadConfig runs an HTTP(S) endpoint that phones and tablets can push
+// videos to. Uploaded files go through the same pipeline as watched files.
+type UploadConfig struct {
+	Name string `json:"name"`
+	// Addr is the host:port to listen on, e.g. ":8443".
+	Addr string `json:"addr"`
+	// CertFile and KeyFile enable HTTPS.
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	// Token must be sent as "Authorization: Bearer <token>". It is
+	// required unless Addr is a loopback address.
+	Token string `json:"token,omitempty"`
+	// MaxSize limits one upload in bytes (default 8 GiB).
+	MaxSize int64  `json:"max_size,omitempty"`
+	DestDir string `json:"dest_dir"`
+	RuleOptions
+}
+
+// asRule returns the equivalent folder rule for an upload from src.
+func (u *UploadConfig) asRule(src string) Rule {
+	return Rule{Name: u.Name, SourceDir: src, DestDir: u.DestDir, RuleOptions: u.RuleOptions}
+}
+
+// uploadEnabled reports whether the configuration accepts uploads.
+func (c *Config) uploadEnabled() bool {
+	return c.Upload != nil && c.Upload.IsEnabled()
+}
+
+// loopbackAddr reports whether the host of addr only accepts connections
+// from this machine.
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// uploadReceiver accepts uploads and hands them to the copier.
+type uploadReceiver struct {
+	cfg    *UploadConfig
+	copier *Copier
+}
+
+// startUploads serves the upload endpoint until the returned function is
+// called. Requests are cancelled along with ctx.
+func startUploads(ctx context.Context, cfg *UploadConfig, c *Copier) (func(), error) {
+	ln, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/upload", &uploadReceiver{cfg: cfg, copier: c})
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 30 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		var err error
+		if cfg.CertFile != "" {
+			err = srv.ServeTLS(ln, cfg.CertFile, cfg.KeyFile)
+		} else {
+			err = srv.Serve(ln)
+		}
+		if err != nil && err != http.ErrServerClosed && svcLogger != nil {
+			svcLogger.Errorf("Upload endpoint stopped: %v", err)
+		}
+	}()
+	scheme := "http"
+	if cfg.CertFile != "" {
+		scheme = "https"
+	}
+	if svcLogger != nil {
+		svcLogger.Infof("Upload %s: accepting uploads at %s://%s/upload", cfg.Name, scheme, ln.Addr())
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}, nil
+}
+
+// ServeHTTP accepts either a multipart form with a "file" field or a raw
+// request body with the file name in the "name" query parameter.
+func (u *uploadReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if u.cfg.Token != "" {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(u.cfg.Token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	max := u.cfg.MaxSize
+	if max <= 0 {
+		max = defaultMaxUploadSize
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, max)
+
+	var name string
+	var body io.Reader
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				http.Error(w, "no file field in form", http.StatusBadRequest)
+				return
+			}
+			if part.FormName() == "file" {
+				name, body = part.FileName(), part
+				break
+			}
+		}
+	} else {
+		name, body = r.URL.Query().Get("name"), r.Body
+	}
+	name = filepath.Base(filepath.Clean("/" + strings.ReplaceAll(name, `\`, "/")))
+	if name == "" || name == "/" || name == "." || strings.HasPrefix(name, ".") {
+		http.Error(w, "a file name is required", http.StatusBadRequest)
+		return
+	}
+
+	// Receive into a private temporary folder under the original name so
+	// the pipeline sees the real file name.
+	dir, err := os.MkdirTemp("", "foldermonitor-upload-")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(dir)
+	local := filepath.Join(dir, name)
+	f, err := os.Create(local)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	n, err := io.Copy(f, body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		if svcLogger != nil {
+			svcLogger.Errorf("Upload %s: receiving %s from %s failed after %d bytes: %v", u.cfg.Name, name, r.RemoteAddr, n, err)
+		}
+		http.Error(w, "upload failed: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if svcLogger != nil {
+		svcLogger.Infof("Upload %s: received %s (%s) from %s", u.cfg.Name, name, FormatBytes(n), r.RemoteAddr)
+	}
+	info, err := os.Stat(local)
+	if err == nil {
+		err = u.copier.archiveFile(r.Context(), u.cfg.asRule(r.RemoteAddr), local, info, "")
+	}
+	if err != nil {
+		// Ask the device to try again later if the problem may clear up.
+		code := http.StatusInternalServerError
+		if ClassifyError(err).retryable() {
+			code = http.StatusServiceUnavailable
+			w.Header().Set("Retry-After", strconv.Itoa(int(copyRetryDelay.Seconds())))
+		}
+		writeJSON(w, code, map[string]string{"error": err.Error(), "class": string(ClassifyError(err))})
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"stored": name, "bytes": n})
+}
+
+// validateUpload checks the upload endpoint settings.
+func validateUpload(u *UploadConfig) ConfigErrors {
+	var problems ConfigErrors
+	key := func(field string) string { return "upload." + field }
+	if _, _, err := net.SplitHostPort(u.Addr); err != nil {
+		problems = append(problems, fmt.Sprintf("%s %q must be host:port or :port: %v", key("addr"), u.Addr, err))
+	} else if u.Token == "" && !loopbackAddr(u.Addr) {
+		problems = append(problems, fmt.Sprintf("%s is required to listen on %q; anyone on the network could upload otherwise", key("token"), u.Addr))
+	}
+	if (u.CertFile == "") != (u.KeyFile == "") {
+		problems = append(problems, key("cert_file")+" and "+key("key_file")+" must be set together")
+	}
+	if u.DestDir == "" {
+		problems = append(problems, key("dest_dir")+" is required")
+	}
+	if u.Name == "" {
+		problems = append(problems, key("name")+" is required")
+	}
+	return append(problems, validateRuleOptions(key, u.RuleOptions)...)
+}
@@ -0,0 +1,43 @@
+package foldermonitor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateUpload(t *testing.T) {
+	tests := []struct {
+		addr, token string
+		ok          bool
+	}{
+		{addr: "127.0.0.1:8443", ok: true},
+		{addr: "localhost:8443", ok: true},
+		{addr: "[::1]:8443", ok: true},
+		{addr: ":8443"},
+		{addr: "0.0.0.0:8443"},
+		{addr: "192.168.1.20:8443"},
+		{addr: ":8443", token: "phones-only", ok: true},
+	}
+	for _, tt := range tests {
+		u := &UploadConfig{Name: "phones", Addr: tt.addr, Token: tt.token, DestDir: t.TempDir()}
+		problems := validateUpload(u)
+		if (len(problems) == 0) != tt.ok {
+			t.Errorf("addr %q token %q: problems %v, want ok=%v", tt.addr, tt.token, problems, tt.ok)
+		}
+	}
+}
+
+func TestDisabledUploadIsNoSource(t *testing.T) {
+	off := false
+	cfg := &Config{Version: CurrentConfigVersion, Upload: &UploadConfig{
+		Name: "phones", Addr: "127.0.0.1:8443", DestDir: t.TempDir(), RuleOptions: RuleOptions{Enabled: &off}}}
+	problems := ValidateConfig(cfg)
+	if len(problems) == 0 || !strings.Contains(problems[0], "no folders configured") {
+		t.Errorf("problems %v, want no folders configured", problems)
+	}
+	on := true
+	cfg.Upload.Enabled = &on
+	if problems := ValidateConfig(cfg); len(problems) > 0 {
+		t.Errorf("enabled upload: %v", problems)
+	}
+}
@@ -0,0 +1,528 @@
+package foldermonitor
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ConfigErrors collects every problem found while validating a config so
+// they can be reported together rather than one per run.
+type ConfigErrors []string
+
+func (e ConfigErrors) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e, "\n  - ")
+}
+
+// ValidateConfigFile checks the config file for keys that Config does not
+// know about, which usually indicate a typo.
+func ValidateConfigFile(path string) ConfigErrors {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ConfigErrors{err.Error()}
+	}
+	raw, err := decodeRawConfig(data, ConfigFormat(path))
+	if err != nil {
+		return ConfigErrors{fmt.Sprintf("%s: %v", path, err)}
+	}
+	var problems ConfigErrors
+	for _, key := range unknownKeys(raw, reflect.TypeOf(Config{}), "") {
+		problems = append(problems, fmt.Sprintf("unknown key %q (valid keys: %s)", key, strings.Join(knownKeys(reflect.TypeOf(Config{})), ", ")))
+	}
+	return problems
+}
+
+// unknownKeys returns the keys of m (recursing into nested objects and
+// lists of objects) that have no matching json field in struct type t.
+func unknownKeys(m map[string]interface{}, t reflect.Type, prefix string) []string {
+	fields := make(map[string]reflect.Type)
+	for _, f := range jsonFields(t) {
+		fields[f.name] = f.typ
+	}
+	var unknown []string
+	for key, value := range m {
+		ft, ok := fields[key]
+		if !ok {
+			unknown = append(unknown, prefix+key)
+			continue
+		}
+		for ft.Kind() == reflect.Ptr || ft.Kind() == reflect.Slice {
+			ft = ft.Elem()
+		}
+		if ft.Kind() != reflect.Struct {
+			continue
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			unknown = append(unknown, unknownKeys(v, ft, prefix+key+".")...)
+		case []interface{}:
+			for i, item := range v {
+				if sub, ok := item.(map[string]interface{}); ok {
+					unknown = append(unknown, unknownKeys(sub, ft, fmt.Sprintf("%s%s[%d].", prefix, key, i))...)
+				}
+			}
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// knownKeys lists the json keys of struct type t.
+func knownKeys(t reflect.Type) []string {
+	var keys []string
+	for _, f := range jsonFields(t) {
+		keys = append(keys, f.name)
+	}
+	return keys
+}
+
+// ValidateConfig checks the semantic consistency of cfg: required fields,
+// existence of the source folders and the relationships between all source
+// and destination folders.
+func ValidateConfig(cfg *Config) ConfigErrors {
+	if problems := validateVersion(cfg); len(problems) > 0 {
+		return problems
+	}
+	var problems ConfigErrors
+	rules := cfg.ActiveRules()
+	// With a remote configuration the folders may all come from
+	// headquarters, and a fleet collector may watch none.
+	collector := cfg.Fleet != nil && cfg.Fleet.Collect
+	if len(rules) == 0 && len(cfg.Volumes) == 0 && len(cfg.Devices) == 0 && !cfg.uploadEnabled() && cfg.Remote == nil && !collector {
+		return ConfigErrors{fmt.Sprintf("no folders configured; set source_dir and dest_dir (in the config file, with -source-dir/-dest-dir or %s/%s) or add rules", envName("source_dir"), envName("dest_dir"))}
+	}
+
+	names := make(map[string]bool)
+	var complete []int
+	for i, rule := range rules {
+		key := ruleKey(cfg, i)
+		if rule.Name == "" {
+			problems = append(problems, key("name")+" is required")
+		} else if names[rule.Name] {
+			problems = append(problems, fmt.Sprintf("%s %q is used by more than one rule", key("name"), rule.Name))
+		}
+		names[rule.Name] = true
+		if rule.SourceDir == "" {
+			problems = append(problems, key("source_dir")+" is required"+requiredHint(key("source_dir")))
+		}
+		if rule.DestDir == "" {
+			problems = append(problems, key("dest_dir")+" is required"+requiredHint(key("dest_dir")))
+		}
+		if rule.SourceDir == "" || rule.DestDir == "" {
+			continue
+		}
+		complete = append(complete, i)
+		if info, err := os.Stat(rule.SourceDir); err != nil {
+			// A disabled rule's source may be offline for maintenance.
+			if rule.IsEnabled() {
+				problems = append(problems, fmt.Sprintf("%s %q is not accessible: %v", key("source_dir"), rule.SourceDir, err))
+			}
+		} else if !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("%s %q is a file, not a folder", key("source_dir"), rule.SourceDir))
+		}
+		if info, err := os.Stat(rule.DestDir); err == nil && !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("%s %q is a file, not a folder", key("dest_dir"), rule.DestDir))
+		}
+		problems = append(problems, validateRuleOptions(key, rule.RuleOptions)...)
+	}
+
+	for i, v := range cfg.Volumes {
+		key := func(field string) string { return fmt.Sprintf("volumes[%d].%s", i, field) }
+		if v.Name == "" {
+			problems = append(problems, key("name")+" is required")
+		} else if names[v.Name] {
+			problems = append(problems, fmt.Sprintf("%s %q is used by more than one rule", key("name"), v.Name))
+		}
+		names[v.Name] = true
+		if len(v.Labels) == 0 {
+			problems = append(problems, key("labels")+" must list at least one volume label")
+		}
+		for _, pattern := range v.Labels {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				problems = append(problems, fmt.Sprintf("%s pattern %q is invalid: %v", key("labels"), pattern, err))
+			}
+		}
+		if v.DestDir == "" {
+			problems = append(problems, key("dest_dir")+" is required")
+		}
+		problems = append(problems, validateRuleOptions(key, v.RuleOptions)...)
+	}
+	for i, d := range cfg.Devices {
+		key := func(field string) string { return fmt.Sprintf("devices[%d].%s", i, field) }
+		if d.Name == "" {
+			problems = append(problems, key("name")+" is required")
+		} else if names[d.Name] {
+			problems = append(problems, fmt.Sprintf("%s %q is used by more than one rule", key("name"), d.Name))
+		}
+		names[d.Name] = true
+		if _, err := filepath.Match(d.Match, ""); err != nil {
+			problems = append(problems, fmt.Sprintf("%s pattern %q is invalid: %v", key("match"), d.Match, err))
+		}
+		if d.DestDir == "" {
+			problems = append(problems, key("dest_dir")+" is required")
+		}
+		problems = append(problems, validateRuleOptions(key, d.RuleOptions)...)
+	}
+	if cfg.Upload != nil {
+		if names[cfg.Upload.Name] {
+			problems = append(problems, fmt.Sprintf("upload.name %q is used by more than one rule", cfg.Upload.Name))
+		}
+		problems = append(problems, validateUpload(cfg.Upload)...)
+		names[cfg.Upload.Name] = true
+	}
+	if len(cfg.Pairs) > 0 {
+		problems = append(problems, validatePairs(cfg.Pairs, names, rules)...)
+	}
+	if t := cfg.Throttle; t != nil {
+		if t.MaxCPU <= 0 && t.MaxDiskQueue <= 0 {
+			problems = append(problems, "throttle needs max_cpu and/or max_disk_queue")
+		}
+		if t.Mode != "" && t.Mode != "defer" && t.Mode != "slow" {
+			problems = append(problems, fmt.Sprintf("throttle.mode %q is not supported (want defer or slow)", t.Mode))
+		}
+		if t.Interval < 0 || t.MaxDefer < 0 || t.SlowRate < 0 {
+			problems = append(problems, "throttle.interval, throttle.max_defer and throttle.slow_rate must not be negative")
+		}
+	}
+	if cfg.Service != nil {
+		problems = append(problems, validateService(cfg.Service)...)
+	}
+	if cfg.SLO != nil {
+		problems = append(problems, validateSLO(cfg.SLO)...)
+	}
+	if cfg.Syslog != nil {
+		problems = append(problems, validateSyslog(cfg.Syslog)...)
+	}
+	if cfg.MQTT != nil {
+		problems = append(problems, validateMQTT(cfg.MQTT)...)
+	}
+	if cfg.Power != nil {
+		problems = append(problems, validatePower(cfg.Power)...)
+	}
+	if cfg.Session != nil {
+		problems = append(problems, validateSession(cfg.Session)...)
+	}
+	if cfg.Chaos != nil {
+		problems = append(problems, validateChaos(cfg.Chaos)...)
+	}
+	if cfg.Metrics != nil {
+		problems = append(problems, validateMetrics(cfg.Metrics)...)
+	}
+	if cfg.StatusFile != nil {
+		problems = append(problems, validateStatusFile(cfg.StatusFile)...)
+	}
+	if cfg.SourceDrives != nil {
+		problems = append(problems, validateSourceDrives(cfg.SourceDrives)...)
+	}
+	if cfg.Fleet != nil {
+		problems = append(problems, validateFleet(cfg)...)
+	}
+	if cfg.Remote != nil {
+		problems = append(problems, validateRemote(cfg.Remote)...)
+	}
+	if cfg.Update != nil {
+		problems = append(problems, validateUpdate(cfg.Update)...)
+	}
+	if cfg.DevicePollInterval < 0 {
+		problems = append(problems, "device_poll_interval must be a positive duration")
+	}
+	if cfg.VolumePollInterval < 0 {
+		problems = append(problems, "volume_poll_interval must be a positive duration")
+	}
+	if cfg.Control != nil {
+		problems = append(problems, validateControl(cfg.Control)...)
+	}
+	if cfg.Gallery != nil {
+		problems = append(problems, validateGallery(cfg.Gallery, names)...)
+	}
+	if cfg.Transcode != nil {
+		problems = append(problems, validateTranscode(cfg.Transcode)...)
+	}
+	problems = append(problems, validateMedia(cfg)...)
+	if cfg.needsEncryptionKey() {
+		if _, err := LoadEncryptionKey(cfg.Encryption); err != nil {
+			problems = append(problems, fmt.Sprintf("encryption is enabled but the key is unusable: %v", err))
+		}
+	}
+
+	// Compare every source against every destination, and sources with each
+	// other, so copies can never feed back into a watched folder.
+	for _, i := range complete {
+		ki := ruleKey(cfg, i)
+		src := cleanPath(rules[i].SourceDir)
+		for _, j := range complete {
+			kj := ruleKey(cfg, j)
+			dst := cleanPath(rules[j].DestDir)
+			switch {
+			case samePath(src, dst):
+				problems = append(problems, fmt.Sprintf("%s and %s are the same folder (%s)", ki("source_dir"), kj("dest_dir"), src))
+			case isWithin(src, dst):
+				problems = append(problems, fmt.Sprintf("%s %s is inside %s %s; archived files would be copied again", ki("source_dir"), src, kj("dest_dir"), dst))
+			case isWithin(dst, src):
+				problems = append(problems, fmt.Sprintf("%s %s is inside %s %s; copies would be detected as new files", kj("dest_dir"), dst, ki("source_dir"), src))
+			}
+			if sh := rules[j].Share; sh != nil && sh.DestDir != "" {
+				if share := cleanPath(sh.DestDir); samePath(src, share) || isWithin(share, src) || isWithin(src, share) {
+					problems = append(problems, fmt.Sprintf("%s %s overlaps %s.dest_dir %s; shared copies would be detected as new files", ki("source_dir"), src, kj("share"), share))
+				}
+			}
+			if f := rules[j].Failover; f != nil && f.DestDir != "" {
+				if secondary := cleanPath(f.DestDir); samePath(src, secondary) || isWithin(secondary, src) || isWithin(src, secondary) {
+					problems = append(problems, fmt.Sprintf("%s %s overlaps %s.dest_dir %s; failed-over copies would be detected as new files", ki("source_dir"), src, kj("failover"), secondary))
+				}
+				if i == j {
+					if primary := cleanPath(rules[j].DestDir); samePath(primary, cleanPath(f.DestDir)) || isWithin(cleanPath(f.DestDir), primary) || isWithin(primary, cleanPath(f.DestDir)) {
+						problems = append(problems, fmt.Sprintf("%s.dest_dir %s overlaps %s %s", kj("failover"), f.DestDir, kj("dest_dir"), rules[j].DestDir))
+					}
+				}
+			}
+			for n, r := range rules[j].Routes {
+				if r.DestDir == "" {
+					continue
+				}
+				if route := cleanPath(r.DestDir); samePath(src, route) || isWithin(route, src) || isWithin(src, route) {
+					problems = append(problems, fmt.Sprintf("%s %s overlaps %s[%d].dest_dir %s; routed copies would be detected as new files", ki("source_dir"), src, kj("routes"), n, route))
+				}
+			}
+			if j > i && samePath(src, cleanPath(rules[j].SourceDir)) {
+				problems = append(problems, fmt.Sprintf("%s and %s watch the same folder (%s)", ki("source_dir"), kj("source_dir"), src))
+			}
+		}
+	}
+
+	if cfg.HealthInterval < 0 {
+		problems = append(problems, "health_interval must be a positive duration such as \"30s\"")
+	}
+	if cfg.ReconcileInterval != 0 && cfg.ReconcileInterval < Duration(minReconcileInterval) {
+		problems = append(problems, fmt.Sprintf("reconcile_interval must be at least %s", minReconcileInterval))
+	}
+	if cfg.QuietPeriod < 0 {
+		problems = append(problems, "quiet_period must be a positive duration such as \"2s\"")
+	}
+	if cfg.CopyTimeout < 0 {
+		problems = append(problems, "copy_timeout must be a positive duration such as \"30m\"")
+	}
+	if cfg.StallTimeout < 0 {
+		problems = append(problems, "stall_timeout must be a positive duration such as \"1m\"")
+	}
+	if cfg.CopyBuffer < 0 || cfg.CopyBuffer > maxCopyBuffer {
+		problems = append(problems, fmt.Sprintf("copy_buffer must be between 0 and %d bytes", int64(maxCopyBuffer)))
+	}
+	for name, level := range cfg.LogLevels {
+		if !slices.Contains(LogComponents, strings.ToLower(name)) {
+			problems = append(problems, fmt.Sprintf("log_levels key %q is not a component (want %s)", name, strings.Join(LogComponents, ", ")))
+		} else if _, err := ParseLogLevel(level); err != nil {
+			problems = append(problems, fmt.Sprintf("log_levels.%s: %v", name, err))
+		}
+	}
+	if cfg.LockRetryDelay < 0 {
+		problems = append(problems, "lock_retry_delay must be a positive duration such as \"200ms\"")
+	}
+	if cfg.UIAddr != "" {
+		if _, _, err := net.SplitHostPort(cfg.UIAddr); err != nil {
+			problems = append(problems, fmt.Sprintf("ui_addr %q must be host:port or :port: %v", cfg.UIAddr, err))
+		}
+	}
+	if cfg.UIAuth != nil {
+		problems = append(problems, validateUIAuth(cfg.UIAuth)...)
+	}
+	return problems
+}
+
+// validateRuleOptions checks the processing options shared by all kinds of
+// rule; key names fields for messages.
+func validateRuleOptions(key func(string) string, o RuleOptions) ConfigErrors {
+	var problems ConfigErrors
+	if !validCompression(o.Compress) {
+		problems = append(problems, fmt.Sprintf("%s %q is not supported (want gzip or zstd)", key("compress"), o.Compress))
+	}
+	if !validArchive(o.Archive) {
+		problems = append(problems, fmt.Sprintf("%s %q is not supported (want zip or tar.zst)", key("archive"), o.Archive))
+	}
+	if _, err := ParseLogLevel(o.LogLevel); err != nil {
+		problems = append(problems, key("log_level")+": "+err.Error())
+	}
+	problems = append(problems, validateGroup(key, o)...)
+	problems = append(problems, validateChecksums(key, o)...)
+	if o.Metadata != nil {
+		problems = append(problems, validateMetadata(key, o.Metadata)...)
+	}
+	if o.Trim != nil {
+		problems = append(problems, validateTrim(key, o.Trim)...)
+	}
+	if o.Share != nil {
+		problems = append(problems, validateShare(key, o.Share)...)
+	}
+	if o.Students != nil {
+		problems = append(problems, validateStudents(key, o.Students)...)
+	}
+	if o.ShotData != nil {
+		problems = append(problems, validateShotData(key, o.ShotData)...)
+	}
+	if o.VisualDuplicates != nil {
+		problems = append(problems, validateVisualDuplicates(key, o.VisualDuplicates)...)
+		if o.Archive != "" {
+			problems = append(problems, key("visual_duplicates")+" cannot be combined with archive")
+		}
+	}
+	if o.Rclone != nil {
+		problems = append(problems, validateRclone(key, o)...)
+	}
+	if o.Rsync != nil {
+		problems = append(problems, validateRsync(key, o)...)
+	}
+	if o.PreserveSecurity && !preserveSecuritySupported {
+		problems = append(problems, key("preserve_security")+" is only supported on Windows and Linux")
+	}
+	if o.ClipTime != nil {
+		problems = append(problems, validateClipTime(key, o.ClipTime)...)
+	}
+	if o.Ownership != nil {
+		switch {
+		case runtime.GOOS == "windows":
+			problems = append(problems, key("ownership")+" is not supported on Windows")
+		case o.PreserveSecurity:
+			problems = append(problems, key("ownership")+" cannot be combined with preserve_security")
+		default:
+			problems = append(problems, validateOwnership(key, o.Ownership)...)
+		}
+	}
+	if o.Rclone != nil && o.Rsync != nil {
+		problems = append(problems, key("rclone")+" cannot be combined with rsync")
+	}
+	if o.Cold != nil {
+		problems = append(problems, validateCold(key, o.Cold)...)
+		if o.Archive != "" {
+			problems = append(problems, key("cold")+" cannot be combined with archive")
+		}
+	}
+	if o.Library != nil {
+		problems = append(problems, validateLibrary(key, o.Library)...)
+		if o.Archive != "" {
+			problems = append(problems, key("library")+" cannot be combined with archive")
+		}
+	}
+	if o.DetectRenames && o.Archive != "" {
+		problems = append(problems, key("detect_renames")+" cannot be combined with archive")
+	}
+	problems = append(problems, validateContent(key, o.Content)...)
+	if o.Antivirus != nil {
+		problems = append(problems, validateAntivirus(key, o.Antivirus)...)
+	}
+	if o.Filter != "" {
+		problems = append(problems, validateFilter(key, o.Filter)...)
+	}
+	problems = append(problems, validatePlugins(key, o.Plugins)...)
+	problems = append(problems, validateRoutes(key, o.Routes)...)
+	if len(o.Routes) > 0 && o.Archive != "" {
+		problems = append(problems, key("routes")+" cannot be combined with archive")
+	}
+	if o.Rollup != nil {
+		problems = append(problems, validateRollup(key, o)...)
+	}
+	if o.Failover != nil {
+		problems = append(problems, validateFailover(key, o.Failover)...)
+	}
+	if o.Schedule != nil {
+		problems = append(problems, validateSchedule(key, o.Schedule)...)
+	}
+	if o.PreCopy != nil {
+		problems = append(problems, validatePreCopy(key, o.PreCopy)...)
+	}
+	if o.MinDuration < 0 || o.MaxDuration < 0 {
+		problems = append(problems, key("min_duration")+" and max_duration must be positive durations such as \"2s\"")
+	} else if o.MaxDuration > 0 && o.MinDuration >= o.MaxDuration {
+		problems = append(problems, key("min_duration")+" must be shorter than max_duration")
+	}
+	if o.SkipIdentical != "" && o.SkipIdentical != "mtime" && o.SkipIdentical != "hash" {
+		problems = append(problems, fmt.Sprintf("%s %q is not supported (want mtime or hash)", key("skip_identical"), o.SkipIdentical))
+	}
+	if o.Order != "" && o.Order != "oldest" && o.Order != "newest" {
+		problems = append(problems, fmt.Sprintf("%s %q is not supported (want oldest or newest)", key("order"), o.Order))
+	}
+	if o.SkipIdentical != "" && o.Archive != "" {
+		problems = append(problems, key("skip_identical")+" cannot be combined with archive")
+	}
+	if o.Snapshot && runtime.GOOS != "windows" {
+		problems = append(problems, key("snapshot")+" is only supported on Windows")
+	}
+	for _, pattern := range o.Exclude {
+		if _, err := path.Match(normalizeRel(pattern), ""); err != nil {
+			problems = append(problems, fmt.Sprintf("%s pattern %q is invalid: %v", key("exclude"), pattern, err))
+		}
+	}
+	for _, ext := range o.PartialExtensions {
+		if strings.Trim(ext, ".") == "" || strings.ContainsAny(ext, `/\*?`) || strings.Contains(ext[1:], ".") {
+			problems = append(problems, fmt.Sprintf("%s %q must be a file extension such as \".part\"", key("partial_extensions"), ext))
+		}
+	}
+	return problems
+}
+
+// ruleKey returns a function naming a field of the i-th active rule the way
+// it appears in the config file: top-level keys for the default rule,
+// rules[n].key otherwise.
+func ruleKey(cfg *Config, i int) func(string) string {
+	rules := cfg.ActiveRules()
+	offset := len(rules) - len(cfg.Rules)
+	return func(field string) string {
+		if i < offset {
+			return field
+		}
+		if name := rules[i].Name; name != "" {
+			return fmt.Sprintf("rules[%d] (%s).%s", i-offset, name, field)
+		}
+		return fmt.Sprintf("rules[%d].%s", i-offset, field)
+	}
+}
+
+// requiredHint explains how to set a top-level required field.
+func requiredHint(key string) string {
+	if strings.Contains(key, "[") {
+		return ""
+	}
+	return fmt.Sprintf("; set it in the config file, with -%s or %s", flagName(key), envName(key))
+}
+
+// cleanPath returns an absolute, cleaned form of p for comparisons.
+func cleanPath(p string) string {
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return filepath.Clean(p)
+}
+
+// samePath compares two cleaned paths, ignoring case on Windows.
+func samePath(a, b string) bool {
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// isWithin reports whether cleaned path child lies strictly below parent.
+func isWithin(child, parent string) bool {
+	if runtime.GOOS == "windows" && !strings.EqualFold(filepath.VolumeName(child), filepath.VolumeName(parent)) {
+		return false
+	}
+	rel, ok := relInside(parent, child)
+	return ok && rel != "."
+}
+
+// relInside returns path relative to base, and false when path lies
+// outside base. Names that merely start with dots, such as "..clips", are
+// inside.
+func relInside(base, path string) (string, bool) {
+	rel, err := filepath.Rel(base, path)
+	if err != nil || filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
@@ -0,0 +1,186 @@
+package foldermonitor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/bits"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Visual duplicate defaults.
+const (
+	defaultVisualFrames   = 8
+	defaultVisualDistance = 10
+	defaultVisualWindow   = 10 * time.Minute
+	// visualLengthSlack is how much the lengths of two exports of one clip
+	// may differ.
+	visualLengthSlack = time.Second
+)
+
+// VisualDuplicateOptions skip videos that look the same as one copied
+// shortly before, such as the high, medium and low quality exports a
+// camera writes of each swing. Frames sampled evenly across each clip are
+// reduced to a 64-bit difference hash with ffmpeg, which survives
+// re-encoding at another bitrate or resolution. The first export copied
+// is kept. The hashes are kept in the store's history.
+type VisualDuplicateOptions struct {
+	// Frames is how many frames are sampled from each clip (default 8).
+	Frames int `json:"frames,omitempty"`
+	// Distance is how many of the 64 bits of a frame's hash may differ for
+	// the frames to count as the same (default 10). Every sampled frame
+	// must match.
+	Distance int `json:"distance,omitempty"`
+	// Window is how far apart the modification times of two clips may be
+	// for them to be compared (default 10m), so that unrelated clips of an
+	// empty bay are not taken for each other.
+	Window Duration `json:"window,omitempty"`
+}
+
+// visualPrint is the sampled look of a clip.
+type visualPrint struct {
+	length time.Duration
+	frames []uint64
+}
+
+// String encodes p for the history, as the hex hashes of its frames.
+func (p visualPrint) String() string {
+	var b strings.Builder
+	for i, f := range p.frames {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%016x", f)
+	}
+	return b.String()
+}
+
+// parseVisualPrint decodes a print recorded in the history.
+func parseVisualPrint(s string, length time.Duration) (visualPrint, bool) {
+	p := visualPrint{length: length}
+	for _, h := range strings.Split(s, ",") {
+		f, err := strconv.ParseUint(h, 16, 64)
+		if err != nil {
+			return visualPrint{}, false
+		}
+		p.frames = append(p.frames, f)
+	}
+	return p, len(p.frames) > 0
+}
+
+// matches reports whether p and q are prints of the same clip.
+func (p visualPrint) matches(q visualPrint, distance int) bool {
+	if len(p.frames) != len(q.frames) || (p.length-q.length).Abs() > visualLengthSlack {
+		return false
+	}
+	for i := range p.frames {
+		if bits.OnesCount64(p.frames[i]^q.frames[i]) > distance {
+			return false
+		}
+	}
+	return true
+}
+
+// visualPrintOf samples frames of the video at path with ffmpeg. Each is
+// scaled to 9x8 grey pixels and hashed by whether each pixel is brighter
+// than its right neighbour.
+func (c *Copier) visualPrintOf(ctx context.Context, o *VisualDuplicateOptions, path string) (visualPrint, error) {
+	length, err := videoDuration(path)
+	if err != nil {
+		return visualPrint{}, err
+	}
+	if length <= 0 {
+		return visualPrint{}, errNoDuration
+	}
+	n := o.Frames
+	if n <= 0 {
+		n = defaultVisualFrames
+	}
+	// Sample from the middle of each of n equal stretches of the clip.
+	rate := float64(n) / length.Seconds()
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin",
+		"-ss", strconv.FormatFloat(0.5/rate, 'f', 3, 64), "-i", path,
+		"-vf", fmt.Sprintf("fps=%.6f,scale=9:8:flags=area,format=gray", rate),
+		"-frames:v", strconv.Itoa(n), "-f", "rawvideo", "-"}
+	cmd := exec.CommandContext(ctx, c.ffmpeg, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return visualPrint{}, context.Cause(ctx)
+		}
+		return visualPrint{}, fmt.Errorf("ffmpeg on %s: %v: %s", filepath.Base(path), err, strings.TrimSpace(stderr.String()))
+	}
+	p := visualPrint{length: length}
+	raw := stdout.Bytes()
+	for ; len(raw) >= 72; raw = raw[72:] {
+		var h uint64
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 8; x++ {
+				h <<= 1
+				if raw[y*9+x] > raw[y*9+x+1] {
+					h |= 1
+				}
+			}
+		}
+		p.frames = append(p.frames, h)
+	}
+	if len(p.frames) == 0 {
+		return visualPrint{}, fmt.Errorf("ffmpeg returned no frames of %s", filepath.Base(path))
+	}
+	return p, nil
+}
+
+// visualDuplicate returns the print of the video at path and, when it
+// looks like a clip of the rule copied within the window whose copy is
+// still in the destination, that copy. Videos that cannot be sampled get
+// an empty print and are copied.
+func (c *Copier) visualDuplicate(ctx context.Context, rule Rule, path string, info os.FileInfo) (visualPrint, string) {
+	o := rule.VisualDuplicates
+	p, err := c.visualPrintOf(ctx, o, path)
+	if err != nil {
+		if ctx.Err() == nil {
+			logDebugf("Rule %s: cannot sample the frames of %s, copying it: %v", rule.Name, path, err)
+		}
+		return visualPrint{}, ""
+	}
+	distance := o.Distance
+	if distance <= 0 {
+		distance = defaultVisualDistance
+	}
+	window := o.Window.or(defaultVisualWindow)
+	for _, rec := range c.store.historyByRule(rule.Name) {
+		if rec.VisualHash == "" || rec.Source == path || rec.ModTime.Sub(info.ModTime()).Abs() > window {
+			continue
+		}
+		q, ok := parseVisualPrint(rec.VisualHash, rec.Length)
+		if !ok || !p.matches(q, distance) {
+			continue
+		}
+		if _, err := os.Stat(rec.Dest); err != nil {
+			continue
+		}
+		return p, rec.Dest
+	}
+	return p, ""
+}
+
+// validateVisualDuplicates checks a rule's visual duplicate settings.
+func validateVisualDuplicates(key func(string) string, o *VisualDuplicateOptions) ConfigErrors {
+	var problems ConfigErrors
+	if o.Frames < 0 || o.Frames > 100 {
+		problems = append(problems, key("visual_duplicates")+".frames must be between 1 and 100")
+	}
+	if o.Distance < 0 || o.Distance > 32 {
+		problems = append(problems, key("visual_duplicates")+".distance must not exceed 32 bits")
+	}
+	if o.Window < 0 {
+		problems = append(problems, key("visual_duplicates")+".window must be a positive duration such as \"10m\"")
+	}
+	return problems
+}
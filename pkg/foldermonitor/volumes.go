@@ -0,0 +1,181 @@
+package foldermonitor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultVolumePollInterval is how often mounted volumes are listed.
+const defaultVolumePollInterval = 5 * time.Second
+
+// VolumeRule imports a folder from removable media, such as a camera's SD
+// card, when a volume with a matching label is inserted.
+type VolumeRule struct {
+	Name string `json:"name"`
+	// Labels are volume labels to match, case-insensitively; shell
+	// wildcards are allowed (e.g. "EOS_*").
+	Labels []string `json:"labels"`
+	// Folder is the folder on the volume to import (default "DCIM"). Its
+	// subfolder structure is kept below DestDir.
+	Folder  string `json:"folder,omitempty"`
+	DestDir string `json:"dest_dir"`
+	// Eject safely ejects the volume after a successful import.
+	Eject bool `json:"eject,omitempty"`
+	RuleOptions
+}
+
+// volume is a mounted filesystem.
+type volume struct {
+	Mount  string
+	Label  string
+	Device string
+}
+
+// matches reports whether v's label matches one of the rule's labels.
+func (r VolumeRule) matches(v volume) bool {
+	for _, pattern := range r.Labels {
+		if ok, _ := filepath.Match(strings.ToLower(pattern), strings.ToLower(v.Label)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// asRule returns the equivalent folder rule for importing from src.
+func (r VolumeRule) asRule(src string) Rule {
+	return Rule{Name: r.Name, SourceDir: src, DestDir: r.DestDir, RuleOptions: r.RuleOptions}
+}
+
+// sourceFolder returns the folder to import from a matching volume.
+func (r VolumeRule) sourceFolder(v volume) string {
+	folder := r.Folder
+	if folder == "" {
+		folder = "DCIM"
+	}
+	return filepath.Join(v.Mount, folder)
+}
+
+// watchVolumes polls for newly mounted volumes until ctx is cancelled,
+// importing each one that matches a volume rule. It waits for running
+// imports before returning.
+func watchVolumes(ctx context.Context, cfg *Config, c *Copier) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ticker := time.NewTicker(cfg.VolumePollInterval.or(defaultVolumePollInterval))
+	defer ticker.Stop()
+
+	// Volumes present at startup are treated as new so that a card left in
+	// the reader across a restart is still imported.
+	seen := make(map[string]bool)
+	for {
+		vols, err := listRemovableVolumes()
+		if err != nil {
+			logDebugf("Listing volumes: %v", err)
+		}
+		current := make(map[string]bool)
+		for _, v := range vols {
+			key := v.Device + "|" + v.Label
+			current[key] = true
+			if seen[key] {
+				continue
+			}
+			logDebugf("Volume arrived: %s (%s) at %s", v.Label, v.Device, v.Mount)
+			for _, rule := range cfg.Volumes {
+				if !rule.IsEnabled() || !rule.matches(v) || (rule.Encrypt && c.key == nil) {
+					continue
+				}
+				wg.Add(1)
+				go func(rule VolumeRule, v volume) {
+					defer wg.Done()
+					importVolume(ctx, rule, v, c)
+				}(rule, v)
+				break
+			}
+		}
+		seen = current
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// importVolume copies every file below the rule's folder on v that is not
+// already at the destination with the same size, then ejects the volume if
+// requested and everything succeeded.
+func importVolume(ctx context.Context, rule VolumeRule, v volume, c *Copier) {
+	src := rule.sourceFolder(v)
+	if svcLogger != nil {
+		svcLogger.Infof("Volume %s: %q inserted, importing %s", rule.Name, v.Label, src)
+	}
+	target := rule.asRule(src)
+	var files []scannedFile
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return filepath.SkipAll
+		}
+		rel, _ := filepath.Rel(src, path)
+		if rule.excluded(rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, scannedFile{path, info})
+		}
+		return nil
+	})
+	if err != nil {
+		if svcLogger != nil {
+			svcLogger.Errorf("Volume %s: import of %s stopped: %v", rule.Name, src, err)
+		}
+		return
+	}
+	rule.sortScan(files)
+	var copied, skipped, failed int
+	for _, f := range files {
+		if ctx.Err() != nil {
+			return
+		}
+		path, info := f.path, f.info
+		// Skip files imported from this card before. Transformed copies
+		// differ in size, so for those the name alone decides.
+		rel, _ := filepath.Rel(src, path)
+		rel = filepath.Dir(rel)
+		name := destFileName(target, path)
+		studentRel, _ := studentDir(ctx, target, path, info)
+		if existing, err := os.Stat(filepath.Join(rule.DestDir, studentRel, rel, name)); err == nil && (name != info.Name() || existing.Size() == info.Size()) {
+			skipped++
+			continue
+		}
+		if c.archiveFile(ctx, target, path, info, rel) != nil {
+			failed++
+		} else {
+			copied++
+		}
+	}
+	if svcLogger != nil {
+		svcLogger.Infof("Volume %s: import finished, %d copied, %d already present, %d failed", rule.Name, copied, skipped, failed)
+	}
+	if rule.Eject && failed == 0 {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := ejectVolume(v); err != nil {
+			if svcLogger != nil {
+				svcLogger.Errorf("Volume %s: could not eject %s: %v", rule.Name, v.Mount, err)
+			}
+		} else if svcLogger != nil {
+			svcLogger.Infof("Volume %s: %s ejected, safe to remove", rule.Name, v.Mount)
+		}
+	}
+}
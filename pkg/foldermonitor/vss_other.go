@@ -0,0 +1,23 @@
+//go:build !windows
+
+package foldermonitor
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+// snapshotSet stands in for Volume Shadow Copy support, which only exists
+// on Windows.
+type snapshotSet struct{}
+
+func newSnapshotSet() *snapshotSet {
+	return &snapshotSet{}
+}
+
+func (s *snapshotSet) resolve(ctx context.Context, path string, info os.FileInfo) (string, error) {
+	return "", errors.New("shadow copies are only supported on Windows")
+}
+
+func (s *snapshotSet) close() {}
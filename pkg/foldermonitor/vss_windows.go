@@ -0,0 +1,98 @@
+package foldermonitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snapshotMaxAge is how long a shadow copy is reused for further locked
+// files on the same volume before a fresh one is taken.
+const snapshotMaxAge = 10 * time.Minute
+
+// shadowCopy is a Volume Shadow Copy created by the monitor.
+type shadowCopy struct {
+	ID      string `json:"ID"`
+	Device  string `json:"Device"` // e.g. \\?\GLOBALROOT\Device\HarddiskVolumeShadowCopy3
+	created time.Time
+}
+
+// snapshotSet creates shadow copies on demand, one per volume, and deletes
+// them when they are replaced or the set is closed.
+type snapshotSet struct {
+	mu       sync.Mutex
+	byVolume map[string]*shadowCopy
+}
+
+func newSnapshotSet() *snapshotSet {
+	return &snapshotSet{byVolume: make(map[string]*shadowCopy)}
+}
+
+// resolve returns the path of the file at path inside a shadow copy of its
+// volume taken after the file was last modified.
+func (s *snapshotSet) resolve(ctx context.Context, path string, info os.FileInfo) (string, error) {
+	vol := filepath.VolumeName(path)
+	if len(vol) != 2 || vol[1] != ':' {
+		return "", errors.New("shadow copies need a local drive letter path")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	shadow := s.byVolume[strings.ToUpper(vol)]
+	if shadow == nil || shadow.created.Before(info.ModTime()) || time.Since(shadow.created) > snapshotMaxAge {
+		if shadow != nil {
+			deleteShadowCopy(shadow)
+		}
+		var err error
+		if shadow, err = createShadowCopy(ctx, vol); err != nil {
+			delete(s.byVolume, strings.ToUpper(vol))
+			return "", err
+		}
+		s.byVolume[strings.ToUpper(vol)] = shadow
+		logDebugf("Created shadow copy %s of %s", shadow.ID, vol)
+	}
+	return shadow.Device + strings.TrimPrefix(path, vol), nil
+}
+
+// close deletes the shadow copies still held.
+func (s *snapshotSet) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for vol, shadow := range s.byVolume {
+		deleteShadowCopy(shadow)
+		delete(s.byVolume, vol)
+	}
+}
+
+// createShadowCopy snapshots the volume with drive letter vol ("C:").
+func createShadowCopy(ctx context.Context, vol string) (*shadowCopy, error) {
+	script := fmt.Sprintf(`$ErrorActionPreference = 'Stop'
+$r = (Get-WmiObject -List Win32_ShadowCopy).Create(%s, 'ClientAccessible')
+if ($r.ReturnValue -ne 0) { throw "Win32_ShadowCopy.Create returned $($r.ReturnValue)" }
+$s = Get-WmiObject Win32_ShadowCopy | Where-Object { $_.ID -eq $r.ShadowID }
+@{ ID = $s.ID; Device = $s.DeviceObject } | ConvertTo-Json -Compress`, psQuote(vol+`\`))
+	var out []shadowCopy
+	if err := runPowerShell(ctx, script, &out); err != nil {
+		return nil, fmt.Errorf("creating shadow copy of %s: %v", vol, err)
+	}
+	if len(out) == 0 || out[0].Device == "" {
+		return nil, fmt.Errorf("creating shadow copy of %s: no snapshot returned", vol)
+	}
+	shadow := out[0]
+	shadow.created = time.Now()
+	return &shadow, nil
+}
+
+// deleteShadowCopy removes a shadow copy, logging failures.
+func deleteShadowCopy(shadow *shadowCopy) {
+	script := fmt.Sprintf(`Get-WmiObject Win32_ShadowCopy | Where-Object { $_.ID -eq %s } | ForEach-Object { $_.Delete() }`, psQuote(shadow.ID))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := runPowerShell(ctx, script, nil); err != nil && svcLogger != nil {
+		svcLogger.Warningf("Could not delete shadow copy %s: %v", shadow.ID, err)
+	}
+}
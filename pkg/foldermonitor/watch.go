@@ -0,0 +1,305 @@
+package foldermonitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// defaultHealthInterval is how often watched folders are probed when the
+// config does not say otherwise.
+const defaultHealthInterval = 30 * time.Second
+
+// canaryName is the file written into a source folder to prove that its
+// watch still delivers events. It is never copied.
+const canaryName = ".foldermonitor-canary"
+
+// Backoff between attempts to attach the watch on an unavailable source
+// folder, such as one on a drive that is not mounted yet.
+const (
+	minAttachBackoff = time.Second
+	maxAttachBackoff = time.Minute
+)
+
+// watchedRule is a rule attached to the watcher plus its health state.
+type watchedRule struct {
+	Rule
+	info       os.FileInfo // source folder identity when the watch was added
+	down       bool
+	canarySent time.Time
+	// retryAt is when the watch on a down folder is next attempted, backoff
+	// how long the attempt after that waits.
+	retryAt time.Time
+	backoff time.Duration
+}
+
+// WatcherOptions tune a Watcher. Zero values select the defaults.
+type WatcherOptions struct {
+	// Canary also writes a hidden canary file into each source folder on
+	// every health probe and recreates the watch if no event arrives for it.
+	Canary bool
+	// HealthInterval is how often watched folders are probed (default 30s).
+	HealthInterval time.Duration
+	// QuietPeriod is how long a new file must go without further events
+	// before it is published (default 2s).
+	QuietPeriod time.Duration
+	// Heartbeat, when set, is called from Run several times a second with
+	// the number of files waiting to settle and the names of rules whose
+	// source folder is unavailable. It stops being called while a copy
+	// holds up the event loop or after Run returns.
+	Heartbeat func(settling int, down []string)
+	// ChaosError is the probability that a health probe declares a healthy
+	// watch dead, for drills.
+	ChaosError float64
+	// Overflow, when set, is called from Run when the OS reports that its
+	// event buffer overflowed, so events for some files may be lost. The
+	// OS does not say which folder overflowed.
+	Overflow func()
+}
+
+// Watcher watches the source folders of rules and publishes each new file
+// or directory on a bus as an EventDetected once its events have settled.
+// It probes the folders periodically and recreates watches that died.
+type Watcher struct {
+	watcher *fsnotify.Watcher
+	bus     *Bus
+	opts    WatcherOptions
+	rules   map[string]*watchedRule
+}
+
+// NewWatcher creates a watcher publishing on bus. Close it when done.
+func NewWatcher(bus *Bus, opts WatcherOptions) (*Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if opts.HealthInterval <= 0 {
+		opts.HealthInterval = defaultHealthInterval
+	}
+	if opts.QuietPeriod <= 0 {
+		opts.QuietPeriod = defaultQuietPeriod
+	}
+	return &Watcher{watcher: watcher, bus: bus, opts: opts, rules: make(map[string]*watchedRule)}, nil
+}
+
+// Add watches rule's source folder. Rules must be added before Run. If the
+// folder cannot be watched yet, for example because its drive is not
+// mounted, the error is returned and Run keeps trying to attach the watch.
+func (w *Watcher) Add(rule Rule) error {
+	r := &watchedRule{Rule: rule}
+	w.rules[filepath.Clean(rule.SourceDir)] = r
+	info, err := os.Stat(rule.SourceDir)
+	if err == nil {
+		err = w.watcher.Add(rule.SourceDir)
+	}
+	if err != nil {
+		r.down = true
+		w.scheduleAttach(r, time.Now())
+		return err
+	}
+	r.info = info
+	return nil
+}
+
+// Close releases the underlying OS watches.
+func (w *Watcher) Close() error {
+	return w.watcher.Close()
+}
+
+// Run processes file system events until ctx is cancelled. It returns an
+// error only if the OS stops delivering events.
+func (w *Watcher) Run(ctx context.Context) error {
+	healthTicker := time.NewTicker(w.opts.HealthInterval)
+	defer healthTicker.Stop()
+	pending := newCoalescer(w.opts.QuietPeriod)
+	settleTicker := time.NewTicker(pending.pollInterval())
+	defer settleTicker.Stop()
+
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return errors.New("event channel closed")
+			}
+			logDebugf("Watcher event: %s", event)
+			if w.isCanary(event) {
+				continue
+			}
+			if r, ok := w.lookup(event.Name); ok {
+				pending.observe(event, r.Rule, time.Now())
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return errors.New("error channel closed")
+			}
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				logEvent(CategoryHealth, LevelWarning, "Watcher: too many changes at once, some events were dropped (%v)", err)
+				if w.opts.Overflow != nil {
+					w.opts.Overflow()
+				}
+				continue
+			}
+			if svcLogger != nil {
+				svcLogger.Errorf("Watcher error: %v", err)
+			}
+		case now := <-settleTicker.C:
+			for _, f := range pending.ready(now) {
+				w.bus.Publish(Event{Kind: EventDetected, Rule: f.rule, Source: f.path, Seen: f.seen, ctx: ctx})
+			}
+			w.retryDown(now)
+			if w.opts.Heartbeat != nil {
+				w.opts.Heartbeat(len(pending.pending), w.downRules())
+			}
+		case <-healthTicker.C:
+			w.checkHealth()
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// lookup returns the rule watching the folder containing path.
+func (w *Watcher) lookup(path string) (*watchedRule, bool) {
+	r, ok := w.rules[filepath.Dir(path)]
+	return r, ok
+}
+
+// Dirs returns the watched source folders.
+func (w *Watcher) Dirs() []string {
+	var dirs []string
+	for _, r := range w.rules {
+		dirs = append(dirs, r.SourceDir)
+	}
+	return dirs
+}
+
+// downRules returns the names of rules whose source folder is unavailable.
+func (w *Watcher) downRules() []string {
+	var down []string
+	for _, r := range w.rules {
+		if r.down {
+			down = append(down, r.Name)
+		}
+	}
+	sort.Strings(down)
+	return down
+}
+
+// isCanary reports whether event concerns a health canary, marking the
+// watch as proven alive and removing the canary file.
+func (w *Watcher) isCanary(event fsnotify.Event) bool {
+	if filepath.Base(event.Name) != canaryName {
+		return false
+	}
+	if r, ok := w.lookup(event.Name); ok && !r.canarySent.IsZero() {
+		r.canarySent = time.Time{}
+		os.Remove(event.Name)
+	}
+	return true
+}
+
+// checkHealth probes every watched folder. A folder that disappeared is
+// reported once and then retried by retryDown; when the folder changed
+// identity (e.g. the drive was remounted) or an earlier canary produced no
+// event, the watch is recreated.
+func (w *Watcher) checkHealth() {
+	for _, r := range w.rules {
+		if r.down {
+			continue
+		}
+		info, err := os.Stat(r.SourceDir)
+		if err == nil && roll(w.opts.ChaosError) {
+			err = fmt.Errorf("%w: watcher error", errChaos)
+		}
+		if err != nil {
+			r.down = true
+			w.scheduleAttach(r, time.Now())
+			logEvent(CategoryHealth, LevelWarning, "Rule %s: source directory unavailable, watch is dead: %v", r.Name, err)
+			continue
+		}
+		reason := ""
+		switch {
+		case !os.SameFile(info, r.info):
+			reason = "source directory was replaced or remounted"
+		case !r.canarySent.IsZero():
+			reason = "canary file produced no event"
+		}
+		if reason != "" {
+			w.reattach(r, info, reason)
+		}
+		if w.opts.Canary && !r.down {
+			w.sendCanary(r)
+		}
+	}
+}
+
+// retryDown attempts to attach the watches on down folders that are due,
+// backing off further for each folder still unavailable.
+func (w *Watcher) retryDown(now time.Time) {
+	for _, r := range w.rules {
+		if !r.down || now.Before(r.retryAt) {
+			continue
+		}
+		info, err := os.Stat(r.SourceDir)
+		if err != nil {
+			w.scheduleAttach(r, now)
+			logDebugf("Rule %s: source directory still unavailable, retrying in %s: %v", r.Name, r.retryAt.Sub(now), err)
+			continue
+		}
+		reason := "source directory is back"
+		if r.info == nil {
+			reason = "source directory became available"
+		}
+		w.reattach(r, info, reason)
+	}
+}
+
+// scheduleAttach sets when the watch on the down folder of r is next
+// attempted, doubling the wait each time up to maxAttachBackoff.
+func (w *Watcher) scheduleAttach(r *watchedRule, now time.Time) {
+	if r.backoff == 0 {
+		r.backoff = minAttachBackoff
+	}
+	r.retryAt = now.Add(r.backoff)
+	if r.backoff *= 2; r.backoff > maxAttachBackoff {
+		r.backoff = maxAttachBackoff
+	}
+}
+
+// reattach recreates the watch on r's source folder.
+func (w *Watcher) reattach(r *watchedRule, info os.FileInfo, reason string) {
+	w.watcher.Remove(r.SourceDir)
+	if err := w.watcher.Add(r.SourceDir); err != nil {
+		if !r.down {
+			logEvent(CategoryHealth, LevelError, "Rule %s: could not recreate watch (%s): %v", r.Name, reason, err)
+		}
+		r.down = true
+		w.scheduleAttach(r, time.Now())
+		return
+	}
+	r.down = false
+	r.info = info
+	r.canarySent = time.Time{}
+	r.backoff = 0
+	logEvent(CategoryHealth, LevelInfo, "Rule %s: recreated watch on %s (%s)", r.Name, r.SourceDir, reason)
+}
+
+// sendCanary touches the canary file; its event is expected before the
+// next health check.
+func (w *Watcher) sendCanary(r *watchedRule) {
+	path := filepath.Join(r.SourceDir, canaryName)
+	os.Remove(path)
+	f, err := os.Create(path)
+	if err != nil {
+		logDebugf("Rule %s: cannot write canary: %v", r.Name, err)
+		return
+	}
+	f.Close()
+	r.canarySent = time.Now()
+}
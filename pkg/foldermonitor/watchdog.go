@@ -0,0 +1,100 @@
+package foldermonitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// Watchdog timing.
+const (
+	// loopRestartDelay is the wait before the first restart of a failed
+	// monitoring loop; it doubles with each failure up to loopRestartMax.
+	loopRestartDelay = 5 * time.Second
+	loopRestartMax   = 5 * time.Minute
+	// loopStable is how long a loop must run for its next failure to be
+	// restarted after the first delay again.
+	loopStable = 10 * time.Minute
+)
+
+// supervise runs the monitoring loop until ctx is cancelled, then closes
+// done. A loop that panics or ends on its own is restarted with growing
+// delays, catching up on the files that arrived while it was down, and the
+// status reports the monitor as degraded meanwhile.
+func (m *Monitor) supervise(ctx context.Context, cfg *Config, subs []subscription, catchUp string, done chan<- struct{}) {
+	defer close(done)
+	go m.watchHeartbeat(ctx)
+	delay := loopRestartDelay
+	for {
+		started := time.Now()
+		err := m.runRecovered(ctx, cfg, subs, catchUp)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = errors.New("monitoring stopped unexpectedly")
+		}
+		if time.Since(started) > loopStable {
+			delay = loopRestartDelay
+		}
+		m.status.loopFailed(err.Error())
+		logEvent(CategoryService, LevelError, "Watchdog: monitoring failed: %v; restarting it in %s", err, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		delay = min(2*delay, loopRestartMax)
+		catchUp = "monitoring was down"
+		logEvent(CategoryService, LevelInfo, "Watchdog: restarting monitoring")
+	}
+}
+
+// runRecovered is run with a panic turned into its error.
+func (m *Monitor) runRecovered(ctx context.Context, cfg *Config, subs []subscription, catchUp string) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = loopPanic(v)
+		}
+	}()
+	return m.run(ctx, cfg, subs, catchUp)
+}
+
+// loopPanic logs the stack of a panic in the monitoring loop and returns it
+// as an error.
+func loopPanic(v any) error {
+	logEvent(CategoryService, LevelError, "Watchdog: panic in monitoring: %v\n%s", v, debug.Stack())
+	return fmt.Errorf("panic: %v", v)
+}
+
+// watchHeartbeat logs when the watcher of a running loop stops reporting
+// in, which the loop cannot notice itself because the watcher is hung, and
+// when it reports in again.
+func (m *Monitor) watchHeartbeat(ctx context.Context) {
+	ticker := time.NewTicker(heartbeatTimeout / 2)
+	defer ticker.Stop()
+	stalled := false
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		snap := m.status.snapshot()
+		if snap.Degraded != "" || snap.Heartbeat.IsZero() {
+			stalled = false
+			continue
+		}
+		// A long copy holds up the heartbeat; stalled copies are aborted
+		// separately.
+		late := time.Since(snap.Heartbeat) > heartbeatTimeout && len(snap.InFlight) == 0
+		if late && !stalled {
+			logEvent(CategoryHealth, LevelError, "Watchdog: the watcher has not reported in since %s", snap.Heartbeat.Format(time.RFC1123))
+		} else if !late && stalled {
+			logEvent(CategoryHealth, LevelInfo, "Watchdog: the watcher is reporting in again")
+		}
+		stalled = late
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package foldermonitor
+
+import "os"
+
+// createWriteThrough creates the destination file at path with O_SYNC, so
+// that each write returns only once it is on the storage. O_DIRECT is not
+// used: it needs block-aligned writes, which compressed or encrypted
+// copies do not make, and still leaves the file's metadata cached.
+func createWriteThrough(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_SYNC, 0666)
+}
+
+// syncDir flushes the entries of the folder at dir to its storage, so
+// that a new file in it survives a power cut.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}